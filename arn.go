@@ -0,0 +1,153 @@
+package lamux
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// accountIDTimeout is the timeout to resolve the account ID at startup.
+const accountIDTimeout = 10 * time.Second
+
+type stsClient interface {
+	GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
+}
+
+var arnPlaceholderRegexp = regexp.MustCompile(`\{[^{}]*\}`)
+
+var arnPlaceholders = []string{"{region}", "{account}", "{function}"}
+
+// validateFunctionARNTemplate validates the template of function ARNs.
+// e.g. arn:aws:lambda:{region}:{account}:function:{function}
+func validateFunctionARNTemplate(tmpl string) error {
+	if !strings.HasPrefix(tmpl, "arn:") {
+		return fmt.Errorf("function ARN template must start with arn:")
+	}
+	if !strings.Contains(tmpl, "{function}") {
+		return fmt.Errorf("function ARN template must contain {function}")
+	}
+	for _, p := range arnPlaceholderRegexp.FindAllString(tmpl, -1) {
+		if !slices.Contains(arnPlaceholders, p) {
+			return fmt.Errorf("unsupported placeholder in function ARN template: %s (%s allowed)", p, strings.Join(arnPlaceholders, ", "))
+		}
+	}
+	return nil
+}
+
+// awsIdentity is the region and account ID resolved from the AWS config.
+type awsIdentity struct {
+	mu        sync.Mutex
+	resolved  bool
+	region    string
+	account   string
+	partition string
+}
+
+// functionARN expands the function name to the ARN by Config.FunctionARNTemplate.
+// Without the template, the function name is expanded to the ARN in the account resolved by
+// Config.ResolveAccountID, or returned as is when the account is not resolved or it is already an ARN.
+func (l *Lamux) functionARN(ctx context.Context, functionName string) (string, error) {
+	tmpl := l.Config.FunctionARNTemplate
+	if tmpl == "" {
+		return l.defaultFunctionARN(functionName), nil
+	}
+	region, account, err := l.resolveIdentity(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve function ARN: %w", err)
+	}
+	return strings.NewReplacer(
+		"{region}", region,
+		"{account}", account,
+		"{function}", functionName,
+	).Replace(tmpl), nil
+}
+
+// resolveIdentity returns the region and account ID. The account ID is resolved by STS only once
+// when the template requires it, and a failure is retried on the next call.
+func (l *Lamux) resolveIdentity(ctx context.Context) (string, string, error) {
+	id := &l.identity
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	if id.resolved {
+		return id.region, id.account, nil
+	}
+	tmpl := l.Config.FunctionARNTemplate
+	region := l.awsCfg.Region
+	if strings.Contains(tmpl, "{region}") && region == "" {
+		return "", "", errors.New("region is not configured")
+	}
+	var account string
+	if strings.Contains(tmpl, "{account}") {
+		out, err := l.stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+		if err != nil {
+			return "", "", fmt.Errorf("failed to get caller identity: %w", err)
+		}
+		account = aws.ToString(out.Account)
+	}
+	id.region, id.account, id.resolved = region, account, true
+	return region, account, nil
+}
+
+// resolveAccountID resolves the account ID of the caller by STS for Config.ResolveAccountID.
+// A failure is logged and ignored, so that lamux works without the permission of sts:GetCallerIdentity.
+func (l *Lamux) resolveAccountID(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, accountIDTimeout)
+	defer cancel()
+	out, err := l.stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		slog.Warn("failed to resolve account ID", "error", err)
+		return
+	}
+	partition := "aws"
+	if a, err := arn.Parse(aws.ToString(out.Arn)); err == nil {
+		partition = a.Partition
+	}
+	id := &l.identity
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	id.region, id.account, id.partition, id.resolved = l.awsCfg.Region, aws.ToString(out.Account), partition, true
+	slog.Info("resolved account ID", "account_id", id.account)
+}
+
+// accountID returns the account ID resolved by resolveAccountID, or empty if not resolved.
+func (l *Lamux) accountID() string {
+	if !l.Config.ResolveAccountID {
+		return ""
+	}
+	id := &l.identity
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	return id.account
+}
+
+// defaultFunctionARN returns the full ARN of the function in the resolved account.
+// Function names already qualified by ARNs (e.g. functions in other accounts) are returned as is.
+func (l *Lamux) defaultFunctionARN(functionName string) string {
+	if !l.Config.ResolveAccountID || strings.Contains(functionName, ":") {
+		return functionName
+	}
+	id := &l.identity
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	if id.account == "" || id.region == "" {
+		return functionName
+	}
+	return arn.ARN{
+		Partition: cmp.Or(id.partition, "aws"),
+		Service:   "lambda",
+		Region:    id.region,
+		AccountID: id.account,
+		Resource:  "function:" + functionName,
+	}.String()
+}
@@ -0,0 +1,183 @@
+package lamux_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	slogcontext "github.com/PumpkinSeed/slog-context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/fujiwara/lamux"
+)
+
+func TestFunctionARNTemplate(t *testing.T) {
+	cases := []struct {
+		name     string
+		template string
+		expect   string
+	}{
+		{
+			name:   "no template",
+			expect: "test-func",
+		},
+		{
+			name:     "full ARN",
+			template: "arn:aws:lambda:{region}:{account}:function:{function}",
+			expect:   "arn:aws:lambda:ap-northeast-1:123456789012:function:test-func",
+		},
+		{
+			name:     "fixed account",
+			template: "arn:aws:lambda:{region}:210987654321:function:{function}",
+			expect:   "arn:aws:lambda:ap-northeast-1:210987654321:function:test-func",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:        "test-func",
+				DomainSuffix:        "example.net",
+				UpstreamTimeout:     time.Second,
+				FunctionARNTemplate: tc.template,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200}
+			app.SetTestClient(client)
+			app.SetTestAWSIdentity("ap-northeast-1", "123456789012")
+
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expect 200, got %d", w.Code)
+			}
+			if e, a := tc.expect, aws.ToString(client.input.FunctionName); e != a {
+				t.Errorf("expect function name %s, got %s", e, a)
+			}
+			if e, a := "test", aws.ToString(client.input.Qualifier); e != a {
+				t.Errorf("expect qualifier %s, got %s", e, a)
+			}
+		})
+	}
+}
+
+func TestFunctionARNTemplateConcurrency(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:        "test-func",
+		DomainSuffix:        "example.net",
+		UpstreamTimeout:     time.Second,
+		FunctionARNTemplate: "arn:aws:lambda:{region}:210987654321:function:{function}",
+		AutoConcurrency:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{reservedConcurrency: aws.Int32(3)}
+	app.SetTestClient(client)
+	app.SetTestAWSIdentity("ap-northeast-1", "123456789012")
+	if _, limited := app.ConcurrencyLimit(context.Background(), "test-func"); !limited {
+		t.Error("expect limited by the reserved concurrency")
+	}
+	if e, a := "arn:aws:lambda:ap-northeast-1:210987654321:function:test-func", client.getConcurrencyName; e != a {
+		t.Errorf("expect lookup of %s, got %s", e, a)
+	}
+}
+
+func TestFunctionARNTemplateValidation(t *testing.T) {
+	for _, tmpl := range []string{
+		"arn:aws:lambda:{region}:{account}:function:myfunc",
+		"arn:aws:lambda:{region}:{accountid}:function:{function}",
+		"{function}",
+	} {
+		cfg := &lamux.Config{
+			FunctionName:        "test-func",
+			DomainSuffix:        "example.net",
+			UpstreamTimeout:     time.Second,
+			FunctionARNTemplate: tmpl,
+		}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected error for %s", tmpl)
+		}
+	}
+}
+
+type mockSTSClient struct {
+	account string
+	err     error
+}
+
+func (m *mockSTSClient) GetCallerIdentity(ctx context.Context, params *sts.GetCallerIdentityInput, optFns ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &sts.GetCallerIdentityOutput{
+		Account: aws.String(m.account),
+		Arn:     aws.String("arn:aws:sts::" + m.account + ":assumed-role/lamux/session"),
+	}, nil
+}
+
+func TestResolveAccountID(t *testing.T) {
+	var buf bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slogcontext.NewHandler(slog.NewJSONHandler(&buf, nil))))
+	defer slog.SetDefault(orig)
+
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:     "*",
+		DomainSuffix:     "example.net",
+		UpstreamTimeout:  time.Second,
+		ResolveAccountID: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{code: 200}
+	app.SetTestClient(client)
+	app.SetTestSTSClient(&mockSTSClient{account: "123456789012"}, "ap-northeast-1")
+	app.ResolveAccountID(context.Background())
+
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test-test-func.example.net/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expect 200, got %d", w.Code)
+	}
+	if e, a := "arn:aws:lambda:ap-northeast-1:123456789012:function:test-func", aws.ToString(client.input.FunctionName); e != a {
+		t.Errorf("expect function name %s, got %s", e, a)
+	}
+	if !strings.Contains(buf.String(), `"account_id":"123456789012"`) {
+		t.Errorf("expect account_id in logs: %s", buf.String())
+	}
+}
+
+func TestResolveAccountIDFailure(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:     "test-func",
+		DomainSuffix:     "example.net",
+		UpstreamTimeout:  time.Second,
+		ResolveAccountID: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{code: 200}
+	app.SetTestClient(client)
+	app.SetTestSTSClient(&mockSTSClient{err: errors.New("access denied")}, "ap-northeast-1")
+	app.ResolveAccountID(context.Background())
+
+	// function names are used as is without the account ID
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expect 200, got %d", w.Code)
+	}
+	if e, a := "test-func", aws.ToString(client.input.FunctionName); e != a {
+		t.Errorf("expect function name %s, got %s", e, a)
+	}
+}
@@ -0,0 +1,89 @@
+package lamux
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+)
+
+const invocationTypeHeader = "X-Lamux-Invocation-Type"
+
+// parseInvocationType parses the invocation type, RequestResponse or Event, case-insensitively.
+func parseInvocationType(s string) (types.InvocationType, error) {
+	for _, t := range []types.InvocationType{types.InvocationTypeRequestResponse, types.InvocationTypeEvent} {
+		if strings.EqualFold(s, string(t)) {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("invalid invocation type %s (%s or %s allowed)", s, types.InvocationTypeRequestResponse, types.InvocationTypeEvent)
+}
+
+// resolveInvocationType returns the invocation type of the request.
+// The precedence is X-Lamux-Invocation-Type header (if allowed) > Config.InvocationTypes > RequestResponse.
+func (l *Lamux) resolveInvocationType(r *http.Request, functionName string) (types.InvocationType, error) {
+	h := r.Header.Get(invocationTypeHeader)
+	r.Header.Del(invocationTypeHeader)
+	if h != "" && l.Config.AllowInvocationTypeHeader {
+		return parseInvocationType(h)
+	}
+	if s, ok := l.Config.InvocationTypes[functionName]; ok {
+		return parseInvocationType(s)
+	}
+	return types.InvocationTypeRequestResponse, nil
+}
+
+// isLargePayload reports whether the invoke payload should be routed to the asynchronous invocation.
+func (cfg *Config) isLargePayload(b []byte) bool {
+	return cfg.LargePayloadThreshold > 0 && int64(len(b)) > cfg.LargePayloadThreshold
+}
+
+// InvokeAsync invokes the function asynchronously (Event invocation type).
+func (l *Lamux) InvokeAsync(ctx context.Context, functionName, alias string, b []byte) error {
+	ctx, span := tracer.Start(ctx, "InvokeAsync")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("lambda.function_name", functionName),
+		attribute.String("lambda.alias", alias),
+		attribute.Int("lambda.payload_size", len(b)),
+	)
+
+	ctx, cancel := context.WithTimeout(ctx, l.Config.FunctionTimeout(functionName))
+	defer cancel()
+	arn, err := l.functionARN(ctx, functionName)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	input := &lambda.InvokeInput{
+		FunctionName:   aws.String(arn),
+		Qualifier:      aws.String(alias),
+		InvocationType: types.InvocationTypeEvent,
+		Payload:        b,
+	}
+	start := time.Now()
+	_, err = l.lambdaClient.Invoke(ctx, input, l.Config.invokeOptions)
+	elapsed := time.Since(start)
+	if err != nil {
+		return l.invokeError(ctx, span, functionName, alias, elapsed, err)
+	}
+	l.observeInvoke(ctx, functionName, alias, elapsed, nil)
+	return nil
+}
+
+// acceptAsync writes 202 Accepted with the tracking ID, which is the same as the request ID passed to the function.
+func acceptAsync(w http.ResponseWriter, trackingID string) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	return json.NewEncoder(w).Encode(struct {
+		TrackingID string `json:"tracking_id"`
+	}{TrackingID: trackingID})
+}
@@ -0,0 +1,232 @@
+package lamux_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/fujiwara/lamux"
+)
+
+func TestLargePayload(t *testing.T) {
+	cases := []struct {
+		name            string
+		bodySize        int
+		clientErr       error
+		expectCode      int
+		expectEventType bool
+	}{
+		{
+			name:       "small payload is synchronous",
+			bodySize:   10,
+			expectCode: http.StatusOK,
+		},
+		{
+			name:            "large payload is asynchronous",
+			bodySize:        2048,
+			expectCode:      http.StatusAccepted,
+			expectEventType: true,
+		},
+		{
+			name:            "large payload too large for async",
+			bodySize:        2048,
+			clientErr:       &types.RequestTooLargeException{Message: aws.String("Request must be smaller than 262144 bytes for the InvokeAsync operation")},
+			expectCode:      http.StatusRequestEntityTooLarge,
+			expectEventType: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:          "test-func",
+				DomainSuffix:          "example.net",
+				UpstreamTimeout:       time.Second,
+				LargePayloadThreshold: 1024,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200, err: tc.clientErr}
+			app.SetTestClient(client)
+
+			r := httptest.NewRequest("POST", "http://test.example.net/upload", strings.NewReader(strings.Repeat("a", tc.bodySize)))
+			r.Header.Set("X-Lamux-Request-Id", "req-1")
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, r)
+			if w.Code != tc.expectCode {
+				t.Fatalf("expect %d, got %d: %s", tc.expectCode, w.Code, w.Body.String())
+			}
+
+			inputs := client.invoked()
+			if len(inputs) != 1 {
+				t.Fatalf("expect 1 invocation, got %d", len(inputs))
+			}
+			if isEvent := inputs[0].InvocationType == types.InvocationTypeEvent; isEvent != tc.expectEventType {
+				t.Errorf("expect event invocation %v, got %s", tc.expectEventType, inputs[0].InvocationType)
+			}
+			if tc.expectCode != http.StatusAccepted {
+				return
+			}
+			var res struct {
+				TrackingID string `json:"tracking_id"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+				t.Fatal(err)
+			}
+			if res.TrackingID != "req-1" {
+				t.Errorf("expect tracking id req-1, got %q", res.TrackingID)
+			}
+			var payload struct {
+				Headers map[string]string `json:"headers"`
+			}
+			if err := json.Unmarshal(inputs[0].Payload, &payload); err != nil {
+				t.Fatal(err)
+			}
+			if a := payload.Headers["x-lamux-request-id"]; a != res.TrackingID {
+				t.Errorf("tracking id must be passed to the function, got %q", a)
+			}
+		})
+	}
+}
+
+func TestLargePayloadValidation(t *testing.T) {
+	for _, cfg := range []lamux.Config{
+		{LargePayloadThreshold: -1},
+		{LargePayloadThreshold: 1024, LargePayloadFunction: "invalid_name!"},
+	} {
+		cfg.FunctionName = "test-func"
+		cfg.DomainSuffix = "example.net"
+		cfg.UpstreamTimeout = time.Second
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestInvocationType(t *testing.T) {
+	cases := []struct {
+		name            string
+		invocationTypes map[string]string
+		allowHeader     bool
+		header          string
+		expectCode      int
+		expectEventType bool
+	}{
+		{
+			name:       "default",
+			expectCode: http.StatusOK,
+		},
+		{
+			name:            "event by config",
+			invocationTypes: map[string]string{"test-func": "Event"},
+			expectCode:      http.StatusAccepted,
+			expectEventType: true,
+		},
+		{
+			name:            "other function in config",
+			invocationTypes: map[string]string{"other-func": "Event"},
+			expectCode:      http.StatusOK,
+		},
+		{
+			name:            "event by header",
+			allowHeader:     true,
+			header:          "event",
+			expectCode:      http.StatusAccepted,
+			expectEventType: true,
+		},
+		{
+			name:            "header overrides config",
+			invocationTypes: map[string]string{"test-func": "Event"},
+			allowHeader:     true,
+			header:          "RequestResponse",
+			expectCode:      http.StatusOK,
+		},
+		{
+			name:       "header not allowed",
+			header:     "Event",
+			expectCode: http.StatusOK,
+		},
+		{
+			name:        "invalid header",
+			allowHeader: true,
+			header:      "DryRun",
+			expectCode:  http.StatusBadRequest,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:              "test-func",
+				DomainSuffix:              "example.net",
+				UpstreamTimeout:           time.Second,
+				InvocationTypes:           tc.invocationTypes,
+				AllowInvocationTypeHeader: tc.allowHeader,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200}
+			app.SetTestClient(client)
+
+			r := httptest.NewRequest("POST", "http://test.example.net/hook", strings.NewReader(`{"event":"push"}`))
+			r.Header.Set("X-Lamux-Request-Id", "req-1")
+			if tc.header != "" {
+				r.Header.Set("X-Lamux-Invocation-Type", tc.header)
+			}
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, r)
+			if w.Code != tc.expectCode {
+				t.Fatalf("expect %d, got %d: %s", tc.expectCode, w.Code, w.Body.String())
+			}
+			inputs := client.invoked()
+			if tc.expectCode == http.StatusBadRequest {
+				if len(inputs) != 0 {
+					t.Errorf("lambda must not be invoked")
+				}
+				return
+			}
+			if len(inputs) != 1 {
+				t.Fatalf("expect 1 invocation, got %d", len(inputs))
+			}
+			if isEvent := inputs[0].InvocationType == types.InvocationTypeEvent; isEvent != tc.expectEventType {
+				t.Errorf("expect event invocation %v, got %s", tc.expectEventType, inputs[0].InvocationType)
+			}
+			if strings.Contains(strings.ToLower(string(inputs[0].Payload)), "x-lamux-invocation-type") {
+				t.Error("invocation type header must not be forwarded")
+			}
+			if tc.expectEventType {
+				var res struct {
+					TrackingID string `json:"tracking_id"`
+				}
+				if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+					t.Fatal(err)
+				}
+				if res.TrackingID != "req-1" {
+					t.Errorf("expect tracking id req-1, got %s", res.TrackingID)
+				}
+			}
+		})
+	}
+}
+
+func TestInvocationTypesValidation(t *testing.T) {
+	for _, m := range []map[string]string{
+		{"test-func": "DryRun"},
+		{"test_func": "Event"},
+	} {
+		cfg := &lamux.Config{
+			FunctionName:    "test-func",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: time.Second,
+			InvocationTypes: m,
+		}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected error for %v", m)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package lamux
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type BasicAuthConfig struct {
+	BasicAuthUser         string `help:"Username for basic authentication" env:"LAMUX_BASIC_AUTH_USER" name:"basic-auth-user"`
+	BasicAuthPassword     string `help:"Password for basic authentication" env:"LAMUX_BASIC_AUTH_PASSWORD" name:"basic-auth-password" xor:"basicAuthPassword"`
+	BasicAuthPasswordHash string `help:"bcrypt hashed password for basic authentication" env:"LAMUX_BASIC_AUTH_PASSWORD_HASH" name:"basic-auth-password-hash" xor:"basicAuthPassword"`
+	BasicAuthRealm        string `help:"Realm for basic authentication" default:"lamux" env:"LAMUX_BASIC_AUTH_REALM" name:"basic-auth-realm"`
+}
+
+func (bc *BasicAuthConfig) Enabled() bool {
+	return bc.BasicAuthUser != ""
+}
+
+func (bc *BasicAuthConfig) Validate() error {
+	if !bc.Enabled() {
+		return nil
+	}
+	if bc.BasicAuthPassword == "" && bc.BasicAuthPasswordHash == "" {
+		return errors.New("basic auth password or password hash must be set")
+	}
+	if bc.BasicAuthPasswordHash != "" {
+		if _, err := bcrypt.Cost([]byte(bc.BasicAuthPasswordHash)); err != nil {
+			return errors.New("invalid bcrypt password hash")
+		}
+	}
+	return nil
+}
+
+func (bc *BasicAuthConfig) verify(user, password string) bool {
+	// compare digests to make the comparison constant time regardless of lengths
+	u1, u2 := sha256.Sum256([]byte(user)), sha256.Sum256([]byte(bc.BasicAuthUser))
+	userOK := subtle.ConstantTimeCompare(u1[:], u2[:]) == 1
+	var passwordOK bool
+	if bc.BasicAuthPasswordHash != "" {
+		passwordOK = bcrypt.CompareHashAndPassword([]byte(bc.BasicAuthPasswordHash), []byte(password)) == nil
+	} else {
+		p1, p2 := sha256.Sum256([]byte(password)), sha256.Sum256([]byte(bc.BasicAuthPassword))
+		passwordOK = subtle.ConstantTimeCompare(p1[:], p2[:]) == 1
+	}
+	return userOK && passwordOK
+}
+
+// authenticateBasic verifies basic authentication credentials of r.
+func (l *Lamux) authenticateBasic(w http.ResponseWriter, r *http.Request) error {
+	user, password, ok := r.BasicAuth()
+	if !ok || !l.Config.BasicAuthConfig.verify(user, password) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="`+l.Config.BasicAuthRealm+`", charset="UTF-8"`)
+		if !ok {
+			return newHandlerError(errors.New("missing basic auth credentials"), http.StatusUnauthorized)
+		}
+		return newHandlerError(errors.New("invalid basic auth credentials"), http.StatusUnauthorized)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package lamux_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	slogcontext "github.com/PumpkinSeed/slog-context"
+	"github.com/fujiwara/lamux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBasicAuth(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs := map[string]lamux.BasicAuthConfig{
+		"plain": {
+			BasicAuthUser:     "admin",
+			BasicAuthPassword: "secret",
+			BasicAuthRealm:    "lamux",
+		},
+		"bcrypt": {
+			BasicAuthUser:         "admin",
+			BasicAuthPasswordHash: string(hash),
+			BasicAuthRealm:        "lamux",
+		},
+	}
+	cases := []struct {
+		name       string
+		user       string
+		password   string
+		expectCode int
+	}{
+		{name: "valid", user: "admin", password: "secret", expectCode: http.StatusOK},
+		{name: "invalid password", user: "admin", password: "wrong", expectCode: http.StatusUnauthorized},
+		{name: "invalid user", user: "root", password: "secret", expectCode: http.StatusUnauthorized},
+		{name: "missing", expectCode: http.StatusUnauthorized},
+	}
+	for cname, bc := range configs {
+		for _, tc := range cases {
+			t.Run(cname+"/"+tc.name, func(t *testing.T) {
+				app, err := lamux.NewLamux(&lamux.Config{
+					FunctionName:    "test-func",
+					DomainSuffix:    "example.net",
+					UpstreamTimeout: time.Second,
+					BasicAuthConfig: bc,
+				})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				client := &mockClient{code: 200}
+				app.SetTestClient(client)
+				r, _ := http.NewRequest("GET", "/", nil)
+				r.Header.Set("X-Forwarded-Host", "test.example.net")
+				if tc.user != "" {
+					r.SetBasicAuth(tc.user, tc.password)
+				}
+				w := httptest.NewRecorder()
+				err = app.HandleProxy(context.Background(), w, r)
+				if tc.expectCode == http.StatusOK {
+					if err != nil {
+						t.Fatalf("unexpected error: %v", err)
+					}
+					return
+				}
+				var herr *lamux.HandlerError
+				if !errors.As(err, &herr) || herr.Code() != tc.expectCode {
+					t.Fatalf("expect status %d, got %v", tc.expectCode, err)
+				}
+				if e, a := `Basic realm="lamux", charset="UTF-8"`, w.Header().Get("WWW-Authenticate"); e != a {
+					t.Errorf("expect WWW-Authenticate %q, got %q", e, a)
+				}
+				if client.input != nil {
+					t.Error("lambda must not be invoked")
+				}
+			})
+		}
+	}
+}
+
+func TestBasicAuthFailureLog(t *testing.T) {
+	var buf bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slogcontext.NewHandler(slog.NewJSONHandler(&buf, nil))))
+	defer slog.SetDefault(orig)
+
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		BasicAuthConfig: lamux.BasicAuthConfig{BasicAuthUser: "admin", BasicAuthPassword: "secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200})
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+	if e, a := http.StatusUnauthorized, w.Code; e != a {
+		t.Fatalf("expect %d, got %d", e, a)
+	}
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry["msg"] != "request" {
+			continue
+		}
+		found = true
+		if entry["function_name"] != "test-func" || entry["alias"] != "test" {
+			t.Errorf("expect the failure attributed to the function: %s", line)
+		}
+	}
+	if !found {
+		t.Errorf("expect the request log: %s", buf.String())
+	}
+}
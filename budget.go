@@ -0,0 +1,76 @@
+package lamux
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+var errBodyBudgetExceeded = errors.New("in-flight request body budget exceeded")
+
+// bodyBudget limits the total bytes of request bodies buffered concurrently.
+type bodyBudget struct {
+	limit int64
+	used  atomic.Int64
+}
+
+func newBodyBudget(limit int64) *bodyBudget {
+	return &bodyBudget{limit: limit}
+}
+
+func (b *bodyBudget) reserve(n int64) bool {
+	for {
+		cur := b.used.Load()
+		if cur+n > b.limit {
+			return false
+		}
+		if b.used.CompareAndSwap(cur, cur+n) {
+			return true
+		}
+	}
+}
+
+func (b *bodyBudget) release(n int64) {
+	b.used.Add(-n)
+}
+
+// budgetReader reserves the budget for bytes read from the body.
+type budgetReader struct {
+	io.ReadCloser
+	budget   *bodyBudget
+	reserved int64
+	read     int64
+	exceeded bool
+}
+
+// wrap reserves the budget for the body of r and returns the function to release it.
+// The Content-Length is reserved in advance, and bytes exceeding it are reserved while reading.
+func (b *bodyBudget) wrap(r *http.Request) (*budgetReader, func(), error) {
+	br := &budgetReader{ReadCloser: r.Body, budget: b}
+	release := func() { b.release(br.reserved) }
+	if r.Body == nil || r.Body == http.NoBody {
+		return br, release, nil
+	}
+	if r.ContentLength > 0 {
+		if !b.reserve(r.ContentLength) {
+			return nil, nil, errBodyBudgetExceeded
+		}
+		br.reserved = r.ContentLength
+	}
+	r.Body = br
+	return br, release, nil
+}
+
+func (br *budgetReader) Read(p []byte) (int, error) {
+	n, err := br.ReadCloser.Read(p)
+	br.read += int64(n)
+	if over := br.read - br.reserved; over > 0 {
+		if !br.budget.reserve(over) {
+			br.exceeded = true
+			return n, errBodyBudgetExceeded
+		}
+		br.reserved += over
+	}
+	return n, err
+}
@@ -0,0 +1,67 @@
+package lamux_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func TestMaxInFlightBodyBytes(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:         "test-func",
+		DomainSuffix:         "example.net",
+		UpstreamTimeout:      time.Second,
+		MaxInFlightBodyBytes: 1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200, latency: 300 * time.Millisecond})
+	handler := app.Handler()
+	body := strings.Repeat("a", 400)
+
+	// 5 concurrent requests of 400 bytes with 1000 bytes budget: only 2 can be buffered at once
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	codes := map[int]int{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("POST", "http://test.example.net/", strings.NewReader(body)))
+			mu.Lock()
+			codes[w.Code]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if e, a := 2, codes[http.StatusOK]; e != a {
+		t.Errorf("expect %d requests succeeded, got %d (%v)", e, a, codes)
+	}
+	if e, a := 3, codes[http.StatusServiceUnavailable]; e != a {
+		t.Errorf("expect %d requests rejected, got %d (%v)", e, a, codes)
+	}
+
+	// the budget is released after the requests are finished
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "http://test.example.net/", strings.NewReader(body)))
+	if e, a := http.StatusOK, w.Code; e != a {
+		t.Errorf("expect %d after capacity freed, got %d", e, a)
+	}
+
+	// a body without Content-Length is limited while reading
+	r := httptest.NewRequest("POST", "http://test.example.net/", io.NopCloser(strings.NewReader(strings.Repeat("a", 1001))))
+	r.ContentLength = -1
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if e, a := http.StatusServiceUnavailable, w.Code; e != a {
+		t.Errorf("expect %d for unknown length body exceeding the budget, got %d", e, a)
+	}
+}
@@ -0,0 +1,194 @@
+package lamux
+
+import (
+	"container/list"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fujiwara/ridge"
+)
+
+const cacheHeader = "X-Lamux-Cache"
+
+// cacheableStatus is the status codes of responses to be cached.
+var cacheableStatus = []int{http.StatusOK, http.StatusMovedPermanently, http.StatusNotFound}
+
+// responseCache stores the responses of functions. The in-memory LRU cache is used by default.
+type responseCache interface {
+	get(key string) (*cachedResponse, bool)
+	set(key string, res *cachedResponse)
+}
+
+type cachedResponse struct {
+	res      ridge.Response
+	storedAt time.Time
+	expires  time.Time
+}
+
+// size returns the approximate bytes of the cached response.
+func (c *cachedResponse) size() int64 {
+	n := len(c.res.Body)
+	for k, v := range c.res.Headers {
+		n += len(k) + len(v)
+	}
+	for k, vs := range c.res.MultiValueHeaders {
+		for _, v := range vs {
+			n += len(k) + len(v)
+		}
+	}
+	return int64(n)
+}
+
+// lruCache is an in-memory response cache evicting the least recently used responses
+// when the total size exceeds maxBytes.
+type lruCache struct {
+	maxBytes int64
+
+	mu      sync.Mutex
+	size    int64
+	ll      *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key string
+	res *cachedResponse
+}
+
+func newLRUCache(maxBytes int64) *lruCache {
+	return &lruCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (c *lruCache) get(key string) (*cachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := e.Value.(*lruEntry)
+	if time.Now().After(entry.res.expires) {
+		c.remove(e)
+		return nil, false
+	}
+	c.ll.MoveToFront(e)
+	return entry.res, true
+}
+
+func (c *lruCache) set(key string, res *cachedResponse) {
+	if res.size() > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	c.entries[key] = c.ll.PushFront(&lruEntry{key: key, res: res})
+	c.size += res.size()
+	for c.size > c.maxBytes {
+		c.remove(c.ll.Back())
+	}
+}
+
+func (c *lruCache) remove(e *list.Element) {
+	entry := e.Value.(*lruEntry)
+	c.ll.Remove(e)
+	delete(c.entries, entry.key)
+	c.size -= entry.res.size()
+}
+
+// cacheable reports whether the response to the request may be cached, by the original request
+// before credentials are stripped. The responses may be private to the client if the request has
+// credentials or cookies, or lamux authenticates requests by basic auth, JWT or signatures.
+func (l *Lamux) cacheable(r *http.Request) bool {
+	if l.cache == nil || l.Config.BasicAuthConfig.Enabled() || l.jwtVerifier != nil || l.Config.SignatureConfig.Enabled() {
+		return false
+	}
+	return r.Header.Get("Authorization") == "" && r.Header.Get("Cookie") == ""
+}
+
+// cacheKey returns the key of the response cache for the request, or empty if the request is not cacheable.
+// The key consists of the routed host, the function and the qualifier to invoke, so that requests
+// routed to different functions never share responses.
+func (cfg *Config) cacheKey(r *http.Request, functionName, qualifier string) string {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return ""
+	}
+	key := r.Method + " " + strings.ToLower(routedHost(r)) + " " + functionName + ":" + qualifier + " " + r.URL.RequestURI()
+	if cfg.CompressResponses {
+		// compressed bodies depend on the encodings accepted by clients
+		key += " " + r.Header.Get("Accept-Encoding")
+	}
+	return key
+}
+
+// cacheTTL returns the TTL to cache the response of the function, or 0 if not cacheable.
+// s-maxage and max-age in Cache-Control take precedence over the default TTL.
+func (cfg *Config) cacheTTL(res *ridge.Response) time.Duration {
+	if !slices.Contains(cacheableStatus, res.StatusCode) {
+		return 0
+	}
+	if isEventStream(responseHeader(res, "Content-Type")) {
+		return 0
+	}
+	if len(res.Cookies) > 0 || responseHeader(res, "Set-Cookie") != "" || responseHeader(res, "Vary") != "" {
+		return 0
+	}
+	ttl := cfg.CacheDefaultTTL
+	var maxAge, sMaxAge = -1, -1
+	for _, d := range strings.Split(responseHeader(res, "Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(d), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache", "private":
+			return 0
+		case "max-age":
+			if n, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil {
+				maxAge = n
+			}
+		case "s-maxage":
+			if n, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil {
+				sMaxAge = n
+			}
+		}
+	}
+	if sMaxAge >= 0 {
+		ttl = time.Duration(sMaxAge) * time.Second
+	} else if maxAge >= 0 {
+		ttl = time.Duration(maxAge) * time.Second
+	}
+	return ttl
+}
+
+// storeResponse stores the response to the cache if cacheable.
+func (l *Lamux) storeResponse(key string, res *ridge.Response) {
+	ttl := l.Config.cacheTTL(res)
+	if ttl <= 0 {
+		return
+	}
+	c := &cachedResponse{res: *res, storedAt: time.Now()}
+	c.expires = c.storedAt.Add(ttl)
+	c.res.Headers = maps.Clone(res.Headers)
+	c.res.MultiValueHeaders = res.MultiValueHeaders.Clone()
+	c.res.Cookies = nil
+	l.cache.set(key, c)
+}
+
+// cachedCopy returns a copy of the cached response with the Age header, to be written to clients.
+func (c *cachedResponse) cachedCopy() *ridge.Response {
+	res := c.res
+	res.Headers = maps.Clone(c.res.Headers)
+	res.MultiValueHeaders = c.res.MultiValueHeaders.Clone()
+	setResponseHeader(&res, "Age", strconv.Itoa(int(time.Since(c.storedAt).Seconds())))
+	setResponseHeader(&res, cacheHeader, "HIT")
+	return &res
+}
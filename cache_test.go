@@ -0,0 +1,309 @@
+package lamux_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	slogcontext "github.com/PumpkinSeed/slog-context"
+	"github.com/fujiwara/lamux"
+)
+
+func newCacheTestApp(t *testing.T, maxBytes int64, client *mockClient) http.Handler {
+	t.Helper()
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		CacheEnabled:    true,
+		CacheDefaultTTL: time.Minute,
+		CacheMaxBytes:   maxBytes,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(client)
+	return app.Handler()
+}
+
+func TestResponseCache(t *testing.T) {
+	var buf bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slogcontext.NewHandler(slog.NewJSONHandler(&buf, nil))))
+	defer slog.SetDefault(orig)
+
+	client := &mockClient{code: 200, payload: []byte(`{"statusCode":200,"body":"hello"}`)}
+	handler := newCacheTestApp(t, 1024*1024, client)
+
+	for _, expect := range []string{"MISS", "HIT"} {
+		buf.Reset()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/foo?bar=baz", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expect 200, got %d", w.Code)
+		}
+		if e, a := expect, w.Header().Get("X-Lamux-Cache"); e != a {
+			t.Errorf("expect X-Lamux-Cache %s, got %s", e, a)
+		}
+		if e, a := "hello", w.Body.String(); e != a {
+			t.Errorf("expect body %s, got %s", e, a)
+		}
+		var cache string
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var entry struct {
+				Msg   string `json:"msg"`
+				Cache string `json:"cache"`
+			}
+			if err := json.Unmarshal([]byte(line), &entry); err == nil && entry.Msg == "response" {
+				cache = entry.Cache
+			}
+		}
+		if e, a := strings.ToLower(expect), cache; e != a {
+			t.Errorf("expect cache %q in logs, got %q", e, a)
+		}
+	}
+	if e, a := 1, len(client.invoked()); e != a {
+		t.Errorf("expect %d invocations, got %d", e, a)
+	}
+
+	// different queries and methods are not shared
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/foo?bar=qux", nil))
+	if e, a := "MISS", w.Header().Get("X-Lamux-Cache"); e != a {
+		t.Errorf("expect X-Lamux-Cache %s, got %s", e, a)
+	}
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("POST", "http://test.example.net/foo?bar=baz", strings.NewReader("x")))
+		if a := w.Header().Get("X-Lamux-Cache"); a != "" {
+			t.Errorf("expect no X-Lamux-Cache for POST, got %s", a)
+		}
+	}
+	if e, a := 4, len(client.invoked()); e != a {
+		t.Errorf("expect %d invocations, got %d", e, a)
+	}
+}
+
+func TestResponseCacheForwardedHost(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "*",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		CacheEnabled:    true,
+		CacheDefaultTTL: time.Minute,
+		CacheMaxBytes:   1024 * 1024,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{code: 200, payload: []byte(`{"statusCode":200,"body":"hello"}`)}
+	app.SetTestClient(client)
+	handler := app.Handler()
+
+	// the requests differ only in X-Forwarded-Host, which routes them to different functions
+	for _, fh := range []string{"test-test-func.example.net", "test-other-func.example.net"} {
+		r := httptest.NewRequest("GET", "http://test-test-func.example.net/foo", nil)
+		r.Header.Set("X-Forwarded-Host", fh)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if a := w.Header().Get("X-Lamux-Cache"); a == "HIT" {
+			t.Errorf("%s: expect no cached response of another function, got %d %s", fh, w.Code, w.Body.String())
+		}
+	}
+	if e, a := 2, len(client.invoked()); e != a {
+		t.Errorf("expect %d invocations, got %d", e, a)
+	}
+}
+
+func TestResponseCachePrivateRequests(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := newJWKSServer(t, key, "key1")
+	cases := map[string]struct {
+		modify  func(*lamux.Config)
+		request func(r *http.Request, i int)
+	}{
+		"jwt with strip authorization": {
+			modify: func(cfg *lamux.Config) {
+				cfg.JWTConfig = lamux.JWTConfig{JWTJWKSURL: jwks.URL, StripAuthorization: true}
+			},
+			request: func(r *http.Request, i int) {
+				token := signJWT(t, key, "key1", map[string]any{"sub": fmt.Sprintf("user%d", i), "exp": time.Now().Add(time.Hour).Unix()})
+				r.Header.Set("Authorization", "Bearer "+token)
+			},
+		},
+		"basic auth": {
+			modify: func(cfg *lamux.Config) {
+				cfg.BasicAuthConfig = lamux.BasicAuthConfig{BasicAuthUser: "admin", BasicAuthPassword: "secret"}
+			},
+			request: func(r *http.Request, _ int) { r.SetBasicAuth("admin", "secret") },
+		},
+		"authorization": {
+			request: func(r *http.Request, i int) { r.Header.Set("Authorization", fmt.Sprintf("Bearer token%d", i)) },
+		},
+		"cookie": {
+			request: func(r *http.Request, i int) { r.Header.Set("Cookie", fmt.Sprintf("session=%d", i)) },
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			cfg := &lamux.Config{
+				FunctionName:    "test-func",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+				CacheEnabled:    true,
+				CacheDefaultTTL: time.Minute,
+				CacheMaxBytes:   1024 * 1024,
+			}
+			if tc.modify != nil {
+				tc.modify(cfg)
+			}
+			app, err := lamux.NewLamux(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200, payload: []byte(`{"statusCode":200,"body":"private"}`)}
+			app.SetTestClient(client)
+			for i := 0; i < 2; i++ {
+				r := httptest.NewRequest("GET", "http://test.example.net/me", nil)
+				tc.request(r, i)
+				w := httptest.NewRecorder()
+				app.Handler().ServeHTTP(w, r)
+				if w.Code != http.StatusOK {
+					t.Fatalf("expect 200, got %d", w.Code)
+				}
+				if a := w.Header().Get("X-Lamux-Cache"); a != "" {
+					t.Errorf("expect no X-Lamux-Cache, got %s", a)
+				}
+			}
+			if e, a := 2, len(client.invoked()); e != a {
+				t.Errorf("expect %d invocations, got %d", e, a)
+			}
+		})
+	}
+}
+
+func TestResponseCacheTTL(t *testing.T) {
+	cases := []struct {
+		name    string
+		payload string
+		sleep   time.Duration
+		cached  bool
+	}{
+		{
+			name:    "default TTL",
+			payload: `{"statusCode":200}`,
+			cached:  true,
+		},
+		{
+			name:    "not found",
+			payload: `{"statusCode":404}`,
+			cached:  true,
+		},
+		{
+			name:    "not cacheable status",
+			payload: `{"statusCode":302,"headers":{"location":"/"}}`,
+		},
+		{
+			name:    "no-store",
+			payload: `{"statusCode":200,"headers":{"cache-control":"no-store"}}`,
+		},
+		{
+			name:    "private",
+			payload: `{"statusCode":200,"headers":{"cache-control":"private, max-age=60"}}`,
+		},
+		{
+			name:    "set-cookie",
+			payload: `{"statusCode":200,"cookies":["a=b"]}`,
+		},
+		{
+			name:    "max-age expired",
+			payload: `{"statusCode":200,"headers":{"cache-control":"max-age=1"}}`,
+			sleep:   1100 * time.Millisecond,
+		},
+		{
+			name:    "max-age=0",
+			payload: `{"statusCode":200,"headers":{"cache-control":"public, max-age=0"}}`,
+		},
+		{
+			name:    "s-maxage",
+			payload: `{"statusCode":200,"headers":{"cache-control":"max-age=0, s-maxage=60"}}`,
+			cached:  true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &mockClient{code: 200, payload: []byte(tc.payload)}
+			handler := newCacheTestApp(t, 1024*1024, client)
+			for i := 0; i < 2; i++ {
+				if i > 0 {
+					time.Sleep(tc.sleep)
+				}
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://test.example.net/", nil))
+			}
+			expect := 2
+			if tc.cached {
+				expect = 1
+			}
+			if a := len(client.invoked()); expect != a {
+				t.Errorf("expect %d invocations, got %d", expect, a)
+			}
+		})
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	client := &mockClient{code: 200, payload: []byte(`{"statusCode":200,"body":"` + strings.Repeat("x", 400) + `"}`)}
+	handler := newCacheTestApp(t, 1000, client)
+
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net"+path, nil))
+		return w.Header().Get("X-Lamux-Cache")
+	}
+	for _, step := range []struct {
+		path   string
+		expect string
+	}{
+		{"/a", "MISS"},
+		{"/b", "MISS"},
+		{"/a", "HIT"},
+		{"/c", "MISS"}, // evicts /b, the least recently used
+		{"/a", "HIT"},
+		{"/b", "MISS"},
+	} {
+		if a := get(step.path); step.expect != a {
+			t.Errorf("%s: expect X-Lamux-Cache %s, got %s", step.path, step.expect, a)
+		}
+	}
+}
+
+func TestResponseCacheValidation(t *testing.T) {
+	for name, modify := range map[string]func(*lamux.Config){
+		"zero max bytes": func(cfg *lamux.Config) { cfg.CacheMaxBytes = 0 },
+		"negative TTL":   func(cfg *lamux.Config) { cfg.CacheDefaultTTL = -time.Second },
+		"CSP nonce":      func(cfg *lamux.Config) { cfg.InjectCSPNonce = true },
+	} {
+		cfg := &lamux.Config{
+			FunctionName:    "test-func",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: time.Second,
+			CacheEnabled:    true,
+			CacheMaxBytes:   1024,
+		}
+		modify(cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package lamux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+var errCircuitOpen = errors.New("circuit breaker is open")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitClosed:
+		return "closed"
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// circuitBreaker short-circuits invocations of functions failing consecutively, per function and alias.
+// The circuit opens after threshold consecutive failures, and half-opens after cooldown
+// to let a single trial invocation through. A successful invocation closes the circuit.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+type circuit struct {
+	state    circuitState
+	failures int
+	openedAt time.Time
+	trial    bool // a trial invocation is in flight in the half-open state
+}
+
+func newCircuitBreaker(cfg *Config) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: cfg.CircuitBreakerThreshold,
+		cooldown:  cfg.CircuitBreakerCooldown,
+		circuits:  make(map[string]*circuit),
+	}
+}
+
+func circuitKey(functionName, alias string) string {
+	return functionName + ":" + alias
+}
+
+// allow returns an error with 503 if the circuit of the function and alias is open.
+func (cb *circuitBreaker) allow(ctx context.Context, functionName, alias string) error {
+	cb.mu.Lock()
+	c, ok := cb.circuits[circuitKey(functionName, alias)]
+	if !ok {
+		cb.mu.Unlock()
+		return nil
+	}
+	from := c.state
+	switch c.state {
+	case circuitOpen:
+		if remaining := cb.cooldown - time.Since(c.openedAt); remaining > 0 {
+			cb.mu.Unlock()
+			return circuitOpenError(functionName, alias, remaining)
+		}
+		c.state, c.trial = circuitHalfOpen, true
+	case circuitHalfOpen:
+		if c.trial {
+			cb.mu.Unlock()
+			return circuitOpenError(functionName, alias, 0)
+		}
+		c.trial = true
+	}
+	to := c.state
+	cb.mu.Unlock()
+	circuitTransition(ctx, functionName, alias, from, to)
+	return nil
+}
+
+// done records the result of the invocation allowed by allow.
+func (cb *circuitBreaker) done(ctx context.Context, functionName, alias string, err error) {
+	key := circuitKey(functionName, alias)
+	cb.mu.Lock()
+	c, ok := cb.circuits[key]
+	if !ok {
+		if !isCircuitFailure(err) {
+			cb.mu.Unlock()
+			return
+		}
+		c = &circuit{}
+		cb.circuits[key] = c
+	}
+	from := c.state
+	c.trial = false
+	switch {
+	case err == nil:
+		c.state, c.failures = circuitClosed, 0
+	case isCircuitFailure(err):
+		c.failures++
+		if c.state == circuitHalfOpen || c.failures >= cb.threshold {
+			c.state, c.openedAt = circuitOpen, time.Now()
+		}
+	}
+	to := c.state
+	cb.mu.Unlock()
+	circuitTransition(ctx, functionName, alias, from, to)
+}
+
+// circuitOpenError returns an error with 503 and Retry-After header (at least 1 second).
+func circuitOpenError(functionName, alias string, retryAfter time.Duration) error {
+	err := newHandlerError(fmt.Errorf("%w: %s", errCircuitOpen, circuitKey(functionName, alias)), http.StatusServiceUnavailable)
+	err.Header().Set("Retry-After", strconv.Itoa(max(int(math.Ceil(retryAfter.Seconds())), 1)))
+	return err
+}
+
+// isCircuitFailure reports whether the error of the invocation indicates the function is unhealthy.
+// Client errors (not found, throttled, too large) and requests canceled by clients are not failures.
+func isCircuitFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var herr *HandlerError
+	if !errors.As(err, &herr) {
+		return true
+	}
+	switch herr.Code() {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// circuitTransition logs the state transition and adds it to the span as an event.
+func circuitTransition(ctx context.Context, functionName, alias string, from, to circuitState) {
+	if from == to {
+		return
+	}
+	slog.WarnContext(ctx, "circuit breaker state changed",
+		"circuit_function_name", functionName,
+		"circuit_alias", alias,
+		"circuit_from", from.String(),
+		"circuit_to", to.String(),
+	)
+	oteltrace.SpanFromContext(ctx).AddEvent("circuit_breaker.state_changed", oteltrace.WithAttributes(
+		attribute.String("lamux.circuit_breaker.from", from.String()),
+		attribute.String("lamux.circuit_breaker.to", to.String()),
+	))
+}
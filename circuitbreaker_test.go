@@ -0,0 +1,126 @@
+package lamux_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func newCircuitBreakerApp(t *testing.T, client *mockClient) http.Handler {
+	t.Helper()
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:            "test-func",
+		DomainSuffix:            "example.net",
+		UpstreamTimeout:         time.Second,
+		CircuitBreakerThreshold: 3,
+		CircuitBreakerCooldown:  100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(client)
+	return app.Handler()
+}
+
+func serveCircuitBreaker(t *testing.T, handler http.Handler, client *mockClient, host string, expectCode int, expectInvoked bool) *httptest.ResponseRecorder {
+	t.Helper()
+	n := len(client.invoked())
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://"+host+"/", nil))
+	if e, a := expectCode, w.Code; e != a {
+		t.Fatalf("expect %d, got %d", e, a)
+	}
+	if invoked := len(client.invoked()) > n; invoked != expectInvoked {
+		t.Fatalf("expect invoked %v, got %v", expectInvoked, invoked)
+	}
+	return w
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	sr := recordSpans()
+	client := &mockClient{code: 500, qualifiers: []string{"other"}}
+	handler := newCircuitBreakerApp(t, client)
+	n := len(sr.Ended())
+
+	for i := 0; i < 3; i++ {
+		serveCircuitBreaker(t, handler, client, "test.example.net", http.StatusBadGateway, true)
+	}
+	// open
+	w := serveCircuitBreaker(t, handler, client, "test.example.net", http.StatusServiceUnavailable, false)
+	if e, a := "1", w.Header().Get("Retry-After"); e != a {
+		t.Errorf("expect Retry-After %s, got %s", e, a)
+	}
+	// other aliases are not affected
+	serveCircuitBreaker(t, handler, client, "other.example.net", http.StatusBadGateway, true)
+
+	// half-open after the cooldown, and closed by a successful trial
+	time.Sleep(150 * time.Millisecond)
+	client.code = 200
+	serveCircuitBreaker(t, handler, client, "test.example.net", http.StatusOK, true)
+	serveCircuitBreaker(t, handler, client, "test.example.net", http.StatusOK, true)
+
+	var transitions []string
+	for _, s := range endedSpansSince(sr, n, "Invoke") {
+		for _, ev := range s.Events() {
+			if ev.Name != "circuit_breaker.state_changed" {
+				continue
+			}
+			var from, to string
+			for _, kv := range ev.Attributes {
+				switch kv.Key {
+				case "lamux.circuit_breaker.from":
+					from = kv.Value.AsString()
+				case "lamux.circuit_breaker.to":
+					to = kv.Value.AsString()
+				}
+			}
+			transitions = append(transitions, from+">"+to)
+		}
+	}
+	expect := []string{"closed>open", "open>half-open", "half-open>closed"}
+	if len(transitions) != len(expect) {
+		t.Fatalf("expect transitions %v, got %v", expect, transitions)
+	}
+	for i := range expect {
+		if expect[i] != transitions[i] {
+			t.Errorf("expect transitions %v, got %v", expect, transitions)
+		}
+	}
+}
+
+func TestCircuitBreakerHalfOpenFailure(t *testing.T) {
+	client := &mockClient{code: 500}
+	handler := newCircuitBreakerApp(t, client)
+	for i := 0; i < 3; i++ {
+		serveCircuitBreaker(t, handler, client, "test.example.net", http.StatusBadGateway, true)
+	}
+	serveCircuitBreaker(t, handler, client, "test.example.net", http.StatusServiceUnavailable, false)
+
+	// the failed trial opens the circuit again
+	time.Sleep(150 * time.Millisecond)
+	serveCircuitBreaker(t, handler, client, "test.example.net", http.StatusBadGateway, true)
+	serveCircuitBreaker(t, handler, client, "test.example.net", http.StatusServiceUnavailable, false)
+}
+
+func TestCircuitBreakerIgnoresClientErrors(t *testing.T) {
+	client := &mockClient{code: 200}
+	handler := newCircuitBreakerApp(t, client)
+	// not found errors do not open the circuit
+	for i := 0; i < 5; i++ {
+		serveCircuitBreaker(t, handler, client, "notfound.example.net", http.StatusNotFound, true)
+	}
+	// a success resets consecutive failures
+	client.code = 500
+	serveCircuitBreaker(t, handler, client, "test.example.net", http.StatusBadGateway, true)
+	serveCircuitBreaker(t, handler, client, "test.example.net", http.StatusBadGateway, true)
+	client.code = 200
+	serveCircuitBreaker(t, handler, client, "test.example.net", http.StatusOK, true)
+	client.code = 500
+	serveCircuitBreaker(t, handler, client, "test.example.net", http.StatusBadGateway, true)
+	serveCircuitBreaker(t, handler, client, "test.example.net", http.StatusBadGateway, true)
+	serveCircuitBreaker(t, handler, client, "test.example.net", http.StatusBadGateway, true)
+	serveCircuitBreaker(t, handler, client, "test.example.net", http.StatusServiceUnavailable, false)
+}
@@ -0,0 +1,92 @@
+package lamux
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientIPResolver derives the client IP address from the remote address and X-Forwarded-For.
+type clientIPResolver struct {
+	trustedCount int
+	trusted      []netip.Prefix
+}
+
+func newClientIPResolver(cfg *Config) (*clientIPResolver, error) {
+	trusted, err := parsePrefixes(cfg.TrustedProxyCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid trusted proxy cidrs: %w", err)
+	}
+	return &clientIPResolver{
+		trustedCount: cfg.TrustedProxyCount,
+		trusted:      trusted,
+	}, nil
+}
+
+// resolve returns the client IP address of r.
+// The hops are X-Forwarded-For entries followed by the remote address.
+// The rightmost trustedCount hops are skipped, and then hops in the trusted CIDRs are skipped from the right.
+// The hops on the left of the client IP are never evaluated because they can be spoofed by clients.
+func (c *clientIPResolver) resolve(r *http.Request) (netip.Addr, error) {
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	remote := r.RemoteAddr
+	if ap, err := netip.ParseAddrPort(remote); err == nil {
+		remote = ap.Addr().String()
+	}
+	hops = append(hops, remote)
+
+	// when the chain is shorter than expected, the leftmost hop is the client
+	i := max(len(hops)-1-c.trustedCount, 0)
+	for ; ; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			if i == len(hops)-1 {
+				return netip.Addr{}, fmt.Errorf("invalid remote address: %s", r.RemoteAddr)
+			}
+			return netip.Addr{}, fmt.Errorf("malformed X-Forwarded-For: %s", hops[i])
+		}
+		addr = addr.Unmap()
+		if i == 0 || !containsAddr(c.trusted, addr) {
+			return addr, nil
+		}
+	}
+}
+
+// parsePrefixes parses CIDRs. A single IP address is treated as a CIDR of the address only.
+func parsePrefixes(ss []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(ss))
+	for _, s := range ss {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, err
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package lamux
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errColdStartTimeout is wrapped by upstream timeouts classified as cold starts.
+var errColdStartTimeout = errors.New("cold start timeout")
+
+// coldStartTracker guesses whether an invocation hits a cold start, by the last time the function
+// and alias responded. Lambda reclaims execution environments idle for a while, so a function
+// which has not responded since startup or for longer than idle is likely to be initialized.
+type coldStartTracker struct {
+	idle time.Duration
+
+	mu       sync.Mutex
+	lastSeen map[string]time.Time
+}
+
+func newColdStartTracker(idle time.Duration) *coldStartTracker {
+	return &coldStartTracker{
+		idle:     idle,
+		lastSeen: make(map[string]time.Time),
+	}
+}
+
+// seen records that the function and alias responded, including function errors.
+func (t *coldStartTracker) seen(functionName, alias string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.lastSeen[functionName+":"+alias] = time.Now()
+}
+
+// timeoutReason returns the reason of a timeout of the function and alias started at start,
+// cold_start or warm. It returns empty if the tracker is disabled.
+func (t *coldStartTracker) timeoutReason(functionName, alias string, start time.Time) string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	last, ok := t.lastSeen[functionName+":"+alias]
+	if !ok || start.Sub(last) > t.idle {
+		return "cold_start"
+	}
+	return "warm"
+}
@@ -0,0 +1,111 @@
+package lamux_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	slogcontext "github.com/PumpkinSeed/slog-context"
+	"github.com/fujiwara/lamux"
+)
+
+func TestColdStartTimeout(t *testing.T) {
+	var buf bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slogcontext.NewHandler(slog.NewJSONHandler(&buf, nil))))
+	defer slog.SetDefault(orig)
+
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:         "test-func",
+		DomainSuffix:         "example.net",
+		UpstreamTimeout:      100 * time.Millisecond,
+		ColdStartIdleTimeout: 200 * time.Millisecond,
+		MetricsEnabled:       true,
+		MetricsPath:          "/metrics",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{code: 200}
+	app.SetTestClient(client)
+	handler := app.Handler()
+
+	steps := []struct {
+		name    string
+		latency time.Duration
+		sleep   time.Duration
+		code    int
+		reason  string
+	}{
+		{name: "first invocation", latency: 300 * time.Millisecond, code: http.StatusGatewayTimeout, reason: "cold_start"},
+		{name: "success", code: http.StatusOK},
+		{name: "timeout after success", latency: 300 * time.Millisecond, code: http.StatusGatewayTimeout, reason: "warm"},
+		{name: "timeout after idle", latency: 300 * time.Millisecond, sleep: 300 * time.Millisecond, code: http.StatusGatewayTimeout, reason: "cold_start"},
+	}
+	for _, s := range steps {
+		time.Sleep(s.sleep)
+		client.latency = s.latency
+		buf.Reset()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+		if e, a := s.code, w.Code; e != a {
+			t.Fatalf("%s: expect %d, got %d", s.name, e, a)
+		}
+		var reason string
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var entry struct {
+				Msg           string `json:"msg"`
+				TimeoutReason string `json:"timeout_reason"`
+			}
+			if err := json.Unmarshal([]byte(line), &entry); err == nil && entry.Msg == "request" {
+				reason = entry.TimeoutReason
+			}
+		}
+		if e, a := s.reason, reason; e != a {
+			t.Errorf("%s: expect timeout_reason %q, got %q", s.name, e, a)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/metrics", nil))
+	b, _ := io.ReadAll(w.Body)
+	for _, expect := range []string{
+		`lamux_invoke_errors_total{alias="test",function_name="test-func",type="cold_start_timeout"} 2`,
+		`lamux_invoke_errors_total{alias="test",function_name="test-func",type="timeout"} 1`,
+	} {
+		if !strings.Contains(string(b), expect) {
+			t.Errorf("metrics must contain %q", expect)
+		}
+	}
+}
+
+func TestColdStartTimeoutDisabled(t *testing.T) {
+	var buf bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slogcontext.NewHandler(slog.NewJSONHandler(&buf, nil))))
+	defer slog.SetDefault(orig)
+
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200, latency: 300 * time.Millisecond})
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+	if e, a := http.StatusGatewayTimeout, w.Code; e != a {
+		t.Fatalf("expect %d, got %d", e, a)
+	}
+	if strings.Contains(buf.String(), "timeout_reason") {
+		t.Errorf("timeout_reason must not be logged: %s", buf.String())
+	}
+}
@@ -0,0 +1,161 @@
+package lamux
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fujiwara/ridge"
+)
+
+// incompressibleTypes are the content types which are already compressed.
+var incompressibleTypes = []string{
+	"image/",
+	"video/",
+	"audio/",
+	"font/woff",
+	"font/woff2",
+	"application/zip",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zstd",
+	"application/x-bzip2",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/pdf",
+}
+
+func isCompressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType == "" // no content type is treated as compressible
+	}
+	if mt == "image/svg+xml" {
+		return true
+	}
+	for _, t := range incompressibleTypes {
+		if mt == t || (strings.HasSuffix(t, "/") && strings.HasPrefix(mt, t)) {
+			return false
+		}
+	}
+	return true
+}
+
+// negotiateEncoding returns "gzip" or "deflate" accepted by the Accept-Encoding header value.
+// An empty string is returned when neither is acceptable.
+func negotiateEncoding(acceptEncoding string) string {
+	var best string
+	var bestQ float64
+	for _, v := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(v), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		q := 1.0
+		if k, qv, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(k) == "q" {
+			f, err := strconv.ParseFloat(strings.TrimSpace(qv), 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		if q <= 0 {
+			continue
+		}
+		switch coding {
+		case "gzip", "x-gzip", "*":
+			coding = "gzip"
+		case "deflate":
+		default:
+			continue
+		}
+		// gzip is preferred on the same quality
+		if q > bestQ || (q == bestQ && coding == "gzip") {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// compressResponse compresses the body of res by the encoding accepted by the client.
+// Responses already encoded, small or of incompressible content types are not compressed.
+func compressResponse(res *ridge.Response, acceptEncoding string, minSize int) error {
+	encoding := negotiateEncoding(acceptEncoding)
+	if encoding == "" {
+		return nil
+	}
+	if res.StatusCode == http.StatusNoContent || res.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if enc := responseHeader(res, "Content-Encoding"); enc != "" && !strings.EqualFold(enc, "identity") {
+		return nil // never double-compress
+	}
+	if strings.Contains(strings.ToLower(responseHeader(res, "Cache-Control")), "no-transform") {
+		return nil
+	}
+	if !isCompressible(responseHeader(res, "Content-Type")) {
+		return nil
+	}
+	body := []byte(res.Body)
+	if res.IsBase64Encoded {
+		b, err := base64.StdEncoding.DecodeString(res.Body)
+		if err != nil {
+			return fmt.Errorf("failed to decode response body: %w", err)
+		}
+		body = b
+	}
+	if len(body) == 0 || len(body) < minSize {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		// "deflate" in HTTP is the zlib format (RFC 9110)
+		w = zlib.NewWriter(&buf)
+	}
+	if _, err := w.Write(body); err != nil {
+		return fmt.Errorf("failed to compress response body: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to compress response body: %w", err)
+	}
+
+	res.Body = base64.StdEncoding.EncodeToString(buf.Bytes())
+	res.IsBase64Encoded = true
+	deleteResponseHeader(res, "Content-Length")
+	setResponseHeader(res, "Content-Encoding", encoding)
+	addVary(res, "Accept-Encoding")
+	return nil
+}
+
+// addVary adds the header name to the Vary header of res.
+func addVary(res *ridge.Response, name string) {
+	var values []string
+	for k, v := range res.Headers {
+		if strings.EqualFold(k, "Vary") {
+			values = append(values, v)
+		}
+	}
+	for k, vs := range res.MultiValueHeaders {
+		if strings.EqualFold(k, "Vary") {
+			values = append(values, vs...)
+		}
+	}
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t == "*" || strings.EqualFold(t, name) {
+				return
+			}
+		}
+	}
+	setResponseHeader(res, "Vary", strings.Join(append(values, name), ", "))
+}
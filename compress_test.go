@@ -0,0 +1,177 @@
+package lamux_test
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func compressTestPayload(t *testing.T, headers map[string]string, body string, base64Encoded bool) []byte {
+	t.Helper()
+	if base64Encoded {
+		body = base64.StdEncoding.EncodeToString([]byte(body))
+	}
+	b, err := json.Marshal(map[string]any{
+		"statusCode":      200,
+		"headers":         headers,
+		"body":            body,
+		"isBase64Encoded": base64Encoded,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestCompressResponses(t *testing.T) {
+	largeJSON := `{"items":[` + strings.Repeat(`{"name":"lamux","value":12345},`, 100) + `{}]}`
+	cases := []struct {
+		name           string
+		disabled       bool
+		acceptEncoding string
+		headers        map[string]string
+		body           string
+		base64Encoded  bool
+		expectEncoding string
+		expectVary     string
+	}{
+		{
+			name:           "gzip",
+			acceptEncoding: "gzip, deflate, br",
+			headers:        map[string]string{"content-type": "application/json", "content-length": "3000"},
+			body:           largeJSON,
+			expectEncoding: "gzip",
+			expectVary:     "Accept-Encoding",
+		},
+		{
+			name:           "deflate preferred by quality",
+			acceptEncoding: "gzip;q=0.5, deflate",
+			headers:        map[string]string{"content-type": "application/json", "vary": "Origin"},
+			body:           largeJSON,
+			expectEncoding: "deflate",
+			expectVary:     "Origin, Accept-Encoding",
+		},
+		{
+			name:           "base64 encoded body",
+			acceptEncoding: "gzip",
+			headers:        map[string]string{"content-type": "text/plain"},
+			body:           largeJSON,
+			base64Encoded:  true,
+			expectEncoding: "gzip",
+			expectVary:     "Accept-Encoding",
+		},
+		{
+			name:           "disabled",
+			disabled:       true,
+			acceptEncoding: "gzip",
+			headers:        map[string]string{"content-type": "application/json"},
+			body:           largeJSON,
+		},
+		{
+			name:    "not accepted",
+			headers: map[string]string{"content-type": "application/json"},
+			body:    largeJSON,
+		},
+		{
+			name:           "gzip not acceptable",
+			acceptEncoding: "gzip;q=0, br",
+			headers:        map[string]string{"content-type": "application/json"},
+			body:           largeJSON,
+		},
+		{
+			name:           "too small",
+			acceptEncoding: "gzip",
+			headers:        map[string]string{"content-type": "application/json"},
+			body:           `{"ok":true}`,
+		},
+		{
+			name:           "already encoded",
+			acceptEncoding: "gzip",
+			headers:        map[string]string{"content-type": "application/json", "content-encoding": "br"},
+			body:           largeJSON,
+			expectEncoding: "br",
+		},
+		{
+			name:           "image",
+			acceptEncoding: "gzip",
+			headers:        map[string]string{"content-type": "image/png"},
+			body:           largeJSON,
+			base64Encoded:  true,
+		},
+		{
+			name:           "no-transform",
+			acceptEncoding: "gzip",
+			headers:        map[string]string{"content-type": "application/json", "cache-control": "public, no-transform"},
+			body:           largeJSON,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:      "test-func",
+				DomainSuffix:      "example.net",
+				UpstreamTimeout:   time.Second,
+				CompressResponses: !tc.disabled,
+				CompressMinSize:   1024,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.SetTestClient(&mockClient{code: 200, payload: compressTestPayload(t, tc.headers, tc.body, tc.base64Encoded)})
+
+			r, _ := http.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Forwarded-Host", "test.example.net")
+			if tc.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tc.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			if err := app.HandleProxy(context.Background(), w, r); err != nil {
+				t.Fatal(err)
+			}
+			if e, a := tc.expectEncoding, w.Header().Get("Content-Encoding"); e != a {
+				t.Fatalf("expect content-encoding %q, got %q", e, a)
+			}
+			if e, a := tc.expectVary, w.Header().Get("Vary"); tc.expectVary != "" && e != a {
+				t.Errorf("expect vary %q, got %q", e, a)
+			}
+
+			compressedLen := w.Body.Len()
+			var body io.Reader = w.Body
+			switch tc.expectEncoding {
+			case "gzip":
+				if body, err = gzip.NewReader(w.Body); err != nil {
+					t.Fatal(err)
+				}
+			case "deflate":
+				if body, err = zlib.NewReader(w.Body); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tc.expectEncoding == "gzip" || tc.expectEncoding == "deflate" {
+				if w.Header().Get("Content-Length") != "" {
+					t.Error("content-length must be removed after compression")
+				}
+				if compressedLen >= len(tc.body) {
+					t.Errorf("body is not compressed: %d >= %d", compressedLen, len(tc.body))
+				}
+			}
+			b, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != tc.body {
+				t.Errorf("unexpected body: %s", string(b))
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package lamux
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+)
+
+// concurrencyRetryAfter is the Retry-After seconds of requests rejected by the concurrency limits.
+const concurrencyRetryAfter = "1"
+
+// concurrencyLookupTimeout is the timeout of each lookup of the reserved concurrency.
+const concurrencyLookupTimeout = 5 * time.Second
+
+// concurrencyLookupBackoff is the interval to retry a failed lookup of the reserved concurrency.
+var concurrencyLookupBackoff = time.Minute
+
+// concurrencyLimiter limits concurrent invocations per function and of all functions.
+type concurrencyLimiter struct {
+	limit  int        // explicit limit for all functions
+	auto   bool       // use the reserved concurrency of the function when limit is not set
+	depth  int        // maximum requests waiting for each semaphore
+	global *semaphore // nil means unlimited
+	lookup func(ctx context.Context, functionName string) (*int32, error)
+
+	mu       sync.Mutex
+	sems     map[string]*functionSemaphore // by the explicit limit, only while in use
+	reserved map[string]*reservation       // by the reserved concurrency, only of validated functions
+}
+
+type functionSemaphore struct {
+	sem  *semaphore
+	refs int
+}
+
+// reservation is the reserved concurrency of a function.
+// A failed lookup is not cached, and is retried after the backoff.
+type reservation struct {
+	mu       sync.Mutex
+	resolved bool
+	retryAt  time.Time
+	sem      *semaphore // nil means unlimited
+}
+
+// semaphore limits concurrent invocations. When it is full, up to depth requests wait for a release.
+type semaphore struct {
+	ch     chan struct{}
+	depth  int64
+	queued atomic.Int64
+}
+
+func newSemaphore(size, depth int) *semaphore {
+	return &semaphore{ch: make(chan struct{}, size), depth: int64(depth)}
+}
+
+// acquire reports whether the semaphore is acquired, waiting in the queue until ctx is done.
+func (s *semaphore) acquire(ctx context.Context) bool {
+	select {
+	case s.ch <- struct{}{}:
+		return true
+	default:
+	}
+	if s.queued.Add(1) > s.depth {
+		s.queued.Add(-1)
+		return false
+	}
+	defer s.queued.Add(-1)
+	select {
+	case s.ch <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (s *semaphore) release() {
+	<-s.ch
+}
+
+func newConcurrencyLimiter(cfg *Config, lookup func(context.Context, string) (*int32, error)) *concurrencyLimiter {
+	c := &concurrencyLimiter{
+		limit:    cfg.ConcurrencyPerFunction,
+		auto:     cfg.AutoConcurrency,
+		depth:    cfg.ConcurrencyQueueDepth,
+		lookup:   lookup,
+		sems:     make(map[string]*functionSemaphore),
+		reserved: make(map[string]*reservation),
+	}
+	if cfg.MaxConcurrency > 0 {
+		c.global = newSemaphore(cfg.MaxConcurrency, cfg.ConcurrencyQueueDepth)
+	}
+	if cfg.FunctionName != "*" {
+		// the fixed function is validated by the configuration
+		c.validated(cfg.FunctionName)
+	}
+	return c
+}
+
+// validated marks the function as existing, which enables the lookup of its reserved concurrency.
+// In wildcard mode, functions are validated by successful invocations, so that host names of
+// nonexistent functions neither grow the map nor cause lookups.
+func (c *concurrencyLimiter) validated(functionName string) {
+	if c.limit > 0 || !c.auto {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.reserved[functionName]; !ok {
+		c.reserved[functionName] = &reservation{}
+	}
+}
+
+// semaphore returns the semaphore of the function, and the function to call when the semaphore is no longer used.
+// The semaphores by the explicit limit are removed while not in use, as an idle one holds no state.
+func (c *concurrencyLimiter) semaphore(ctx context.Context, functionName string) (*semaphore, func()) {
+	if c.limit > 0 {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		s, ok := c.sems[functionName]
+		if !ok {
+			s = &functionSemaphore{sem: newSemaphore(c.limit, c.depth)}
+			c.sems[functionName] = s
+		}
+		s.refs++
+		return s.sem, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if s.refs--; s.refs == 0 {
+				delete(c.sems, functionName)
+			}
+		}
+	}
+	c.mu.Lock()
+	r, ok := c.reserved[functionName]
+	c.mu.Unlock()
+	if !ok {
+		return nil, func() {}
+	}
+	return r.semaphore(ctx, c, functionName), func() {}
+}
+
+// semaphore looks up the reserved concurrency unless resolved already or waiting for the backoff.
+// Concurrent callers wait for a running lookup.
+func (r *reservation) semaphore(ctx context.Context, c *concurrencyLimiter, functionName string) *semaphore {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.resolved || time.Now().Before(r.retryAt) {
+		return r.sem
+	}
+	// the lookup is not canceled by the client, as the result is shared
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), concurrencyLookupTimeout)
+	defer cancel()
+	reserved, err := c.lookup(ctx, functionName)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to get function concurrency", "error", err, "retry_after", concurrencyLookupBackoff)
+		r.retryAt = time.Now().Add(concurrencyLookupBackoff)
+		return nil
+	}
+	r.resolved = true
+	if reserved == nil {
+		// unreserved concurrency
+		return nil
+	}
+	size := int(*reserved)
+	slog.InfoContext(ctx, "concurrency limit by reserved concurrency", "limit", size)
+	r.sem = newSemaphore(size, c.depth)
+	return r.sem
+}
+
+// acquire acquires the semaphores of the function and of all functions, and returns the function to release them.
+// When a semaphore is full and its queue is full or ctx is done while waiting, a HandlerError with 503 is returned.
+func (c *concurrencyLimiter) acquire(ctx context.Context, functionName string) (func(), error) {
+	release := func() {}
+	if sem, done := c.semaphore(ctx, functionName); sem != nil {
+		if !sem.acquire(ctx) {
+			done()
+			return nil, concurrencyLimitError(fmt.Errorf("too many concurrent requests for %s (max %d)", functionName, cap(sem.ch)))
+		}
+		release = func() {
+			sem.release()
+			done()
+		}
+	}
+	if c.global != nil {
+		if !c.global.acquire(ctx) {
+			release()
+			return nil, concurrencyLimitError(fmt.Errorf("too many concurrent requests (max %d)", cap(c.global.ch)))
+		}
+		releaseFunction := release
+		release = func() {
+			c.global.release()
+			releaseFunction()
+		}
+	}
+	return release, nil
+}
+
+// concurrencyLimitError returns a HandlerError with 503 and Retry-After for the exceeded concurrency limit.
+// 429 is left to the rate limit and the throttling of Lambda.
+func concurrencyLimitError(err error) *HandlerError {
+	herr := newHandlerError(err, http.StatusServiceUnavailable)
+	herr.Header().Set("Retry-After", concurrencyRetryAfter)
+	return herr
+}
+
+// reservedConcurrency returns the reserved concurrency of the function, or nil if unreserved.
+func (l *Lamux) reservedConcurrency(ctx context.Context, functionName string) (*int32, error) {
+	arn, err := l.functionARN(ctx, functionName)
+	if err != nil {
+		return nil, err
+	}
+	out, err := l.lambdaClient.GetFunctionConcurrency(ctx, &lambda.GetFunctionConcurrencyInput{
+		FunctionName: aws.String(arn),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.ReservedConcurrentExecutions, nil
+}
@@ -0,0 +1,347 @@
+package lamux_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/fujiwara/lamux"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	cases := []struct {
+		name          string
+		limit         int
+		auto          bool
+		client        *mockClient
+		expectLimited bool
+		expectSize    int
+		expectLookups int
+	}{
+		{
+			name:          "reserved concurrency",
+			auto:          true,
+			client:        &mockClient{reservedConcurrency: aws.Int32(3)},
+			expectLimited: true,
+			expectSize:    3,
+			expectLookups: 1,
+		},
+		{
+			name:          "explicit limit takes precedence",
+			limit:         5,
+			auto:          true,
+			client:        &mockClient{reservedConcurrency: aws.Int32(3)},
+			expectLimited: true,
+			expectSize:    5,
+		},
+		{
+			name:          "unreserved",
+			auto:          true,
+			client:        &mockClient{},
+			expectLookups: 1,
+		},
+		{
+			name:          "lookup error",
+			auto:          true,
+			client:        &mockClient{getConcurrencyErr: errors.New("AccessDeniedException")},
+			expectLookups: 1,
+		},
+		{
+			name:   "disabled",
+			client: &mockClient{reservedConcurrency: aws.Int32(3)},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:           "test-func",
+				DomainSuffix:           "example.net",
+				UpstreamTimeout:        time.Second,
+				ConcurrencyPerFunction: tc.limit,
+				AutoConcurrency:        tc.auto,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.SetTestClient(tc.client)
+			for i := 0; i < 2; i++ { // cached
+				size, limited := app.ConcurrencyLimit(context.Background(), "test-func")
+				if limited != tc.expectLimited || size != tc.expectSize {
+					t.Errorf("expect limited=%v size=%d, got limited=%v size=%d", tc.expectLimited, tc.expectSize, limited, size)
+				}
+			}
+			if e, a := tc.expectLookups, tc.client.getConcurrencyRequests; e != a {
+				t.Errorf("expect %d lookups, got %d", e, a)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLookupRetry(t *testing.T) {
+	defer lamux.SetConcurrencyLookupBackoff(100 * time.Millisecond)()
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		AutoConcurrency: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{reservedConcurrency: aws.Int32(3), getConcurrencyErr: errors.New("ThrottlingException")}
+	app.SetTestClient(client)
+	for i := 0; i < 2; i++ { // waiting for the backoff
+		if _, limited := app.ConcurrencyLimit(context.Background(), "test-func"); limited {
+			t.Error("expect unlimited while the lookup fails")
+		}
+	}
+	if e, a := 1, client.getConcurrencyRequests; e != a {
+		t.Errorf("expect %d lookups, got %d", e, a)
+	}
+
+	client.getConcurrencyErr = nil
+	time.Sleep(150 * time.Millisecond)
+	if size, limited := app.ConcurrencyLimit(context.Background(), "test-func"); !limited || size != 3 {
+		t.Errorf("expect limited by 3 after the retry, got limited=%v size=%d", limited, size)
+	}
+	if e, a := 2, client.getConcurrencyRequests; e != a {
+		t.Errorf("expect %d lookups, got %d", e, a)
+	}
+}
+
+func TestConcurrencyLookupCanceledRequest(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		AutoConcurrency: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{reservedConcurrency: aws.Int32(3), checkContext: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if size, limited := app.ConcurrencyLimit(ctx, "test-func"); !limited || size != 3 {
+		t.Errorf("expect limited by 3 regardless of the canceled request, got limited=%v size=%d", limited, size)
+	}
+}
+
+func TestConcurrencyWildcard(t *testing.T) {
+	cases := []struct {
+		name          string
+		limit         int
+		auto          bool
+		expectLookups int
+	}{
+		{name: "reserved concurrency", auto: true, expectLookups: 1},
+		{name: "explicit limit", limit: 2},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:           "*",
+				DomainSuffix:           "example.net",
+				UpstreamTimeout:        time.Second,
+				ConcurrencyPerFunction: tc.limit,
+				AutoConcurrency:        tc.auto,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200, reservedConcurrency: aws.Int32(3)}
+			app.SetTestClient(client)
+			handler := app.Handler()
+			for i := 0; i < 10; i++ {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest("GET", fmt.Sprintf("http://test-nonexistent%d.example.net/", i), nil))
+				if w.Code != http.StatusNotFound {
+					t.Errorf("expect 404, got %d", w.Code)
+				}
+			}
+			if n := app.ConcurrencyEntries(); n != 0 {
+				t.Errorf("expect no entries for nonexistent functions, got %d", n)
+			}
+			if n := client.getConcurrencyRequests; n != 0 {
+				t.Errorf("expect no lookups for nonexistent functions, got %d", n)
+			}
+			for i := 0; i < 2; i++ {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest("GET", "http://test-test-func.example.net/", nil))
+				if w.Code != http.StatusOK {
+					t.Errorf("expect 200, got %d", w.Code)
+				}
+			}
+			if e, a := tc.expectLookups, client.getConcurrencyRequests; e != a {
+				t.Errorf("expect %d lookups after the function is validated, got %d", e, a)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimitBackpressure(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		AutoConcurrency: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200, latency: 300 * time.Millisecond, reservedConcurrency: aws.Int32(2)})
+	handler := app.Handler()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	codes := map[int]int{}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+			mu.Lock()
+			codes[w.Code]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if e, a := 2, codes[http.StatusOK]; e != a {
+		t.Errorf("expect %d requests succeeded, got %d (%v)", e, a, codes)
+	}
+	if e, a := 2, codes[http.StatusServiceUnavailable]; e != a {
+		t.Errorf("expect %d requests rejected, got %d (%v)", e, a, codes)
+	}
+}
+
+// concurrentCodes sends n concurrent requests and returns the counts of status codes.
+// Rejected requests must have Retry-After.
+func concurrentCodes(t *testing.T, handler http.Handler, n int) map[int]int {
+	t.Helper()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	codes := map[int]int{}
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+			if w.Code == http.StatusServiceUnavailable && w.Header().Get("Retry-After") != "1" {
+				t.Errorf("expect Retry-After 1, got %q", w.Header().Get("Retry-After"))
+			}
+			mu.Lock()
+			codes[w.Code]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return codes
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		MaxConcurrency:  2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200, latency: 300 * time.Millisecond})
+	codes := concurrentCodes(t, app.Handler(), 4)
+	if e, a := 2, codes[http.StatusOK]; e != a {
+		t.Errorf("expect %d requests succeeded, got %d (%v)", e, a, codes)
+	}
+	if e, a := 2, codes[http.StatusServiceUnavailable]; e != a {
+		t.Errorf("expect %d requests rejected, got %d (%v)", e, a, codes)
+	}
+}
+
+func TestConcurrencyQueue(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:           "test-func",
+		DomainSuffix:           "example.net",
+		UpstreamTimeout:        time.Second,
+		ConcurrencyPerFunction: 1,
+		ConcurrencyQueueDepth:  1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200, latency: 200 * time.Millisecond})
+	codes := concurrentCodes(t, app.Handler(), 3)
+	if e, a := 2, codes[http.StatusOK]; e != a {
+		t.Errorf("expect %d requests succeeded including the queued one, got %d (%v)", e, a, codes)
+	}
+	if e, a := 1, codes[http.StatusServiceUnavailable]; e != a {
+		t.Errorf("expect %d requests rejected, got %d (%v)", e, a, codes)
+	}
+}
+
+func TestConcurrencyRelease(t *testing.T) {
+	cases := []struct {
+		name   string
+		client *mockClient
+		code   int
+	}{
+		{name: "error", client: &mockClient{err: errors.New("TooManyRequestsException")}, code: http.StatusBadGateway},
+		{name: "timeout", client: &mockClient{code: 200, latency: time.Second}, code: http.StatusGatewayTimeout},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:           "test-func",
+				DomainSuffix:           "example.net",
+				UpstreamTimeout:        100 * time.Millisecond,
+				ConcurrencyPerFunction: 1,
+				MaxConcurrency:         1,
+				MetricsEnabled:         true,
+				MetricsPath:            "/metrics",
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.SetTestClient(tc.client)
+			handler := app.Handler()
+			for i := 0; i < 3; i++ {
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+				if e, a := tc.code, w.Code; e != a {
+					t.Errorf("request %d: expect %d, got %d", i, e, a)
+				}
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/metrics", nil))
+			if expect := `lamux_concurrent_invocations{alias="test",function_name="test-func"} 0`; !strings.Contains(w.Body.String(), expect) {
+				t.Errorf("metrics must contain %q", expect)
+			}
+		})
+	}
+}
+
+func TestConcurrencyValidation(t *testing.T) {
+	for name, modify := range map[string]func(*lamux.Config){
+		"negative max concurrency": func(cfg *lamux.Config) { cfg.MaxConcurrency = -1 },
+		"negative queue depth":     func(cfg *lamux.Config) { cfg.ConcurrencyQueueDepth = -1 },
+	} {
+		cfg := &lamux.Config{
+			FunctionName:    "test-func",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: time.Second,
+		}
+		modify(cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -3,77 +3,646 @@ package lamux
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
+	"path"
 	"regexp"
 	"strings"
 	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
 )
 
 var aliasRegexp = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
 var functionNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9-]+$`)
+var versionRegexp = regexp.MustCompile(`^[0-9]+$`)
+var regionRegexp = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+var domainLabelRegexp = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
 
 type Config struct {
 	Port            int           `help:"Port to listen on" default:"8080" env:"LAMUX_PORT" name:"port"`
 	FunctionName    string        `help:"Name of the Lambda function to proxy" default:"*" env:"LAMUX_FUNCTION_NAME" name:"function-name"`
 	DomainSuffix    string        `help:"Domain suffix to accept requests for" default:"localdomain" env:"LAMUX_DOMAIN_SUFFIX" name:"domain-suffix"`
 	UpstreamTimeout time.Duration `help:"Timeout for upstream requests" default:"30s" env:"LAMUX_UPSTREAM_TIMEOUT" name:"upstream-timeout"`
+	Region          string        `help:"AWS region of the Lambda functions (default: the region of the AWS config)" env:"LAMUX_REGION" name:"region"`
 	Version         bool          `help:"Show version information" name:"version"`
+	ConfigFile      string        `help:"Path to config file (YAML or JSON)" env:"LAMUX_CONFIG" name:"config"`
+	LogDestinations []string      `help:"Log destinations (stdout, stderr, syslog, syslog://host:port, syslog+tcp://host:port or file path)" default:"stdout" env:"LAMUX_LOG_DESTINATIONS" name:"log-destinations"`
+	LogLevel        string        `help:"Log level (debug, info, warn, error)" default:"info" env:"LAMUX_LOG_LEVEL" name:"log-level" enum:"debug,info,warn,error"`
+	LogFormat       string        `help:"Log format (json, combined)" default:"json" env:"LAMUX_LOG_FORMAT" name:"log-format" enum:"json,combined"`
+	LogFields       []string      `help:"Fields to include in logs (default: all fields)" env:"LAMUX_LOG_FIELDS" name:"log-fields"`
+
+	TrustedProxyCount           int                      `help:"Number of trusted proxies in front of lamux to derive the client IP from X-Forwarded-For" default:"0" env:"LAMUX_TRUSTED_PROXY_COUNT" name:"trusted-proxy-count"`
+	TrustedProxyCIDRs           []string                 `help:"Trusted proxy IP ranges (CIDR) to derive the client IP from X-Forwarded-For" env:"LAMUX_TRUSTED_PROXY_CIDRS" name:"trusted-proxy-cidrs"`
+	Listen                      []string                 `help:"Addresses to listen on (host:port, :port or unix:/path/to.sock; default: :{port})" env:"LAMUX_LISTEN" name:"listen"`
+	ProxyProtocol               bool                     `help:"Decode PROXY protocol (v1 and v2) headers to get client addresses behind load balancers" env:"LAMUX_PROXY_PROTOCOL" name:"proxy-protocol"`
+	ProxyProtocolStrict         bool                     `help:"Reject connections without PROXY protocol headers (default: accept them with their remote addresses)" env:"LAMUX_PROXY_PROTOCOL_STRICT" name:"proxy-protocol-strict"`
+	EnableH2C                   bool                     `help:"Accept cleartext HTTP/2 (h2c) connections" env:"LAMUX_ENABLE_H2C" name:"enable-h2c"`
+	ServeFaviconEmpty           bool                     `help:"Respond 204 No Content to /favicon.ico without invoking functions" env:"LAMUX_SERVE_FAVICON_EMPTY" name:"serve-favicon-empty"`
+	RobotsTxt                   string                   `help:"Body of /robots.txt served without invoking functions (empty to invoke functions)" env:"LAMUX_ROBOTS_TXT" name:"robots-txt"`
+	HealthCheckPath             string                   `help:"Path for health check endpoint (empty to disable)" default:"/healthz" env:"LAMUX_HEALTH_CHECK_PATH" name:"health-check-path"`
+	ReadinessCheckPath          string                   `help:"Path for readiness check endpoint responding 503 when the readiness probe fails (e.g. /readyz, empty to disable)" env:"LAMUX_READINESS_CHECK_PATH" name:"readiness-check-path"`
+	ReadinessProbe              string                   `help:"Upstream probe of the readiness check (none, sts: verify AWS credentials, invoke: invoke --readiness-probe-target)" default:"none" env:"LAMUX_READINESS_PROBE" name:"readiness-probe" enum:"none,sts,invoke"`
+	ReadinessProbeTarget        string                   `help:"Function and alias to invoke by the invoke readiness probe ({function}:{alias})" env:"LAMUX_READINESS_PROBE_TARGET" name:"readiness-probe-target"`
+	ReadinessProbeTTL           time.Duration            `help:"Duration to cache the result of the readiness probe" default:"10s" env:"LAMUX_READINESS_PROBE_TTL" name:"readiness-probe-ttl"`
+	ColdStartIdleTimeout        time.Duration            `help:"Classify timeouts of functions not responded since startup or for this duration as cold starts (0 to disable)" default:"0" env:"LAMUX_COLD_START_IDLE_TIMEOUT" name:"cold-start-idle-timeout"`
+	LambdaClientTimeout         time.Duration            `help:"Timeout of each HTTP request to the Lambda API regardless of --upstream-timeout (0 means no timeout)" default:"0" env:"LAMUX_LAMBDA_CLIENT_TIMEOUT" name:"lambda-client-timeout"`
+	CredentialWaitTimeout       time.Duration            `help:"Wait for AWS credentials to be available at startup up to this duration (0 means no wait)" default:"0" env:"LAMUX_CREDENTIAL_WAIT_TIMEOUT" name:"credential-wait-timeout"`
+	RequestReadTimeout          time.Duration            `help:"Timeout for reading request bodies from clients (0 means unlimited)" default:"0" env:"LAMUX_REQUEST_READ_TIMEOUT" name:"request-read-timeout"`
+	MaxRequestDuration          time.Duration            `help:"Maximum duration of each request including reading the body, invoking and writing the response (0 means unlimited)" default:"0" env:"LAMUX_MAX_REQUEST_DURATION" name:"max-request-duration"`
+	ServerReadTimeout           time.Duration            `help:"Timeout of the HTTP server for reading entire requests including bodies (0 means unlimited)" default:"0" env:"LAMUX_SERVER_READ_TIMEOUT" name:"server-read-timeout"`
+	ServerReadHeaderTimeout     time.Duration            `help:"Timeout of the HTTP server for reading request headers (0 means --server-read-timeout)" default:"10s" env:"LAMUX_SERVER_READ_HEADER_TIMEOUT" name:"server-read-header-timeout"`
+	ServerWriteTimeout          time.Duration            `help:"Timeout of the HTTP server from the end of reading request headers to writing responses (0 means unlimited, must be longer than upstream timeouts)" default:"0" env:"LAMUX_SERVER_WRITE_TIMEOUT" name:"server-write-timeout"`
+	ServerIdleTimeout           time.Duration            `help:"Timeout of the HTTP server for idle keep-alive connections (0 means --server-read-timeout)" default:"120s" env:"LAMUX_SERVER_IDLE_TIMEOUT" name:"server-idle-timeout"`
+	MaxHeaderBytes              int                      `help:"Maximum size of the request line and headers in bytes, rejected by 431 (0 means 1048576)" default:"1048576" env:"LAMUX_MAX_HEADER_BYTES" name:"max-header-bytes"`
+	CacheEnabled                bool                     `help:"Cache responses of functions to GET and HEAD requests in memory" env:"LAMUX_CACHE_ENABLED" name:"cache-enabled"`
+	CacheDefaultTTL             time.Duration            `help:"TTL of cached responses without max-age in Cache-Control (0 means not cached)" default:"0" env:"LAMUX_CACHE_DEFAULT_TTL" name:"cache-default-ttl"`
+	CacheMaxBytes               int64                    `help:"Maximum total bytes of cached responses, evicted by LRU" default:"67108864" env:"LAMUX_CACHE_MAX_BYTES" name:"cache-max-bytes"`
+	Backend                     string                   `help:"Backend to invoke functions (invoke: Invoke API, function-url: HTTP requests to Function URLs)" default:"invoke" env:"LAMUX_BACKEND" name:"backend" enum:"invoke,function-url"`
+	FunctionURLTemplate         string                   `help:"Template of Function URLs for --backend=function-url (e.g. https://{alias}-{function}.example.com)" env:"LAMUX_FUNCTION_URL_TEMPLATE" name:"function-url-template"`
+	FunctionURLAuth             string                   `help:"Auth type of Function URLs (none, iam: sign requests by SigV4)" default:"none" env:"LAMUX_FUNCTION_URL_AUTH" name:"function-url-auth" enum:"none,iam"`
+	FunctionARNTemplate         string                   `help:"Template to expand function names to ARNs (e.g. arn:aws:lambda:{region}:{account}:function:{function})" env:"LAMUX_FUNCTION_ARN_TEMPLATE" name:"function-arn-template"`
+	ResolveAccountID            bool                     `help:"Resolve the account ID by STS at startup to log it and invoke functions by full ARNs" env:"LAMUX_RESOLVE_ACCOUNT_ID" name:"resolve-account-id"`
+	ValidateFunctions           bool                     `help:"Validate that the function and its aliases exist, and exit without starting the server" name:"validate"`
+	FunctionTimeouts            map[string]time.Duration `help:"Upstream timeouts per function (func1=10s;func2=5m)" env:"LAMUX_FUNCTION_TIMEOUTS" name:"function-timeouts"`
+	AllowedMethods              map[string]string        `help:"Allowed HTTP methods per function (func1=GET,HEAD;func2=POST)" env:"LAMUX_ALLOWED_METHODS" name:"allowed-methods"`
+	AllowedPaths                map[string]string        `help:"Allowed path pattern (regular expression) per function (func1=^/api/;func2=^/(v1|v2)/)" env:"LAMUX_ALLOWED_PATHS" name:"allowed-paths"`
+	Verbose404                  bool                     `help:"List the valid routes (aliases in --alias-map, --allowed-paths and --allowed-methods) in 404 responses for development" env:"LAMUX_VERBOSE_404" name:"verbose-404"`
+	StrictConfigValidation      bool                     `help:"Fail to start on ambiguous routing configurations instead of logging warnings" env:"LAMUX_STRICT_CONFIG_VALIDATION" name:"strict-config-validation"`
+	AllowSuspiciousHost         bool                     `help:"Allow hosts containing control characters, spaces or more than one colon" env:"LAMUX_ALLOW_SUSPICIOUS_HOST" name:"allow-suspicious-host"`
+	DenyHosts                   []string                 `help:"Host patterns to reject with 400 (glob patterns matched by path.Match)" default:"localhost,127.*,169.254.*" env:"LAMUX_DENY_HOSTS" name:"deny-hosts"`
+	TrustForwardedPort          bool                     `help:"Reflect X-Forwarded-Port to the Host header forwarded to functions" env:"LAMUX_TRUST_FORWARDED_PORT" name:"trust-forwarded-port"`
+	DefaultAlias                string                   `help:"Alias for requests to the domain suffix itself with a fixed function name (empty to reject them)" env:"LAMUX_DEFAULT_ALIAS" name:"default-alias"`
+	AliasPattern                string                   `help:"Regular expression of aliases in host names (default: ^[a-zA-Z0-9]+$)" env:"LAMUX_ALIAS_PATTERN" name:"alias-pattern"`
+	FunctionNamePattern         string                   `help:"Regular expression of function names in host names (default: ^[a-zA-Z0-9-]+$)" env:"LAMUX_FUNCTION_NAME_PATTERN" name:"function-name-pattern"`
+	AliasMap                    map[string]string        `help:"Map aliases in host names to real Lambda aliases (key1=value1;key2=value2)" env:"LAMUX_ALIAS_MAP" name:"alias-map"`
+	StrictAlias                 bool                     `help:"Reject aliases not in --alias-map" env:"LAMUX_STRICT_ALIAS" name:"strict-alias"`
+	WeightedAliasCookie         string                   `help:"Cookie name to keep the alias chosen by weighted-aliases sticky" env:"LAMUX_WEIGHTED_ALIAS_COOKIE" name:"weighted-alias-cookie"`
+	WeightedAliasCookieTTL      time.Duration            `help:"Lifetime of the sticky cookie of weighted-aliases (0 means the browser session)" default:"24h" env:"LAMUX_WEIGHTED_ALIAS_COOKIE_TTL" name:"weighted-alias-cookie-ttl"`
+	ReloadOnSIGHUP              bool                     `help:"Reload --alias-map, --strict-alias, --allowed-methods and --allowed-paths on SIGHUP" env:"LAMUX_RELOAD_ON_SIGHUP" name:"reload-on-sighup"`
+	CostCenterByAlias           map[string]string        `help:"Cost centers per alias forwarded by X-Cost-Center header (alias1=team-a;alias2=team-b)" env:"LAMUX_COST_CENTER_BY_ALIAS" name:"cost-center-by-alias"`
+	Qualifier                   string                   `help:"Override qualifier (version number or alias) for all requests" env:"LAMUX_QUALIFIER" name:"qualifier"`
+	AllowQualifierHeader        bool                     `help:"Allow overriding qualifier by X-Lamux-Qualifier request header" env:"LAMUX_ALLOW_QUALIFIER_HEADER" name:"allow-qualifier-header"`
+	InvocationTypes             map[string]string        `help:"Invocation types per function (func1=Event;func2=RequestResponse)" env:"LAMUX_INVOCATION_TYPES" name:"invocation-types"`
+	AllowInvocationTypeHeader   bool                     `help:"Allow selecting the invocation type by X-Lamux-Invocation-Type request header" env:"LAMUX_ALLOW_INVOCATION_TYPE_HEADER" name:"allow-invocation-type-header"`
+	RateLimitSourceHeader       bool                     `help:"Add X-Lamux-RateLimit-Source header to 429 responses" env:"LAMUX_RATE_LIMIT_SOURCE_HEADER" name:"rate-limit-source-header"`
+	PayloadLogSampleRate        float64                  `help:"Fraction of requests (0.0-1.0) to log the request and response payloads" default:"0" env:"LAMUX_PAYLOAD_LOG_SAMPLE_RATE" name:"payload-log-sample-rate"`
+	PayloadLogRedactHeaders     []string                 `help:"Headers to be redacted in logged payloads" default:"Authorization,Proxy-Authorization,Cookie,Set-Cookie,X-Api-Key" env:"LAMUX_PAYLOAD_LOG_REDACT_HEADERS" name:"payload-log-redact-headers"`
+	RichReadiness               bool                     `help:"Include recent invoke latency stats in health check response" env:"LAMUX_RICH_READINESS" name:"rich-readiness"`
+	MetricsEnabled              bool                     `help:"Enable Prometheus metrics endpoint" env:"LAMUX_METRICS_ENABLED" name:"metrics-enabled"`
+	MetricsPath                 string                   `help:"Path for Prometheus metrics endpoint" default:"/metrics" env:"LAMUX_METRICS_PATH" name:"metrics-path"`
+	HopByHopHeaders             []string                 `help:"Hop-by-hop headers to be removed from requests and responses (default: RFC 7230 hop-by-hop headers)" env:"LAMUX_HOP_BY_HOP_HEADERS" name:"hop-by-hop-headers"`
+	ForwardHeaders              []string                 `help:"Request headers to forward to functions (default: all headers)" env:"LAMUX_FORWARD_HEADERS" name:"forward-headers"`
+	DropHeaders                 []string                 `help:"Request headers not to forward to functions" env:"LAMUX_DROP_HEADERS" name:"drop-headers"`
+	PromoteQueryParams          []string                 `help:"Query parameters to copy into the lamux object in the invoke payload" env:"LAMUX_PROMOTE_QUERY_PARAMS" name:"promote-query-params"`
+	CollapseRequestHeaders      bool                     `help:"Join repeated request headers into a single value" env:"LAMUX_COLLAPSE_REQUEST_HEADERS" name:"collapse-request-headers"`
+	AllowedResponseContentTypes []string                 `help:"Content types allowed to be returned by functions (e.g. application/json,image/*)" env:"LAMUX_ALLOWED_RESPONSE_CONTENT_TYPES" name:"allowed-response-content-types"`
+	ResponseHeaders             map[string]string        `help:"Headers to add to responses of functions (Strict-Transport-Security=max-age=63072000;X-Content-Type-Options=nosniff)" env:"LAMUX_RESPONSE_HEADERS" name:"response-headers"`
+	OverrideResponseHeaders     bool                     `help:"Override the headers set by functions with --response-headers" env:"LAMUX_OVERRIDE_RESPONSE_HEADERS" name:"override-response-headers"`
+	StatusCodeOverrides         map[int]int              `help:"Override status codes returned by functions (502=503;500=503)" env:"LAMUX_STATUS_CODE_OVERRIDES" name:"status-code-overrides"`
+	MaxResponseHeaderCount      int                      `help:"Maximum number of response headers from the function (0 means unlimited)" default:"0" env:"LAMUX_MAX_RESPONSE_HEADER_COUNT" name:"max-response-header-count"`
+	ConcurrencyPerFunction      int                      `help:"Maximum concurrent invocations per function (0 means unlimited)" default:"0" env:"LAMUX_CONCURRENCY_PER_FUNCTION" name:"concurrency-per-function"`
+	RateLimit                   float64                  `help:"Requests per second allowed per alias of each function before returning 429 (0 means unlimited)" default:"0" env:"LAMUX_RATE_LIMIT" name:"rate-limit"`
+	RateLimitByAlias            map[string]float64       `help:"Requests per second per alias overriding --rate-limit (alias1=10;alias2=0.5)" env:"LAMUX_RATE_LIMIT_BY_ALIAS" name:"rate-limit-by-alias"`
+	RateLimitBurst              int                      `help:"Maximum burst of requests allowed by rate limits (0 means the rate rounded up)" default:"0" env:"LAMUX_RATE_LIMIT_BURST" name:"rate-limit-burst"`
+	RateLimitKey                string                   `help:"Key of rate limits (alias or client-ip)" default:"alias" env:"LAMUX_RATE_LIMIT_KEY" name:"rate-limit-key" enum:"alias,client-ip"`
+	AutoConcurrency             bool                     `help:"Use the reserved concurrency of the function as the concurrency limit when --concurrency-per-function is not set" env:"LAMUX_AUTO_CONCURRENCY" name:"auto-concurrency"`
+	MaxConcurrency              int                      `help:"Maximum concurrent invocations of all functions before returning 503 (0 means unlimited)" default:"0" env:"LAMUX_MAX_CONCURRENCY" name:"max-concurrency"`
+	ConcurrencyQueueDepth       int                      `help:"Maximum requests waiting for the concurrency limits before rejected (0 means rejected immediately)" default:"0" env:"LAMUX_CONCURRENCY_QUEUE_DEPTH" name:"concurrency-queue-depth"`
+	CircuitBreakerThreshold     int                      `help:"Consecutive failures of a function and alias to open the circuit breaker (0 means disabled)" default:"0" env:"LAMUX_CIRCUIT_BREAKER_THRESHOLD" name:"circuit-breaker-threshold"`
+	CircuitBreakerCooldown      time.Duration            `help:"Duration to keep the circuit breaker open before a trial invocation" default:"30s" env:"LAMUX_CIRCUIT_BREAKER_COOLDOWN" name:"circuit-breaker-cooldown"`
+	MaxInFlightBodyBytes        int64                    `help:"Maximum total bytes of request bodies buffered concurrently (0 means unlimited)" default:"0" env:"LAMUX_MAX_IN_FLIGHT_BODY_BYTES" name:"max-in-flight-body-bytes"`
+	TimeoutBodyFile             string                   `help:"File to serve as the response body on upstream timeouts" env:"LAMUX_TIMEOUT_BODY_FILE" name:"timeout-body-file"`
+	ErrorResponseFormat         string                   `help:"Format of error response bodies (text, json)" default:"text" env:"LAMUX_ERROR_RESPONSE_FORMAT" name:"error-response-format" enum:"text,json"`
+	HideErrorDetails            bool                     `help:"Return generic messages in error responses instead of error details (details are still logged)" env:"LAMUX_HIDE_ERROR_DETAILS" name:"hide-error-details"`
+	StreamThresholdBytes        int64                    `help:"Stream response bodies larger than this size in bytes, and write smaller ones with Content-Length (0 means disabled)" default:"0" env:"LAMUX_STREAM_THRESHOLD_BYTES" name:"stream-threshold-bytes"`
+	SSEPassthrough              bool                     `help:"Flush Server-Sent Events (text/event-stream) responses incrementally and disable buffering by proxies" env:"LAMUX_SSE_PASSTHROUGH" name:"sse-passthrough"`
+	CompressResponses           bool                     `help:"Compress responses by gzip or deflate accepted by clients" env:"LAMUX_COMPRESS_RESPONSES" name:"compress-responses"`
+	CompressMinSize             int                      `help:"Minimum body size in bytes to compress responses" default:"1024" env:"LAMUX_COMPRESS_MIN_SIZE" name:"compress-min-size"`
+	RawPayloadPassthrough       bool                     `help:"Forward the request body verbatim as the invoke payload and return the raw response payload" env:"LAMUX_RAW_PAYLOAD_PASSTHROUGH" name:"raw-payload-passthrough"`
+	RawPayloadContentType       string                   `help:"Content-Type of raw responses" default:"application/json" env:"LAMUX_RAW_PAYLOAD_CONTENT_TYPE" name:"raw-payload-content-type"`
+	RawResponseFallback         bool                     `help:"Return the payload as JSON with 200 when the function returns a payload which is not a response object (otherwise 502)" env:"LAMUX_RAW_RESPONSE_FALLBACK" name:"raw-response-fallback"`
+	MaxPayloadSize              int64                    `help:"Maximum size of the invoke payload in bytes (0 means unlimited)" default:"6291456" env:"LAMUX_MAX_PAYLOAD_SIZE" name:"max-payload-size"`
+	LargePayloadThreshold       int64                    `help:"Invoke the function asynchronously and return 202 when the invoke payload exceeds this size in bytes (0 means disabled)" default:"0" env:"LAMUX_LARGE_PAYLOAD_THRESHOLD" name:"large-payload-threshold"`
+	LargePayloadFunction        string                   `help:"Name of the Lambda function to invoke asynchronously for large payloads (default is the same as the request)" env:"LAMUX_LARGE_PAYLOAD_FUNCTION" name:"large-payload-function"`
+	ShadowFunction              string                   `help:"Name of the Lambda function to receive a copy of each request" env:"LAMUX_SHADOW_FUNCTION" name:"shadow-function"`
+	ShadowAlias                 string                   `help:"Alias of the shadow function (default is the same as the request)" env:"LAMUX_SHADOW_ALIAS" name:"shadow-alias"`
+	WarmupTargets               []string                 `help:"Function and alias pairs to invoke periodically to keep warm (func1:alias1,func2:alias2)" env:"LAMUX_WARMUP_TARGETS" name:"warmup-targets"`
+	WarmupInterval              time.Duration            `help:"Interval of warmup invocations" default:"5m" env:"LAMUX_WARMUP_INTERVAL" name:"warmup-interval"`
+	SelfFunctionName            string                   `help:"Name of the Lambda function running Lamux to detect recursive calls (default is $AWS_LAMBDA_FUNCTION_NAME)" env:"LAMUX_SELF_FUNCTION_NAME" name:"self-function-name"`
+	DisableLoopDetection        bool                     `help:"Disable detecting recursive calls and requests looping through Lamux" env:"LAMUX_DISABLE_LOOP_DETECTION" name:"disable-loop-detection"`
 
 	TraceConfig
+	JWTConfig
+	BasicAuthConfig
+	SignatureConfig
+	CORSConfig
+	IPFilterConfig
+	GeoConfig
+	CSPConfig
+	MetricConfig
+
+	// configurable only by the config file
+	AliasResponseHeaders map[string]map[string]string `kong:"-" yaml:"alias-response-headers"`
+	WeightedAliases      map[string]map[string]int    `kong:"-" yaml:"weighted-aliases"`
+	ResponseRewrites     []ResponseRewriteRule        `kong:"-" yaml:"response-rewrites"`
+
+	// compiled by Validate
+	aliasRe        *regexp.Regexp `kong:"-"`
+	functionNameRe *regexp.Regexp `kong:"-"`
 }
 
 func (cfg *Config) Validate() error {
 	if cfg.Port < 0 {
 		return fmt.Errorf("port must not be negative")
 	}
+	if _, err := cfg.listenAddresses(); err != nil {
+		return err
+	}
+	if _, err := parseLogLevel(cfg.LogLevel); err != nil {
+		return err
+	}
+	switch cfg.LogFormat {
+	case "", "json", "combined":
+	default:
+		return fmt.Errorf("invalid log format %s (json or combined allowed)", cfg.LogFormat)
+	}
 	if cfg.FunctionName == "" {
 		return fmt.Errorf("function name must be set")
 	}
 	if cfg.FunctionName != "*" && !functionNameRegexp.MatchString(cfg.FunctionName) {
 		return fmt.Errorf("invalid function name (%s allowed)", functionNameRegexp.String())
 	}
+	if err := cfg.compileHostPatterns(); err != nil {
+		return err
+	}
 	if cfg.DomainSuffix == "" {
 		return fmt.Errorf("domain suffix must be set")
 	}
+	if err := validateDomainSuffix(cfg.DomainSuffix); err != nil {
+		return err
+	}
+	if err := cfg.checkAmbiguousRouting(); err != nil {
+		if cfg.StrictConfigValidation {
+			return err
+		}
+		slog.Warn("ambiguous routing configuration", "error", err)
+	}
 	if cfg.UpstreamTimeout <= 0 {
 		return fmt.Errorf("upstream timeout must be greater than 0")
 	}
+	if cfg.Region != "" && !regionRegexp.MatchString(cfg.Region) {
+		return fmt.Errorf("invalid region: %s", cfg.Region)
+	}
+	if cfg.ColdStartIdleTimeout < 0 {
+		return fmt.Errorf("cold start idle timeout must not be negative")
+	}
+	if cfg.LambdaClientTimeout < 0 {
+		return fmt.Errorf("lambda client timeout must not be negative")
+	}
+	if cfg.CredentialWaitTimeout < 0 {
+		return fmt.Errorf("credential wait timeout must not be negative")
+	}
+	if cfg.RequestReadTimeout < 0 {
+		return fmt.Errorf("request read timeout must not be negative")
+	}
+	if cfg.MaxRequestDuration < 0 {
+		return fmt.Errorf("max request duration must not be negative")
+	}
+	if err := cfg.validateServerTimeouts(); err != nil {
+		return err
+	}
+	if cfg.MaxHeaderBytes < 0 {
+		return fmt.Errorf("max header bytes must not be negative")
+	}
+	for k, v := range cfg.FunctionTimeouts {
+		if !cfg.hostFunctionNameRegexp().MatchString(k) {
+			return fmt.Errorf("invalid function name in function timeouts: %s", k)
+		}
+		if v <= 0 {
+			return fmt.Errorf("function timeout for %s must be greater than 0", k)
+		}
+	}
+	for _, p := range cfg.DenyHosts {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid deny host pattern %s: %w", p, err)
+		}
+	}
+	if _, err := newRouteFilter(cfg); err != nil {
+		return err
+	}
+	if cfg.CacheEnabled {
+		if cfg.CacheDefaultTTL < 0 {
+			return fmt.Errorf("cache default TTL must not be negative")
+		}
+		if cfg.CacheMaxBytes <= 0 {
+			return fmt.Errorf("cache max bytes must be greater than 0")
+		}
+		if cfg.InjectCSPNonce {
+			return fmt.Errorf("cache cannot be enabled with CSP nonce injection, which requires a unique nonce per response")
+		}
+	}
+	if len(cfg.PromoteQueryParams) > 0 {
+		if err := cfg.validatePromoteQueryParams(); err != nil {
+			return err
+		}
+	}
+	switch cfg.Backend {
+	case "", backendInvoke:
+	case backendFunctionURL:
+		if cfg.FunctionURLTemplate == "" {
+			return fmt.Errorf("function URL template must be set for %s backend", backendFunctionURL)
+		}
+	default:
+		return fmt.Errorf("invalid backend: %s (%s or %s allowed)", cfg.Backend, backendInvoke, backendFunctionURL)
+	}
+	if cfg.FunctionURLTemplate != "" {
+		if err := validateFunctionURLTemplate(cfg.FunctionURLTemplate); err != nil {
+			return err
+		}
+	}
+	switch cfg.FunctionURLAuth {
+	case "", "none", "iam":
+	default:
+		return fmt.Errorf("invalid function URL auth: %s (none or iam allowed)", cfg.FunctionURLAuth)
+	}
+	if cfg.FunctionARNTemplate != "" {
+		if err := validateFunctionARNTemplate(cfg.FunctionARNTemplate); err != nil {
+			return err
+		}
+	}
+	if cfg.DefaultAlias != "" {
+		if cfg.FunctionName == "*" {
+			return fmt.Errorf("default alias cannot be used with the wildcard function name")
+		}
+		if !cfg.hostAliasRegexp().MatchString(cfg.DefaultAlias) {
+			return fmt.Errorf("invalid default alias %s (%s allowed)", cfg.DefaultAlias, cfg.hostAliasRegexp().String())
+		}
+	}
+	for k, v := range cfg.AliasMap {
+		if !cfg.hostAliasRegexp().MatchString(k) {
+			return fmt.Errorf("invalid alias map key %s (%s allowed)", k, cfg.hostAliasRegexp().String())
+		}
+		if err := checkLowerAlias("alias map key", k); err != nil {
+			return err
+		}
+		if !aliasRegexp.MatchString(v) {
+			return fmt.Errorf("invalid alias map value %s (%s allowed)", v, aliasRegexp.String())
+		}
+	}
+	if err := cfg.validateWeightedAliases(); err != nil {
+		return err
+	}
+	if err := cfg.validateResponseRewrites(); err != nil {
+		return err
+	}
+	for k, v := range cfg.CostCenterByAlias {
+		if !cfg.hostAliasRegexp().MatchString(k) {
+			return fmt.Errorf("invalid alias in cost center by alias: %s (%s allowed)", k, cfg.hostAliasRegexp().String())
+		}
+		if err := checkLowerAlias("alias in cost center by alias", k); err != nil {
+			return err
+		}
+		if v == "" {
+			return fmt.Errorf("cost center for %s must not be empty", k)
+		}
+	}
+	if cfg.Qualifier != "" && !isValidQualifier(cfg.Qualifier) {
+		return fmt.Errorf("invalid qualifier (%s or %s allowed)", versionRegexp.String(), aliasRegexp.String())
+	}
+	for k, v := range cfg.InvocationTypes {
+		if !cfg.hostFunctionNameRegexp().MatchString(k) {
+			return fmt.Errorf("invalid function name in invocation types: %s", k)
+		}
+		if _, err := parseInvocationType(v); err != nil {
+			return fmt.Errorf("invalid invocation type for %s: %w", k, err)
+		}
+	}
+	if cfg.HealthCheckPath != "" && !isValidLocalPath(cfg.HealthCheckPath) {
+		return fmt.Errorf("invalid health check path: %s", cfg.HealthCheckPath)
+	}
+	if err := cfg.validateReadiness(); err != nil {
+		return err
+	}
+	if cfg.MetricsEnabled {
+		if !isValidLocalPath(cfg.MetricsPath) {
+			return fmt.Errorf("invalid metrics path: %s", cfg.MetricsPath)
+		}
+		if cfg.MetricsPath == cfg.HealthCheckPath {
+			return fmt.Errorf("metrics path must be different from health check path")
+		}
+	}
+	for _, p := range cfg.localPaths() {
+		if p == cfg.HealthCheckPath || (cfg.MetricsEnabled && p == cfg.MetricsPath) {
+			return fmt.Errorf("%s is served locally and must not be used for health check or metrics path", p)
+		}
+	}
+	if err := validateContentTypes(cfg.AllowedResponseContentTypes); err != nil {
+		return fmt.Errorf("invalid allowed response content types: %w", err)
+	}
+	if err := validateResponseHeaders(cfg.ResponseHeaders); err != nil {
+		return fmt.Errorf("invalid response headers: %w", err)
+	}
+	for alias, headers := range cfg.AliasResponseHeaders {
+		if err := validateResponseHeaders(headers); err != nil {
+			return fmt.Errorf("invalid response headers for alias %s: %w", alias, err)
+		}
+	}
+	for k, v := range cfg.StatusCodeOverrides {
+		if !isValidStatusCode(k) || !isValidStatusCode(v) {
+			return fmt.Errorf("invalid status code override %d=%d (100-599 allowed)", k, v)
+		}
+	}
+	if cfg.PayloadLogSampleRate < 0 || cfg.PayloadLogSampleRate > 1 {
+		return fmt.Errorf("payload log sample rate must be between 0 and 1")
+	}
+	if cfg.MaxResponseHeaderCount < 0 {
+		return fmt.Errorf("max response header count must not be negative")
+	}
+	if cfg.ConcurrencyPerFunction < 0 {
+		return fmt.Errorf("concurrency per function must not be negative")
+	}
+	if cfg.MaxConcurrency < 0 {
+		return fmt.Errorf("max concurrency must not be negative")
+	}
+	if cfg.ConcurrencyQueueDepth < 0 {
+		return fmt.Errorf("concurrency queue depth must not be negative")
+	}
+	if cfg.RateLimit < 0 {
+		return fmt.Errorf("rate limit must not be negative")
+	}
+	for k, v := range cfg.RateLimitByAlias {
+		if !cfg.hostAliasRegexp().MatchString(k) {
+			return fmt.Errorf("invalid alias in rate limit by alias: %s", k)
+		}
+		if err := checkLowerAlias("alias in rate limit by alias", k); err != nil {
+			return err
+		}
+		if v < 0 {
+			return fmt.Errorf("rate limit for %s must not be negative", k)
+		}
+	}
+	if cfg.RateLimitBurst < 0 {
+		return fmt.Errorf("rate limit burst must not be negative")
+	}
+	switch cfg.RateLimitKey {
+	case "", rateLimitKeyAlias, rateLimitKeyClientIP:
+	default:
+		return fmt.Errorf("invalid rate limit key %s (%s or %s allowed)", cfg.RateLimitKey, rateLimitKeyAlias, rateLimitKeyClientIP)
+	}
+	if cfg.CircuitBreakerThreshold < 0 {
+		return fmt.Errorf("circuit breaker threshold must not be negative")
+	}
+	if cfg.CircuitBreakerThreshold > 0 && cfg.CircuitBreakerCooldown <= 0 {
+		return fmt.Errorf("circuit breaker cooldown must be greater than 0")
+	}
+	if cfg.MaxInFlightBodyBytes < 0 {
+		return fmt.Errorf("max in-flight body bytes must not be negative")
+	}
+	switch cfg.ErrorResponseFormat {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("invalid error response format %s (text or json allowed)", cfg.ErrorResponseFormat)
+	}
+	if cfg.StreamThresholdBytes < 0 {
+		return fmt.Errorf("stream threshold bytes must not be negative")
+	}
+	if cfg.CompressMinSize < 0 {
+		return fmt.Errorf("compress min size must not be negative")
+	}
+	if cfg.MaxPayloadSize < 0 {
+		return fmt.Errorf("max payload size must not be negative")
+	}
+	if cfg.LargePayloadThreshold < 0 {
+		return fmt.Errorf("large payload threshold must not be negative")
+	}
+	if cfg.LargePayloadFunction != "" && !functionNameRegexp.MatchString(cfg.LargePayloadFunction) {
+		return fmt.Errorf("invalid large payload function name (%s allowed)", functionNameRegexp.String())
+	}
+	if cfg.ShadowFunction != "" && !functionNameRegexp.MatchString(cfg.ShadowFunction) {
+		return fmt.Errorf("invalid shadow function name (%s allowed)", functionNameRegexp.String())
+	}
+	if cfg.ShadowAlias != "" && !isValidQualifier(cfg.ShadowAlias) {
+		return fmt.Errorf("invalid shadow alias (%s or %s allowed)", versionRegexp.String(), aliasRegexp.String())
+	}
+	for _, s := range cfg.WarmupTargets {
+		if _, err := parseWarmupTarget(s); err != nil {
+			return err
+		}
+	}
+	if len(cfg.WarmupTargets) > 0 && cfg.WarmupInterval <= 0 {
+		return fmt.Errorf("warmup interval must be greater than 0")
+	}
+	if cfg.TrustedProxyCount < 0 {
+		return fmt.Errorf("trusted proxy count must not be negative")
+	}
+	if _, err := parsePrefixes(cfg.TrustedProxyCIDRs); err != nil {
+		return fmt.Errorf("invalid trusted proxy cidrs: %w", err)
+	}
+	if err := cfg.IPFilterConfig.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.GeoConfig.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.TraceConfig.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.MetricConfig.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.CSPConfig.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.CORSConfig.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.BasicAuthConfig.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.SignatureConfig.Validate(); err != nil {
+		return err
+	}
+	if err := cfg.JWTConfig.Validate(); err != nil {
+		return err
+	}
 	return nil
 }
 
+// routedHost returns the host to route the request by, X-Forwarded-Host or Host.
+func routedHost(r *http.Request) string {
+	if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+		return host
+	}
+	return r.Host
+}
+
 func (cfg *Config) ExtractAliasAndFunctionName(_ context.Context, r *http.Request) (string, string, error) {
-	var host string
-	if host = r.Header.Get("X-Forwarded-Host"); host == "" {
-		host = r.Host
+	host := routedHost(r)
+	if !cfg.AllowSuspiciousHost {
+		if err := checkHost(host); err != nil {
+			return "", "", err
+		}
 	}
 	if raw, _, err := net.SplitHostPort(host); err == nil {
 		host = raw
 	}
-	if !strings.HasSuffix(host, cfg.DomainSuffix) {
+	// host names are case-insensitive, and the original host is still logged
+	host, err := toASCIIHost(strings.ToLower(host))
+	if err != nil {
+		return "", "", fmt.Errorf("invalid host: %w", err)
+	}
+	if err := cfg.checkDenyHosts(host); err != nil {
+		return "", "", err
+	}
+	suffix := cfg.domainSuffix()
+	if host == suffix && cfg.FunctionName != "*" && cfg.DefaultAlias != "" {
+		return cfg.DefaultAlias, cfg.FunctionName, nil
+	}
+	// the domain suffix must follow a dot, and only a single label is allowed before it
+	target, ok := strings.CutSuffix(host, "."+suffix)
+	if !ok {
 		return "", "", fmt.Errorf("invalid domain suffix (must be %s)", cfg.DomainSuffix)
 	}
+	if strings.Contains(target, ".") {
+		return "", "", fmt.Errorf("invalid host name: only a single label is allowed before %s", cfg.DomainSuffix)
+	}
 
 	if cfg.FunctionName != "*" { // fixed function name
-		alias := strings.TrimSuffix(host, "."+cfg.DomainSuffix)
-		if !aliasRegexp.MatchString(alias) {
-			return "", "", fmt.Errorf("invalid alias (%s allowed)", aliasRegexp.String())
+		alias := target
+		if !cfg.hostAliasRegexp().MatchString(alias) {
+			return "", "", fmt.Errorf("invalid alias (%s allowed)", cfg.hostAliasRegexp().String())
 		}
 		return alias, cfg.FunctionName, nil
 	}
 
 	// extract alias and function name from host
-	target := strings.TrimSuffix(host, "."+cfg.DomainSuffix)
 	p := strings.SplitN(target, "-", 2)
 	if len(p) != 2 {
 		return "", "", fmt.Errorf("invalid host name format. must be {alias}-{function}.%s", cfg.DomainSuffix)
 	}
 	alias, functionName := p[0], p[1]
-	if !aliasRegexp.MatchString(alias) {
-		return "", "", fmt.Errorf("invalid alias (%s allowed)", aliasRegexp.String())
+	if !cfg.hostAliasRegexp().MatchString(alias) {
+		return "", "", fmt.Errorf("invalid alias (%s allowed)", cfg.hostAliasRegexp().String())
 	}
-	if !functionNameRegexp.MatchString(functionName) {
-		return "", "", fmt.Errorf("invalid function name (%s allowed)", functionNameRegexp.String())
+	if !cfg.hostFunctionNameRegexp().MatchString(functionName) {
+		return "", "", fmt.Errorf("invalid function name (%s allowed)", cfg.hostFunctionNameRegexp().String())
 	}
 	return alias, functionName, nil
 }
+
+// toASCIIHost converts the internationalized host name to ASCII (punycode).
+// ASCII host names are returned as is, because idna rejects some characters seen in hosts (e.g. underscores).
+func toASCIIHost(host string) (string, error) {
+	for i := 0; i < len(host); i++ {
+		if host[i] >= utf8.RuneSelf {
+			return idna.Lookup.ToASCII(host)
+		}
+	}
+	return host, nil
+}
+
+// domainSuffix returns the domain suffix in lower case ASCII (punycode) to match hosts.
+func (cfg *Config) domainSuffix() string {
+	suffix := strings.ToLower(cfg.DomainSuffix)
+	if s, err := toASCIIHost(suffix); err == nil {
+		return s
+	}
+	return suffix
+}
+
+// validateDomainSuffix validates the domain suffix is a DNS name without leading or trailing dots.
+// Internationalized names are validated in punycode (xn--...).
+func validateDomainSuffix(suffix string) error {
+	ascii, err := toASCIIHost(suffix)
+	if err != nil {
+		return fmt.Errorf("invalid domain suffix %q: %w", suffix, err)
+	}
+	if len(ascii) > 253 {
+		return fmt.Errorf("invalid domain suffix %q: longer than 253 characters", suffix)
+	}
+	for _, label := range strings.Split(ascii, ".") {
+		if !domainLabelRegexp.MatchString(label) {
+			return fmt.Errorf("invalid domain suffix %q: each label must be 1-63 letters, digits or hyphens, not starting or ending with a hyphen", suffix)
+		}
+	}
+	return nil
+}
+
+// checkAmbiguousRouting returns an error if aliases and function names may not be extracted
+// from hosts as intended with the domain suffix.
+func (cfg *Config) checkAmbiguousRouting() error {
+	suffix := cfg.DomainSuffix
+	if cfg.FunctionName == "*" {
+		return nil
+	}
+	// e.g. prod-myfunc.example.net with the fixed function myfunc:
+	// prod-myfunc.example.net is not routed, and x.prod-myfunc.example.net is routed to the alias x
+	label, _, _ := strings.Cut(suffix, ".")
+	if alias, ok := strings.CutSuffix(label, "-"+cfg.FunctionName); ok && cfg.hostAliasRegexp().MatchString(alias) {
+		return fmt.Errorf("the first label of domain suffix %s is in the {alias}-{function} form of the fixed function %s", suffix, cfg.FunctionName)
+	}
+	return nil
+}
+
+// maxUpstreamTimeout returns the longest timeout of UpstreamTimeout and FunctionTimeouts.
+func (cfg *Config) maxUpstreamTimeout() time.Duration {
+	d := cfg.UpstreamTimeout
+	for _, v := range cfg.FunctionTimeouts {
+		d = max(d, v)
+	}
+	return d
+}
+
+// FunctionTimeout returns the upstream timeout for the function.
+func (cfg *Config) FunctionTimeout(functionName string) time.Duration {
+	if d, ok := cfg.FunctionTimeouts[functionName]; ok {
+		return d
+	}
+	return cfg.UpstreamTimeout
+}
+
+// MapAlias translates the alias extracted from the host to the real Lambda alias by AliasMap.
+// Unmapped aliases pass through, or are rejected when StrictAlias is set.
+func (cfg *Config) MapAlias(alias string) (string, error) {
+	t := &routingTable{aliasMap: cfg.AliasMap, strictAlias: cfg.StrictAlias}
+	return t.mapAlias(alias)
+}
+
+// checkHost rejects hosts containing control characters, spaces or more than one colon.
+func checkHost(host string) error {
+	for _, c := range host {
+		switch {
+		case c < 0x20 || c == 0x7f:
+			return fmt.Errorf("invalid host: contains control character %q", c)
+		case c == ' ':
+			return fmt.Errorf("invalid host: contains space")
+		}
+	}
+	if strings.Count(host, ":") > 1 {
+		return fmt.Errorf("invalid host: contains more than one colon")
+	}
+	return nil
+}
+
+// checkDenyHosts returns an error if the host matches any of DenyHosts.
+// The patterns are matched case-insensitively by path.Match, ignoring a trailing dot of the host.
+func (cfg *Config) checkDenyHosts(host string) error {
+	h := strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, p := range cfg.DenyHosts {
+		if ok, _ := path.Match(strings.ToLower(p), h); ok {
+			return fmt.Errorf("invalid host: %s is denied", host)
+		}
+	}
+	return nil
+}
+
+func isValidStatusCode(code int) bool {
+	return code >= 100 && code <= 599
+}
+
+func isValidQualifier(q string) bool {
+	return versionRegexp.MatchString(q) || aliasRegexp.MatchString(q)
+}
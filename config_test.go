@@ -1,9 +1,16 @@
 package lamux_test
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"errors"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/fujiwara/lamux"
 )
@@ -139,6 +146,118 @@ var TestCasesNG = []testCase{
 			return req
 		},
 	},
+	{
+		name: "domain suffix without dot boundary",
+		cfg: &lamux.Config{
+			Port:            8080,
+			FunctionName:    "myfunc",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: 30,
+		},
+		req: func() *http.Request {
+			req, _ := http.NewRequest("GET", "http://example.net", nil)
+			req.Host = "fooexample.net"
+			return req
+		},
+	},
+	{
+		name: "domain suffix without dot boundary with wildcard",
+		cfg: &lamux.Config{
+			Port:            8080,
+			FunctionName:    "*",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: 30,
+		},
+		req: func() *http.Request {
+			req, _ := http.NewRequest("GET", "http://example.net", nil)
+			req.Host = "prod-myfuncexample.net"
+			return req
+		},
+	},
+	{
+		name: "multiple labels before domain suffix",
+		cfg: &lamux.Config{
+			Port:            8080,
+			FunctionName:    "myfunc",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: 30,
+		},
+		req: func() *http.Request {
+			req, _ := http.NewRequest("GET", "http://example.net", nil)
+			req.Host = "a.b.example.net"
+			return req
+		},
+	},
+	{
+		name: "multiple labels before domain suffix with wildcard",
+		cfg: &lamux.Config{
+			Port:            8080,
+			FunctionName:    "*",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: 30,
+		},
+		req: func() *http.Request {
+			req, _ := http.NewRequest("GET", "http://example.net", nil)
+			req.Host = "a.prod-myfunc.example.net"
+			return req
+		},
+	},
+	{
+		name: "host with newline",
+		cfg: &lamux.Config{
+			Port:            8080,
+			FunctionName:    "myfunc",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: 30,
+		},
+		req: func() *http.Request {
+			req, _ := http.NewRequest("GET", "http://example.net", nil)
+			req.Host = "myalias\n.example.net"
+			return req
+		},
+	},
+	{
+		name: "host with control byte",
+		cfg: &lamux.Config{
+			Port:            8080,
+			FunctionName:    "myfunc",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: 30,
+		},
+		req: func() *http.Request {
+			req, _ := http.NewRequest("GET", "http://example.net", nil)
+			req.Host = "myalias\x01.example.net"
+			return req
+		},
+	},
+	{
+		name: "host with space",
+		cfg: &lamux.Config{
+			Port:            8080,
+			FunctionName:    "myfunc",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: 30,
+		},
+		req: func() *http.Request {
+			req, _ := http.NewRequest("GET", "http://example.net", nil)
+			req.Host = "myalias .example.net"
+			return req
+		},
+	},
+	{
+		name: "host with more than one colon",
+		cfg: &lamux.Config{
+			Port:            8080,
+			FunctionName:    "myfunc",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: 30,
+		},
+		req: func() *http.Request {
+			req, _ := http.NewRequest("GET", "http://example.net", nil)
+			req.Host = "myalias.example.net:80:80"
+			return req
+		},
+	},
 }
 
 func TestConfigOK(t *testing.T) {
@@ -179,3 +298,400 @@ func TestConfigNG(t *testing.T) {
 		})
 	}
 }
+
+func TestSuspiciousHost(t *testing.T) {
+	for _, host := range []string{"test\n.example.net", "test\x00.example.net", "test\x7f.example.net"} {
+		for _, allow := range []bool{false, true} {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:        "test-func",
+				DomainSuffix:        "example.net",
+				UpstreamTimeout:     time.Second,
+				AllowSuspiciousHost: allow,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.SetTestClient(&mockClient{code: 200})
+			r, _ := http.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Forwarded-Host", host)
+			err = app.HandleProxy(context.Background(), httptest.NewRecorder(), r)
+			var herr *lamux.HandlerError
+			if !errors.As(err, &herr) || herr.Code() != http.StatusBadRequest {
+				t.Errorf("host %q allow=%v: expect 400, got %v", host, allow, err)
+				continue
+			}
+			// with --allow-suspicious-host the host is rejected later as an invalid alias
+			if strings.Contains(herr.Error(), "control character") == allow {
+				t.Errorf("host %q allow=%v: unexpected reason %q", host, allow, herr.Error())
+			}
+		}
+	}
+}
+
+func TestDenyHosts(t *testing.T) {
+	cfg := &lamux.Config{
+		FunctionName:    "*",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		DenyHosts:       []string{"localhost", "127.*", "169.254.*", "*.internal.example.net"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		host     string
+		forward  bool
+		expectOK bool
+	}{
+		{host: "169.254.169.254"},
+		{host: "169.254.169.254:80"},
+		{host: "169.254.169.254", forward: true},
+		{host: "LOCALHOST:8080"},
+		{host: "localhost."},
+		{host: "127.0.0.1"},
+		{host: "test-func.internal.example.net"},
+		{host: "test-func.example.net", expectOK: true},
+		{host: "test-func.example.net:8080", forward: true, expectOK: true},
+	}
+	for _, c := range cases {
+		t.Run(c.host, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/", nil)
+			if c.forward {
+				req.Host = "localhost:8080" // e.g. a reverse proxy in front of lamux
+				req.Header.Set("X-Forwarded-Host", c.host)
+			} else {
+				req.Host = c.host
+			}
+			_, _, err := cfg.ExtractAliasAndFunctionName(context.Background(), req)
+			if c.expectOK && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if !c.expectOK && (err == nil || !strings.Contains(err.Error(), "denied")) {
+				t.Errorf("expect denied, got %v", err)
+			}
+		})
+	}
+}
+
+func TestDenyHostsValidation(t *testing.T) {
+	cfg := &lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		DenyHosts:       []string{"[169.254"},
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+func TestRegion(t *testing.T) {
+	t.Setenv("AWS_REGION", "ap-northeast-1")
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		Region:          "us-gov-west-1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "us-gov-west-1", app.AWSRegion(); e != a {
+		t.Errorf("expect region %s, got %s", e, a)
+	}
+
+	for _, region := range []string{"tokyo", "ap-northeast", "AP-NORTHEAST-1", "ap-northeast-1 "} {
+		cfg := &lamux.Config{
+			FunctionName:    "test-func",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: time.Second,
+			Region:          region,
+		}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected validation error for %q", region)
+		}
+	}
+}
+
+func TestAmbiguousRouting(t *testing.T) {
+	cases := []struct {
+		function  string
+		suffix    string
+		ambiguous bool
+	}{
+		{function: "myfunc", suffix: "example.net"},
+		{function: "myfunc", suffix: "my-company.example.net"},
+		{function: "myfunc", suffix: "prod-myfunc.example.net", ambiguous: true},
+		{function: "my-func", suffix: "prod-my-func.example.net", ambiguous: true},
+		{function: "*", suffix: "prod-myfunc.example.net"},
+	}
+	for _, tc := range cases {
+		for _, strict := range []bool{false, true} {
+			var buf bytes.Buffer
+			orig := slog.Default()
+			slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+			cfg := &lamux.Config{
+				FunctionName:           tc.function,
+				DomainSuffix:           tc.suffix,
+				UpstreamTimeout:        time.Second,
+				StrictConfigValidation: strict,
+			}
+			err := cfg.Validate()
+			slog.SetDefault(orig)
+
+			warned := strings.Contains(buf.String(), "ambiguous routing configuration")
+			switch {
+			case !tc.ambiguous && (err != nil || warned):
+				t.Errorf("%s %s: unexpected error %v or warning %s", tc.function, tc.suffix, err, buf.String())
+			case tc.ambiguous && strict && err == nil:
+				t.Errorf("%s %s: expected error in strict mode", tc.function, tc.suffix)
+			case tc.ambiguous && !strict && (err != nil || !warned):
+				t.Errorf("%s %s: expected warning without error, got %v", tc.function, tc.suffix, err)
+			}
+		}
+	}
+}
+
+func TestMixedCaseHost(t *testing.T) {
+	cases := []struct {
+		function string
+		suffix   string
+		host     string
+		expect   result
+	}{
+		{function: "myfunc", suffix: "example.com", host: "MyAlias.Example.com", expect: result{alias: "myalias", function: "myfunc"}},
+		{function: "myfunc", suffix: "Example.COM", host: "prod.example.com", expect: result{alias: "prod", function: "myfunc"}},
+		{function: "*", suffix: "example.com", host: "PROD-My-Func.EXAMPLE.COM:8080", expect: result{alias: "prod", function: "my-func"}},
+		{function: "*", suffix: "Example.com", host: "prod-myfunc.eXample.com", expect: result{alias: "prod", function: "myfunc"}},
+		{function: "*", suffix: "例え.JP", host: "Prod-MyFunc.XN--R8JZ45G.jp", expect: result{alias: "prod", function: "myfunc"}},
+	}
+	for _, c := range cases {
+		t.Run(c.host, func(t *testing.T) {
+			cfg := &lamux.Config{
+				FunctionName:    c.function,
+				DomainSuffix:    c.suffix,
+				UpstreamTimeout: time.Second,
+			}
+			if err := cfg.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Host = c.host
+			alias, function, err := cfg.ExtractAliasAndFunctionName(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a := (result{alias: alias, function: function}); a != c.expect {
+				t.Errorf("expect %v, got %v", c.expect, a)
+			}
+		})
+	}
+}
+
+func TestDomainSuffixValidation(t *testing.T) {
+	cases := []struct {
+		suffix string
+		valid  bool
+	}{
+		{suffix: "example.com", valid: true},
+		{suffix: "localdomain", valid: true},
+		{suffix: "my-company.example.com", valid: true},
+		{suffix: "xn--r8jz45g.jp", valid: true},
+		{suffix: ".example.com"},
+		{suffix: "example.com."},
+		{suffix: "example..com"},
+		{suffix: "-api.example.com"},
+		{suffix: "api-.example.com"},
+		{suffix: "*.example.com"},
+		{suffix: "example.com:8080"},
+		{suffix: "例え.jp", valid: true},
+		{suffix: "exämple.com", valid: true},
+		{suffix: "例え.jp."},
+		{suffix: "ex\u200dample.com"},
+		{suffix: strings.Repeat("a", 64) + ".example.com"},
+	}
+	for _, tc := range cases {
+		cfg := &lamux.Config{
+			FunctionName:    "*",
+			DomainSuffix:    tc.suffix,
+			UpstreamTimeout: time.Second,
+		}
+		err := cfg.Validate()
+		if tc.valid && err != nil {
+			t.Errorf("%q: unexpected error %s", tc.suffix, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%q: expected error", tc.suffix)
+		}
+	}
+}
+
+func TestInternationalizedDomainSuffix(t *testing.T) {
+	cases := []struct {
+		function string
+		suffix   string
+		host     string
+		expect   result
+	}{
+		{function: "*", suffix: "例え.jp", host: "test-myfunc.xn--r8jz45g.jp", expect: result{alias: "test", function: "myfunc"}},
+		{function: "*", suffix: "xn--r8jz45g.jp", host: "test-myfunc.例え.jp", expect: result{alias: "test", function: "myfunc"}},
+		{function: "*", suffix: "例え.jp", host: "test-myfunc.例え.jp:8080", expect: result{alias: "test", function: "myfunc"}},
+		{function: "myfunc", suffix: "例え.jp", host: "prod.xn--r8jz45g.jp", expect: result{alias: "prod", function: "myfunc"}},
+	}
+	for _, c := range cases {
+		t.Run(c.host, func(t *testing.T) {
+			cfg := &lamux.Config{
+				FunctionName:    c.function,
+				DomainSuffix:    c.suffix,
+				UpstreamTimeout: time.Second,
+			}
+			if err := cfg.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Host = c.host
+			alias, function, err := cfg.ExtractAliasAndFunctionName(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a := (result{alias: alias, function: function}); a != c.expect {
+				t.Errorf("expect %v, got %v", c.expect, a)
+			}
+		})
+	}
+}
+
+func TestHostPatterns(t *testing.T) {
+	cases := []struct {
+		function        string
+		aliasPattern    string
+		functionPattern string
+		host            string
+		expect          result
+	}{
+		{function: "myfunc", aliasPattern: `^[a-z0-9_]+$`, host: "feature_123.example.com", expect: result{alias: "feature_123", function: "myfunc"}},
+		{function: "myfunc", aliasPattern: `^[a-z0-9-]+$`, host: "pr-456.example.com", expect: result{alias: "pr-456", function: "myfunc"}},
+		{function: "*", aliasPattern: `^[a-z0-9_]+$`, host: "feature_123-myfunc.example.com", expect: result{alias: "feature_123", function: "myfunc"}},
+		{function: "*", functionPattern: `^[a-z0-9_-]+$`, host: "prod-my_func.example.com", expect: result{alias: "prod", function: "my_func"}},
+	}
+	for _, c := range cases {
+		t.Run(c.host, func(t *testing.T) {
+			cfg := &lamux.Config{
+				FunctionName:        c.function,
+				DomainSuffix:        "example.com",
+				UpstreamTimeout:     time.Second,
+				AliasPattern:        c.aliasPattern,
+				FunctionNamePattern: c.functionPattern,
+			}
+			if err := cfg.Validate(); err != nil {
+				t.Fatal(err)
+			}
+			req, _ := http.NewRequest("GET", "/", nil)
+			req.Host = c.host
+			alias, function, err := cfg.ExtractAliasAndFunctionName(context.Background(), req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a := (result{alias: alias, function: function}); a != c.expect {
+				t.Errorf("expect %v, got %v", c.expect, a)
+			}
+		})
+	}
+
+	// the default patterns still reject them
+	cfg := &lamux.Config{
+		FunctionName:    "myfunc",
+		DomainSuffix:    "example.com",
+		UpstreamTimeout: time.Second,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Host = "feature_123.example.com"
+	if _, _, err := cfg.ExtractAliasAndFunctionName(context.Background(), req); err == nil {
+		t.Error("expected error with the default alias pattern")
+	}
+}
+
+func TestHostPatternsValidation(t *testing.T) {
+	cases := []struct {
+		function        string
+		aliasPattern    string
+		functionPattern string
+		expect          string
+	}{
+		{aliasPattern: `^[a-z`, expect: "invalid alias pattern"},
+		{aliasPattern: `[a-z0-9]+`, expect: "must be anchored"},
+		{aliasPattern: `^[a-z]+|[0-9]+$`, expect: "must be anchored"},
+		{aliasPattern: `^[a-z0-9]*$`, expect: "must not match empty"},
+		{aliasPattern: `^.+$`, expect: "too permissive"},
+		{aliasPattern: `^[^.]+$`, expect: "too permissive"},
+		{aliasPattern: `^[a-z0-9_.]+$`, expect: "too permissive"},
+		{aliasPattern: `^[a-z/]+$`, expect: "too permissive"},
+		{aliasPattern: `^[\x{100}-\x{200}]+$`, expect: "too permissive"},
+		{function: "*", aliasPattern: `^[a-z0-9-]+$`, expect: "must not match hyphens"},
+		{function: "*", aliasPattern: `^[a-z]+(-[0-9]+)?$`, expect: "must not match hyphens"},
+		{functionPattern: `^\S+$`, expect: "too permissive"},
+	}
+	for _, c := range cases {
+		cfg := &lamux.Config{
+			FunctionName:        cmp.Or(c.function, "myfunc"),
+			DomainSuffix:        "example.com",
+			UpstreamTimeout:     time.Second,
+			AliasPattern:        c.aliasPattern,
+			FunctionNamePattern: c.functionPattern,
+		}
+		err := cfg.Validate()
+		if err == nil || !strings.Contains(err.Error(), c.expect) {
+			t.Errorf("%s %s: expect error %q, got %v", c.aliasPattern, c.functionPattern, c.expect, err)
+		}
+	}
+}
+
+func TestDefaultAlias(t *testing.T) {
+	cfg := &lamux.Config{
+		FunctionName:    "myfunc",
+		DomainSuffix:    "example.com",
+		UpstreamTimeout: time.Second,
+		DefaultAlias:    "current",
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	for host, expect := range map[string]result{
+		"example.com":      {alias: "current", function: "myfunc"},
+		"Example.com:8080": {alias: "current", function: "myfunc"},
+		"prod.example.com": {alias: "prod", function: "myfunc"},
+	} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.Host = host
+		alias, function, err := cfg.ExtractAliasAndFunctionName(context.Background(), req)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", host, err)
+			continue
+		}
+		if a := (result{alias: alias, function: function}); a != expect {
+			t.Errorf("%s: expect %v, got %v", host, expect, a)
+		}
+	}
+
+	// without the default alias
+	cfg.DefaultAlias = ""
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Host = "example.com"
+	if _, _, err := cfg.ExtractAliasAndFunctionName(context.Background(), req); err == nil {
+		t.Error("expected error without the default alias")
+	}
+
+	for _, c := range []lamux.Config{
+		{FunctionName: "*", DefaultAlias: "current"},
+		{FunctionName: "myfunc", DefaultAlias: "cur-rent"},
+	} {
+		c.DomainSuffix = "example.com"
+		c.UpstreamTimeout = time.Second
+		if err := c.Validate(); err == nil {
+			t.Errorf("expected validation error for %s with %s", c.DefaultAlias, c.FunctionName)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package lamux
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"gopkg.in/yaml.v3"
+)
+
+// parseConfig parses the command line arguments, environment variables and the config file.
+// The precedence is defaults < config file < environment variables < flags.
+// The config is validated after merging all of them.
+func parseConfig(args []string, options ...kong.Option) (*Config, error) {
+	var file *configFile
+	if path := configFilePath(args); path != "" {
+		var err error
+		if file, err = loadConfigFile(path); err != nil {
+			return nil, err
+		}
+		options = append(options, kong.Resolvers(file))
+	}
+	cfg := &Config{}
+	parser, err := kong.New(cfg, options...)
+	if err != nil {
+		return nil, err
+	}
+	if file != nil {
+		// file only fields must be set before validation in parsing
+		if err := file.apply(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := parser.Parse(args); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// configFilePath returns the path of the config file specified by --config flag or LAMUX_CONFIG.
+func configFilePath(args []string) string {
+	for i, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if path, ok := strings.CutPrefix(arg, "--config="); ok {
+			return path
+		}
+		if arg == "--config" && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return os.Getenv("LAMUX_CONFIG")
+}
+
+// configFile is a kong resolver that resolves flag values from a YAML or JSON file.
+// The keys are the flag names (e.g. function-name or function_name).
+type configFile struct {
+	path   string
+	values map[string]any
+}
+
+func loadConfigFile(path string) (*configFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	values := map[string]any{}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(b, &values)
+	} else {
+		err = yaml.Unmarshal(b, &values)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return &configFile{path: path, values: values}, nil
+}
+
+func (f *configFile) lookup(name string) (any, bool) {
+	if v, ok := f.values[name]; ok {
+		return v, true
+	}
+	v, ok := f.values[strings.ReplaceAll(name, "-", "_")]
+	return v, ok
+}
+
+// Validate reports unknown keys in the config file.
+func (f *configFile) Validate(app *kong.Application) error {
+	known := map[string]bool{}
+	for _, flag := range app.Flags {
+		known[flag.Name] = true
+		known[strings.ReplaceAll(flag.Name, "-", "_")] = true
+	}
+	for _, field := range fileOnlyFields(reflect.ValueOf(&Config{}).Elem()) {
+		known[field.name] = true
+		known[strings.ReplaceAll(field.name, "-", "_")] = true
+	}
+	for key := range f.values {
+		if !known[key] || key == "config" || key == "help" || key == "version" {
+			return fmt.Errorf("unknown key in config file %s: %s", f.path, key)
+		}
+	}
+	return nil
+}
+
+// Resolve returns the value in the config file for the flag.
+// The flags set by environment variables are not resolved to take precedence over the file.
+func (f *configFile) Resolve(_ *kong.Context, _ *kong.Path, flag *kong.Flag) (any, error) {
+	for _, env := range flag.Tag.Envs {
+		if _, ok := os.LookupEnv(env); ok {
+			return nil, nil
+		}
+	}
+	v, ok := f.lookup(flag.Name)
+	if !ok {
+		return nil, nil
+	}
+	return v, nil
+}
+
+// apply sets the file only fields (tagged with `kong:"-" yaml:"name"`) of cfg.
+func (f *configFile) apply(cfg *Config) error {
+	for _, field := range fileOnlyFields(reflect.ValueOf(cfg).Elem()) {
+		v, ok := f.lookup(field.name)
+		if !ok {
+			continue
+		}
+		b, err := yaml.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s in config file %s: %w", field.name, f.path, err)
+		}
+		if err := yaml.Unmarshal(b, field.value.Addr().Interface()); err != nil {
+			return fmt.Errorf("invalid %s in config file %s: %w", field.name, f.path, err)
+		}
+	}
+	return nil
+}
+
+type fileOnlyField struct {
+	name  string
+	value reflect.Value
+}
+
+// fileOnlyFields returns the fields configurable only by the config file, including embedded structs.
+func fileOnlyFields(v reflect.Value) []fileOnlyField {
+	var fields []fileOnlyField
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			fields = append(fields, fileOnlyFields(v.Field(i))...)
+			continue
+		}
+		if sf.Tag.Get("kong") != "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(sf.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields = append(fields, fileOnlyField{name: name, value: v.Field(i)})
+	}
+	return fields
+}
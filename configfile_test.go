@@ -0,0 +1,150 @@
+package lamux_test
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigFileYAML(t *testing.T) {
+	// partial file: other fields have default values
+	path := writeConfigFile(t, "lamux.yaml", `
+function-name: test-func
+upstream_timeout: 10s
+allow-cidrs:
+  - 192.0.2.0/24
+  - 2001:db8::/32
+trace-headers:
+  key1: value1
+`)
+	cfg, err := lamux.ParseConfig([]string{"--config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "test-func", cfg.FunctionName; e != a {
+		t.Errorf("expect function name %q, got %q", e, a)
+	}
+	if e, a := 10*time.Second, cfg.UpstreamTimeout; e != a {
+		t.Errorf("expect upstream timeout %s, got %s", e, a)
+	}
+	if e, a := []string{"192.0.2.0/24", "2001:db8::/32"}, cfg.AllowCIDRs; !slices.Equal(e, a) {
+		t.Errorf("expect allow cidrs %v, got %v", e, a)
+	}
+	if e, a := "value1", cfg.TraceHeaders["key1"]; e != a {
+		t.Errorf("expect trace header %q, got %q", e, a)
+	}
+	if e, a := 8080, cfg.Port; e != a {
+		t.Errorf("expect default port %d, got %d", e, a)
+	}
+	if e, a := "localdomain", cfg.DomainSuffix; e != a {
+		t.Errorf("expect default domain suffix %q, got %q", e, a)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected validation error: %v", err)
+	}
+}
+
+func TestConfigFileJSON(t *testing.T) {
+	path := writeConfigFile(t, "lamux.json", `{
+	"function-name": "test-func",
+	"port": 9000,
+	"metrics-enabled": true
+}`)
+	cfg, err := lamux.ParseConfig([]string{"--config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, a := 9000, cfg.Port; e != a {
+		t.Errorf("expect port %d, got %d", e, a)
+	}
+	if !cfg.MetricsEnabled {
+		t.Error("expect metrics enabled")
+	}
+	if e, a := "/metrics", cfg.MetricsPath; e != a {
+		t.Errorf("expect default metrics path %q, got %q", e, a)
+	}
+}
+
+func TestConfigFileOnlyFields(t *testing.T) {
+	path := writeConfigFile(t, "lamux.yaml", `
+function-name: test-func
+alias_response_headers:
+  canary:
+    X-Canary: "true"
+`)
+	cfg, err := lamux.ParseConfig([]string{"--config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "true", cfg.AliasResponseHeaders["canary"]["X-Canary"]; e != a {
+		t.Errorf("expect alias response header %q, got %q", e, a)
+	}
+}
+
+func TestConfigFilePrecedence(t *testing.T) {
+	path := writeConfigFile(t, "lamux.yaml", `
+function-name: file-func
+domain-suffix: file.example.com
+port: 9000
+`)
+	t.Setenv("LAMUX_CONFIG", "")
+	t.Setenv("LAMUX_DOMAIN_SUFFIX", "env.example.com")
+	t.Setenv("LAMUX_PORT", "9001")
+	cfg, err := lamux.ParseConfig([]string{"--config", path, "--port", "9002"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, a := "file-func", cfg.FunctionName; e != a {
+		t.Errorf("file must override default: expect %q, got %q", e, a)
+	}
+	if e, a := "env.example.com", cfg.DomainSuffix; e != a {
+		t.Errorf("env must override file: expect %q, got %q", e, a)
+	}
+	if e, a := 9002, cfg.Port; e != a {
+		t.Errorf("flag must override env: expect %d, got %d", e, a)
+	}
+}
+
+func TestConfigFileInvalid(t *testing.T) {
+	cases := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{name: "unknown key", file: "lamux.yaml", content: "unknown-key: 1\n"},
+		{name: "invalid yaml", file: "lamux.yaml", content: "port: [\n"},
+		{name: "invalid json", file: "lamux.json", content: `{"port": }`},
+		{name: "invalid value", file: "lamux.yaml", content: "port: abc\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeConfigFile(t, tc.file, tc.content)
+			if _, err := lamux.ParseConfig([]string{"--config", path}); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	// validated after merging
+	path := writeConfigFile(t, "lamux.yaml", "port: -1\n")
+	if _, err := lamux.ParseConfig([]string{"--config", path}); err == nil {
+		t.Error("expected validation error")
+	}
+	path = writeConfigFile(t, "lamux.yaml", "basic-auth-password: secret\n")
+	if _, err := lamux.ParseConfig([]string{"--config", path, "--basic-auth-user", "user"}); err != nil {
+		t.Errorf("flags and file must be merged before validation: %v", err)
+	}
+}
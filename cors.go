@@ -0,0 +1,112 @@
+package lamux
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type CORSConfig struct {
+	CORSAllowOrigins     []string      `help:"Allowed origins for CORS (* and wildcard like https://*.example.com are supported)" env:"LAMUX_CORS_ALLOW_ORIGINS" name:"cors-allow-origins"`
+	CORSAllowMethods     []string      `help:"Allowed methods for CORS" default:"GET,HEAD,POST,PUT,PATCH,DELETE" env:"LAMUX_CORS_ALLOW_METHODS" name:"cors-allow-methods"`
+	CORSAllowHeaders     []string      `help:"Allowed request headers for CORS" env:"LAMUX_CORS_ALLOW_HEADERS" name:"cors-allow-headers"`
+	CORSMaxAge           time.Duration `help:"Max age of CORS preflight responses" default:"0s" env:"LAMUX_CORS_MAX_AGE" name:"cors-max-age"`
+	CORSReflectOrigin    bool          `help:"Reflect the request origin instead of * in Access-Control-Allow-Origin" env:"LAMUX_CORS_REFLECT_ORIGIN" name:"cors-reflect-origin"`
+	CORSAllowCredentials bool          `help:"Allow credentials for CORS (implies --cors-reflect-origin)" env:"LAMUX_CORS_ALLOW_CREDENTIALS" name:"cors-allow-credentials"`
+}
+
+func (cc *CORSConfig) Enabled() bool {
+	return len(cc.CORSAllowOrigins) > 0
+}
+
+func (cc *CORSConfig) Validate() error {
+	if cc.CORSMaxAge < 0 {
+		return errors.New("cors max age must not be negative")
+	}
+	for _, o := range cc.CORSAllowOrigins {
+		if o != "*" && strings.Count(o, "*") > 1 {
+			return errors.New("cors allow origin must not contain more than one wildcard: " + o)
+		}
+	}
+	return nil
+}
+
+// allowOrigin returns the value of Access-Control-Allow-Origin for the origin.
+// An empty string is returned when the origin is not allowed.
+func (cc *CORSConfig) allowOrigin(origin string) string {
+	reflect := cc.CORSReflectOrigin || cc.CORSAllowCredentials
+	for _, o := range cc.CORSAllowOrigins {
+		switch {
+		case o == "*":
+			if reflect {
+				return origin
+			}
+			return "*"
+		case strings.Contains(o, "*"):
+			prefix, suffix, _ := strings.Cut(strings.ToLower(o), "*")
+			lo := strings.ToLower(origin)
+			if len(lo) > len(prefix)+len(suffix) && strings.HasPrefix(lo, prefix) && strings.HasSuffix(lo, suffix) {
+				return origin
+			}
+		case strings.EqualFold(o, origin):
+			return origin
+		}
+	}
+	return ""
+}
+
+// handleCORS sets Access-Control-Allow-* headers to the responses of the next handler.
+// Preflight requests are passed to the next handler, which answers them by writePreflight
+// after the IP filter and the validation of the host.
+func (l *Lamux) handleCORS(next http.Handler) http.Handler {
+	cc := &l.Config.CORSConfig
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !isPreflight(r) {
+			if allowOrigin := cc.allowOrigin(origin); allowOrigin != "" {
+				w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+				if cc.CORSAllowCredentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == http.MethodOptions && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// writePreflight answers the CORS preflight request without invoking Lambda functions.
+func (cc *CORSConfig) writePreflight(w http.ResponseWriter, r *http.Request) {
+	h := w.Header()
+	h.Add("Vary", "Access-Control-Request-Method")
+	h.Add("Vary", "Access-Control-Request-Headers")
+	allowOrigin := cc.allowOrigin(r.Header.Get("Origin"))
+	method := r.Header.Get("Access-Control-Request-Method")
+	if allowOrigin == "" || !slices.Contains(cc.CORSAllowMethods, method) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	h.Set("Access-Control-Allow-Origin", allowOrigin)
+	h.Set("Access-Control-Allow-Methods", strings.Join(cc.CORSAllowMethods, ", "))
+	if len(cc.CORSAllowHeaders) > 0 {
+		h.Set("Access-Control-Allow-Headers", strings.Join(cc.CORSAllowHeaders, ", "))
+	}
+	if cc.CORSAllowCredentials {
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+	if cc.CORSMaxAge > 0 {
+		h.Set("Access-Control-Max-Age", strconv.Itoa(int(cc.CORSMaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,183 @@
+package lamux_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func TestCORS(t *testing.T) {
+	cases := []struct {
+		name              string
+		cors              lamux.CORSConfig
+		method            string
+		origin            string
+		requestMethod     string
+		expectCode        int
+		expectAllowOrigin string
+		expectInvoked     bool
+	}{
+		{
+			name:              "preflight exact origin",
+			cors:              lamux.CORSConfig{CORSAllowOrigins: []string{"https://app.example.com"}},
+			method:            "OPTIONS",
+			origin:            "https://app.example.com",
+			requestMethod:     "POST",
+			expectCode:        http.StatusNoContent,
+			expectAllowOrigin: "https://app.example.com",
+		},
+		{
+			name:          "preflight disallowed origin",
+			cors:          lamux.CORSConfig{CORSAllowOrigins: []string{"https://app.example.com"}},
+			method:        "OPTIONS",
+			origin:        "https://evil.example.com",
+			requestMethod: "POST",
+			expectCode:    http.StatusNoContent,
+		},
+		{
+			name:          "preflight disallowed method",
+			cors:          lamux.CORSConfig{CORSAllowOrigins: []string{"*"}},
+			method:        "OPTIONS",
+			origin:        "https://app.example.com",
+			requestMethod: "CONNECT",
+			expectCode:    http.StatusNoContent,
+		},
+		{
+			name:              "wildcard subdomain",
+			cors:              lamux.CORSConfig{CORSAllowOrigins: []string{"https://*.example.com"}},
+			method:            "GET",
+			origin:            "https://app.example.com",
+			expectCode:        http.StatusOK,
+			expectAllowOrigin: "https://app.example.com",
+			expectInvoked:     true,
+		},
+		{
+			name:          "wildcard subdomain not matched",
+			cors:          lamux.CORSConfig{CORSAllowOrigins: []string{"https://*.example.com"}},
+			method:        "GET",
+			origin:        "https://example.com",
+			expectCode:    http.StatusOK,
+			expectInvoked: true,
+		},
+		{
+			name:              "any origin",
+			cors:              lamux.CORSConfig{CORSAllowOrigins: []string{"*"}},
+			method:            "GET",
+			origin:            "https://app.example.com",
+			expectCode:        http.StatusOK,
+			expectAllowOrigin: "*",
+			expectInvoked:     true,
+		},
+		{
+			name:              "reflect origin",
+			cors:              lamux.CORSConfig{CORSAllowOrigins: []string{"*"}, CORSReflectOrigin: true},
+			method:            "GET",
+			origin:            "https://app.example.com",
+			expectCode:        http.StatusOK,
+			expectAllowOrigin: "https://app.example.com",
+			expectInvoked:     true,
+		},
+		{
+			name:          "OPTIONS without preflight headers",
+			cors:          lamux.CORSConfig{CORSAllowOrigins: []string{"*"}},
+			method:        "OPTIONS",
+			expectCode:    http.StatusOK,
+			expectInvoked: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.cors.CORSAllowMethods = []string{"GET", "POST"}
+			tc.cors.CORSAllowHeaders = []string{"Content-Type"}
+			tc.cors.CORSMaxAge = time.Minute
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:    "test-func",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+				CORSConfig:      tc.cors,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200}
+			app.SetTestClient(client)
+
+			r := httptest.NewRequest(tc.method, "http://test.example.net/", nil)
+			if tc.origin != "" {
+				r.Header.Set("Origin", tc.origin)
+			}
+			if tc.requestMethod != "" {
+				r.Header.Set("Access-Control-Request-Method", tc.requestMethod)
+			}
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, r)
+
+			if e, a := tc.expectCode, w.Code; e != a {
+				t.Errorf("expect %d, got %d", e, a)
+			}
+			if e, a := tc.expectAllowOrigin, w.Header().Get("Access-Control-Allow-Origin"); e != a {
+				t.Errorf("expect allow origin %q, got %q", e, a)
+			}
+			if e, a := tc.expectInvoked, client.input != nil; e != a {
+				t.Errorf("expect invoked %v, got %v", e, a)
+			}
+			if tc.requestMethod != "" && tc.expectAllowOrigin != "" {
+				if e, a := "GET, POST", w.Header().Get("Access-Control-Allow-Methods"); e != a {
+					t.Errorf("expect allow methods %q, got %q", e, a)
+				}
+				if e, a := "Content-Type", w.Header().Get("Access-Control-Allow-Headers"); e != a {
+					t.Errorf("expect allow headers %q, got %q", e, a)
+				}
+				if e, a := "60", w.Header().Get("Access-Control-Max-Age"); e != a {
+					t.Errorf("expect max age %q, got %q", e, a)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSPreflightFiltered(t *testing.T) {
+	cases := []struct {
+		name       string
+		host       string
+		remote     string
+		expectCode int
+	}{
+		{name: "allowed", host: "test.example.net", remote: "192.0.2.1:12345", expectCode: http.StatusNoContent},
+		{name: "denied client", host: "test.example.net", remote: "198.51.100.1:12345", expectCode: http.StatusForbidden},
+		{name: "invalid host", host: "test.example.com", remote: "192.0.2.1:12345", expectCode: http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:    "test-func",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+				CORSConfig:      lamux.CORSConfig{CORSAllowOrigins: []string{"*"}, CORSAllowMethods: []string{"GET", "POST"}},
+				IPFilterConfig:  lamux.IPFilterConfig{AllowCIDRs: []string{"192.0.2.0/24"}},
+				// preflight requests carry no credentials
+				BasicAuthConfig: lamux.BasicAuthConfig{BasicAuthUser: "admin", BasicAuthPassword: "secret"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200}
+			app.SetTestClient(client)
+			r := httptest.NewRequest("OPTIONS", "http://"+tc.host+"/", nil)
+			r.RemoteAddr = tc.remote
+			r.Header.Set("Origin", "https://app.example.com")
+			r.Header.Set("Access-Control-Request-Method", "POST")
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, r)
+			if e, a := tc.expectCode, w.Code; e != a {
+				t.Errorf("expect %d, got %d", e, a)
+			}
+			if client.input != nil {
+				t.Error("preflight requests must not invoke the function")
+			}
+		})
+	}
+}
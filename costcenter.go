@@ -0,0 +1,22 @@
+package lamux
+
+import (
+	"context"
+	"net/http"
+
+	slogcontext "github.com/PumpkinSeed/slog-context"
+)
+
+const costCenterHeader = "X-Cost-Center"
+
+// setCostCenter sets the cost center of the alias to the request header, and adds it to the log context.
+// The header sent by the client is always removed not to be spoofed.
+func (cfg *Config) setCostCenter(ctx context.Context, h http.Header, alias string) context.Context {
+	h.Del(costCenterHeader)
+	cc, ok := cfg.CostCenterByAlias[alias]
+	if !ok {
+		return ctx
+	}
+	h.Set(costCenterHeader, cc)
+	return slogcontext.WithValue(ctx, "cost_center", cc)
+}
@@ -0,0 +1,84 @@
+package lamux_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	slogcontext "github.com/PumpkinSeed/slog-context"
+	"github.com/fujiwara/lamux"
+)
+
+func TestCostCenterByAlias(t *testing.T) {
+	cases := []struct {
+		host     string
+		spoofed  string
+		expectCC string
+	}{
+		{host: "current.example.net", expectCC: "team-a"},
+		{host: "beta.example.net", expectCC: "team-b"},
+		{host: "beta.example.net", spoofed: "team-x", expectCC: "team-b"},
+		{host: "other.example.net", spoofed: "team-x", expectCC: ""},
+	}
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:      "test-func",
+		DomainSuffix:      "example.net",
+		UpstreamTimeout:   time.Second,
+		CostCenterByAlias: map[string]string{"current": "team-a", "beta": "team-b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range cases {
+		t.Run(tc.host, func(t *testing.T) {
+			var buf bytes.Buffer
+			orig := slog.Default()
+			slog.SetDefault(slog.New(slogcontext.NewHandler(slog.NewJSONHandler(&buf, nil))))
+			defer slog.SetDefault(orig)
+
+			client := &mockClient{code: 200, qualifiers: []string{"current", "beta", "other"}}
+			app.SetTestClient(client)
+			r := httptest.NewRequest("GET", "http://"+tc.host+"/", nil)
+			if tc.spoofed != "" {
+				r.Header.Set("X-Cost-Center", tc.spoofed)
+			}
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expect 200, got %d", w.Code)
+			}
+			var payload struct {
+				Headers map[string]string `json:"headers"`
+			}
+			if err := json.Unmarshal(client.input.Payload, &payload); err != nil {
+				t.Fatal(err)
+			}
+			if e, a := tc.expectCC, payload.Headers["x-cost-center"]; e != a {
+				t.Errorf("expect cost center %q, got %q", e, a)
+			}
+			logged := strings.Contains(buf.String(), `"cost_center":"`+tc.expectCC+`"`)
+			if (tc.expectCC != "") != logged {
+				t.Errorf("expect cost center %q to be logged: %s", tc.expectCC, buf.String())
+			}
+		})
+	}
+}
+
+func TestCostCenterByAliasValidation(t *testing.T) {
+	for _, m := range []map[string]string{{"in-valid": "team-a"}, {"current": ""}, {"Current": "team-a"}} {
+		cfg := &lamux.Config{
+			FunctionName:      "test-func",
+			DomainSuffix:      "example.net",
+			UpstreamTimeout:   time.Second,
+			CostCenterByAlias: m,
+		}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected validation error for %v", m)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package lamux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// credentialRetryInterval is the initial interval to retry resolving credentials,
+// doubled up to credentialRetryMaxInterval.
+var credentialRetryInterval = 500 * time.Millisecond
+
+const credentialRetryMaxInterval = 10 * time.Second
+
+// waitForCredentials retries retrieving credentials from the provider with backoff until the timeout,
+// for environments where credentials are not available immediately at startup (e.g. IRSA or instance profiles).
+func waitForCredentials(ctx context.Context, provider aws.CredentialsProvider, timeout time.Duration) error {
+	if provider == nil {
+		return errors.New("no credentials provider is configured")
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	interval := credentialRetryInterval
+	for {
+		_, err := provider.Retrieve(ctx)
+		if err == nil {
+			return nil
+		}
+		slog.WarnContext(ctx, "failed to retrieve credentials, retrying", "error", err, "retry_after", interval.Seconds())
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed to retrieve credentials in %s: %w", timeout, err)
+		case <-time.After(interval):
+		}
+		interval = min(interval*2, credentialRetryMaxInterval)
+	}
+}
@@ -0,0 +1,69 @@
+package lamux_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/fujiwara/lamux"
+)
+
+// flakyProvider fails to retrieve credentials until failures reach zero.
+type flakyProvider struct {
+	failures atomic.Int32
+	calls    atomic.Int32
+}
+
+func (p *flakyProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	p.calls.Add(1)
+	if p.failures.Add(-1) >= 0 {
+		return aws.Credentials{}, errors.New("credentials are not available yet")
+	}
+	return aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET", Source: "test"}, nil
+}
+
+func TestWaitForCredentials(t *testing.T) {
+	defer lamux.SetCredentialRetryInterval(10 * time.Millisecond)()
+
+	t.Run("eventually succeeds", func(t *testing.T) {
+		p := &flakyProvider{}
+		p.failures.Store(3)
+		if err := lamux.WaitForCredentials(context.Background(), aws.NewCredentialsCache(p), time.Second); err != nil {
+			t.Fatal(err)
+		}
+		if e, a := int32(4), p.calls.Load(); e != a {
+			t.Errorf("expect %d calls, got %d", e, a)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		p := &flakyProvider{}
+		p.failures.Store(1000)
+		start := time.Now()
+		err := lamux.WaitForCredentials(context.Background(), p, 100*time.Millisecond)
+		if err == nil {
+			t.Fatal("expected error on timeout")
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("expect to give up by the timeout, took %s", elapsed)
+		}
+		t.Log(err)
+	})
+}
+
+func TestCredentialWaitTimeout(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "SECRET")
+	_, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:          "test-func",
+		DomainSuffix:          "example.net",
+		UpstreamTimeout:       time.Second,
+		CredentialWaitTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,187 @@
+package lamux
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/fujiwara/ridge"
+)
+
+const (
+	cspNonceHeader      = "X-Lamux-CSP-Nonce"
+	cspNoncePlaceholder = "{nonce}"
+)
+
+type CSPConfig struct {
+	InjectCSPNonce bool   `help:"Generate a per-request CSP nonce and set Content-Security-Policy header to HTML responses" env:"LAMUX_INJECT_CSP_NONCE" name:"inject-csp-nonce"`
+	CSPPolicy      string `help:"Content-Security-Policy header value ({nonce} is replaced with the nonce)" default:"script-src 'nonce-{nonce}'; style-src 'nonce-{nonce}'" env:"LAMUX_CSP_POLICY" name:"csp-policy"`
+	CSPRewriteBody bool   `help:"Add the nonce attribute to <script> and <style> tags in HTML responses" env:"LAMUX_CSP_REWRITE_BODY" name:"csp-rewrite-body"`
+}
+
+func (cc *CSPConfig) Validate() error {
+	if cc.InjectCSPNonce && !strings.Contains(cc.CSPPolicy, cspNoncePlaceholder) {
+		return errors.New("csp policy must contain " + cspNoncePlaceholder)
+	}
+	return nil
+}
+
+// newCSPNonce returns a random base64 encoded nonce.
+func newCSPNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate csp nonce: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+// injectCSPNonce sets the Content-Security-Policy header with the nonce to the HTML response,
+// and adds the nonce attribute to <script> and <style> tags in the body if rewriteBody is true.
+func (cc *CSPConfig) injectCSPNonce(res *ridge.Response, nonce string) error {
+	if !isHTML(responseHeader(res, "Content-Type")) {
+		return nil
+	}
+	setResponseHeader(res, "Content-Security-Policy", strings.ReplaceAll(cc.CSPPolicy, cspNoncePlaceholder, nonce))
+	if !cc.CSPRewriteBody {
+		return nil
+	}
+	if enc := responseHeader(res, "Content-Encoding"); enc != "" && !strings.EqualFold(enc, "identity") {
+		return nil // compressed bodies are not rewritten
+	}
+	body := res.Body
+	if res.IsBase64Encoded {
+		b, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return fmt.Errorf("failed to decode response body: %w", err)
+		}
+		body = string(b)
+	}
+	body = addNonceAttribute(body, nonce)
+	if res.IsBase64Encoded {
+		body = base64.StdEncoding.EncodeToString([]byte(body))
+	}
+	res.Body = body
+	deleteResponseHeader(res, "Content-Length")
+	return nil
+}
+
+// injectHTTPCSPNonce is injectCSPNonce for the responses from Function URLs.
+// The body is buffered only when it is rewritten.
+func (cc *CSPConfig) injectHTTPCSPNonce(res *http.Response, nonce string) error {
+	if !isHTML(res.Header.Get("Content-Type")) {
+		return nil
+	}
+	res.Header.Set("Content-Security-Policy", strings.ReplaceAll(cc.CSPPolicy, cspNoncePlaceholder, nonce))
+	if !cc.CSPRewriteBody {
+		return nil
+	}
+	if enc := res.Header.Get("Content-Encoding"); enc != "" && !strings.EqualFold(enc, "identity") {
+		return nil // compressed bodies are not rewritten
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	body := addNonceAttribute(string(b), nonce)
+	res.Body = io.NopCloser(strings.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+func isHTML(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mt == "text/html"
+}
+
+// responseHeader returns the first value of the header in res case-insensitively.
+func responseHeader(res *ridge.Response, key string) string {
+	for k, v := range res.Headers {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	for k, vs := range res.MultiValueHeaders {
+		if strings.EqualFold(k, key) && len(vs) > 0 {
+			return vs[0]
+		}
+	}
+	return ""
+}
+
+// addNonceAttribute adds the nonce attribute to <script> and <style> tags which do not have one.
+// The contents of script and style elements are skipped, so tags in inline scripts are not rewritten.
+func addNonceAttribute(html, nonce string) string {
+	var b strings.Builder
+	b.Grow(len(html) + 64)
+	lower := asciiLower(html)
+	i := 0
+	for {
+		j := strings.IndexByte(lower[i:], '<')
+		if j < 0 {
+			break
+		}
+		j += i
+		name := nonceTagName(lower[j:])
+		if name == "" {
+			b.WriteString(html[i : j+1])
+			i = j + 1
+			continue
+		}
+		end := strings.IndexByte(lower[j:], '>')
+		if end < 0 {
+			break
+		}
+		end += j
+		nameEnd := j + 1 + len(name)
+		b.WriteString(html[i:nameEnd])
+		if !strings.Contains(lower[nameEnd:end], "nonce=") {
+			b.WriteString(` nonce="` + nonce + `"`)
+		}
+		i = nameEnd
+		// skip the contents to the closing tag
+		closing := strings.Index(lower[end:], "</"+name)
+		if closing < 0 {
+			break
+		}
+		next := end + closing + len("</")
+		b.WriteString(html[i:next])
+		i = next
+	}
+	b.WriteString(html[i:])
+	return b.String()
+}
+
+// nonceTagName returns "script" or "style" if s starts with the start tag of them.
+func nonceTagName(s string) string {
+	for _, name := range []string{"script", "style"} {
+		if !strings.HasPrefix(s, "<"+name) {
+			continue
+		}
+		if len(s) == len(name)+1 {
+			return ""
+		}
+		switch s[len(name)+1] {
+		case ' ', '\t', '\n', '\r', '\f', '/', '>':
+			return name
+		}
+	}
+	return ""
+}
+
+// asciiLower lowers ASCII letters only, so that the byte offsets are preserved.
+func asciiLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,199 @@
+package lamux_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+const cspTestHTML = `<!DOCTYPE html><html><head>` +
+	`<SCRIPT src="/app.js"></SCRIPT>` +
+	`<style>body { color: red }</style>` +
+	`<script nonce="fixed">var s = "<script>";</script>` +
+	`<scripts></scripts>` +
+	`</head><body>héllo</body></html>`
+
+func cspTestPayload(t *testing.T, contentType string, base64Encoded bool) []byte {
+	t.Helper()
+	body := cspTestHTML
+	if base64Encoded {
+		body = base64.StdEncoding.EncodeToString([]byte(body))
+	}
+	b, err := json.Marshal(map[string]any{
+		"statusCode": 200,
+		"headers": map[string]string{
+			"content-type":            contentType,
+			"content-length":          "1234",
+			"content-security-policy": "default-src *",
+		},
+		"body":            body,
+		"isBase64Encoded": base64Encoded,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestCSPNonce(t *testing.T) {
+	cases := []struct {
+		name          string
+		contentType   string
+		base64Encoded bool
+		rewriteBody   bool
+		expectHeader  bool
+		expectBody    string
+	}{
+		{
+			name:         "header only",
+			contentType:  "text/html; charset=utf-8",
+			expectHeader: true,
+			expectBody:   cspTestHTML,
+		},
+		{
+			name:         "rewrite body",
+			contentType:  "text/html; charset=utf-8",
+			rewriteBody:  true,
+			expectHeader: true,
+			expectBody: `<!DOCTYPE html><html><head>` +
+				`<SCRIPT nonce="{nonce}" src="/app.js"></SCRIPT>` +
+				`<style nonce="{nonce}">body { color: red }</style>` +
+				`<script nonce="fixed">var s = "<script>";</script>` +
+				`<scripts></scripts>` +
+				`</head><body>héllo</body></html>`,
+		},
+		{
+			name:          "rewrite base64 encoded body",
+			contentType:   "text/html",
+			base64Encoded: true,
+			rewriteBody:   true,
+			expectHeader:  true,
+			expectBody: `<!DOCTYPE html><html><head>` +
+				`<SCRIPT nonce="{nonce}" src="/app.js"></SCRIPT>` +
+				`<style nonce="{nonce}">body { color: red }</style>` +
+				`<script nonce="fixed">var s = "<script>";</script>` +
+				`<scripts></scripts>` +
+				`</head><body>héllo</body></html>`,
+		},
+		{
+			name:        "not html",
+			contentType: "text/plain",
+			rewriteBody: true,
+			expectBody:  cspTestHTML,
+		},
+	}
+	nonceRegexp := regexp.MustCompile(`^script-src 'nonce-([A-Za-z0-9+/=]{24})'$`)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:    "test-func",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+				CSPConfig: lamux.CSPConfig{
+					InjectCSPNonce: true,
+					CSPPolicy:      "script-src 'nonce-{nonce}'",
+					CSPRewriteBody: tc.rewriteBody,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200, payload: cspTestPayload(t, tc.contentType, tc.base64Encoded)}
+			app.SetTestClient(client)
+
+			r, _ := http.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Forwarded-Host", "test.example.net")
+			r.Header.Set("X-Lamux-CSP-Nonce", "spoofed")
+			w := httptest.NewRecorder()
+			if err := app.HandleProxy(context.Background(), w, r); err != nil {
+				t.Fatal(err)
+			}
+
+			var payload struct {
+				Headers map[string]string `json:"headers"`
+			}
+			if err := json.Unmarshal(client.input.Payload, &payload); err != nil {
+				t.Fatal(err)
+			}
+			nonce := payload.Headers["x-lamux-csp-nonce"]
+			if nonce == "" || nonce == "spoofed" {
+				t.Fatalf("unexpected nonce passed to the function: %q", nonce)
+			}
+
+			csp := w.Header().Get("Content-Security-Policy")
+			if !tc.expectHeader {
+				if csp != "default-src *" {
+					t.Errorf("csp header must not be changed, got %q", csp)
+				}
+			} else {
+				m := nonceRegexp.FindStringSubmatch(csp)
+				if m == nil {
+					t.Fatalf("unexpected csp header: %q", csp)
+				}
+				if m[1] != nonce {
+					t.Errorf("nonce mismatch: header %q, function %q", m[1], nonce)
+				}
+			}
+			expectBody := strings.ReplaceAll(tc.expectBody, "{nonce}", nonce)
+			if a := w.Body.String(); a != expectBody {
+				t.Errorf("unexpected body:\nexpect %s\ngot    %s", expectBody, a)
+			}
+			if tc.rewriteBody && tc.expectHeader && w.Header().Get("Content-Length") != "" {
+				t.Error("content-length must be removed after rewriting body")
+			}
+		})
+	}
+}
+
+func TestCSPNonceUnique(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		CSPConfig: lamux.CSPConfig{
+			InjectCSPNonce: true,
+			CSPPolicy:      "script-src 'nonce-{nonce}'",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200, payload: cspTestPayload(t, "text/html", false)})
+	seen := make(map[string]struct{})
+	for i := 0; i < 10; i++ {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Forwarded-Host", "test.example.net")
+		w := httptest.NewRecorder()
+		if err := app.HandleProxy(context.Background(), w, r); err != nil {
+			t.Fatal(err)
+		}
+		csp := w.Header().Get("Content-Security-Policy")
+		if _, ok := seen[csp]; ok {
+			t.Fatalf("nonce is reused: %s", csp)
+		}
+		seen[csp] = struct{}{}
+	}
+}
+
+func TestCSPConfigValidate(t *testing.T) {
+	cfg := &lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		CSPConfig: lamux.CSPConfig{
+			InjectCSPNonce: true,
+			CSPPolicy:      "script-src 'self'",
+		},
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for csp policy without {nonce}")
+	}
+}
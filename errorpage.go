@@ -0,0 +1,73 @@
+package lamux
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// errorPage is a static response body served instead of the plaintext error message.
+type errorPage struct {
+	contentType string
+	body        []byte
+}
+
+func loadErrorPage(path string) (*errorPage, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	ct := mime.TypeByExtension(filepath.Ext(path))
+	if ct == "" {
+		ct = http.DetectContentType(b)
+	}
+	return &errorPage{contentType: ct, body: b}, nil
+}
+
+// writeError writes the error response in Config.ErrorResponseFormat.
+// The error message is replaced with the status text when Config.HideErrorDetails is set.
+// The message of HandlerError is written instead of the error unless the log level is debug.
+// 404 responses are written in JSON with the valid routes when Config.Verbose404 is set.
+func (l *Lamux) writeError(w http.ResponseWriter, err error, code int, requestID string) {
+	msg := err.Error()
+	var herr *HandlerError
+	isHandlerError := errors.As(err, &herr)
+	if isHandlerError && herr.message != "" && l.Config.LogLevel != "debug" {
+		msg = herr.message
+	}
+	if l.Config.HideErrorDetails {
+		msg = http.StatusText(code)
+	}
+	var routes []route
+	if l.Config.Verbose404 && code == http.StatusNotFound && isHandlerError {
+		routes = herr.routes
+	}
+	if l.Config.ErrorResponseFormat != "json" && routes == nil {
+		http.Error(w, msg, code)
+		return
+	}
+	b, _ := json.Marshal(struct {
+		Error     string  `json:"error"`
+		RequestID string  `json:"request_id,omitempty"`
+		Routes    []route `json:"routes,omitempty"`
+	}{Error: msg, RequestID: requestID, Routes: routes})
+	b = append(b, '\n')
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	w.Write(b)
+}
+
+func (p *errorPage) write(w http.ResponseWriter, code int) {
+	w.Header().Set("Content-Type", p.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(p.body)))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(code)
+	w.Write(p.body)
+}
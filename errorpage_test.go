@@ -0,0 +1,230 @@
+package lamux_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	slogcontext "github.com/PumpkinSeed/slog-context"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/fujiwara/lamux"
+)
+
+func TestTimeoutBody(t *testing.T) {
+	body := "<html><body>Sorry, the server is busy. Please try again later.</body></html>\n"
+	path := filepath.Join(t.TempDir(), "timeout.html")
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: 100 * time.Millisecond,
+		TimeoutBodyFile: path,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		app.SetTestClient(&mockClient{code: 200, latency: time.Second})
+		w := httptest.NewRecorder()
+		app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+		if e, a := http.StatusGatewayTimeout, w.Code; e != a {
+			t.Errorf("expect %d, got %d", e, a)
+		}
+		if e, a := "text/html; charset=utf-8", w.Header().Get("Content-Type"); e != a {
+			t.Errorf("expect content type %q, got %q", e, a)
+		}
+		if e, a := body, w.Body.String(); e != a {
+			t.Errorf("expect body %q, got %q", e, a)
+		}
+	})
+
+	t.Run("other errors", func(t *testing.T) {
+		app.SetTestClient(&mockClient{code: 500})
+		w := httptest.NewRecorder()
+		app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+		if e, a := http.StatusBadGateway, w.Code; e != a {
+			t.Errorf("expect %d, got %d", e, a)
+		}
+		if w.Body.String() == body {
+			t.Error("timeout body must not be served on other errors")
+		}
+	})
+}
+
+func TestTimeoutBodyFileNotFound(t *testing.T) {
+	_, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		TimeoutBodyFile: filepath.Join(t.TempDir(), "notfound.html"),
+	})
+	if err == nil {
+		t.Error("expected error for nonexistent timeout body file")
+	}
+}
+
+func TestErrorResponseFormat(t *testing.T) {
+	cases := []struct {
+		name        string
+		format      string
+		hide        bool
+		contentType string
+		check       func(t *testing.T, body string)
+	}{
+		{
+			name:        "text",
+			format:      "text",
+			contentType: "text/plain; charset=utf-8",
+			check: func(t *testing.T, body string) {
+				if body == http.StatusText(http.StatusBadGateway)+"\n" {
+					t.Errorf("expect error details, got %q", body)
+				}
+			},
+		},
+		{
+			name:        "text hidden",
+			format:      "text",
+			hide:        true,
+			contentType: "text/plain; charset=utf-8",
+			check: func(t *testing.T, body string) {
+				if e, a := http.StatusText(http.StatusBadGateway)+"\n", body; e != a {
+					t.Errorf("expect body %q, got %q", e, a)
+				}
+			},
+		},
+		{
+			name:        "json",
+			format:      "json",
+			contentType: "application/json",
+			check: func(t *testing.T, body string) {
+				var v map[string]string
+				if err := json.Unmarshal([]byte(body), &v); err != nil {
+					t.Fatal(err)
+				}
+				if v["error"] == "" || v["error"] == http.StatusText(http.StatusBadGateway) {
+					t.Errorf("expect error details, got %q", v["error"])
+				}
+				if e, a := "req-123", v["request_id"]; e != a {
+					t.Errorf("expect request_id %q, got %q", e, a)
+				}
+			},
+		},
+		{
+			name:        "json hidden",
+			format:      "json",
+			hide:        true,
+			contentType: "application/json",
+			check: func(t *testing.T, body string) {
+				e := `{"error":"Bad Gateway","request_id":"req-123"}` + "\n"
+				if body != e {
+					t.Errorf("expect body %q, got %q", e, body)
+				}
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:        "test-func",
+				DomainSuffix:        "example.net",
+				UpstreamTimeout:     time.Second,
+				ErrorResponseFormat: tc.format,
+				HideErrorDetails:    tc.hide,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.SetTestClient(&mockClient{code: 500})
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "http://test.example.net/", nil)
+			req.Header.Set("X-Lamux-Request-Id", "req-123")
+			app.Handler().ServeHTTP(w, req)
+			if e, a := http.StatusBadGateway, w.Code; e != a {
+				t.Errorf("expect %d, got %d", e, a)
+			}
+			if e, a := tc.contentType, w.Header().Get("Content-Type"); e != a {
+				t.Errorf("expect content type %q, got %q", e, a)
+			}
+			tc.check(t, w.Body.String())
+		})
+	}
+}
+
+func TestErrorResponseFormatValidation(t *testing.T) {
+	_, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:        "test-func",
+		DomainSuffix:        "example.net",
+		UpstreamTimeout:     time.Second,
+		ErrorResponseFormat: "xml",
+	})
+	if err == nil {
+		t.Error("expected error for invalid error response format")
+	}
+}
+
+func TestFunctionNotFound(t *testing.T) {
+	arn := "arn:aws:lambda:ap-northeast-1:123456789012:function:test-func:test"
+	for _, tc := range []struct {
+		logLevel string
+		expect   string
+	}{
+		{"info", "function test-func with alias test is not found\n"},
+		{"debug", arn},
+	} {
+		t.Run(tc.logLevel, func(t *testing.T) {
+			var buf bytes.Buffer
+			orig := slog.Default()
+			slog.SetDefault(slog.New(slogcontext.NewHandler(slog.NewJSONHandler(&buf, nil))))
+			defer slog.SetDefault(orig)
+
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:    "*",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+				LogLevel:        tc.logLevel,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.SetTestClient(&mockClient{code: 200, err: &types.ResourceNotFoundException{
+				Message: aws.String("Function not found: " + arn),
+			}})
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test-test-func.example.net/", nil))
+			if e, a := http.StatusNotFound, w.Code; e != a {
+				t.Errorf("expect %d, got %d", e, a)
+			}
+			if body := w.Body.String(); !strings.Contains(body, tc.expect) {
+				t.Errorf("expect body contains %q, got %q", tc.expect, body)
+			}
+			if tc.logLevel == "info" && strings.Contains(w.Body.String(), "arn:") {
+				t.Errorf("body must not contain ARNs: %q", w.Body.String())
+			}
+			var found bool
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var entry struct {
+					Msg          string `json:"msg"`
+					FunctionName string `json:"function_name"`
+					Alias        string `json:"alias"`
+				}
+				if err := json.Unmarshal([]byte(line), &entry); err == nil && entry.Msg == "function not found" {
+					found = entry.FunctionName == "test-func" && entry.Alias == "test"
+				}
+			}
+			if !found {
+				t.Errorf("function not found log with the function name and alias not found in %s", buf.String())
+			}
+		})
+	}
+}
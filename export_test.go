@@ -2,18 +2,186 @@ package lamux
 
 import (
 	"context"
+	"io"
+	"log/slog"
+	"math/rand/v2"
 	"net/http"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	otellog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 type LambdaClient lambdaClient
 
+type STSClient stsClient
+
 func (l *Lamux) SetTestClient(client LambdaClient) {
 	l.awsCfg = aws.Config{}
 	l.lambdaClient = client
 }
 
+func (l *Lamux) ValidateFunctions(ctx context.Context) error {
+	return l.validateFunctions(ctx)
+}
+
 func (l *Lamux) HandleProxy(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	return l.handleProxy(ctx, w, r)
 }
+
+func (l *Lamux) Handler() http.Handler {
+	return l.newHandler()
+}
+
+func ParseConfig(args []string) (*Config, error) {
+	return parseConfig(args)
+}
+
+func NewLogHandler(cfg *Config) (slog.Handler, func() error, error) {
+	return newLogHandler(cfg)
+}
+
+func SetStdout(w io.Writer) func() {
+	orig := stdout
+	stdout = w
+	return func() { stdout = orig }
+}
+
+func (l *Lamux) InstanceID() string {
+	return l.instanceID
+}
+
+func (l *Lamux) ConcurrencyEntries() int {
+	l.concurrency.mu.Lock()
+	defer l.concurrency.mu.Unlock()
+	return len(l.concurrency.sems) + len(l.concurrency.reserved)
+}
+
+func (l *Lamux) RateLimitBuckets() int {
+	l.rateLimiter.mu.Lock()
+	defer l.rateLimiter.mu.Unlock()
+	return len(l.rateLimiter.buckets)
+}
+
+func (l *Lamux) ConcurrencyLimit(ctx context.Context, functionName string) (int, bool) {
+	if l.concurrency == nil {
+		return 0, false
+	}
+	sem, done := l.concurrency.semaphore(ctx, functionName)
+	defer done()
+	if sem == nil {
+		return 0, false
+	}
+	return cap(sem.ch), true
+}
+
+func (l *Lamux) SetTestMeterProvider(mp metric.MeterProvider) (err error) {
+	l.otelMetrics, err = newOtelMetrics(mp)
+	return err
+}
+
+func (l *Lamux) SetTestLoggerProvider(lp otellog.LoggerProvider) {
+	l.accessLogger = lp.Logger("github.com/fujiwara/lamux")
+}
+
+func SetupOtelSDK(ctx context.Context, tc *TraceConfig, mc *MetricConfig) (func(context.Context) error, error) {
+	return setupOtelSDK(ctx, tc, mc)
+}
+
+func (l *Lamux) RunWarmup(ctx context.Context) {
+	l.runWarmup(ctx)
+}
+
+func (l *Lamux) SetTestAWSIdentity(region, account string) {
+	l.identity.region, l.identity.account, l.identity.resolved = region, account, true
+}
+
+func (l *Lamux) SetTestPayloadLogSeed(seed uint64) {
+	l.payloadLogger.rand = rand.New(rand.NewPCG(seed, seed))
+}
+
+func WaitForCredentials(ctx context.Context, provider aws.CredentialsProvider, timeout time.Duration) error {
+	return waitForCredentials(ctx, provider, timeout)
+}
+
+func SetConcurrencyLookupBackoff(d time.Duration) func() {
+	orig := concurrencyLookupBackoff
+	concurrencyLookupBackoff = d
+	return func() { concurrencyLookupBackoff = orig }
+}
+
+func SetMaxRateLimitBuckets(n int) func() {
+	orig := maxRateLimitBuckets
+	maxRateLimitBuckets = n
+	return func() { maxRateLimitBuckets = orig }
+}
+
+func SetCredentialRetryInterval(d time.Duration) func() {
+	orig := credentialRetryInterval
+	credentialRetryInterval = d
+	return func() { credentialRetryInterval = orig }
+}
+
+func NewPropagator(tc *TraceConfig) propagation.TextMapPropagator {
+	return newPropagator(tc)
+}
+
+func NewSampler(tc *TraceConfig) sdktrace.Sampler {
+	return newSampler(tc)
+}
+
+func NewTraceProvider(ctx context.Context, tc *TraceConfig) (*sdktrace.TracerProvider, error) {
+	return newTraceProvider(ctx, tc)
+}
+
+func NewTraceExporter(ctx context.Context, tc *TraceConfig) (sdktrace.SpanExporter, error) {
+	return newTraceExporter(ctx, tc)
+}
+
+func NewLogExporter(ctx context.Context, tc *TraceConfig) (sdklog.Exporter, error) {
+	return newLogExporter(ctx, tc)
+}
+
+func NewMetricExporter(ctx context.Context, tc *TraceConfig, mc *MetricConfig) (sdkmetric.Exporter, error) {
+	return newMetricExporter(ctx, tc, mc)
+}
+
+func (l *Lamux) SetTestSTSClient(client STSClient, region string) {
+	l.stsClient = client
+	l.awsCfg.Region = region
+}
+
+func (l *Lamux) ResolveAccountID(ctx context.Context) {
+	l.resolveAccountID(ctx)
+}
+
+func (cfg *Config) LambdaOptions(o *lambda.Options) {
+	cfg.lambdaOptions(o)
+}
+
+func (l *Lamux) AWSRegion() string {
+	return l.awsCfg.Region
+}
+
+func (l *Lamux) Reload(args []string) error {
+	return l.reload(args)
+}
+
+func (l *Lamux) SetTestAWSCredentials(region string, provider aws.CredentialsProvider) {
+	l.awsCfg.Region = region
+	l.awsCfg.Credentials = provider
+}
+
+func (cfg *Config) Serve(ctx context.Context, handler http.Handler) error {
+	return cfg.serve(ctx, handler)
+}
+
+func (cfg *Config) NewServer(handler http.Handler) *http.Server {
+	return cfg.newServer(handler)
+}
@@ -0,0 +1,93 @@
+package lamux
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	forwardedProtoHeader = "X-Forwarded-Proto"
+	forwardedPortHeader  = "X-Forwarded-Port"
+)
+
+// applyForwardedPort reflects the port in X-Forwarded-Port to the Host of the request forwarded to the function,
+// so that the function can reconstruct the original URL. The port is omitted when it is the default port
+// of the scheme (X-Forwarded-Proto, or the scheme of the request).
+func applyForwardedPort(r *http.Request) error {
+	v := firstValue(r.Header.Get(forwardedPortHeader))
+	if v == "" {
+		return nil
+	}
+	port, err := parsePort(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s header: %q", forwardedPortHeader, v)
+	}
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if port == defaultPort(forwardedScheme(r, true)) {
+		r.Host = host
+	} else {
+		r.Host = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	return nil
+}
+
+// setForwardedProto sets X-Forwarded-Proto and X-Forwarded-Port of the request forwarded to the function
+// to the scheme and port of the original request, as API Gateway does, so that the function can build absolute URLs.
+// When the headers set by proxies are trusted, their first valid values are used.
+// Otherwise, the scheme of the connection and the port of Host (or the default port of the scheme) are used.
+func setForwardedProto(r *http.Request, trusted bool) {
+	scheme := forwardedScheme(r, trusted)
+	r.Header.Set(forwardedProtoHeader, scheme)
+	port := defaultPort(scheme)
+	if p, err := parsePort(firstValue(r.Header.Get(forwardedPortHeader))); trusted && err == nil {
+		port = p
+	} else if _, v, err := net.SplitHostPort(r.Host); err == nil {
+		if p, err := parsePort(v); err == nil {
+			port = p
+		}
+	}
+	r.Header.Set(forwardedPortHeader, strconv.Itoa(port))
+}
+
+// forwardedScheme returns the first value of X-Forwarded-Proto if trusted and valid (http or https),
+// otherwise the scheme of the connection.
+func forwardedScheme(r *http.Request, trusted bool) string {
+	if trusted {
+		switch proto := strings.ToLower(firstValue(r.Header.Get(forwardedProtoHeader))); proto {
+		case "http", "https":
+			return proto
+		}
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// firstValue returns the first value of the comma separated header value set by proxies.
+func firstValue(v string) string {
+	first, _, _ := strings.Cut(v, ",")
+	return strings.TrimSpace(first)
+}
+
+// parsePort parses the port number in 1-65535.
+func parsePort(s string) (int, error) {
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0, fmt.Errorf("invalid port: %q", s)
+	}
+	return port, nil
+}
+
+func defaultPort(scheme string) int {
+	if scheme == "https" {
+		return 443
+	}
+	return 80
+}
@@ -0,0 +1,148 @@
+package lamux_test
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func TestTrustForwardedPort(t *testing.T) {
+	cases := []struct {
+		name       string
+		trust      bool
+		host       string
+		port       string
+		proto      string
+		expectCode int
+		expectHost string
+		expectPort string // the port in X-Forwarded-Port by default
+	}{
+		{name: "non-standard port", trust: true, host: "test.example.net", port: "8443", proto: "https", expectCode: http.StatusOK, expectHost: "test.example.net:8443"},
+		{name: "replace listening port", trust: true, host: "test.example.net:8080", port: "8000", proto: "http", expectCode: http.StatusOK, expectHost: "test.example.net:8000"},
+		{name: "default https port", trust: true, host: "test.example.net:8080", port: "443", proto: "https", expectCode: http.StatusOK, expectHost: "test.example.net"},
+		{name: "default http port", trust: true, host: "test.example.net", port: "80", expectCode: http.StatusOK, expectHost: "test.example.net"},
+		{name: "no header", trust: true, host: "test.example.net:8080", expectCode: http.StatusOK, expectHost: "test.example.net:8080", expectPort: "8080"},
+		{name: "invalid port", trust: true, host: "test.example.net", port: "99999", expectCode: http.StatusBadRequest},
+		{name: "not trusted", host: "test.example.net", port: "8443", proto: "https", expectCode: http.StatusOK, expectHost: "test.example.net", expectPort: "80"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:       "test-func",
+				DomainSuffix:       "example.net",
+				UpstreamTimeout:    time.Second,
+				TrustForwardedPort: tc.trust,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200}
+			app.SetTestClient(client)
+			r := httptest.NewRequest("GET", "http://"+tc.host+"/", nil)
+			if tc.port != "" {
+				r.Header.Set("X-Forwarded-Port", tc.port)
+			}
+			if tc.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tc.proto)
+			}
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, r)
+			if e, a := tc.expectCode, w.Code; e != a {
+				t.Fatalf("expect %d, got %d", e, a)
+			}
+			if tc.expectCode != http.StatusOK {
+				return
+			}
+			var payload struct {
+				Headers        map[string]string `json:"headers"`
+				RequestContext struct {
+					DomainName string `json:"domainName"`
+				} `json:"requestContext"`
+			}
+			if err := json.Unmarshal(client.input.Payload, &payload); err != nil {
+				t.Fatal(err)
+			}
+			if e, a := tc.expectHost, payload.Headers["host"]; e != a {
+				t.Errorf("expect host %q, got %q", e, a)
+			}
+			if e, a := tc.expectHost, payload.RequestContext.DomainName; e != a {
+				t.Errorf("expect domain name %q, got %q", e, a)
+			}
+			if e, a := cmp.Or(tc.expectPort, tc.port), payload.Headers["x-forwarded-port"]; e != a {
+				t.Errorf("expect x-forwarded-port %q, got %q", e, a)
+			}
+		})
+	}
+}
+
+func TestForwardedProto(t *testing.T) {
+	cases := []struct {
+		name        string
+		url         string
+		trust       bool
+		proto       string
+		port        string
+		expectCode  int
+		expectProto string
+		expectPort  string
+	}{
+		{name: "http", url: "http://test.example.net/", expectProto: "http", expectPort: "80"},
+		{name: "https", url: "https://test.example.net/", expectProto: "https", expectPort: "443"},
+		{name: "port in host", url: "http://test.example.net:8080/", expectProto: "http", expectPort: "8080"},
+		{name: "untrusted", url: "http://test.example.net:8080/", proto: "https", port: "443", expectProto: "http", expectPort: "8080"},
+		{name: "behind proxy", url: "http://test.example.net:8080/", trust: true, proto: "https", port: "443", expectProto: "https", expectPort: "443"},
+		{name: "proto only", url: "http://test.example.net/", trust: true, proto: "HTTPS", expectProto: "https", expectPort: "443"},
+		{name: "multiple proxies", url: "http://test.example.net/", trust: true, proto: "https, http", port: "8443, 80", expectProto: "https", expectPort: "8443"},
+		{name: "invalid proto", url: "http://test.example.net/", trust: true, proto: "javascript", expectProto: "http", expectPort: "80"},
+		{name: "invalid port", url: "http://test.example.net/", trust: true, port: "abc", expectCode: http.StatusBadRequest},
+		{name: "invalid port untrusted", url: "http://test.example.net/", port: "abc", expectProto: "http", expectPort: "80"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:       "test-func",
+				DomainSuffix:       "example.net",
+				UpstreamTimeout:    time.Second,
+				ForwardHeaders:     []string{"User-Agent"},
+				TrustForwardedPort: tc.trust,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200}
+			app.SetTestClient(client)
+			r := httptest.NewRequest("GET", tc.url, nil)
+			if tc.proto != "" {
+				r.Header.Set("X-Forwarded-Proto", tc.proto)
+			}
+			if tc.port != "" {
+				r.Header.Set("X-Forwarded-Port", tc.port)
+			}
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, r)
+			if e, a := cmp.Or(tc.expectCode, http.StatusOK), w.Code; e != a {
+				t.Fatalf("expect %d, got %d", e, a)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var payload struct {
+				Headers map[string]string `json:"headers"`
+			}
+			if err := json.Unmarshal(client.input.Payload, &payload); err != nil {
+				t.Fatal(err)
+			}
+			if e, a := tc.expectProto, payload.Headers["x-forwarded-proto"]; e != a {
+				t.Errorf("expect x-forwarded-proto %q, got %q", e, a)
+			}
+			if e, a := tc.expectPort, payload.Headers["x-forwarded-port"]; e != a {
+				t.Errorf("expect x-forwarded-port %q, got %q", e, a)
+			}
+		})
+	}
+}
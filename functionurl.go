@@ -0,0 +1,314 @@
+package lamux
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	slogcontext "github.com/PumpkinSeed/slog-context"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+const (
+	backendInvoke      = "invoke"
+	backendFunctionURL = "function-url"
+)
+
+var functionURLPlaceholders = []string{"{function}", "{alias}", "{region}"}
+
+// functionURLClient sends HTTP requests to Function URLs.
+type functionURLClient interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+func newFunctionURLClient() *http.Client {
+	return &http.Client{
+		// redirects are passed through to clients
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// validateFunctionURLTemplate validates the template of Function URLs.
+// e.g. https://{alias}-{function}.example.com/
+func validateFunctionURLTemplate(tmpl string) error {
+	for _, p := range arnPlaceholderRegexp.FindAllString(tmpl, -1) {
+		if !slices.Contains(functionURLPlaceholders, p) {
+			return fmt.Errorf("unsupported placeholder in function URL template: %s (%s allowed)", p, strings.Join(functionURLPlaceholders, ", "))
+		}
+	}
+	u, err := url.Parse(strings.NewReplacer("{function}", "function", "{alias}", "alias", "{region}", "region").Replace(tmpl))
+	if err != nil {
+		return fmt.Errorf("invalid function URL template: %w", err)
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("function URL template must be an absolute http or https URL")
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("function URL template must not contain a query or fragment")
+	}
+	return nil
+}
+
+// functionURL returns the URL to request the Function URL of the function and alias,
+// with the path and query of the request appended.
+func (l *Lamux) functionURL(functionName, alias string, r *http.Request) (string, error) {
+	base := strings.NewReplacer(
+		"{function}", functionName,
+		"{alias}", alias,
+		"{region}", l.awsCfg.Region,
+	).Replace(l.Config.FunctionURLTemplate)
+	u, err := url.Parse(strings.TrimSuffix(base, "/") + r.URL.RequestURI())
+	if err != nil {
+		return "", fmt.Errorf("invalid function URL: %w", err)
+	}
+	return u.String(), nil
+}
+
+// proxyFunctionURL proxies the request to the Function URL of the function instead of Invoke API,
+// and passes the response through to the client.
+func (l *Lamux) proxyFunctionURL(ctx context.Context, w http.ResponseWriter, r *http.Request, functionName, alias, qualifier, nonce string, body []byte) error {
+	info := getRequestInfo(ctx)
+	if l.concurrency != nil {
+		release, err := l.concurrency.acquire(ctx, functionName)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	invokeStart := time.Now()
+	res, err := l.InvokeFunctionURL(ctx, r, functionName, qualifier, body)
+	upstreamDuration := time.Since(invokeStart)
+	ctx = slogcontext.WithValue(ctx, "upstream_duration", upstreamDuration.Seconds())
+	span := oteltrace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.KeyValue{
+		Key:   attribute.Key("lamux.upstream_duration"),
+		Value: attribute.Float64Value(upstreamDuration.Seconds()),
+	})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if l.concurrency != nil {
+		l.concurrency.validated(functionName)
+	}
+
+	removeHopByHopHeaders(res.Header, l.Config.hopByHopHeaders())
+	if limit := l.Config.MaxResponseHeaderCount; limit > 0 {
+		if n := countHTTPHeaders(res.Header); n > limit {
+			return newHandlerError(fmt.Errorf("too many response headers: %d (max %d)", n, limit), http.StatusBadGateway)
+		}
+	}
+	if len(l.Config.AllowedResponseContentTypes) > 0 {
+		if err := checkContentType(res.Header.Get("Content-Type"), res.ContentLength != 0, l.Config.AllowedResponseContentTypes); err != nil {
+			return newHandlerError(err, http.StatusBadGateway)
+		}
+	}
+	eventStream := l.Config.SSEPassthrough && isEventStream(res.Header.Get("Content-Type"))
+	if l.Config.InjectCSPNonce {
+		if err := l.Config.CSPConfig.injectHTTPCSPNonce(res, nonce); err != nil {
+			return newHandlerError(err, http.StatusBadGateway)
+		}
+	}
+	if !eventStream {
+		if err := rewriteHTTPResponseBody(res, l.Config.ResponseRewrites); err != nil {
+			return newHandlerError(err, http.StatusBadGateway)
+		}
+	}
+	for k, vs := range res.Header {
+		w.Header()[k] = vs
+	}
+	for k, v := range l.Config.responseHeaders(alias) {
+		if l.Config.OverrideResponseHeaders || w.Header().Get(k) == "" {
+			w.Header().Set(k, v)
+		}
+	}
+	if res.StatusCode == http.StatusTooManyRequests && l.Config.RateLimitSourceHeader {
+		w.Header().Set(rateLimitSourceHeader, "function")
+	}
+	if eventStream {
+		setEventStreamHeaders(w.Header())
+	}
+	code := res.StatusCode
+	if c, ok := l.Config.StatusCodeOverrides[code]; ok {
+		code = c
+	}
+	info.status = code
+	w.WriteHeader(code)
+	var size int64
+	if eventStream {
+		size, err = copyEventStream(w, res.Body)
+	} else {
+		size, err = io.Copy(w, res.Body)
+		if err != nil {
+			err = fmt.Errorf("failed to write response: %w", err)
+		}
+	}
+	ctx = slogcontext.WithValue(ctx, "response_size", size)
+	span.SetAttributes(attribute.KeyValue{
+		Key:   attribute.Key("http.response.body.size"),
+		Value: attribute.Int64Value(size),
+	})
+	if err != nil {
+		return err
+	}
+	if code != res.StatusCode {
+		slog.InfoContext(ctx, "handleProxy", "upstream_status", res.StatusCode, "overridden_status", code)
+	} else {
+		slog.InfoContext(ctx, "handleProxy", "upstream_status", res.StatusCode)
+	}
+	return nil
+}
+
+// InvokeFunctionURL sends the request to the Function URL of the function and alias.
+// The upstream timeout is applied until the response body is closed.
+func (l *Lamux) InvokeFunctionURL(ctx context.Context, r *http.Request, functionName, alias string, body []byte) (*http.Response, error) {
+	ctx, span := tracer.Start(ctx, "InvokeFunctionURL")
+	defer span.End()
+	span.SetAttributes(
+		attribute.KeyValue{
+			Key:   attribute.Key("lambda.function_name"),
+			Value: attribute.StringValue(functionName),
+		},
+		attribute.KeyValue{
+			Key:   attribute.Key("lambda.alias"),
+			Value: attribute.StringValue(alias),
+		},
+	)
+
+	if l.circuitBreaker == nil {
+		return l.invokeFunctionURL(ctx, span, r, functionName, alias, body)
+	}
+	if err := l.circuitBreaker.allow(ctx, functionName, alias); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	res, err := l.invokeFunctionURL(ctx, span, r, functionName, alias, body)
+	l.circuitBreaker.done(ctx, functionName, alias, err)
+	return res, err
+}
+
+func (l *Lamux) invokeFunctionURL(ctx context.Context, span oteltrace.Span, r *http.Request, functionName, alias string, body []byte) (*http.Response, error) {
+	timeout := l.Config.FunctionTimeout(functionName)
+	span.SetAttributes(attribute.KeyValue{
+		Key:   attribute.Key("lamux.upstream_timeout"),
+		Value: attribute.Float64Value(timeout.Seconds()),
+	})
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+
+	u, err := l.functionURL(functionName, alias, r)
+	if err != nil {
+		cancel()
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, r.Method, u, bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		span.SetStatus(codes.Error, err.Error())
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header = r.Header.Clone()
+	req.Header.Del("Content-Length") // set by the body
+	l.Config.filterRequestHeaders(req.Header)
+	if req.Header.Get("X-Forwarded-Host") == "" {
+		req.Header.Set("X-Forwarded-Host", r.Host)
+	}
+	if l.Config.FunctionURLAuth == "iam" {
+		if err := l.signFunctionURLRequest(ctx, req, body); err != nil {
+			cancel()
+			span.SetStatus(codes.Error, err.Error())
+			return nil, err
+		}
+	}
+	slog.DebugContext(ctx, "InvokeFunctionURL", "function_url", u, "payload_size", len(body), "upstream_timeout", timeout.Seconds())
+	start := time.Now()
+	res, err := l.functionURLClient.Do(req)
+	elapsed := time.Since(start)
+	if err != nil {
+		defer cancel()
+		return nil, l.invokeError(ctx, span, functionName, alias, elapsed, err)
+	}
+	l.coldStarts.seen(functionName, alias)
+	span.SetAttributes(attribute.KeyValue{
+		Key:   attribute.Key("lambda.status_code"),
+		Value: attribute.IntValue(res.StatusCode),
+	})
+	l.observeInvoke(ctx, functionName, alias, elapsed, nil)
+	res.Body = &cancelOnCloseBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// rewriteHTTPResponseBody applies the response rewrites to the body of the response from Function URLs.
+// The body is buffered only when any rule matches.
+func rewriteHTTPResponseBody(res *http.Response, rules []ResponseRewriteRule) error {
+	rules = matchingRewrites(res.Header.Get("Content-Type"), res.Header.Get("Content-Encoding"), rules)
+	if len(rules) == 0 {
+		return nil
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	body := applyRewrites(string(b), rules)
+	res.Body = io.NopCloser(strings.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	return nil
+}
+
+func countHTTPHeaders(h http.Header) int {
+	n := 0
+	for _, vs := range h {
+		n += len(vs)
+	}
+	return n
+}
+
+// signFunctionURLRequest signs the request by SigV4 for Function URLs with the AWS_IAM auth type.
+func (l *Lamux) signFunctionURLRequest(ctx context.Context, req *http.Request, body []byte) error {
+	if l.awsCfg.Credentials == nil {
+		return errors.New("failed to sign request: credentials are not configured")
+	}
+	if l.awsCfg.Region == "" {
+		return errors.New("failed to sign request: region is not configured")
+	}
+	creds, err := l.awsCfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retrieve credentials: %w", err)
+	}
+	sum := sha256.Sum256(body)
+	hash := hex.EncodeToString(sum[:])
+	req.Header.Set("X-Amz-Content-Sha256", hash)
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, hash, "lambda", l.awsCfg.Region, time.Now()); err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+	return nil
+}
+
+// cancelOnCloseBody cancels the context of the request when the response body is closed.
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,379 @@
+package lamux_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/fujiwara/lamux"
+)
+
+type functionURLRequest struct {
+	method string
+	uri    string
+	body   string
+	header http.Header
+}
+
+func newFunctionURLServer(t *testing.T, latency time.Duration) (*httptest.Server, chan functionURLRequest) {
+	t.Helper()
+	ch := make(chan functionURLRequest, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		ch <- functionURLRequest{method: r.Method, uri: r.RequestURI, body: string(b), header: r.Header}
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("X-Function", "ok")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+	t.Cleanup(ts.Close)
+	return ts, ch
+}
+
+func TestFunctionURLBackend(t *testing.T) {
+	ts, ch := newFunctionURLServer(t, 0)
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:        "test-func",
+		DomainSuffix:        "example.net",
+		UpstreamTimeout:     time.Second,
+		Backend:             "function-url",
+		FunctionURLTemplate: ts.URL + "/{function}/{alias}/",
+		ResponseHeaders:     map[string]string{"X-Content-Type-Options": "nosniff"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{code: 200}
+	app.SetTestClient(client)
+
+	req := httptest.NewRequest("POST", "http://test.example.net/foo/bar?x=1&y=2", strings.NewReader("hello"))
+	req.Header.Set("X-Custom", "value")
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, req)
+	if e, a := http.StatusCreated, w.Code; e != a {
+		t.Fatalf("expect %d, got %d", e, a)
+	}
+	if e, a := "created", w.Body.String(); e != a {
+		t.Errorf("expect body %q, got %q", e, a)
+	}
+	for k, v := range map[string]string{"X-Function": "ok", "Content-Type": "text/plain", "X-Content-Type-Options": "nosniff"} {
+		if e, a := v, w.Header().Get(k); e != a {
+			t.Errorf("expect %s: %q, got %q", k, e, a)
+		}
+	}
+
+	got := <-ch
+	if e, a := "POST", got.method; e != a {
+		t.Errorf("expect method %s, got %s", e, a)
+	}
+	if e, a := "/test-func/test/foo/bar?x=1&y=2", got.uri; e != a {
+		t.Errorf("expect uri %s, got %s", e, a)
+	}
+	if e, a := "hello", got.body; e != a {
+		t.Errorf("expect body %q, got %q", e, a)
+	}
+	for k, v := range map[string]string{"X-Custom": "value", "X-Forwarded-Host": "test.example.net"} {
+		if e, a := v, got.header.Get(k); e != a {
+			t.Errorf("expect request header %s: %q, got %q", k, e, a)
+		}
+	}
+	if got.header.Get("Authorization") != "" {
+		t.Error("request must not be signed without iam auth")
+	}
+	if n := len(client.invoked()); n != 0 {
+		t.Errorf("Invoke API must not be called, got %d", n)
+	}
+}
+
+func TestFunctionURLBackendIAM(t *testing.T) {
+	ts, ch := newFunctionURLServer(t, 0)
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:        "test-func",
+		DomainSuffix:        "example.net",
+		UpstreamTimeout:     time.Second,
+		Backend:             "function-url",
+		FunctionURLTemplate: ts.URL,
+		FunctionURLAuth:     "iam",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200})
+	app.SetTestAWSCredentials("ap-northeast-1", aws.CredentialsProviderFunc(func(context.Context) (aws.Credentials, error) {
+		return aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}, nil
+	}))
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+	if e, a := http.StatusCreated, w.Code; e != a {
+		t.Fatalf("expect %d, got %d", e, a)
+	}
+	got := <-ch
+	auth := got.header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") || !strings.Contains(auth, "/ap-northeast-1/lambda/aws4_request") {
+		t.Errorf("unexpected Authorization header: %q", auth)
+	}
+	if got.header.Get("X-Amz-Content-Sha256") == "" {
+		t.Error("X-Amz-Content-Sha256 header must be set")
+	}
+}
+
+func TestFunctionURLBackendTimeout(t *testing.T) {
+	ts, _ := newFunctionURLServer(t, 5*time.Second)
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:        "test-func",
+		DomainSuffix:        "example.net",
+		UpstreamTimeout:     100 * time.Millisecond,
+		Backend:             "function-url",
+		FunctionURLTemplate: ts.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200})
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+	if e, a := http.StatusGatewayTimeout, w.Code; e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+}
+
+func TestFunctionURLBackendValidation(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		template string
+	}{
+		{name: "no template"},
+		{name: "unsupported placeholder", template: "https://{account}.example.com"},
+		{name: "relative URL", template: "/{function}"},
+		{name: "query", template: "https://{function}.example.com/?x=1"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &lamux.Config{
+				FunctionName:        "test-func",
+				DomainSuffix:        "example.net",
+				UpstreamTimeout:     time.Second,
+				Backend:             "function-url",
+				FunctionURLTemplate: tc.template,
+			}
+			if err := cfg.Validate(); err == nil {
+				t.Error("expected validation error")
+			}
+		})
+	}
+}
+
+func TestFunctionURLBackendForwardHeaders(t *testing.T) {
+	ts, ch := newFunctionURLServer(t, 0)
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:        "test-func",
+		DomainSuffix:        "example.net",
+		UpstreamTimeout:     time.Second,
+		Backend:             "function-url",
+		FunctionURLTemplate: ts.URL + "/{function}/{alias}/",
+		ForwardHeaders:      []string{"X-Allowed", "Authorization"},
+		DropHeaders:         []string{"Authorization"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200})
+	req := httptest.NewRequest("GET", "http://test.example.net/", nil)
+	req.Header.Set("X-Allowed", "yes")
+	req.Header.Set("X-Secret", "no")
+	req.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, req)
+	if e, a := http.StatusCreated, w.Code; e != a {
+		t.Fatalf("expect %d, got %d", e, a)
+	}
+	got := <-ch
+	if e, a := "yes", got.header.Get("X-Allowed"); e != a {
+		t.Errorf("expect X-Allowed %q, got %q", e, a)
+	}
+	for _, name := range []string{"X-Secret", "Authorization"} {
+		if v := got.header.Get(name); v != "" {
+			t.Errorf("%s must not be forwarded, got %q", name, v)
+		}
+	}
+	if got.header.Get("X-Lamux-Request-Id") == "" {
+		t.Error("X-Lamux-Request-Id must be forwarded")
+	}
+}
+
+func TestFunctionURLBackendResponseChecks(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		modify     func(*lamux.Config)
+		expectCode int
+		expectBody string
+	}{
+		{
+			name:       "allowed content type",
+			modify:     func(cfg *lamux.Config) { cfg.AllowedResponseContentTypes = []string{"text/*"} },
+			expectCode: http.StatusCreated,
+			expectBody: "created",
+		},
+		{
+			name:       "disallowed content type",
+			modify:     func(cfg *lamux.Config) { cfg.AllowedResponseContentTypes = []string{"application/json"} },
+			expectCode: http.StatusBadGateway,
+		},
+		{
+			name:       "too many headers",
+			modify:     func(cfg *lamux.Config) { cfg.MaxResponseHeaderCount = 2 },
+			expectCode: http.StatusBadGateway,
+		},
+		{
+			name: "rewrite",
+			modify: func(cfg *lamux.Config) {
+				cfg.ResponseRewrites = []lamux.ResponseRewriteRule{{ContentType: "text/plain", From: "created", To: "rewritten"}}
+			},
+			expectCode: http.StatusCreated,
+			expectBody: "rewritten",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ts, _ := newFunctionURLServer(t, 0)
+			cfg := &lamux.Config{
+				FunctionName:        "test-func",
+				DomainSuffix:        "example.net",
+				UpstreamTimeout:     time.Second,
+				Backend:             "function-url",
+				FunctionURLTemplate: ts.URL + "/{function}/{alias}/",
+			}
+			tc.modify(cfg)
+			app, err := lamux.NewLamux(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.SetTestClient(&mockClient{code: 200})
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+			if e, a := tc.expectCode, w.Code; e != a {
+				t.Fatalf("expect %d, got %d", e, a)
+			}
+			if tc.expectBody == "" {
+				return
+			}
+			if e, a := tc.expectBody, w.Body.String(); e != a {
+				t.Errorf("expect body %q, got %q", e, a)
+			}
+			if e, a := strconv.Itoa(len(tc.expectBody)), w.Header().Get("Content-Length"); e != a {
+				t.Errorf("expect Content-Length %s, got %s", e, a)
+			}
+		})
+	}
+}
+
+func TestFunctionURLBackendCSPNonce(t *testing.T) {
+	nonces := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonces <- r.Header.Get("X-Lamux-CSP-Nonce")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Security-Policy", "default-src *")
+		w.Write([]byte(cspTestHTML))
+	}))
+	t.Cleanup(ts.Close)
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:        "test-func",
+		DomainSuffix:        "example.net",
+		UpstreamTimeout:     time.Second,
+		Backend:             "function-url",
+		FunctionURLTemplate: ts.URL + "/{function}/{alias}/",
+		CSPConfig: lamux.CSPConfig{
+			InjectCSPNonce: true,
+			CSPPolicy:      "script-src 'nonce-{nonce}'",
+			CSPRewriteBody: true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200})
+	req := httptest.NewRequest("GET", "http://test.example.net/", nil)
+	req.Header.Set("X-Lamux-CSP-Nonce", "spoofed")
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, req)
+	if e, a := http.StatusOK, w.Code; e != a {
+		t.Fatalf("expect %d, got %d", e, a)
+	}
+	nonce := <-nonces
+	if nonce == "" || nonce == "spoofed" {
+		t.Fatalf("unexpected nonce forwarded to the function: %q", nonce)
+	}
+	if e, a := "script-src 'nonce-"+nonce+"'", w.Header().Get("Content-Security-Policy"); e != a {
+		t.Errorf("expect csp header %q, got %q", e, a)
+	}
+	if !strings.Contains(w.Body.String(), `<style nonce="`+nonce+`">`) {
+		t.Errorf("nonce attribute is not added: %s", w.Body.String())
+	}
+	if e, a := strconv.Itoa(w.Body.Len()), w.Header().Get("Content-Length"); e != a {
+		t.Errorf("expect Content-Length %s, got %s", e, a)
+	}
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func TestFunctionURLBackendMaxPayloadSize(t *testing.T) {
+	for _, size := range []int{1024, 1 << 20} {
+		t.Run(strconv.Itoa(size), func(t *testing.T) {
+			ts, ch := newFunctionURLServer(t, 0)
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:        "test-func",
+				DomainSuffix:        "example.net",
+				UpstreamTimeout:     time.Second,
+				Backend:             "function-url",
+				FunctionURLTemplate: ts.URL + "/{function}/{alias}/",
+				MaxPayloadSize:      1024,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.SetTestClient(&mockClient{code: 200})
+			body := &countingReader{r: strings.NewReader(strings.Repeat("a", size))}
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest("POST", "http://test.example.net/", body))
+			if size <= 1024 {
+				if e, a := http.StatusCreated, w.Code; e != a {
+					t.Fatalf("expect %d, got %d", e, a)
+				}
+				if got := <-ch; len(got.body) != size {
+					t.Errorf("expect body size %d, got %d", size, len(got.body))
+				}
+				return
+			}
+			if e, a := http.StatusRequestEntityTooLarge, w.Code; e != a {
+				t.Fatalf("expect %d, got %d", e, a)
+			}
+			if body.n > 1024+1 {
+				t.Errorf("the body must not be read beyond the limit, read %d bytes", body.n)
+			}
+			select {
+			case <-ch:
+				t.Error("function URL must not be requested")
+			default:
+			}
+		})
+	}
+}
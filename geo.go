@@ -0,0 +1,97 @@
+package lamux
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	slogcontext "github.com/PumpkinSeed/slog-context"
+)
+
+const viewerCountryHeader = "CloudFront-Viewer-Country"
+
+// geoHeaders are the geolocation headers added by CloudFront.
+var geoHeaders = []string{
+	viewerCountryHeader,
+	"CloudFront-Viewer-Country-Name",
+	"CloudFront-Viewer-Country-Region",
+	"CloudFront-Viewer-Country-Region-Name",
+	"CloudFront-Viewer-City",
+	"CloudFront-Viewer-Postal-Code",
+	"CloudFront-Viewer-Time-Zone",
+	"CloudFront-Viewer-Latitude",
+	"CloudFront-Viewer-Longitude",
+	"CloudFront-Viewer-Metro-Code",
+}
+
+var countryCodeRegexp = regexp.MustCompile(`^[a-zA-Z]{2}$`)
+
+type GeoConfig struct {
+	ForwardGeoHeaders bool     `help:"Forward and log CloudFront geolocation headers (CloudFront-Viewer-Country, etc.)" env:"LAMUX_FORWARD_GEO_HEADERS" name:"forward-geo-headers"`
+	GeoAllowCountries []string `help:"Allowed countries by CloudFront-Viewer-Country header (ISO 3166-1 alpha-2 codes)" env:"LAMUX_GEO_ALLOW_COUNTRIES" name:"geo-allow-countries"`
+	GeoDenyCountries  []string `help:"Denied countries by CloudFront-Viewer-Country header (ISO 3166-1 alpha-2 codes)" env:"LAMUX_GEO_DENY_COUNTRIES" name:"geo-deny-countries"`
+}
+
+// Enabled reports whether the country filter is enabled.
+func (gc *GeoConfig) Enabled() bool {
+	return len(gc.GeoAllowCountries) > 0 || len(gc.GeoDenyCountries) > 0
+}
+
+func (gc *GeoConfig) Validate() error {
+	for _, c := range slices.Concat(gc.GeoAllowCountries, gc.GeoDenyCountries) {
+		if !countryCodeRegexp.MatchString(c) {
+			return fmt.Errorf("invalid country code: %s", c)
+		}
+	}
+	return nil
+}
+
+// checkCountry returns an error if the country of the viewer is not allowed.
+// When the allowed countries are set, requests without the country header are rejected.
+func (gc *GeoConfig) checkCountry(h http.Header) error {
+	country := h.Get(viewerCountryHeader)
+	if country != "" && containsFold(gc.GeoDenyCountries, country) {
+		return newHandlerError(fmt.Errorf("country %s is denied", country), http.StatusForbidden)
+	}
+	if len(gc.GeoAllowCountries) > 0 {
+		if country == "" {
+			return newHandlerError(fmt.Errorf("missing %s header", viewerCountryHeader), http.StatusForbidden)
+		}
+		if !containsFold(gc.GeoAllowCountries, country) {
+			return newHandlerError(fmt.Errorf("country %s is not allowed", country), http.StatusForbidden)
+		}
+	}
+	return nil
+}
+
+func containsFold(list []string, s string) bool {
+	return slices.ContainsFunc(list, func(v string) bool {
+		return strings.EqualFold(v, s)
+	})
+}
+
+// setGeoContext adds the geolocation headers to the log context.
+// e.g. CloudFront-Viewer-Country is logged as cloudfront_viewer_country.
+func setGeoContext(ctx context.Context, h http.Header) context.Context {
+	for _, name := range geoHeaders {
+		if v := h.Get(name); v != "" {
+			ctx = slogcontext.WithValue(ctx, strings.ReplaceAll(strings.ToLower(name), "-", "_"), v)
+		}
+	}
+	return ctx
+}
+
+// saveGeoHeaders returns a copy of the geolocation headers in h,
+// to be restored after removing hop-by-hop headers.
+func saveGeoHeaders(h http.Header) http.Header {
+	saved := http.Header{}
+	for _, name := range geoHeaders {
+		if vs := h.Values(name); len(vs) > 0 {
+			saved[http.CanonicalHeaderKey(name)] = slices.Clone(vs)
+		}
+	}
+	return saved
+}
@@ -0,0 +1,171 @@
+package lamux_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	slogcontext "github.com/PumpkinSeed/slog-context"
+	"github.com/fujiwara/lamux"
+)
+
+func TestGeoCountryFilter(t *testing.T) {
+	cases := []struct {
+		name       string
+		cfg        lamux.GeoConfig
+		country    string
+		expectCode int
+	}{
+		{
+			name:       "allowed",
+			cfg:        lamux.GeoConfig{GeoAllowCountries: []string{"JP", "US"}},
+			country:    "JP",
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "allowed case insensitive",
+			cfg:        lamux.GeoConfig{GeoAllowCountries: []string{"jp"}},
+			country:    "JP",
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "not allowed",
+			cfg:        lamux.GeoConfig{GeoAllowCountries: []string{"JP", "US"}},
+			country:    "FR",
+			expectCode: http.StatusForbidden,
+		},
+		{
+			name:       "no country with allow list",
+			cfg:        lamux.GeoConfig{GeoAllowCountries: []string{"JP"}},
+			expectCode: http.StatusForbidden,
+		},
+		{
+			name:       "denied",
+			cfg:        lamux.GeoConfig{GeoDenyCountries: []string{"KP"}},
+			country:    "KP",
+			expectCode: http.StatusForbidden,
+		},
+		{
+			name:       "not denied",
+			cfg:        lamux.GeoConfig{GeoDenyCountries: []string{"KP"}},
+			country:    "JP",
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "no country with deny list",
+			cfg:        lamux.GeoConfig{GeoDenyCountries: []string{"KP"}},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "deny precedes allow",
+			cfg:        lamux.GeoConfig{GeoAllowCountries: []string{"JP"}, GeoDenyCountries: []string{"JP"}},
+			country:    "JP",
+			expectCode: http.StatusForbidden,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:    "test-func",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+				GeoConfig:       tc.cfg,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200}
+			app.SetTestClient(client)
+			r := httptest.NewRequest("GET", "http://test.example.net/", nil)
+			if tc.country != "" {
+				r.Header.Set("CloudFront-Viewer-Country", tc.country)
+			}
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, r)
+			if e, a := tc.expectCode, w.Code; e != a {
+				t.Errorf("expect %d, got %d", e, a)
+			}
+			if tc.expectCode == http.StatusForbidden && client.input != nil {
+				t.Error("lambda must not be invoked")
+			}
+		})
+	}
+}
+
+func TestForwardGeoHeaders(t *testing.T) {
+	for _, forward := range []bool{true, false} {
+		var buf bytes.Buffer
+		orig := slog.Default()
+		slog.SetDefault(slog.New(slogcontext.NewHandler(slog.NewJSONHandler(&buf, nil))))
+
+		app, err := lamux.NewLamux(&lamux.Config{
+			FunctionName:    "test-func",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: time.Second,
+			GeoConfig:       lamux.GeoConfig{ForwardGeoHeaders: forward},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &mockClient{code: 200}
+		app.SetTestClient(client)
+		r := httptest.NewRequest("GET", "http://test.example.net/", nil)
+		r.Header.Set("CloudFront-Viewer-Country", "JP")
+		r.Header.Set("CloudFront-Viewer-City", "Tokyo")
+		// geo headers listed in the Connection header are removed as hop-by-hop headers unless forwarded
+		r.Header.Set("Connection", "CloudFront-Viewer-Country, CloudFront-Viewer-City")
+		w := httptest.NewRecorder()
+		app.Handler().ServeHTTP(w, r)
+		slog.SetDefault(orig)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expect 200, got %d", w.Code)
+		}
+
+		var payload struct {
+			Headers map[string]string `json:"headers"`
+		}
+		if err := json.Unmarshal(client.input.Payload, &payload); err != nil {
+			t.Fatal(err)
+		}
+		country, city := payload.Headers["cloudfront-viewer-country"], payload.Headers["cloudfront-viewer-city"]
+		logged := strings.Contains(buf.String(), `"cloudfront_viewer_country":"JP"`) &&
+			strings.Contains(buf.String(), `"cloudfront_viewer_city":"Tokyo"`)
+		if forward {
+			if country != "JP" || city != "Tokyo" {
+				t.Errorf("geo headers must be forwarded, got country=%q city=%q", country, city)
+			}
+			if !logged {
+				t.Errorf("geo headers must be logged: %s", buf.String())
+			}
+		} else {
+			if country != "" || city != "" {
+				t.Errorf("geo headers listed in Connection must be removed, got country=%q city=%q", country, city)
+			}
+			if logged {
+				t.Errorf("geo headers must not be logged: %s", buf.String())
+			}
+		}
+	}
+}
+
+func TestGeoConfigValidation(t *testing.T) {
+	for _, gc := range []lamux.GeoConfig{
+		{GeoAllowCountries: []string{"JPN"}},
+		{GeoDenyCountries: []string{"J1"}},
+	} {
+		cfg := &lamux.Config{
+			FunctionName:    "test-func",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: time.Second,
+			GeoConfig:       gc,
+		}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected error for %v", gc)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package lamux_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+	"golang.org/x/net/http2"
+)
+
+func TestH2C(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		EnableH2C:       true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200})
+	ts := httptest.NewServer(app.Handler())
+	defer ts.Close()
+
+	client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+	req, _ := http.NewRequest("GET", ts.URL+"/", nil)
+	req.Host = "test.example.net"
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("h2c request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if e, a := 2, resp.ProtoMajor; e != a {
+		t.Errorf("expect HTTP/%d, got %s", e, resp.Proto)
+	}
+	if e, a := http.StatusOK, resp.StatusCode; e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+}
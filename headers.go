@@ -0,0 +1,264 @@
+package lamux
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/fujiwara/ridge"
+	"go.opentelemetry.io/otel"
+	"golang.org/x/net/http/httpguts"
+)
+
+// defaultHopByHopHeaders are the hop-by-hop headers defined in RFC 7230,
+// which must not be forwarded by proxies.
+var defaultHopByHopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+func (cfg *Config) hopByHopHeaders() []string {
+	if len(cfg.HopByHopHeaders) > 0 {
+		return cfg.HopByHopHeaders
+	}
+	return defaultHopByHopHeaders
+}
+
+// connectionTokens returns the header names listed in the Connection header values.
+func connectionTokens(values []string) []string {
+	var tokens []string
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	return tokens
+}
+
+// removeHopByHopHeaders removes the hop-by-hop headers and the headers listed in the Connection header from h.
+func removeHopByHopHeaders(h http.Header, names []string) {
+	for _, t := range connectionTokens(h.Values("Connection")) {
+		h.Del(t)
+	}
+	for _, name := range names {
+		h.Del(name)
+	}
+}
+
+// removeResponseHopByHopHeaders removes the hop-by-hop headers and the headers listed in the Connection header from res.
+func removeResponseHopByHopHeaders(res *ridge.Response, names []string) {
+	var conn []string
+	for k, v := range res.Headers {
+		if strings.EqualFold(k, "Connection") {
+			conn = append(conn, v)
+		}
+	}
+	for k, vs := range res.MultiValueHeaders {
+		if strings.EqualFold(k, "Connection") {
+			conn = append(conn, vs...)
+		}
+	}
+	for _, t := range connectionTokens(conn) {
+		deleteResponseHeader(res, t)
+	}
+	for _, name := range names {
+		deleteResponseHeader(res, name)
+	}
+}
+
+// removeResponseTransferEncoding removes Transfer-Encoding set by the function, because the response is framed
+// by the HTTP server of lamux. Content-Length is also removed, which must be ignored with Transfer-Encoding (RFC 7230 3.3.3).
+// It reports whether the function requested the chunked transfer coding.
+func removeResponseTransferEncoding(res *ridge.Response) bool {
+	te := responseHeader(res, "Transfer-Encoding")
+	if te == "" {
+		return false
+	}
+	deleteResponseHeader(res, "Transfer-Encoding")
+	deleteResponseHeader(res, "Content-Length")
+	return slices.Contains(connectionTokens([]string{strings.ToLower(te)}), "chunked")
+}
+
+// filterPayloadHeaders removes the headers not in ForwardHeaders (if set) and the headers in DropHeaders
+// from the payload. Cookies are filtered as the Cookie header.
+// Host, Content-Type and the headers set by lamux (request ID, trace context, CSP nonce, cost center, via,
+// X-Forwarded-Proto and X-Forwarded-Port) are forwarded with ForwardHeaders, and Host is never dropped.
+func (cfg *Config) filterPayloadHeaders(payload *ridge.RequestV2) {
+	forward := cfg.headerForwarder()
+	for name := range payload.Headers {
+		if !forward(name) {
+			delete(payload.Headers, name)
+		}
+	}
+	if len(payload.Cookies) > 0 && !forward("cookie") {
+		payload.Cookies = nil
+	}
+}
+
+// filterRequestHeaders removes the headers from the request to Function URLs as filterPayloadHeaders does.
+func (cfg *Config) filterRequestHeaders(h http.Header) {
+	if len(cfg.ForwardHeaders) == 0 && len(cfg.DropHeaders) == 0 {
+		return
+	}
+	forward := cfg.headerForwarder()
+	for name := range h {
+		if !forward(name) {
+			delete(h, name)
+		}
+	}
+}
+
+// headerForwarder returns the function reporting whether the request header is forwarded to functions
+// by ForwardHeaders and DropHeaders.
+func (cfg *Config) headerForwarder() func(name string) bool {
+	var allowed []string
+	if len(cfg.ForwardHeaders) > 0 {
+		allowed = append([]string{"Content-Type", requestIDHeader, cspNonceHeader, costCenterHeader, viaHeader, forwardedProtoHeader, forwardedPortHeader}, otel.GetTextMapPropagator().Fields()...)
+		allowed = append(allowed, cfg.ForwardHeaders...)
+	}
+	return func(name string) bool {
+		equal := func(h string) bool { return strings.EqualFold(h, name) }
+		switch {
+		case strings.EqualFold(name, "host"):
+			return true
+		case slices.ContainsFunc(cfg.DropHeaders, equal):
+			return false
+		case allowed == nil:
+			return true
+		default:
+			return slices.ContainsFunc(allowed, equal)
+		}
+	}
+}
+
+// responseHeaders returns ResponseHeaders merged with AliasResponseHeaders for the alias.
+// The headers for the alias take precedence.
+func (cfg *Config) responseHeaders(alias string) map[string]string {
+	aliasHeaders, ok := cfg.AliasResponseHeaders[alias]
+	if !ok {
+		return cfg.ResponseHeaders
+	}
+	headers := make(map[string]string, len(cfg.ResponseHeaders)+len(aliasHeaders))
+	for k, v := range cfg.ResponseHeaders {
+		headers[http.CanonicalHeaderKey(k)] = v
+	}
+	for k, v := range aliasHeaders {
+		headers[http.CanonicalHeaderKey(k)] = v
+	}
+	return headers
+}
+
+// setStaticResponseHeaders sets ResponseHeaders and AliasResponseHeaders for the alias to res.
+// Headers set by the function are kept unless OverrideResponseHeaders is set.
+func (cfg *Config) setStaticResponseHeaders(res *ridge.Response, alias string) {
+	for k, v := range cfg.responseHeaders(alias) {
+		if !cfg.OverrideResponseHeaders && responseHeader(res, k) != "" {
+			continue
+		}
+		setResponseHeader(res, k, v)
+	}
+}
+
+// validateResponseHeaders validates the names and values of the static response headers.
+// Framing headers are not allowed, which are set by the HTTP server.
+func validateResponseHeaders(headers map[string]string) error {
+	for k, v := range headers {
+		if !httpguts.ValidHeaderFieldName(k) {
+			return fmt.Errorf("invalid header name %q", k)
+		}
+		if !httpguts.ValidHeaderFieldValue(v) {
+			return fmt.Errorf("invalid header value for %s: %q", k, v)
+		}
+		if strings.EqualFold(k, "Content-Length") || strings.EqualFold(k, "Transfer-Encoding") {
+			return fmt.Errorf("%s is not allowed", k)
+		}
+	}
+	return nil
+}
+
+// deleteResponseHeader deletes the header from res case-insensitively.
+func deleteResponseHeader(res *ridge.Response, key string) {
+	for k := range res.Headers {
+		if strings.EqualFold(k, key) {
+			delete(res.Headers, k)
+		}
+	}
+	for k := range res.MultiValueHeaders {
+		if strings.EqualFold(k, key) {
+			delete(res.MultiValueHeaders, k)
+		}
+	}
+}
+
+// collapseHeaders joins repeated header values into a single comma separated value.
+// Exactly duplicated values are removed, and the order of the first occurrence is preserved.
+func collapseHeaders(h http.Header) {
+	for k, vs := range h {
+		if len(vs) < 2 || k == "Cookie" {
+			continue
+		}
+		seen := make(map[string]struct{}, len(vs))
+		values := make([]string, 0, len(vs))
+		for _, v := range vs {
+			v = strings.TrimSpace(v)
+			if _, ok := seen[v]; ok || v == "" {
+				continue
+			}
+			seen[v] = struct{}{}
+			values = append(values, v)
+		}
+		h[k] = []string{strings.Join(values, ", ")}
+	}
+}
+
+// checkResponseContentType returns an error if the content type of res is not allowed.
+func checkResponseContentType(res *ridge.Response, allowed []string) error {
+	return checkContentType(responseHeader(res, "Content-Type"), res.Body != "", allowed)
+}
+
+// checkContentType returns an error if the content type of a response is not allowed.
+// Wildcards like "application/*" are supported. Responses with a body but without Content-Type are not allowed,
+// because the content type would be sniffed by the server.
+func checkContentType(contentType string, hasBody bool, allowed []string) error {
+	if contentType == "" {
+		if !hasBody {
+			return nil
+		}
+		return fmt.Errorf("response content type is missing")
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("invalid response content type %q: %w", contentType, err)
+	}
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if a == mt || a == "*/*" {
+			return nil
+		}
+		if prefix, ok := strings.CutSuffix(a, "/*"); ok && strings.HasPrefix(mt, prefix+"/") {
+			return nil
+		}
+	}
+	return fmt.Errorf("response content type %q is not allowed", mt)
+}
+
+func validateContentTypes(types []string) error {
+	for _, t := range types {
+		mt, params, err := mime.ParseMediaType(t)
+		if err != nil || mt != strings.ToLower(t) || len(params) > 0 || !strings.Contains(mt, "/") {
+			return fmt.Errorf("invalid content type: %s", t)
+		}
+	}
+	return nil
+}
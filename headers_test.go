@@ -0,0 +1,427 @@
+package lamux_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+type forwardedRequest struct {
+	Headers map[string]string `json:"headers"`
+}
+
+func proxyAndCapture(t *testing.T, cfg *lamux.Config, r *http.Request) (*httptest.ResponseRecorder, *forwardedRequest) {
+	t.Helper()
+	if cfg.FunctionName == "" {
+		cfg.FunctionName = "test-func"
+	}
+	if cfg.DomainSuffix == "" {
+		cfg.DomainSuffix = "example.net"
+	}
+	if cfg.UpstreamTimeout == 0 {
+		cfg.UpstreamTimeout = time.Second
+	}
+	app, err := lamux.NewLamux(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client := &mockClient{code: 200}
+	app.SetTestClient(client)
+	w := httptest.NewRecorder()
+	if err := app.HandleProxy(context.Background(), w, r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var fr forwardedRequest
+	if err := json.Unmarshal(client.input.Payload, &fr); err != nil {
+		t.Fatalf("failed to unmarshal payload: %v", err)
+	}
+	return w, &fr
+}
+
+func TestCollapseRequestHeaders(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Forwarded-Host", "test.example.net")
+	r.Header.Add("Accept", "text/html")
+	r.Header.Add("Accept", "application/json")
+	r.Header.Add("Accept", "text/html")
+	r.Header.Add("Accept", "*/*")
+
+	_, fr := proxyAndCapture(t, &lamux.Config{CollapseRequestHeaders: true}, r)
+	if e, a := "text/html, application/json, */*", fr.Headers["accept"]; e != a {
+		t.Errorf("expect %q, got %q", e, a)
+	}
+}
+
+func TestRemoveHopByHopRequestHeaders(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Forwarded-Host", "test.example.net")
+	r.Header.Set("Connection", "keep-alive, X-Hop")
+	r.Header.Set("Keep-Alive", "timeout=5")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+	r.Header.Set("X-Hop", "hop")
+	r.Header.Set("X-End", "end")
+
+	_, fr := proxyAndCapture(t, &lamux.Config{}, r)
+	for _, name := range []string{"connection", "keep-alive", "upgrade", "proxy-authorization", "x-hop"} {
+		if v, ok := fr.Headers[name]; ok {
+			t.Errorf("%s must be removed, got %q", name, v)
+		}
+	}
+	if e, a := "end", fr.Headers["x-end"]; e != a {
+		t.Errorf("expect %q, got %q", e, a)
+	}
+
+	r, _ = http.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Forwarded-Host", "test.example.net")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("X-Custom-Hop", "hop")
+	_, fr = proxyAndCapture(t, &lamux.Config{HopByHopHeaders: []string{"X-Custom-Hop"}}, r)
+	if _, ok := fr.Headers["x-custom-hop"]; ok {
+		t.Error("x-custom-hop must be removed")
+	}
+	if e, a := "websocket", fr.Headers["upgrade"]; e != a {
+		t.Errorf("expect %q, got %q", e, a)
+	}
+}
+
+func TestRemoveHopByHopResponseHeaders(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{
+		code: 200,
+		payload: []byte(`{"statusCode":200,"headers":{"connection":"close, x-hop","keep-alive":"timeout=5","x-hop":"hop","x-end":"end"},` +
+			`"multiValueHeaders":{"Transfer-Encoding":["chunked"]}}`),
+	})
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Forwarded-Host", "test.example.net")
+	w := httptest.NewRecorder()
+	if err := app.HandleProxy(context.Background(), w, r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, name := range []string{"Connection", "Keep-Alive", "Transfer-Encoding", "X-Hop"} {
+		if v := w.Header().Values(name); len(v) > 0 {
+			t.Errorf("%s must be removed, got %q", name, v)
+		}
+	}
+	if e, a := "end", w.Header().Get("X-End"); e != a {
+		t.Errorf("expect %q, got %q", e, a)
+	}
+}
+
+func TestAllowedResponseContentTypes(t *testing.T) {
+	allowed := []string{"Application/JSON", "image/*"}
+	cases := []struct {
+		name       string
+		payload    string
+		expectCode int
+	}{
+		{
+			name:       "allowed",
+			payload:    `{"statusCode":200,"headers":{"content-type":"application/json; charset=utf-8"},"body":"{}"}`,
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "allowed by wildcard",
+			payload:    `{"statusCode":200,"multiValueHeaders":{"Content-Type":["image/png"]},"body":"iVBORw0KGgo=","isBase64Encoded":true}`,
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "disallowed",
+			payload:    `{"statusCode":200,"headers":{"Content-Type":"text/html"},"body":"<html></html>"}`,
+			expectCode: http.StatusBadGateway,
+		},
+		{
+			name:       "missing content type",
+			payload:    `{"statusCode":200,"body":"<html></html>"}`,
+			expectCode: http.StatusBadGateway,
+		},
+		{
+			name:       "missing content type without body",
+			payload:    `{"statusCode":204}`,
+			expectCode: http.StatusNoContent,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:                "test-func",
+				DomainSuffix:                "example.net",
+				UpstreamTimeout:             time.Second,
+				AllowedResponseContentTypes: allowed,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.SetTestClient(&mockClient{code: 200, payload: []byte(tc.payload)})
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+			if w.Code != tc.expectCode {
+				t.Errorf("expect %d, got %d", tc.expectCode, w.Code)
+			}
+			if tc.expectCode == http.StatusBadGateway && strings.Contains(w.Body.String(), "<html>") {
+				t.Error("disallowed body must not be returned")
+			}
+		})
+	}
+}
+
+func TestAllowedResponseContentTypesValidation(t *testing.T) {
+	for _, types := range [][]string{{"json"}, {"application/json; charset=utf-8"}} {
+		cfg := &lamux.Config{
+			FunctionName:                "test-func",
+			DomainSuffix:                "example.net",
+			UpstreamTimeout:             time.Second,
+			AllowedResponseContentTypes: types,
+		}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected error for %v", types)
+		}
+	}
+}
+
+func TestForwardAndDropHeaders(t *testing.T) {
+	cases := []struct {
+		name          string
+		forward       []string
+		drop          []string
+		expectHeaders []string
+		expectMissing []string
+		expectCookies bool
+	}{
+		{
+			name:          "default",
+			expectHeaders: []string{"authorization", "x-custom", "x-other", "content-type"},
+			expectCookies: true,
+		},
+		{
+			name:          "drop cookies and auth",
+			drop:          []string{"Cookie", "Authorization"},
+			expectHeaders: []string{"x-custom", "x-other", "content-type"},
+			expectMissing: []string{"authorization"},
+		},
+		{
+			name:          "allowlist",
+			forward:       []string{"X-Custom"},
+			expectHeaders: []string{"host", "content-type", "x-custom", "x-lamux-request-id"},
+			expectMissing: []string{"authorization", "x-other"},
+		},
+		{
+			name:          "allowlist with cookies",
+			forward:       []string{"X-Custom", "Cookie", "Authorization"},
+			drop:          []string{"Authorization"},
+			expectHeaders: []string{"host", "x-custom"},
+			expectMissing: []string{"authorization", "x-other"},
+			expectCookies: true,
+		},
+		{
+			name:          "host is never dropped",
+			drop:          []string{"Host", "Content-Type"},
+			expectHeaders: []string{"host"},
+			expectMissing: []string{"content-type"},
+			expectCookies: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:    "test-func",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+				ForwardHeaders:  tc.forward,
+				DropHeaders:     tc.drop,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200}
+			app.SetTestClient(client)
+			r := httptest.NewRequest("POST", "http://test.example.net/", strings.NewReader("{}"))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Authorization", "Bearer secret")
+			r.Header.Set("Cookie", "session=secret")
+			r.Header.Set("X-Custom", "custom")
+			r.Header.Set("X-Other", "other")
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, r)
+			if w.Code != http.StatusOK {
+				t.Fatalf("expect 200, got %d", w.Code)
+			}
+			var payload struct {
+				Headers map[string]string `json:"headers"`
+				Cookies []string          `json:"cookies"`
+			}
+			if err := json.Unmarshal(client.input.Payload, &payload); err != nil {
+				t.Fatal(err)
+			}
+			for _, h := range tc.expectHeaders {
+				if _, ok := payload.Headers[h]; !ok {
+					t.Errorf("expect header %s to be forwarded: %v", h, payload.Headers)
+				}
+			}
+			for _, h := range tc.expectMissing {
+				if _, ok := payload.Headers[h]; ok {
+					t.Errorf("expect header %s to be removed: %v", h, payload.Headers)
+				}
+			}
+			if e, a := tc.expectCookies, len(payload.Cookies) > 0; e != a {
+				t.Errorf("expect cookies forwarded %v, got %v", e, payload.Cookies)
+			}
+		})
+	}
+}
+
+func TestResponseHeaders(t *testing.T) {
+	static := map[string]string{
+		"Strict-Transport-Security": "max-age=63072000",
+		"X-Content-Type-Options":    "nosniff",
+	}
+	cases := []struct {
+		name     string
+		override bool
+		expect   map[string]string
+	}{
+		{
+			name: "keep function headers",
+			expect: map[string]string{
+				"Strict-Transport-Security": "max-age=300",
+				"X-Content-Type-Options":    "nosniff",
+			},
+		},
+		{
+			name:     "override",
+			override: true,
+			expect:   static,
+		},
+	}
+	payload := []byte(`{"statusCode":200,"headers":{"strict-transport-security":"max-age=300"},"body":"ok"}`)
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:            "test-func",
+				DomainSuffix:            "example.net",
+				UpstreamTimeout:         time.Second,
+				ResponseHeaders:         static,
+				OverrideResponseHeaders: tc.override,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.SetTestClient(&mockClient{code: 200, payload: payload})
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expect 200, got %d", w.Code)
+			}
+			for k, v := range tc.expect {
+				if vs := w.Header().Values(k); len(vs) != 1 || vs[0] != v {
+					t.Errorf("expect %s: %q, got %q", k, v, vs)
+				}
+			}
+		})
+	}
+}
+
+func TestAliasResponseHeaders(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		ResponseHeaders: map[string]string{"X-Content-Type-Options": "nosniff", "X-Release": "stable"},
+		AliasResponseHeaders: map[string]map[string]string{
+			"canary": {"X-Canary": "true", "X-Release": "canary"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200, qualifiers: []string{"canary"}})
+	cases := []struct {
+		host   string
+		expect map[string]string
+	}{
+		{
+			host:   "canary.example.net",
+			expect: map[string]string{"X-Content-Type-Options": "nosniff", "X-Canary": "true", "X-Release": "canary"},
+		},
+		{
+			host:   "test.example.net",
+			expect: map[string]string{"X-Content-Type-Options": "nosniff", "X-Canary": "", "X-Release": "stable"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.host, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://"+tc.host+"/", nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expect 200, got %d", w.Code)
+			}
+			for k, v := range tc.expect {
+				if e, a := v, w.Header().Get(k); e != a {
+					t.Errorf("expect %s: %q, got %q", k, e, a)
+				}
+			}
+		})
+	}
+}
+
+func TestAliasResponseHeadersValidation(t *testing.T) {
+	cfg := &lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		AliasResponseHeaders: map[string]map[string]string{
+			"canary": {"Content-Length": "0"},
+		},
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expect error for Content-Length in alias response headers")
+	}
+}
+
+func TestResponseHeadersValidation(t *testing.T) {
+	for _, h := range []map[string]string{
+		{"Invalid Name": "value"},
+		{"X-Test": "invalid\nvalue"},
+		{"Content-Length": "0"},
+	} {
+		cfg := &lamux.Config{
+			FunctionName:    "test-func",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: time.Second,
+			ResponseHeaders: h,
+		}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected validation error for %v", h)
+		}
+	}
+}
+
+func TestParseResponseHeaders(t *testing.T) {
+	// semicolons in values are escaped by backslashes
+	cfg, err := lamux.ParseConfig([]string{"--response-headers", `Strict-Transport-Security=max-age=63072000\; includeSubDomains;X-Frame-Options=DENY`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := map[string]string{
+		"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+		"X-Frame-Options":           "DENY",
+	}
+	for k, v := range expect {
+		if a := cfg.ResponseHeaders[k]; a != v {
+			t.Errorf("expect %s: %q, got %q", k, v, a)
+		}
+	}
+}
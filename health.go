@@ -0,0 +1,28 @@
+package lamux
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+type healthCheckResponse struct {
+	Status  string               `json:"status"`
+	Version string               `json:"version"`
+	Uptime  float64              `json:"uptime"`
+	Invoke  *invokeStatsSnapshot `json:"invoke,omitempty"`
+}
+
+func (l *Lamux) handleHealthCheck(w http.ResponseWriter, r *http.Request) {
+	res := healthCheckResponse{
+		Status:  "ok",
+		Version: Version,
+		Uptime:  time.Since(l.startedAt).Seconds(),
+	}
+	if l.invokeStats != nil {
+		res.Invoke = l.invokeStats.snapshot()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(res)
+}
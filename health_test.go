@@ -0,0 +1,95 @@
+package lamux_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func TestHealthCheck(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		HealthCheckPath: "/healthz",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client := &mockClient{code: 200}
+	app.SetTestClient(client)
+
+	// health check does not require a valid domain suffix
+	r := httptest.NewRequest("GET", "http://localhost:8080/healthz", nil)
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, r)
+	if e, a := http.StatusOK, w.Code; e != a {
+		t.Fatalf("expect %d, got %d", e, a)
+	}
+	var res struct {
+		Status  string  `json:"status"`
+		Version string  `json:"version"`
+		Uptime  float64 `json:"uptime"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if res.Status != "ok" || res.Version != lamux.Version {
+		t.Errorf("unexpected response: %#v", res)
+	}
+	if client.input != nil {
+		t.Error("lambda must not be invoked by health check")
+	}
+}
+
+func TestHealthCheckRichReadiness(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		HealthCheckPath: "/healthz",
+		RichReadiness:   true,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	app.SetTestClient(&mockClient{code: 200, latency: 10 * time.Millisecond})
+	handler := app.Handler()
+	for i := 0; i < 3; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status: %d", w.Code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/healthz", nil))
+	var res struct {
+		Invoke *struct {
+			Count       int        `json:"count"`
+			P50         float64    `json:"p50"`
+			P95         float64    `json:"p95"`
+			LastSuccess *time.Time `json:"last_success"`
+		} `json:"invoke"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if res.Invoke == nil {
+		t.Fatal("invoke stats must be included")
+	}
+	if res.Invoke.Count != 3 {
+		t.Errorf("expect count 3, got %d", res.Invoke.Count)
+	}
+	if res.Invoke.P50 <= 0 || res.Invoke.P95 < res.Invoke.P50 {
+		t.Errorf("unexpected latency stats: p50=%f p95=%f", res.Invoke.P50, res.Invoke.P95)
+	}
+	if res.Invoke.LastSuccess == nil {
+		t.Error("last_success must be set")
+	}
+}
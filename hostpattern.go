@@ -0,0 +1,128 @@
+package lamux
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// allowedHostRune reports whether the rune can be in aliases and function names in host names.
+// Dots are not allowed, as aliases and function names are in a single label of host names.
+func allowedHostRune(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '-' || r == '_'
+}
+
+// compileHostPatterns compiles AliasPattern and FunctionNamePattern.
+// In the wildcard function mode, aliases must not contain hyphens, which separate aliases and function names.
+func (cfg *Config) compileHostPatterns() error {
+	var err error
+	if cfg.aliasRe, err = compileHostPattern("alias", cfg.AliasPattern, aliasRegexp); err != nil {
+		return err
+	}
+	if cfg.functionNameRe, err = compileHostPattern("function name", cfg.FunctionNamePattern, functionNameRegexp); err != nil {
+		return err
+	}
+	if cfg.FunctionName == "*" && cfg.AliasPattern != "" {
+		parsed, _ := syntax.Parse(cfg.AliasPattern, syntax.Perl)
+		if _, ok := matchesRune(parsed, func(r rune) bool { return r == '-' }); ok {
+			return fmt.Errorf("alias pattern %s must not match hyphens, which separate aliases and function names in host names", cfg.AliasPattern)
+		}
+	}
+	return nil
+}
+
+// hostAliasRegexp returns the regexp of aliases in host names.
+func (cfg *Config) hostAliasRegexp() *regexp.Regexp {
+	if cfg.aliasRe != nil {
+		return cfg.aliasRe
+	}
+	return aliasRegexp
+}
+
+// checkLowerAlias returns an error if the alias in host names configured as name has uppercase letters.
+// Such aliases never match, as host names are lowercased.
+func checkLowerAlias(name, alias string) error {
+	if alias != strings.ToLower(alias) {
+		return fmt.Errorf("%s %s must be lowercase, as host names are case-insensitive", name, alias)
+	}
+	return nil
+}
+
+// hostFunctionNameRegexp returns the regexp of function names in host names.
+func (cfg *Config) hostFunctionNameRegexp() *regexp.Regexp {
+	if cfg.functionNameRe != nil {
+		return cfg.functionNameRe
+	}
+	return functionNameRegexp
+}
+
+// compileHostPattern compiles the pattern of aliases or function names in host names.
+// The default is returned for empty patterns. Patterns must match whole names, must not match empty names,
+// and must not match runes other than letters, digits, hyphens and underscores.
+func compileHostPattern(name, pattern string, def *regexp.Regexp) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return def, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s pattern: %w", name, err)
+	}
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s pattern: %w", name, err)
+	}
+	if !isAnchored(parsed) {
+		return nil, fmt.Errorf("%s pattern %s must be anchored by ^ and $ to match whole names", name, pattern)
+	}
+	if re.MatchString("") {
+		return nil, fmt.Errorf("%s pattern %s must not match empty names", name, pattern)
+	}
+	if r, ok := matchesRune(parsed, func(r rune) bool { return !allowedHostRune(r) }); ok {
+		return nil, fmt.Errorf("%s pattern %s is too permissive: matches %q (only letters, digits, hyphens and underscores allowed)", name, pattern, r)
+	}
+	return re, nil
+}
+
+// isAnchored reports whether the regexp matches from the beginning to the end of text.
+func isAnchored(re *syntax.Regexp) bool {
+	if re.Op != syntax.OpConcat || len(re.Sub) < 2 {
+		return false
+	}
+	first, last := re.Sub[0].Op, re.Sub[len(re.Sub)-1].Op
+	return first == syntax.OpBeginText && last == syntax.OpEndText
+}
+
+// matchesRune returns a rune satisfying pred which the regexp may match.
+// Non-ASCII runes are represented by utf8.RuneSelf.
+func matchesRune(re *syntax.Regexp, pred func(rune) bool) (rune, bool) {
+	switch re.Op {
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		for r := rune(0); r <= utf8.RuneSelf; r++ {
+			if pred(r) {
+				return r, true
+			}
+		}
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if pred(r) {
+				return r, true
+			}
+		}
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(re.Rune); i += 2 {
+			for r := min(re.Rune[i], utf8.RuneSelf); r <= min(re.Rune[i+1], utf8.RuneSelf); r++ {
+				if pred(r) {
+					return r, true
+				}
+			}
+		}
+	}
+	for _, sub := range re.Sub {
+		if r, ok := matchesRune(sub, pred); ok {
+			return r, true
+		}
+	}
+	return 0, false
+}
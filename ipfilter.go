@@ -0,0 +1,67 @@
+package lamux
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+)
+
+type IPFilterConfig struct {
+	AllowCIDRs                 []string `help:"Allowed client IP ranges (CIDR)" env:"LAMUX_ALLOW_CIDRS" name:"allow-cidrs"`
+	DenyCIDRs                  []string `help:"Denied client IP ranges (CIDR)" env:"LAMUX_DENY_CIDRS" name:"deny-cidrs"`
+	IPFilterExcludeHealthCheck bool     `help:"Do not apply the IP filter to the health and readiness check endpoints" env:"LAMUX_IP_FILTER_EXCLUDE_HEALTH_CHECK" name:"ip-filter-exclude-health-check"`
+}
+
+func (ic *IPFilterConfig) Enabled() bool {
+	return len(ic.AllowCIDRs) > 0 || len(ic.DenyCIDRs) > 0
+}
+
+func (ic *IPFilterConfig) Validate() error {
+	_, err := newIPFilter(ic)
+	return err
+}
+
+type ipFilter struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+func newIPFilter(ic *IPFilterConfig) (*ipFilter, error) {
+	f := &ipFilter{}
+	var err error
+	if f.allow, err = parsePrefixes(ic.AllowCIDRs); err != nil {
+		return nil, fmt.Errorf("invalid allow cidrs: %w", err)
+	}
+	if f.deny, err = parsePrefixes(ic.DenyCIDRs); err != nil {
+		return nil, fmt.Errorf("invalid deny cidrs: %w", err)
+	}
+	return f, nil
+}
+
+// check returns an error if the client IP is not allowed.
+func (f *ipFilter) check(addr netip.Addr) error {
+	if containsAddr(f.deny, addr) {
+		return newHandlerError(fmt.Errorf("client ip %s is denied", addr), http.StatusForbidden)
+	}
+	if len(f.allow) > 0 && !containsAddr(f.allow, addr) {
+		return newHandlerError(fmt.Errorf("client ip %s is not allowed", addr), http.StatusForbidden)
+	}
+	return nil
+}
+
+// ipFilterMiddleware rejects the requests from the clients not allowed, for handlers not wrapped by wrapHandler.
+func (l *Lamux) ipFilterMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		addr, err := l.clientIPResolver.resolve(r)
+		if err == nil {
+			err = l.ipFilter.check(addr)
+		}
+		if err != nil {
+			slog.WarnContext(r.Context(), "blocked by ip filter", "remote", r.RemoteAddr, "path", r.URL.Path, "error", err)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,255 @@
+package lamux_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func TestIPFilter(t *testing.T) {
+	cases := []struct {
+		name       string
+		cfg        lamux.IPFilterConfig
+		trusted    []string
+		count      int
+		remoteAddr string
+		xff        []string
+		expectCode int
+	}{
+		{
+			name:       "allowed",
+			cfg:        lamux.IPFilterConfig{AllowCIDRs: []string{"192.0.2.0/24"}},
+			remoteAddr: "192.0.2.1:12345",
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "not allowed",
+			cfg:        lamux.IPFilterConfig{AllowCIDRs: []string{"192.0.2.0/24"}},
+			remoteAddr: "198.51.100.1:12345",
+			expectCode: http.StatusForbidden,
+		},
+		{
+			name:       "denied",
+			cfg:        lamux.IPFilterConfig{AllowCIDRs: []string{"192.0.2.0/24"}, DenyCIDRs: []string{"192.0.2.100"}},
+			remoteAddr: "192.0.2.100:12345",
+			expectCode: http.StatusForbidden,
+		},
+		{
+			name:       "IPv6 allowed",
+			cfg:        lamux.IPFilterConfig{AllowCIDRs: []string{"2001:db8::/32"}},
+			remoteAddr: "[2001:db8::1]:12345",
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "IPv6 not allowed",
+			cfg:        lamux.IPFilterConfig{AllowCIDRs: []string{"2001:db8::/32"}},
+			remoteAddr: "[2001:db9::1]:12345",
+			expectCode: http.StatusForbidden,
+		},
+		{
+			name:       "IPv4-mapped IPv6",
+			cfg:        lamux.IPFilterConfig{AllowCIDRs: []string{"192.0.2.0/24"}},
+			remoteAddr: "[::ffff:192.0.2.1]:12345",
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "XFF from untrusted remote is ignored",
+			cfg:        lamux.IPFilterConfig{AllowCIDRs: []string{"192.0.2.0/24"}},
+			remoteAddr: "198.51.100.1:12345",
+			xff:        []string{"192.0.2.1"},
+			expectCode: http.StatusForbidden,
+		},
+		{
+			name:       "rightmost untrusted hop",
+			cfg:        lamux.IPFilterConfig{AllowCIDRs: []string{"192.0.2.0/24"}},
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.1:12345",
+			xff:        []string{"198.51.100.1, 192.0.2.1", "10.0.0.2"},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "spoofed leftmost hop",
+			cfg:        lamux.IPFilterConfig{AllowCIDRs: []string{"192.0.2.0/24"}},
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.1:12345",
+			xff:        []string{"192.0.2.1, 198.51.100.1"},
+			expectCode: http.StatusForbidden,
+		},
+		{
+			name:       "IPv6 in XFF",
+			cfg:        lamux.IPFilterConfig{AllowCIDRs: []string{"2001:db8::/32"}},
+			trusted:    []string{"fd00::/8"},
+			remoteAddr: "[fd00::1]:12345",
+			xff:        []string{"2001:db8::1"},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "malformed XFF",
+			cfg:        lamux.IPFilterConfig{AllowCIDRs: []string{"10.0.0.0/8"}},
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.1:12345",
+			xff:        []string{"not-an-ip"},
+			expectCode: http.StatusForbidden,
+		},
+		{
+			name:       "malformed XFF beyond the client hop is not evaluated",
+			cfg:        lamux.IPFilterConfig{AllowCIDRs: []string{"192.0.2.0/24"}},
+			trusted:    []string{"10.0.0.0/8"},
+			remoteAddr: "10.0.0.1:12345",
+			xff:        []string{"garbage, 192.0.2.1"},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "trusted proxy count",
+			cfg:        lamux.IPFilterConfig{AllowCIDRs: []string{"192.0.2.0/24"}},
+			count:      2,
+			remoteAddr: "10.0.0.2:12345",
+			xff:        []string{"198.51.100.1, 192.0.2.1, 10.0.0.1"},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "trusted proxy count with spoofed hop",
+			cfg:        lamux.IPFilterConfig{AllowCIDRs: []string{"192.0.2.0/24"}},
+			count:      1,
+			remoteAddr: "10.0.0.1:12345",
+			xff:        []string{"192.0.2.1, 198.51.100.1"},
+			expectCode: http.StatusForbidden,
+		},
+		{
+			name:       "trusted proxy count longer than chain",
+			cfg:        lamux.IPFilterConfig{AllowCIDRs: []string{"192.0.2.0/24"}},
+			count:      3,
+			remoteAddr: "10.0.0.1:12345",
+			xff:        []string{"192.0.2.1"},
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "trusted proxy count with malformed XFF",
+			cfg:        lamux.IPFilterConfig{AllowCIDRs: []string{"192.0.2.0/24"}},
+			count:      1,
+			remoteAddr: "10.0.0.1:12345",
+			xff:        []string{"192.0.2.1, bogus"},
+			expectCode: http.StatusForbidden,
+		},
+		{
+			name:       "trusted proxy count zero ignores XFF",
+			cfg:        lamux.IPFilterConfig{AllowCIDRs: []string{"192.0.2.0/24"}},
+			remoteAddr: "10.0.0.1:12345",
+			xff:        []string{"192.0.2.1"},
+			expectCode: http.StatusForbidden,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:      "test-func",
+				DomainSuffix:      "example.net",
+				UpstreamTimeout:   time.Second,
+				IPFilterConfig:    tc.cfg,
+				TrustedProxyCIDRs: tc.trusted,
+				TrustedProxyCount: tc.count,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200}
+			app.SetTestClient(client)
+
+			r := httptest.NewRequest("GET", "http://test.example.net/", nil)
+			r.RemoteAddr = tc.remoteAddr
+			for _, v := range tc.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, r)
+			if e, a := tc.expectCode, w.Code; e != a {
+				t.Errorf("expect %d, got %d", e, a)
+			}
+			if tc.expectCode == http.StatusForbidden && client.input != nil {
+				t.Error("lambda must not be invoked for blocked requests")
+			}
+		})
+	}
+}
+
+func TestIPFilterHealthCheck(t *testing.T) {
+	for _, exclude := range []bool{false, true} {
+		app, err := lamux.NewLamux(&lamux.Config{
+			FunctionName:    "test-func",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: time.Second,
+			HealthCheckPath: "/healthz",
+			IPFilterConfig: lamux.IPFilterConfig{
+				AllowCIDRs:                 []string{"192.0.2.0/24"},
+				IPFilterExcludeHealthCheck: exclude,
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("GET", "http://localhost/healthz", nil)
+		r.RemoteAddr = "198.51.100.1:12345"
+		w := httptest.NewRecorder()
+		app.Handler().ServeHTTP(w, r)
+		expect := http.StatusForbidden
+		if exclude {
+			expect = http.StatusOK
+		}
+		if w.Code != expect {
+			t.Errorf("exclude=%v: expect %d, got %d", exclude, expect, w.Code)
+		}
+	}
+}
+
+func TestIPFilterMetrics(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		MetricsEnabled:  true,
+		MetricsPath:     "/metrics",
+		IPFilterConfig: lamux.IPFilterConfig{
+			AllowCIDRs:                 []string{"192.0.2.0/24"},
+			IPFilterExcludeHealthCheck: true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for remote, expect := range map[string]int{
+		"192.0.2.1:12345":    http.StatusOK,
+		"198.51.100.1:12345": http.StatusForbidden,
+	} {
+		r := httptest.NewRequest("GET", "http://localhost/metrics", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		app.Handler().ServeHTTP(w, r)
+		if w.Code != expect {
+			t.Errorf("%s: expect %d, got %d", remote, expect, w.Code)
+		}
+	}
+}
+
+func TestIPFilterInvalidCIDR(t *testing.T) {
+	cfg := &lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		IPFilterConfig:  lamux.IPFilterConfig{AllowCIDRs: []string{"192.0.2.0/33"}},
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for invalid CIDR")
+	}
+	cfg = &lamux.Config{
+		FunctionName:      "test-func",
+		DomainSuffix:      "example.net",
+		UpstreamTimeout:   time.Second,
+		TrustedProxyCount: -1,
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for negative trusted proxy count")
+	}
+}
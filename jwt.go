@@ -0,0 +1,418 @@
+package lamux
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+const subjectHeader = "X-Lamux-Subject"
+
+type JWTConfig struct {
+	JWTJWKSURL             string            `help:"JWKS URL to verify JWT in Authorization header" env:"LAMUX_JWT_JWKS_URL" name:"jwt-jwks-url"`
+	JWTIssuer              string            `help:"Expected issuer (iss) of JWT" env:"LAMUX_JWT_ISSUER" name:"jwt-issuer"`
+	JWTAudience            string            `help:"Expected audience (aud) of JWT" env:"LAMUX_JWT_AUDIENCE" name:"jwt-audience"`
+	StripAuthorization     bool              `help:"Strip Authorization header after JWT verification" env:"LAMUX_STRIP_AUTHORIZATION" name:"strip-authorization"`
+	JWTJWKSRefreshInterval time.Duration     `help:"Interval to refresh the cached JWKS (0 to refresh only on unknown key IDs)" default:"1h" env:"LAMUX_JWT_JWKS_REFRESH_INTERVAL" name:"jwt-jwks-refresh-interval"`
+	JWTLeeway              time.Duration     `help:"Leeway for exp and nbf claims of JWT to allow clock skew" default:"0" env:"LAMUX_JWT_LEEWAY" name:"jwt-leeway"`
+	JWTAllowMissingExp     bool              `help:"Accept JWT without exp claim, which never expires" env:"LAMUX_JWT_ALLOW_MISSING_EXP" name:"jwt-allow-missing-exp"`
+	JWTForwardClaims       map[string]string `help:"Forward JWT claims to functions as headers (claim1=header1;claim2=header2)" env:"LAMUX_JWT_FORWARD_CLAIMS" name:"jwt-forward-claims"`
+}
+
+func (jc *JWTConfig) Enabled() bool {
+	return jc.JWTJWKSURL != ""
+}
+
+func (jc *JWTConfig) Validate() error {
+	if !jc.Enabled() {
+		return nil
+	}
+	if u, err := url.Parse(jc.JWTJWKSURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid JWKS URL: %s", jc.JWTJWKSURL)
+	}
+	if jc.JWTJWKSRefreshInterval < 0 {
+		return errors.New("JWKS refresh interval must not be negative")
+	}
+	if jc.JWTLeeway < 0 {
+		return errors.New("JWT leeway must not be negative")
+	}
+	for claim, header := range jc.JWTForwardClaims {
+		if claim == "" || !httpguts.ValidHeaderFieldName(header) {
+			return fmt.Errorf("invalid JWT forward claim: %s=%s", claim, header)
+		}
+	}
+	return nil
+}
+
+type jwtClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	ExpiresAt *int64   `json:"exp"`
+	NotBefore *int64   `json:"nbf"`
+
+	// all the claims to be forwarded by JWTForwardClaims
+	raw map[string]json.RawMessage
+}
+
+// claimValue returns the claim as a header value. Strings are returned as is,
+// arrays of strings are joined by commas, and the others are returned in JSON.
+func (c *jwtClaims) claimValue(name string) (string, bool) {
+	raw, ok := c.raw[name]
+	if !ok {
+		return "", false
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, true
+	}
+	var ss []string
+	if err := json.Unmarshal(raw, &ss); err == nil {
+		return strings.Join(ss, ","), true
+	}
+	return string(raw), true
+}
+
+// audience accepts both a single string and an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	var ss []string
+	if err := json.Unmarshal(b, &ss); err != nil {
+		return err
+	}
+	*a = ss
+	return nil
+}
+
+func (a audience) Contains(s string) bool {
+	for _, v := range a {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k *jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid n: %w", err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid e: %w", err)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %w", err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", k.Kty)
+	}
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+type jwtVerifier struct {
+	cfg    *JWTConfig
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+	failedAt  time.Time  // of the last refresh
+	fetching  *jwksFetch // in flight
+}
+
+// jwksFetch is a fetch of JWKS shared by concurrent requests.
+type jwksFetch struct {
+	done chan struct{}
+	keys map[string]crypto.PublicKey
+	err  error
+}
+
+func newJWTVerifier(cfg *JWTConfig) *jwtVerifier {
+	return &jwtVerifier{
+		cfg:    cfg,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// minJWKSRefetchInterval limits refetching JWKS on unknown kid, and retrying failed refreshes.
+const minJWKSRefetchInterval = time.Minute
+
+// key returns the key of kid. JWKS is refetched on unknown kid, or when the keys are older than
+// JWTJWKSRefreshInterval. The cached keys are used while refreshing fails.
+func (v *jwtVerifier) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	key, ok := v.keys[kid]
+	if ok {
+		interval := v.cfg.JWTJWKSRefreshInterval
+		if interval == 0 || time.Since(v.fetchedAt) < interval || time.Since(v.failedAt) < minJWKSRefetchInterval {
+			v.mu.Unlock()
+			return key, nil
+		}
+	} else if v.keys != nil && time.Since(v.fetchedAt) < minJWKSRefetchInterval {
+		v.mu.Unlock()
+		return nil, fmt.Errorf("unknown key id: %s", kid)
+	}
+	f := v.fetching
+	if f == nil {
+		f = &jwksFetch{done: make(chan struct{})}
+		v.fetching = f
+		// the fetch is not canceled by the request, as the result is shared
+		go v.refresh(context.WithoutCancel(ctx), f)
+	}
+	v.mu.Unlock()
+
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if f.err != nil {
+		if ok {
+			slog.WarnContext(ctx, "failed to refresh JWKS, using the cached keys", "error", f.err)
+			return key, nil
+		}
+		return nil, f.err
+	}
+	if key, ok := f.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key id: %s", kid)
+}
+
+// refresh fetches JWKS without holding the lock, and swaps the keys.
+func (v *jwtVerifier) refresh(ctx context.Context, f *jwksFetch) {
+	f.keys, f.err = v.fetchKeys(ctx)
+	v.mu.Lock()
+	if f.err != nil {
+		v.failedAt = time.Now()
+	} else {
+		v.keys = f.keys
+		v.fetchedAt = time.Now()
+	}
+	v.fetching = nil
+	v.mu.Unlock()
+	close(f.done)
+}
+
+func (v *jwtVerifier) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.cfg.JWTJWKSURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create JWKS request: %w", err)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: %s", resp.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			continue // skip unsupported keys
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (v *jwtVerifier) Verify(ctx context.Context, token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid token header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid token signature: %w", err)
+	}
+	key, err := v.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid token claims: %w", err)
+	}
+	if len(v.cfg.JWTForwardClaims) > 0 {
+		if err := decodeSegment(parts[1], &claims.raw); err != nil {
+			return nil, fmt.Errorf("invalid token claims: %w", err)
+		}
+	}
+	now := time.Now().Unix()
+	leeway := int64(v.cfg.JWTLeeway.Seconds())
+	if claims.ExpiresAt == nil {
+		if !v.cfg.JWTAllowMissingExp {
+			return nil, errors.New("token has no exp claim")
+		}
+	} else if now >= *claims.ExpiresAt+leeway {
+		return nil, errors.New("token is expired")
+	}
+	if claims.NotBefore != nil && now < *claims.NotBefore-leeway {
+		return nil, errors.New("token is not valid yet")
+	}
+	if v.cfg.JWTIssuer != "" && claims.Issuer != v.cfg.JWTIssuer {
+		return nil, fmt.Errorf("invalid issuer: %s", claims.Issuer)
+	}
+	if v.cfg.JWTAudience != "" && !claims.Audience.Contains(v.cfg.JWTAudience) {
+		return nil, errors.New("invalid audience")
+	}
+	return &claims, nil
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed string, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm: %s", alg)
+	}
+	h := hash.New()
+	h.Write([]byte(signed))
+	digest := h.Sum(nil)
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return fmt.Errorf("algorithm %s does not match RSA key", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, sig); err != nil {
+			return errors.New("invalid signature")
+		}
+	case *ecdsa.PublicKey:
+		if !strings.HasPrefix(alg, "ES") {
+			return fmt.Errorf("algorithm %s does not match EC key", alg)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid signature")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+	default:
+		return errors.New("unsupported key")
+	}
+	return nil
+}
+
+// authenticateJWT verifies the bearer token and sets the subject header and the forwarded claims to r.
+func (l *Lamux) authenticateJWT(ctx context.Context, r *http.Request) error {
+	// never trust the subject and claim headers sent by clients
+	r.Header.Del(subjectHeader)
+	for _, header := range l.Config.JWTForwardClaims {
+		r.Header.Del(header)
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return newHandlerError(errors.New("missing bearer token"), http.StatusUnauthorized)
+	}
+	claims, err := l.jwtVerifier.Verify(ctx, token)
+	if err != nil {
+		return newHandlerError(fmt.Errorf("invalid token: %w", err), http.StatusUnauthorized)
+	}
+	r.Header.Set(subjectHeader, claims.Subject)
+	for claim, header := range l.Config.JWTForwardClaims {
+		if v, ok := claims.claimValue(claim); ok {
+			r.Header.Set(header, v)
+		}
+	}
+	if l.Config.StripAuthorization {
+		r.Header.Del("Authorization")
+	}
+	return nil
+}
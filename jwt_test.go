@@ -0,0 +1,451 @@
+package lamux_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func newJWKSServer(t *testing.T, key *rsa.PrivateKey, kid string) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA",
+					"kid": kid,
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+				},
+			},
+		})
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func signJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestJWT(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newJWKSServer(t, key, "key1")
+	now := time.Now()
+	validClaims := map[string]any{
+		"iss": "https://issuer.example.com",
+		"sub": "user-1",
+		"aud": "lamux",
+		"exp": now.Add(time.Hour).Unix(),
+	}
+
+	cases := []struct {
+		name       string
+		token      string
+		expectCode int
+	}{
+		{
+			name:       "valid token",
+			token:      signJWT(t, key, "key1", validClaims),
+			expectCode: http.StatusOK,
+		},
+		{
+			name:       "missing token",
+			token:      "",
+			expectCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "invalid signature",
+			token:      signJWT(t, otherKey, "key1", validClaims),
+			expectCode: http.StatusUnauthorized,
+		},
+		{
+			name: "expired",
+			token: signJWT(t, key, "key1", map[string]any{
+				"iss": "https://issuer.example.com",
+				"sub": "user-1",
+				"aud": "lamux",
+				"exp": now.Add(-time.Hour).Unix(),
+			}),
+			expectCode: http.StatusUnauthorized,
+		},
+		{
+			name: "invalid audience",
+			token: signJWT(t, key, "key1", map[string]any{
+				"iss": "https://issuer.example.com",
+				"sub": "user-1",
+				"aud": []string{"other"},
+				"exp": now.Add(time.Hour).Unix(),
+			}),
+			expectCode: http.StatusUnauthorized,
+		},
+		{
+			name: "invalid issuer",
+			token: signJWT(t, key, "key1", map[string]any{
+				"iss": "https://evil.example.com",
+				"sub": "user-1",
+				"aud": "lamux",
+				"exp": now.Add(time.Hour).Unix(),
+			}),
+			expectCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:    "test-func",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+				JWTConfig: lamux.JWTConfig{
+					JWTJWKSURL:         ts.URL,
+					JWTIssuer:          "https://issuer.example.com",
+					JWTAudience:        "lamux",
+					StripAuthorization: true,
+				},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			client := &mockClient{code: 200}
+			app.SetTestClient(client)
+
+			r, _ := http.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Forwarded-Host", "test.example.net")
+			r.Header.Set("X-Lamux-Subject", "spoofed")
+			if tc.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			w := httptest.NewRecorder()
+			err = app.HandleProxy(context.Background(), w, r)
+			if tc.expectCode != http.StatusOK {
+				var herr *lamux.HandlerError
+				if !errors.As(err, &herr) {
+					t.Fatalf("expected HandlerError, got %v", err)
+				}
+				if herr.Code() != tc.expectCode {
+					t.Errorf("expect %d, got %d", tc.expectCode, herr.Code())
+				}
+				if client.input != nil {
+					t.Error("lambda must not be invoked")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var payload struct {
+				Headers map[string]string `json:"headers"`
+			}
+			if err := json.Unmarshal(client.input.Payload, &payload); err != nil {
+				t.Fatal(err)
+			}
+			if e, a := "user-1", payload.Headers["x-lamux-subject"]; e != a {
+				t.Errorf("expect subject %q, got %q", e, a)
+			}
+			if a, ok := payload.Headers["authorization"]; ok {
+				t.Errorf("authorization header must be stripped, got %q", a)
+			}
+		})
+	}
+}
+
+func newJWTTestApp(t *testing.T, jc lamux.JWTConfig) (*lamux.Lamux, *mockClient) {
+	t.Helper()
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		JWTConfig:       jc,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{code: 200}
+	app.SetTestClient(client)
+	return app, client
+}
+
+func requestWithToken(token string) *http.Request {
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Forwarded-Host", "test.example.net")
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestJWTForwardClaims(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newJWKSServer(t, key, "key1")
+	app, client := newJWTTestApp(t, lamux.JWTConfig{
+		JWTJWKSURL: ts.URL,
+		JWTForwardClaims: map[string]string{
+			"email":   "X-Lamux-Email",
+			"groups":  "X-Lamux-Groups",
+			"admin":   "X-Lamux-Admin",
+			"missing": "X-Lamux-Missing",
+		},
+	})
+	token := signJWT(t, key, "key1", map[string]any{
+		"sub":    "user-1",
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"email":  "user@example.com",
+		"groups": []string{"dev", "ops"},
+		"admin":  true,
+	})
+	r := requestWithToken(token)
+	r.Header.Set("X-Lamux-Missing", "spoofed")
+	if err := app.HandleProxy(context.Background(), httptest.NewRecorder(), r); err != nil {
+		t.Fatal(err)
+	}
+	var payload struct {
+		Headers map[string]string `json:"headers"`
+	}
+	if err := json.Unmarshal(client.input.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	for h, expect := range map[string]string{
+		"x-lamux-email":  "user@example.com",
+		"x-lamux-groups": "dev,ops",
+		"x-lamux-admin":  "true",
+	} {
+		if a := payload.Headers[h]; expect != a {
+			t.Errorf("expect %s %q, got %q", h, expect, a)
+		}
+	}
+	if a, ok := payload.Headers["x-lamux-missing"]; ok {
+		t.Errorf("header of the missing claim must be removed, got %q", a)
+	}
+}
+
+func TestJWTMissingExp(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newJWKSServer(t, key, "key1")
+	token := signJWT(t, key, "key1", map[string]any{"sub": "user-1"})
+	for _, allow := range []bool{false, true} {
+		app, client := newJWTTestApp(t, lamux.JWTConfig{JWTJWKSURL: ts.URL, JWTAllowMissingExp: allow})
+		err := app.HandleProxy(context.Background(), httptest.NewRecorder(), requestWithToken(token))
+		if e, a := allow, err == nil; e != a {
+			t.Errorf("allow missing exp %v: expect ok %v, got %v", allow, e, err)
+		}
+		if !allow && len(client.invoked()) > 0 {
+			t.Error("function must not be invoked with a token without exp")
+		}
+	}
+}
+
+func TestJWTLeeway(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := newJWKSServer(t, key, "key1")
+	now := time.Now()
+	for _, tc := range []struct {
+		name   string
+		leeway time.Duration
+		claims map[string]any
+		ok     bool
+	}{
+		{"expired", 0, map[string]any{"exp": now.Add(-10 * time.Second).Unix()}, false},
+		{"expired within leeway", time.Minute, map[string]any{"exp": now.Add(-10 * time.Second).Unix()}, true},
+		{"expired beyond leeway", time.Minute, map[string]any{"exp": now.Add(-2 * time.Minute).Unix()}, false},
+		{"not valid yet", 0, map[string]any{"nbf": now.Add(10 * time.Second).Unix(), "exp": now.Add(time.Hour).Unix()}, false},
+		{"not valid yet within leeway", time.Minute, map[string]any{"nbf": now.Add(10 * time.Second).Unix(), "exp": now.Add(time.Hour).Unix()}, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app, _ := newJWTTestApp(t, lamux.JWTConfig{JWTJWKSURL: ts.URL, JWTLeeway: tc.leeway})
+			err := app.HandleProxy(context.Background(), httptest.NewRecorder(), requestWithToken(signJWT(t, key, "key1", tc.claims)))
+			if e, a := tc.ok, err == nil; e != a {
+				t.Errorf("expect ok %v, got %v", e, err)
+			}
+		})
+	}
+}
+
+func TestJWKSRefresh(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := newJWKSServer(t, key, "key1")
+	var mu sync.Mutex
+	var fetched int
+	failing := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		fetched++
+		if failing {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		resp, err := http.Get(jwks.URL)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		io.Copy(w, resp.Body)
+	}))
+	defer ts.Close()
+
+	app, _ := newJWTTestApp(t, lamux.JWTConfig{JWTJWKSURL: ts.URL, JWTJWKSRefreshInterval: 50 * time.Millisecond})
+	token := signJWT(t, key, "key1", map[string]any{"exp": time.Now().Add(time.Hour).Unix()})
+	request := func() {
+		t.Helper()
+		if err := app.HandleProxy(context.Background(), httptest.NewRecorder(), requestWithToken(token)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fetches := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return fetched
+	}
+
+	request()
+	request()
+	if e, a := 1, fetches(); e != a {
+		t.Errorf("expect %d fetches within the interval, got %d", e, a)
+	}
+	time.Sleep(100 * time.Millisecond)
+	request()
+	if e, a := 2, fetches(); e != a {
+		t.Errorf("expect %d fetches after the interval, got %d", e, a)
+	}
+
+	// the cached keys are used while refreshing fails
+	mu.Lock()
+	failing = true
+	mu.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	request()
+	request()
+	if e, a := 3, fetches(); e != a {
+		t.Errorf("expect %d fetches after the failure, got %d", e, a)
+	}
+}
+
+func TestJWKSFetchShared(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := newJWKSServer(t, key, "key1")
+	var mu sync.Mutex
+	var fetched int
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched++
+		mu.Unlock()
+		<-release
+		resp, err := http.Get(jwks.URL)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		io.Copy(w, resp.Body)
+	}))
+	defer ts.Close()
+	fetches := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return fetched
+	}
+
+	app, _ := newJWTTestApp(t, lamux.JWTConfig{JWTJWKSURL: ts.URL})
+	token := signJWT(t, key, "key1", map[string]any{"exp": time.Now().Add(time.Hour).Unix()})
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- app.HandleProxy(context.Background(), httptest.NewRecorder(), requestWithToken(token))
+		}()
+	}
+	for fetches() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// requests waiting for the fetch are not blocked beyond their contexts
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := app.HandleProxy(ctx, httptest.NewRecorder(), requestWithToken(token)); err == nil {
+		t.Error("expect an error while fetching JWKS")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expect the request to be canceled, took %s", elapsed)
+	}
+
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if e, a := 1, fetches(); e != a {
+		t.Errorf("expect %d fetch shared by the requests, got %d", e, a)
+	}
+}
+
+func TestJWTValidation(t *testing.T) {
+	for name, modify := range map[string]func(*lamux.JWTConfig){
+		"invalid JWKS URL":          func(jc *lamux.JWTConfig) { jc.JWTJWKSURL = "/jwks.json" },
+		"negative refresh interval": func(jc *lamux.JWTConfig) { jc.JWTJWKSRefreshInterval = -time.Second },
+		"negative leeway":           func(jc *lamux.JWTConfig) { jc.JWTLeeway = -time.Second },
+		"invalid claim header":      func(jc *lamux.JWTConfig) { jc.JWTForwardClaims = map[string]string{"email": "X Email"} },
+	} {
+		cfg := &lamux.Config{
+			FunctionName:    "test-func",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: time.Second,
+			JWTConfig:       lamux.JWTConfig{JWTJWKSURL: "https://example.com/jwks.json"},
+		}
+		modify(&cfg.JWTConfig)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -1,61 +1,244 @@
 package lamux
 
 import (
+	"cmp"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	slogcontext "github.com/PumpkinSeed/slog-context"
-	"github.com/alecthomas/kong"
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/lambda"
 	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	extensions "github.com/fujiwara/lambda-extensions"
 	"github.com/fujiwara/ridge"
+	"github.com/google/uuid"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
+	otellog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/log/global"
+	"go.opentelemetry.io/otel/propagation"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 )
 
 var Version = "current"
 
+var errFunctionError = errors.New("function error")
+
+const (
+	qualifierHeader       = "X-Lamux-Qualifier"
+	rateLimitSourceHeader = "X-Lamux-RateLimit-Source"
+)
+
 type Lamux struct {
 	Config *Config
 
-	awsCfg       aws.Config
-	lambdaClient lambdaClient
+	awsCfg            aws.Config
+	lambdaClient      lambdaClient
+	stsClient         stsClient
+	functionURLClient functionURLClient
+	jwtVerifier       *jwtVerifier
+	metrics           *metrics
+	otelMetrics       *otelMetrics
+	accessLogger      otellog.Logger
+	invokeStats       *invokeStats
+	coldStarts        *coldStartTracker
+	cache             responseCache
+	timeoutPage       *errorPage
+	ipFilter          *ipFilter
+	clientIPResolver  *clientIPResolver
+	bodyBudget        *bodyBudget
+	concurrency       *concurrencyLimiter
+	rateLimiter       *rateLimiter
+	readiness         *readinessProber
+	circuitBreaker    *circuitBreaker
+	routes            atomic.Pointer[routingTable]
+	labels            *validatedLabels
+	payloadLogger     *payloadLogger
+	identity          awsIdentity
+	startedAt         time.Time
+	instanceID        string // to detect loops by viaHeader
 }
 
 type lambdaClient interface {
 	Invoke(ctx context.Context, params *lambda.InvokeInput, optFns ...func(*lambda.Options)) (*lambda.InvokeOutput, error)
+	GetFunctionConcurrency(ctx context.Context, params *lambda.GetFunctionConcurrencyInput, optFns ...func(*lambda.Options)) (*lambda.GetFunctionConcurrencyOutput, error)
+	GetFunction(ctx context.Context, params *lambda.GetFunctionInput, optFns ...func(*lambda.Options)) (*lambda.GetFunctionOutput, error)
+	ListAliases(ctx context.Context, params *lambda.ListAliasesInput, optFns ...func(*lambda.Options)) (*lambda.ListAliasesOutput, error)
+}
+
+// newLambdaHTTPClient returns the HTTP client for the Lambda API,
+// which severs each request taking longer than timeout even if the context deadline is longer.
+func newLambdaHTTPClient(timeout time.Duration) aws.HTTPClient {
+	return awshttp.NewBuildableClient().WithTimeout(timeout)
+}
+
+// lambdaOptions applies LambdaClientTimeout to the options of the Lambda client.
+func (cfg *Config) lambdaOptions(o *lambda.Options) {
+	if cfg.LambdaClientTimeout > 0 {
+		o.HTTPClient = newLambdaHTTPClient(cfg.LambdaClientTimeout)
+	}
+}
+
+// invokeOptions disables the retries of Invoke by the AWS SDK with LambdaClientTimeout,
+// because the timed out invocation may be still running and functions are not always idempotent.
+func (cfg *Config) invokeOptions(o *lambda.Options) {
+	if cfg.LambdaClientTimeout > 0 {
+		o.RetryMaxAttempts = 1
+	}
 }
 
 func NewLamux(cfg *Config) (*Lamux, error) {
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid config: %w", err)
 	}
-	awsCfg, err := config.LoadDefaultConfig(context.Background())
+	var opts []func(*config.LoadOptions) error
+	if cfg.Region != "" {
+		opts = append(opts, config.WithRegion(cfg.Region))
+	}
+	awsCfg, err := config.LoadDefaultConfig(context.Background(), opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
-	return &Lamux{
+	if cfg.CredentialWaitTimeout > 0 {
+		if err := waitForCredentials(context.Background(), awsCfg.Credentials, cfg.CredentialWaitTimeout); err != nil {
+			return nil, err
+		}
+	}
+	l := &Lamux{
 		Config:       cfg,
 		awsCfg:       awsCfg,
-		lambdaClient: lambda.NewFromConfig(awsCfg),
-	}, nil
+		lambdaClient: lambda.NewFromConfig(awsCfg, cfg.lambdaOptions),
+		stsClient:    sts.NewFromConfig(awsCfg),
+		labels:       newValidatedLabels(),
+		startedAt:    time.Now(),
+		instanceID:   uuid.NewString(),
+	}
+	if cfg.JWTConfig.Enabled() {
+		l.jwtVerifier = newJWTVerifier(&cfg.JWTConfig)
+	}
+	if cfg.ReadinessCheckPath != "" {
+		l.readiness = l.newReadinessProber()
+	}
+	if cfg.MetricsEnabled {
+		l.metrics = newMetrics()
+	}
+	if cfg.MetricConfig.Enabled() {
+		// instruments are delegated to the meter provider set by setupOtelSDK later
+		l.otelMetrics, err = newOtelMetrics(otel.GetMeterProvider())
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.OTelLogsEnabled {
+		// the logger is delegated to the logger provider set by setupOtelSDK later
+		l.accessLogger = global.Logger("github.com/fujiwara/lamux")
+	}
+	if cfg.RichReadiness {
+		l.invokeStats = newInvokeStats()
+	}
+	if cfg.Backend == backendFunctionURL {
+		l.functionURLClient = newFunctionURLClient()
+	}
+	if cfg.CacheEnabled {
+		l.cache = newLRUCache(cfg.CacheMaxBytes)
+	}
+	if cfg.ColdStartIdleTimeout > 0 {
+		l.coldStarts = newColdStartTracker(cfg.ColdStartIdleTimeout)
+	}
+	l.clientIPResolver, err = newClientIPResolver(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.IPFilterConfig.Enabled() {
+		l.ipFilter, err = newIPFilter(&cfg.IPFilterConfig)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ConcurrencyPerFunction > 0 || cfg.AutoConcurrency || cfg.MaxConcurrency > 0 {
+		l.concurrency = newConcurrencyLimiter(cfg, l.reservedConcurrency)
+	}
+	if cfg.RateLimit > 0 || len(cfg.RateLimitByAlias) > 0 {
+		l.rateLimiter = newRateLimiter(cfg, l.clientIPResolver)
+	}
+	if cfg.CircuitBreakerThreshold > 0 {
+		l.circuitBreaker = newCircuitBreaker(cfg)
+	}
+	routes, err := newRoutingTable(cfg)
+	if err != nil {
+		return nil, err
+	}
+	l.routes.Store(routes)
+	if cfg.PayloadLogSampleRate > 0 {
+		l.payloadLogger = newPayloadLogger(cfg)
+	}
+	if cfg.MaxInFlightBodyBytes > 0 {
+		l.bodyBudget = newBodyBudget(cfg.MaxInFlightBodyBytes)
+	}
+	if cfg.TimeoutBodyFile != "" {
+		l.timeoutPage, err = loadErrorPage(cfg.TimeoutBodyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load timeout body: %w", err)
+		}
+	}
+	return l, nil
 }
 
 type handlerFunc func(ctx context.Context, w http.ResponseWriter, r *http.Request) error
 
+// requestInfo holds the routing results of a request, filled by handlers.
+type requestInfo struct {
+	functionName  string
+	alias         string
+	qualifier     string
+	status        int
+	timeoutReason string
+	cache         string
+	readDeadline  time.Time // read deadline of the connection for the request, zero means none
+	hostRouted    bool      // the qualifier is routed by the alias of the host, not overridden
+}
+
+type requestInfoKey struct{}
+
+func withRequestInfo(ctx context.Context) (context.Context, *requestInfo) {
+	info := &requestInfo{}
+	return context.WithValue(ctx, requestInfoKey{}, info), info
+}
+
+func getRequestInfo(ctx context.Context) *requestInfo {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{}
+}
+
 type HandlerError struct {
-	err  error
-	code int
+	err    error
+	code   int
+	header http.Header
+	routes []route // valid routes to be listed in 404 responses
+
+	// message is written to clients instead of the error, which may contain internal details like ARNs
+	message string
 }
 
 func (h *HandlerError) Error() string {
@@ -70,6 +253,14 @@ func (h *HandlerError) Code() int {
 	return h.code
 }
 
+// Header returns the headers to be set to the error response.
+func (h *HandlerError) Header() http.Header {
+	if h.header == nil {
+		h.header = make(http.Header)
+	}
+	return h.header
+}
+
 func newHandlerError(err error, code int) *HandlerError {
 	return &HandlerError{err: err, code: code}
 }
@@ -77,29 +268,42 @@ func newHandlerError(err error, code int) *HandlerError {
 func Run(ctx context.Context) error {
 	slog.SetDefault(slog.New(slogcontext.NewHandler(slog.NewJSONHandler(os.Stdout, nil))))
 
-	cfg := &Config{}
-	kong.Parse(cfg)
+	cfg, err := parseConfig(os.Args[1:])
+	if err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
 	if cfg.Version {
 		fmt.Println(Version)
 		return nil
 	}
+	closeLog, err := setupLogger(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to setup logger: %w", err)
+	}
+	defer closeLog()
 
 	l, err := NewLamux(cfg)
 	if err != nil {
 		return err
 	}
-	otelShutdown, err := setupOtelSDK(ctx, &cfg.TraceConfig)
+	otelShutdown, err := setupOtelSDK(ctx, &cfg.TraceConfig, &cfg.MetricConfig)
 	if err != nil {
 		return fmt.Errorf("failed to setup Otel SDK: %w", err)
 	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/", l.wrapHandler(l.handleProxy))
-	var handler http.Handler
-	if l.Config.TraceConfig.Enabled() {
-		handler = otelhttp.NewHandler(mux, "/")
-	} else {
-		handler = mux
+	if cfg.ResolveAccountID {
+		l.resolveAccountID(ctx)
+	}
+	if cfg.ValidateFunctions {
+		defer otelShutdown(context.Background())
+		return l.validateFunctions(ctx)
+	}
+	handler := l.newHandler()
+	if len(cfg.WarmupTargets) > 0 {
+		go l.runWarmup(ctx)
+	}
+	if cfg.ReloadOnSIGHUP {
+		go l.watchReload(ctx, os.Args[1:])
 	}
 
 	if ridge.AsLambdaExtension() {
@@ -113,14 +317,52 @@ func Run(ctx context.Context) error {
 		go ec.Run(ctx)
 	}
 
-	addr := fmt.Sprintf(":%d", cfg.Port)
+	addrs, err := cfg.listenAddresses()
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		names = append(names, a.String())
+	}
 	slog.Info("starting",
-		"addr", addr,
+		"addr", strings.Join(names, ","),
 		"function_name", cfg.FunctionName,
 		"domain_suffix", cfg.DomainSuffix,
+		"region", l.awsCfg.Region,
+		"health_check_path", cfg.HealthCheckPath,
 		"trace_config", cfg.TraceConfig,
 	)
-	r := ridge.New(addr, "/", handler)
+	if d := cfg.maxUpstreamTimeout(); cfg.LambdaClientTimeout > 0 && cfg.LambdaClientTimeout < d {
+		slog.Warn("lambda client timeout is shorter than the upstream timeout, invocations longer than it are severed without retries",
+			"lambda_client_timeout", cfg.LambdaClientTimeout,
+			"upstream_timeout", d,
+		)
+	}
+	if d := cfg.maxUpstreamTimeout(); cfg.ServerWriteTimeout > 0 && cfg.ServerWriteTimeout <= d {
+		slog.Warn("server write timeout is not longer than the upstream timeout, responses of slow functions will be cut off",
+			"server_write_timeout", cfg.ServerWriteTimeout,
+			"upstream_timeout", d,
+		)
+	}
+	if cfg.JWTConfig.Enabled() && cfg.JWTAudience == "" {
+		slog.Warn("jwt audience is not set, tokens issued for any audience by the JWKS keys are accepted; set --jwt-audience",
+			"jwt_jwks_url", cfg.JWTJWKSURL)
+	}
+	if len(cfg.ResponseRewrites) > 0 {
+		slog.Warn("response rewrites decode and copy the whole bodies of matching responses, which costs CPU and memory on large bodies",
+			"rules", len(cfg.ResponseRewrites))
+	}
+	if l.concurrency != nil && cfg.FunctionName != "*" {
+		// look up the reserved concurrency of the fixed function before serving
+		l.concurrency.semaphore(ctx, cfg.FunctionName)
+	}
+	if !ridge.AsLambdaHandler() {
+		// serve by the own server to apply the server timeouts, which ridge does not support
+		defer otelShutdown(context.Background())
+		return cfg.serve(ctx, handler)
+	}
+	r := ridge.New(addrs[0].address, "/", handler)
 	r.TermHandler = func() {
 		otelShutdown(context.Background())
 	}
@@ -128,34 +370,163 @@ func Run(ctx context.Context) error {
 	return nil
 }
 
+func (l *Lamux) newHandler() http.Handler {
+	mux := http.NewServeMux()
+	if l.Config.HealthCheckPath != "" {
+		// health check requests are not logged and do not require a valid domain suffix
+		var health http.Handler = http.HandlerFunc(l.handleHealthCheck)
+		if l.ipFilter != nil && !l.Config.IPFilterExcludeHealthCheck {
+			health = l.ipFilterMiddleware(health)
+		}
+		mux.Handle(l.Config.HealthCheckPath, health)
+	}
+	if l.Config.ReadinessCheckPath != "" {
+		var readiness http.Handler = http.HandlerFunc(l.handleReadinessCheck)
+		if l.ipFilter != nil && !l.Config.IPFilterExcludeHealthCheck {
+			readiness = l.ipFilterMiddleware(readiness)
+		}
+		mux.Handle(l.Config.ReadinessCheckPath, readiness)
+	}
+	if l.metrics != nil {
+		mux.Handle(l.Config.MetricsPath, l.withIPFilter(l.metrics.handler()))
+	}
+	// favicon and robots.txt requests are answered locally without invoking functions
+	if l.Config.ServeFaviconEmpty {
+		mux.Handle(faviconPath, l.withIPFilter(http.HandlerFunc(l.handleFavicon)))
+	}
+	if l.Config.RobotsTxt != "" {
+		mux.Handle(robotsTxtPath, l.withIPFilter(http.HandlerFunc(l.handleRobotsTxt)))
+	}
+	var proxy http.Handler = l.wrapHandler(l.handleProxy)
+	if l.Config.CORSConfig.Enabled() {
+		proxy = l.handleCORS(proxy)
+	}
+	mux.Handle("/", proxy)
+	var handler http.Handler = mux
+	if l.Config.TraceConfig.Enabled() {
+		handler = otelhttp.NewHandler(handler, "/")
+	}
+	if l.Config.EnableH2C {
+		handler = h2c.NewHandler(handler, &http2.Server{})
+	}
+	return handler
+}
+
 func (l *Lamux) wrapHandler(h handlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
+		now := time.Now()
+		server := l.Config.serverDeadlines(now)
+		readDeadline := server.read
+		if d := l.Config.MaxRequestDuration; d > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, d)
+			defer cancel()
+			defer setRequestDeadline(w, now.Add(d), server)()
+			readDeadline = earlier(now.Add(d), server.read)
+		}
+		id := requestID(r)
+		r.Header.Set(requestIDHeader, id)
+		w.Header().Set(requestIDHeader, id)
 		ctx = setRequestContext(ctx, r)
+		clientIP, ipErr := l.clientIPResolver.resolve(r)
+		if ipErr == nil {
+			ctx = slogcontext.WithValue(ctx, "client_ip", clientIP.String())
+		}
+		if account := l.accountID(); account != "" {
+			ctx = slogcontext.WithValue(ctx, "account_id", account)
+		}
+		if l.Config.ForwardGeoHeaders {
+			ctx = setGeoContext(ctx, r.Header)
+		}
+		ctx, info := withRequestInfo(ctx)
+		info.readDeadline = readDeadline
 		start := time.Now()
-		err := h(ctx, w, r)
+		var err error
+		if l.ipFilter != nil {
+			if ipErr != nil {
+				err = newHandlerError(ipErr, http.StatusForbidden)
+			} else {
+				err = l.ipFilter.check(clientIP)
+			}
+		}
+		if err == nil && l.Config.GeoConfig.Enabled() {
+			err = l.Config.GeoConfig.checkCountry(r.Header)
+		}
+		cw := &countingResponseWriter{ResponseWriter: w}
+		if err == nil {
+			err = h(ctx, cw, r)
+		}
+		if err != nil && l.Config.MaxRequestDuration > 0 && exceeded(ctx) {
+			info.timeoutReason = "max_request_duration"
+			err = newHandlerError(fmt.Errorf("%w: %w", errMaxRequestDuration, err), http.StatusGatewayTimeout)
+			// allow writing the error response after the deadline
+			http.NewResponseController(w).SetWriteDeadline(time.Now().Add(errorWriteTimeout))
+		}
 		elapsed := time.Since(start)
 		ctx = slogcontext.WithValue(ctx, "duration", elapsed.Seconds())
+		if info.functionName != "" {
+			// attribute failures of the handler (e.g. authentication) to the function
+			ctx = slogcontext.WithValue(ctx, "function_name", info.functionName)
+			ctx = slogcontext.WithValue(ctx, "alias", info.alias)
+		}
+		if info.cache != "" {
+			ctx = slogcontext.WithValue(ctx, "cache", info.cache)
+		}
 		if err != nil {
+			code := http.StatusInternalServerError
+			logErr := err
 			var herr *HandlerError
-			var code int
 			if errors.As(err, &herr) {
-				slog.ErrorContext(ctx, "request", "status", herr.Code(), "error", herr.Unwrap())
-				code = herr.Code()
+				code, logErr = herr.Code(), herr.Unwrap()
+				for k, v := range herr.Header() {
+					w.Header()[k] = v
+				}
+			}
+			l.observeRequest(ctx, info.functionName, info.alias, code, elapsed)
+			l.emitAccessLog(ctx, r, info, code, start, elapsed, err)
+			if code == http.StatusTooManyRequests && l.Config.RateLimitSourceHeader {
+				w.Header().Set(rateLimitSourceHeader, "proxy")
+			}
+			if code == http.StatusGatewayTimeout && l.timeoutPage != nil {
+				l.timeoutPage.write(cw, code)
 			} else {
-				slog.ErrorContext(ctx, "request", "status", http.StatusInternalServerError, "error", err)
-				code = http.StatusInternalServerError
+				l.writeError(cw, err, code, id)
+			}
+			ctx = slogcontext.WithValue(ctx, "response_size", cw.size)
+			if info.timeoutReason != "" {
+				ctx = slogcontext.WithValue(ctx, "timeout_reason", info.timeoutReason)
 			}
-			http.Error(w, err.Error(), code)
+			slog.ErrorContext(ctx, "request", "status", code, "error", logErr)
 			return
 		}
-		slog.InfoContext(ctx, "response", "status", http.StatusOK)
+		l.observeRequest(ctx, info.functionName, info.alias, info.status, elapsed)
+		l.emitAccessLog(ctx, r, info, info.status, start, elapsed, nil)
+		ctx = slogcontext.WithValue(ctx, "response_size", cw.size)
+		slog.InfoContext(ctx, "response", "status", cmp.Or(info.status, http.StatusOK))
 	}
 }
 
+// countingResponseWriter counts the bytes of the response body written.
+type countingResponseWriter struct {
+	http.ResponseWriter
+	size int64
+}
+
+func (w *countingResponseWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *countingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func setRequestContext(ctx context.Context, r *http.Request) context.Context {
 	ctx = slogcontext.WithValue(ctx, "remote", r.RemoteAddr)
 	ctx = slogcontext.WithValue(ctx, "method", r.Method)
+	ctx = slogcontext.WithValue(ctx, "proto", r.Proto)
 	ctx = slogcontext.WithValue(ctx, "url", r.URL.String())
 	ctx = slogcontext.WithValue(ctx, "host", r.Host)
 	ctx = slogcontext.WithValue(ctx, "ua", r.UserAgent())
@@ -168,9 +539,7 @@ func setRequestContext(ctx context.Context, r *http.Request) context.Context {
 	if id := r.Header.Get("X-Amz-Cf-Id"); id != "" {
 		ctx = slogcontext.WithValue(ctx, "x_amz_cf_id", id)
 	}
-	if id := r.Header.Get("X-Amzn-RequestId"); id != "" {
-		ctx = slogcontext.WithValue(ctx, "request_id", id)
-	}
+	ctx = slogcontext.WithValue(ctx, "request_id", r.Header.Get(requestIDHeader))
 	return ctx
 }
 
@@ -181,40 +550,561 @@ func (l *Lamux) handleProxy(ctx context.Context, w http.ResponseWriter, r *http.
 		slog.ErrorContext(ctx, "handleProxy", "error", err)
 		return err
 	}
-	// prevent recursive call
-	if os.Getenv("AWS_LAMBDA_FUNCTION_NAME") == functionName {
-		return fmt.Errorf("recursive call detected: %s", functionName)
+	if err := l.checkLoop(r, functionName); err != nil {
+		return err
 	}
 	ctx = slogcontext.WithValue(ctx, "function_name", functionName)
 	ctx = slogcontext.WithValue(ctx, "alias", alias)
+	if _, ok := l.Config.FunctionTimeouts[functionName]; ok {
+		ctx = slogcontext.WithValue(ctx, "upstream_timeout", l.Config.FunctionTimeout(functionName).Seconds())
+	}
+	info := getRequestInfo(ctx)
+	info.functionName, info.alias = functionName, alias
+	cacheable := l.cacheable(r)
 
-	payload, err := ridge.ToRequestV2(r)
+	if l.Config.CORSConfig.Enabled() && isPreflight(r) {
+		// preflight requests carry no credentials
+		info.status = http.StatusNoContent
+		l.Config.CORSConfig.writePreflight(w, r)
+		return nil
+	}
+	if l.Config.BasicAuthConfig.Enabled() {
+		if err := l.authenticateBasic(w, r); err != nil {
+			return err
+		}
+	}
+
+	r = r.Clone(ctx)
+	if l.jwtVerifier != nil {
+		if err := l.authenticateJWT(ctx, r); err != nil {
+			return err
+		}
+	}
+	var signature *signatureVerifier
+	if l.Config.SignatureConfig.Enabled() {
+		if signature, err = l.Config.newSignatureVerifier(r, time.Now()); err != nil {
+			return err
+		}
+	}
+	routes := l.routing()
+	if routes.routeFilter != nil {
+		if err := routes.routeFilter.check(functionName, r); err != nil {
+			return err
+		}
+	}
+	if l.Config.ForwardGeoHeaders {
+		// geo headers are forwarded even if listed as hop-by-hop headers
+		geo := saveGeoHeaders(r.Header)
+		removeHopByHopHeaders(r.Header, l.Config.hopByHopHeaders())
+		for k, vs := range geo {
+			r.Header[k] = vs
+		}
+	} else {
+		removeHopByHopHeaders(r.Header, l.Config.hopByHopHeaders())
+	}
+	// forward the current trace context so that the function continues the trace
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(r.Header))
+	if l.Config.CollapseRequestHeaders {
+		collapseHeaders(r.Header)
+	}
+	if l.Config.TrustForwardedPort {
+		if err := applyForwardedPort(r); err != nil {
+			return newHandlerError(err, http.StatusBadRequest)
+		}
+	}
+	setForwardedProto(r, l.Config.TrustForwardedPort)
+	if len(l.Config.CostCenterByAlias) > 0 {
+		ctx = l.Config.setCostCenter(ctx, r.Header, alias)
+	}
+	l.addVia(r.Header)
+	var realAlias string
+	weighted, ok := routes.weightedAliases(functionName, alias)
+	if !ok {
+		// unknown aliases are rejected by --strict-alias before the rule for the function
+		if realAlias, err = routes.mapAlias(alias); err != nil {
+			herr := newHandlerError(err, http.StatusNotFound)
+			herr.routes = l.Config.aliasRoutes(routes.aliasMap, functionName)
+			return herr
+		}
+		weighted, ok = routes.functionWeightedAliases(functionName, realAlias)
+	}
+	if ok {
+		realAlias = l.Config.chooseWeightedAlias(w, r, weighted)
+		ctx = slogcontext.WithValue(ctx, "weighted_alias", realAlias)
+	}
+	qualifier, err := l.resolveQualifier(r, realAlias)
 	if err != nil {
-		return fmt.Errorf("failed to convert request: %w", err)
+		return newHandlerError(err, http.StatusBadRequest)
 	}
-	b, err := json.Marshal(payload)
+	info.hostRouted = qualifier == realAlias
+	if qualifier != alias {
+		info.qualifier = qualifier
+		ctx = slogcontext.WithValue(ctx, "qualifier", qualifier)
+	}
+	invocationType, err := l.resolveInvocationType(r, functionName)
 	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
+		return newHandlerError(err, http.StatusBadRequest)
 	}
+	slog.DebugContext(ctx, "handleProxy", "resolved_alias", realAlias, "resolved_qualifier", qualifier, "invocation_type", string(invocationType))
 
-	resp, err := l.Invoke(ctx, functionName, alias, b)
-	if err != nil {
+	var nonce string
+	if l.Config.InjectCSPNonce {
+		if nonce, err = newCSPNonce(); err != nil {
+			return err
+		}
+		r.Header.Set(cspNonceHeader, nonce)
+	} else {
+		r.Header.Del(cspNonceHeader) // never trust the nonce header sent by clients
+	}
+
+	var cacheKey string
+	if cacheable && l.Config.Backend != backendFunctionURL && invocationType == types.InvocationTypeRequestResponse {
+		cacheKey = l.Config.cacheKey(r, functionName, qualifier)
+	}
+	if cacheKey != "" {
+		if c, ok := l.cache.get(cacheKey); ok {
+			info.cache = "hit"
+			res := c.cachedCopy()
+			info.status = res.StatusCode
+			size, err := l.writeResponse(ctx, w, res, false)
+			ctx = slogcontext.WithValue(ctx, "response_size", size)
+			if err != nil {
+				return err
+			}
+			slog.InfoContext(ctx, "handleProxy", "cache", "hit", "status", res.StatusCode)
+			return nil
+		}
+		info.cache = "miss"
+	}
+	if l.rateLimiter != nil {
+		if err := l.rateLimiter.allow(functionName, alias, r); err != nil {
+			slog.WarnContext(ctx, "rate limited", "error", err)
+			l.observeThrottle(ctx, functionName, alias)
+			return err
+		}
+	}
+
+	var readTimeout *readTimeoutReader
+	if l.Config.RequestReadTimeout > 0 {
+		var clear func()
+		readTimeout, clear = wrapReadTimeout(w, r, l.Config.RequestReadTimeout, getRequestInfo(ctx).readDeadline)
+		defer clear()
+	}
+	var body *budgetReader
+	if l.bodyBudget != nil {
+		var release func()
+		body, release, err = l.bodyBudget.wrap(r)
+		if err != nil {
+			return newHandlerError(err, http.StatusServiceUnavailable)
+		}
+		defer release()
+	}
+	// readError returns the cause of failures reading the body
+	readError := func(err error) error {
+		if body != nil && body.exceeded {
+			return newHandlerError(errBodyBudgetExceeded, http.StatusServiceUnavailable)
+		}
+		if readTimeout != nil && readTimeout.timedOut {
+			return newHandlerError(errRequestReadTimeout, http.StatusRequestTimeout)
+		}
 		return err
 	}
+	if l.Config.Backend == backendFunctionURL {
+		var src io.Reader = r.Body
+		limit := l.Config.MaxPayloadSize
+		if limit > 0 {
+			// read one more byte to detect the excess without buffering the whole body
+			src = io.LimitReader(r.Body, limit+1)
+		}
+		b, err := io.ReadAll(src)
+		if err != nil {
+			return readError(fmt.Errorf("failed to read request body: %w", err))
+		}
+		if limit > 0 && int64(len(b)) > limit {
+			return newHandlerError(fmt.Errorf("request body exceeds the limit %d bytes", limit), http.StatusRequestEntityTooLarge)
+		}
+		if signature != nil {
+			if err := signature.verify(); err != nil {
+				return err
+			}
+		}
+		return l.proxyFunctionURL(ctx, w, r, functionName, alias, qualifier, nonce, b)
+	}
+	b, err := l.convertRequest(ctx, r)
+	if err != nil {
+		return readError(err)
+	}
+	if signature != nil {
+		if err := signature.verify(); err != nil {
+			return err
+		}
+	}
+	if limit := l.Config.MaxPayloadSize; limit > 0 && int64(len(b)) > limit {
+		return newHandlerError(fmt.Errorf("payload size %d bytes exceeds the limit %d bytes", len(b), limit), http.StatusRequestEntityTooLarge)
+	}
+	slog.DebugContext(ctx, "handleProxy", "payload_size", len(b))
+	logPayload := l.payloadLogger.sample()
+	if logPayload {
+		l.payloadLogger.log(ctx, "request_payload", b)
+	}
+	if l.Config.isLargePayload(b) {
+		asyncFunctionName := functionName
+		if l.Config.LargePayloadFunction != "" {
+			asyncFunctionName = l.Config.LargePayloadFunction
+		}
+		ctx = slogcontext.WithValue(ctx, "async_function_name", asyncFunctionName)
+		if err := l.InvokeAsync(ctx, asyncFunctionName, qualifier, b); err != nil {
+			return err
+		}
+		info.status = http.StatusAccepted
+		slog.InfoContext(ctx, "handleProxy", "upstream_status", http.StatusAccepted, "payload_size", len(b))
+		return acceptAsync(w, r.Header.Get(requestIDHeader))
+	}
+	if invocationType == types.InvocationTypeEvent {
+		ctx = slogcontext.WithValue(ctx, "invocation_type", string(invocationType))
+		if err := l.InvokeAsync(ctx, functionName, qualifier, b); err != nil {
+			return err
+		}
+		info.status = http.StatusAccepted
+		slog.InfoContext(ctx, "handleProxy", "upstream_status", http.StatusAccepted)
+		return acceptAsync(w, r.Header.Get(requestIDHeader))
+	}
+
+	if l.Config.ShadowFunction != "" {
+		l.invokeShadow(ctx, realAlias, b)
+	}
+	if l.concurrency != nil {
+		release, err := l.concurrency.acquire(ctx, functionName)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+	defer l.observeConcurrency(ctx, functionName, alias)()
+	invokeStart := time.Now()
+	resp, err := l.Invoke(ctx, functionName, qualifier, b)
+	upstreamDuration := time.Since(invokeStart)
+	ctx = slogcontext.WithValue(ctx, "upstream_duration", upstreamDuration.Seconds())
+	span := oteltrace.SpanFromContext(ctx)
+	span.SetAttributes(attribute.KeyValue{
+		Key:   attribute.Key("lamux.upstream_duration"),
+		Value: attribute.Float64Value(upstreamDuration.Seconds()),
+	})
+	if err != nil {
+		return functionNotFound(ctx, err, functionName, alias, qualifier)
+	}
+	if l.concurrency != nil {
+		l.concurrency.validated(functionName)
+	}
+	if logPayload {
+		l.payloadLogger.log(ctx, "response_payload", resp.Payload)
+	}
+	if l.Config.RawPayloadPassthrough {
+		info.status = http.StatusOK
+		slog.InfoContext(ctx, "handleProxy", "upstream_status", http.StatusOK)
+		for k, v := range l.Config.responseHeaders(alias) {
+			w.Header().Set(k, v)
+		}
+		return writeRawResponse(w, l.Config.RawPayloadContentType, resp.Payload)
+	}
+
+	if json.Valid(resp.Payload) && !isResponseObject(resp.Payload) {
+		if !l.Config.RawResponseFallback {
+			return newHandlerError(errors.New("function returned a payload which is not a response object"), http.StatusBadGateway)
+		}
+		info.status = http.StatusOK
+		slog.InfoContext(ctx, "handleProxy", "upstream_status", http.StatusOK, "raw_response", true)
+		for k, v := range l.Config.responseHeaders(alias) {
+			w.Header().Set(k, v)
+		}
+		return writeRawResponse(w, "application/json", resp.Payload)
+	}
 
 	var res ridge.Response
 	if err := json.Unmarshal(resp.Payload, &res); err != nil {
 		return fmt.Errorf("failed to unmarshal response: %w", err)
 	}
+	chunked := removeResponseTransferEncoding(&res)
+	removeResponseHopByHopHeaders(&res, l.Config.hopByHopHeaders())
+	if limit := l.Config.MaxResponseHeaderCount; limit > 0 {
+		if n := countHeaders(&res); n > limit {
+			return newHandlerError(fmt.Errorf("too many response headers: %d (max %d)", n, limit), http.StatusBadGateway)
+		}
+	}
+	if l.Config.InjectCSPNonce {
+		if err := l.Config.CSPConfig.injectCSPNonce(&res, nonce); err != nil {
+			return newHandlerError(err, http.StatusBadGateway)
+		}
+	}
+	if len(l.Config.ResponseRewrites) > 0 {
+		if err := rewriteResponseBody(&res, l.Config.ResponseRewrites); err != nil {
+			return newHandlerError(err, http.StatusBadGateway)
+		}
+	}
+	if len(l.Config.AllowedResponseContentTypes) > 0 {
+		if err := checkResponseContentType(&res, l.Config.AllowedResponseContentTypes); err != nil {
+			return newHandlerError(err, http.StatusBadGateway)
+		}
+	}
+	if res.StatusCode == http.StatusTooManyRequests && l.Config.RateLimitSourceHeader {
+		setResponseHeader(&res, rateLimitSourceHeader, "function")
+	}
+	if l.Config.CompressResponses {
+		if err := compressResponse(&res, r.Header.Get("Accept-Encoding"), l.Config.CompressMinSize); err != nil {
+			return newHandlerError(err, http.StatusBadGateway)
+		}
+	}
+	if err := decodeResponseBody(&res); err != nil {
+		return newHandlerError(err, http.StatusBadGateway)
+	}
+	if l.Config.SSEPassthrough && isEventStream(responseHeader(&res, "Content-Type")) {
+		// the body buffered by Invoke API is written at once, but proxies must not buffer it further
+		setEventStreamResponseHeaders(&res)
+	}
+	l.Config.setStaticResponseHeaders(&res, alias)
 	upstreamCode := res.StatusCode
-	if _, err := res.WriteTo(w); err != nil {
-		return fmt.Errorf("failed to write response: %w", err)
+	if code, ok := l.Config.StatusCodeOverrides[upstreamCode]; ok {
+		res.StatusCode = code
+	}
+	info.status = res.StatusCode
+	if cacheKey != "" {
+		l.storeResponse(cacheKey, &res)
+		setResponseHeader(&res, cacheHeader, "MISS")
+	}
+	size, err := l.writeResponse(ctx, w, &res, chunked)
+	ctx = slogcontext.WithValue(ctx, "response_size", size)
+	span.SetAttributes(attribute.KeyValue{
+		Key:   attribute.Key("http.response.body.size"),
+		Value: attribute.Int64Value(size),
+	})
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != upstreamCode {
+		slog.InfoContext(ctx, "handleProxy", "upstream_status", upstreamCode, "overridden_status", res.StatusCode)
+	} else {
+		slog.InfoContext(ctx, "handleProxy", "upstream_status", upstreamCode)
 	}
-	slog.InfoContext(ctx, "handleProxy", "upstream_status", upstreamCode)
 
 	return nil
 }
 
+// resolveQualifier returns the qualifier to invoke.
+// The precedence is X-Lamux-Qualifier header (if allowed) > Config.Qualifier > alias from host.
+func (l *Lamux) resolveQualifier(r *http.Request, alias string) (string, error) {
+	q := r.Header.Get(qualifierHeader)
+	r.Header.Del(qualifierHeader)
+	if q != "" && l.Config.AllowQualifierHeader {
+		if !isValidQualifier(q) {
+			return "", fmt.Errorf("invalid %s header (%s or %s allowed)", qualifierHeader, versionRegexp.String(), aliasRegexp.String())
+		}
+		return q, nil
+	}
+	if l.Config.Qualifier != "" {
+		return l.Config.Qualifier, nil
+	}
+	return alias, nil
+}
+
+// observeRequest records the request in metrics. Metrics are labeled by the function names
+// and aliases validated by invocations, otherwise by unknownLabel.
+func (l *Lamux) observeRequest(ctx context.Context, functionName, alias string, code int, elapsed time.Duration) {
+	functionName, alias = l.labels.labels(functionName, alias)
+	l.metrics.observeRequest(functionName, alias, code, elapsed)
+	l.otelMetrics.observeRequest(ctx, functionName, alias, code, elapsed)
+}
+
+func (l *Lamux) observeThrottle(ctx context.Context, functionName, alias string) {
+	functionName, alias = l.labels.labels(functionName, alias)
+	l.metrics.observeThrottle(functionName, alias)
+	l.otelMetrics.observeThrottle(ctx, functionName, alias)
+}
+
+// observeConcurrency counts the invocation in progress, and returns the function to call when it is done.
+// The labels are kept until done, even if validated by the invocation.
+func (l *Lamux) observeConcurrency(ctx context.Context, functionName, alias string) func() {
+	functionName, alias = l.labels.labels(functionName, alias)
+	l.metrics.observeConcurrency(functionName, alias, 1)
+	l.otelMetrics.observeConcurrency(ctx, functionName, alias, 1)
+	return func() {
+		l.metrics.observeConcurrency(functionName, alias, -1)
+		l.otelMetrics.observeConcurrency(ctx, functionName, alias, -1)
+	}
+}
+
+func (l *Lamux) observeInvoke(ctx context.Context, functionName, alias string, elapsed time.Duration, err error) {
+	if err == nil || invokeErrorType(err) != "not_found" {
+		// Lambda returns not found for arbitrary names, so the function and the alias exist
+		l.labels.add(functionName, alias)
+		if info := getRequestInfo(ctx); info.functionName != "" && info.hostRouted {
+			l.labels.add(info.functionName, info.alias)
+		}
+	}
+	functionName, alias = l.labels.labels(functionName, alias)
+	l.metrics.observeInvoke(functionName, alias, elapsed, err)
+	l.otelMetrics.observeInvoke(ctx, functionName, alias, elapsed, err)
+	l.invokeStats.record(elapsed, err == nil)
+}
+
+// setResponseHeader sets the header to res, replacing any values set by the function.
+func setResponseHeader(res *ridge.Response, key, value string) {
+	deleteResponseHeader(res, key)
+	if res.Headers == nil {
+		res.Headers = make(map[string]string)
+	}
+	res.Headers[key] = value
+}
+
+// decodeResponseBody decodes the base64 encoded body of res before writing any headers,
+// so that a broken body results in 502 instead of a truncated response.
+// Content-Length set by the function is corrected to the length of the decoded body.
+// An empty body (e.g. responses to HEAD requests) keeps Content-Length as is.
+func decodeResponseBody(res *ridge.Response) error {
+	if res.IsBase64Encoded {
+		b, err := base64.StdEncoding.DecodeString(res.Body)
+		if err != nil {
+			return fmt.Errorf("failed to decode base64 encoded response body: %w", err)
+		}
+		res.Body, res.IsBase64Encoded = string(b), false
+	}
+	if res.Body != "" && responseHeader(res, "Content-Length") != "" {
+		setResponseHeader(res, "Content-Length", strconv.Itoa(len(res.Body)))
+	}
+	return nil
+}
+
+func countHeaders(res *ridge.Response) int {
+	n := len(res.Headers)
+	for _, vs := range res.MultiValueHeaders {
+		n += len(vs)
+	}
+	return n
+}
+
+// convertRequest converts the request to the invoke payload in a span.
+func (l *Lamux) convertRequest(ctx context.Context, r *http.Request) (b []byte, err error) {
+	_, span := tracer.Start(ctx, "ConvertRequest")
+	defer func() {
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.SetAttributes(attribute.KeyValue{
+			Key:   attribute.Key("lamux.payload_size"),
+			Value: attribute.IntValue(len(b)),
+		})
+		span.End()
+	}()
+	if l.Config.RawPayloadPassthrough {
+		return readRawPayload(r)
+	}
+	payload, err := ridge.ToRequestV2(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert request: %w", err)
+	}
+	if len(l.Config.ForwardHeaders) > 0 || len(l.Config.DropHeaders) > 0 {
+		l.Config.filterPayloadHeaders(&payload)
+	}
+	var v any = payload
+	if len(l.Config.PromoteQueryParams) > 0 {
+		v = l.Config.promoteQueryParams(payload)
+	}
+	if b, err = json.Marshal(v); err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+	return b, nil
+}
+
+// writeResponse writes the response to the client in a span.
+func (l *Lamux) writeResponse(ctx context.Context, w http.ResponseWriter, res *ridge.Response, chunked bool) (int64, error) {
+	_, span := tracer.Start(ctx, "WriteResponse")
+	defer span.End()
+	size, err := writeResponse(w, res, l.Config.StreamThresholdBytes, chunked)
+	span.SetAttributes(attribute.KeyValue{
+		Key:   attribute.Key("lamux.bytes_written"),
+		Value: attribute.Int64Value(size),
+	})
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return size, err
+}
+
+// functionNotFound logs the routing attempted when the function or the qualifier is not found,
+// and replaces the message to clients with the parsed function name and alias.
+func functionNotFound(ctx context.Context, err error, functionName, alias, qualifier string) error {
+	var herr *HandlerError
+	var enf *types.ResourceNotFoundException
+	if !errors.As(err, &herr) || !errors.As(err, &enf) {
+		return err
+	}
+	slog.WarnContext(ctx, "function not found", "qualifier", qualifier, "error", err)
+	herr.message = fmt.Sprintf("function %s with alias %s is not found", functionName, alias)
+	return err
+}
+
+// invokeError converts the error of Invoke API to HandlerError, and records it to the span and metrics.
+func (l *Lamux) invokeError(ctx context.Context, span oteltrace.Span, functionName, alias string, elapsed time.Duration, err error) error {
+	start := time.Now().Add(-elapsed)
+	if ctx.Err() != nil {
+		switch {
+		case errors.Is(ctx.Err(), context.Canceled):
+			err = newHandlerError(ctx.Err(), http.StatusGatewayTimeout)
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			err = newHandlerError(l.timeoutError(ctx, span, functionName, alias, start, ctx.Err()), http.StatusGatewayTimeout)
+		default:
+		}
+		span.SetStatus(codes.Error, err.Error())
+		l.observeInvoke(ctx, functionName, alias, elapsed, err)
+		return fmt.Errorf("upstream timeout: %w", err)
+	}
+	var enf *types.ResourceNotFoundException
+	var tmr *types.TooManyRequestsException
+	var rtl *types.RequestTooLargeException
+	if errors.As(err, &enf) {
+		err = newHandlerError(err, http.StatusNotFound)
+	} else if errors.As(err, &tmr) {
+		herr := newHandlerError(err, http.StatusTooManyRequests)
+		if tmr.RetryAfterSeconds != nil {
+			herr.Header().Set("Retry-After", *tmr.RetryAfterSeconds)
+		}
+		err = herr
+	} else if errors.As(err, &rtl) {
+		err = newHandlerError(err, http.StatusRequestEntityTooLarge)
+	} else if isTimeoutError(err) {
+		// the request to the Lambda API is severed by LambdaClientTimeout
+		err = newHandlerError(l.timeoutError(ctx, span, functionName, alias, start, err), http.StatusGatewayTimeout)
+	} else {
+		err = newHandlerError(err, http.StatusBadGateway)
+	}
+	span.SetStatus(codes.Error, err.Error())
+	l.observeInvoke(ctx, functionName, alias, elapsed, err)
+	return fmt.Errorf("failed to invoke: %w", err)
+}
+
+// timeoutError classifies the timeout of the invocation by Config.ColdStartIdleTimeout.
+// The reason is recorded to the request info and the span, and cold starts wrap errColdStartTimeout.
+func (l *Lamux) timeoutError(ctx context.Context, span oteltrace.Span, functionName, alias string, start time.Time, err error) error {
+	reason := l.coldStarts.timeoutReason(functionName, alias, start)
+	if reason == "" {
+		return err
+	}
+	getRequestInfo(ctx).timeoutReason = reason
+	span.SetAttributes(attribute.KeyValue{
+		Key:   attribute.Key("lamux.timeout_reason"),
+		Value: attribute.StringValue(reason),
+	})
+	if reason == "cold_start" {
+		return fmt.Errorf("%w: %w", errColdStartTimeout, err)
+	}
+	return err
+}
+
+// isTimeoutError reports whether err is a timeout of the network.
+func isTimeoutError(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
 func (l *Lamux) Invoke(ctx context.Context, functionName, alias string, b []byte) (*lambda.InvokeOutput, error) {
 	ctx, span := tracer.Start(ctx, "Invoke")
 
@@ -228,38 +1118,59 @@ func (l *Lamux) Invoke(ctx context.Context, functionName, alias string, b []byte
 			Value: attribute.StringValue(alias),
 		},
 	)
+	if info := getRequestInfo(ctx); info.qualifier != "" {
+		span.SetAttributes(
+			attribute.KeyValue{
+				Key:   attribute.Key("lambda.alias"),
+				Value: attribute.StringValue(info.alias),
+			},
+			attribute.KeyValue{
+				Key:   attribute.Key("lambda.requested_qualifier"),
+				Value: attribute.StringValue(info.qualifier),
+			},
+		)
+	}
 	defer span.End()
 
-	ctx, cancel := context.WithTimeout(ctx, l.Config.UpstreamTimeout)
+	if l.circuitBreaker == nil {
+		return l.invoke(ctx, span, functionName, alias, b)
+	}
+	if err := l.circuitBreaker.allow(ctx, functionName, alias); err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	resp, err := l.invoke(ctx, span, functionName, alias, b)
+	l.circuitBreaker.done(ctx, functionName, alias, err)
+	return resp, err
+}
+
+func (l *Lamux) invoke(ctx context.Context, span oteltrace.Span, functionName, alias string, b []byte) (*lambda.InvokeOutput, error) {
+	timeout := l.Config.FunctionTimeout(functionName)
+	span.SetAttributes(attribute.KeyValue{
+		Key:   attribute.Key("lamux.upstream_timeout"),
+		Value: attribute.Float64Value(timeout.Seconds()),
+	})
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
+	arn, err := l.functionARN(ctx, functionName)
+	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
 	input := &lambda.InvokeInput{
-		FunctionName: aws.String(functionName),
+		FunctionName: aws.String(arn),
 		Qualifier:    aws.String(alias),
 		Payload:      b,
 	}
-	resp, err := l.lambdaClient.Invoke(ctx, input)
+	slog.DebugContext(ctx, "Invoke", "invoke_function", arn, "invoke_qualifier", alias, "payload_size", len(b), "upstream_timeout", timeout.Seconds())
+	start := time.Now()
+	resp, err := l.lambdaClient.Invoke(ctx, input, l.Config.invokeOptions)
+	elapsed := time.Since(start)
 	if err != nil {
-		if ctx.Err() != nil {
-			switch {
-			case errors.Is(ctx.Err(), context.Canceled):
-				err = newHandlerError(ctx.Err(), http.StatusGatewayTimeout)
-			case errors.Is(ctx.Err(), context.DeadlineExceeded):
-				err = newHandlerError(ctx.Err(), http.StatusGatewayTimeout)
-			default:
-			}
-			span.SetStatus(codes.Error, err.Error())
-			return nil, fmt.Errorf("upstream timeout: %w", err)
-		}
-		var enf *types.ResourceNotFoundException
-		if errors.As(err, &enf) {
-			err = newHandlerError(err, http.StatusNotFound)
-		} else {
-			err = newHandlerError(err, http.StatusBadGateway)
-		}
-		span.SetStatus(codes.Error, err.Error())
-		return nil, fmt.Errorf("failed to invoke: %w", err)
+		return nil, l.invokeError(ctx, span, functionName, alias, elapsed, err)
 	}
+	l.coldStarts.seen(functionName, alias)
 	span.SetAttributes(
 		attribute.KeyValue{
 			Key:   attribute.Key("lambda.executed_version"),
@@ -272,7 +1183,15 @@ func (l *Lamux) Invoke(ctx context.Context, functionName, alias string, b []byte
 	)
 	if resp.FunctionError != nil {
 		span.SetStatus(codes.Error, *resp.FunctionError)
-		return nil, newHandlerError(fmt.Errorf(*resp.FunctionError), http.StatusInternalServerError)
+		err := fmt.Errorf("%w: %s", errFunctionError, *resp.FunctionError)
+		l.observeInvoke(ctx, functionName, alias, elapsed, err)
+		return nil, newHandlerError(err, http.StatusInternalServerError)
+	}
+	if l.Config.TraceLinkResponse {
+		if sc := responseSpanContext(resp.Payload); sc.IsValid() {
+			span.AddLink(oteltrace.Link{SpanContext: sc})
+		}
 	}
+	l.observeInvoke(ctx, functionName, alias, elapsed, nil)
 	return resp, nil
 }
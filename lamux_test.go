@@ -1,11 +1,22 @@
 package lamux_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -20,19 +31,104 @@ type mockClient struct {
 	code          int32
 	functionError *string
 	latency       time.Duration
+	payload       []byte
+	qualifiers    []string
+	err           error
+
+	reservedConcurrency    *int32
+	getConcurrencyErr      error
+	getConcurrencyRequests int
+	getConcurrencyName     string
+	checkContext           bool // fail lookups by canceled contexts
+
+	mu      sync.Mutex
+	input   *lambda.InvokeInput
+	inputs  []*lambda.InvokeInput
+	options lambda.Options // applied to the last invocation
+}
+
+// invoked returns all inputs of the invocations.
+func (m *mockClient) invoked() []*lambda.InvokeInput {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.inputs)
+}
+
+func (m *mockClient) GetFunctionConcurrency(ctx context.Context, input *lambda.GetFunctionConcurrencyInput, optFns ...func(*lambda.Options)) (*lambda.GetFunctionConcurrencyOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.getConcurrencyRequests++
+	m.getConcurrencyName = aws.ToString(input.FunctionName)
+	if m.checkContext && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if m.getConcurrencyErr != nil {
+		return nil, m.getConcurrencyErr
+	}
+	return &lambda.GetFunctionConcurrencyOutput{
+		ReservedConcurrentExecutions: m.reservedConcurrency,
+	}, nil
+}
+
+func (m *mockClient) GetFunction(ctx context.Context, input *lambda.GetFunctionInput, optFns ...func(*lambda.Options)) (*lambda.GetFunctionOutput, error) {
+	if name := aws.ToString(input.FunctionName); name != "test-func" && !strings.HasSuffix(name, ":function:test-func") {
+		return nil, &types.ResourceNotFoundException{
+			Message: aws.String("Function not found"),
+		}
+	}
+	if q := aws.ToString(input.Qualifier); q != "" && q != "1" {
+		return nil, &types.ResourceNotFoundException{
+			Message: aws.String("Function not found"),
+		}
+	}
+	return &lambda.GetFunctionOutput{
+		Configuration: &types.FunctionConfiguration{FunctionName: aws.String("test-func")},
+	}, nil
+}
+
+// ListAliases returns "test" and the qualifiers, one alias per page.
+func (m *mockClient) ListAliases(ctx context.Context, input *lambda.ListAliasesInput, optFns ...func(*lambda.Options)) (*lambda.ListAliasesOutput, error) {
+	aliases := append([]string{"test"}, m.qualifiers...)
+	i := 0
+	if input.Marker != nil {
+		i, _ = strconv.Atoi(*input.Marker)
+	}
+	out := &lambda.ListAliasesOutput{
+		Aliases: []types.AliasConfiguration{{Name: aws.String(aliases[i])}},
+	}
+	if i+1 < len(aliases) {
+		out.NextMarker = aws.String(strconv.Itoa(i + 1))
+	}
+	return out, nil
 }
 
 func (m *mockClient) Invoke(ctx context.Context, input *lambda.InvokeInput, optFns ...func(*lambda.Options)) (*lambda.InvokeOutput, error) {
-	if aws.ToString(input.FunctionName) != "test-func" {
+	m.mu.Lock()
+	m.inputs = append(m.inputs, input)
+	m.options = lambda.Options{}
+	for _, fn := range optFns {
+		fn(&m.options)
+	}
+	if input.InvocationType != types.InvocationTypeEvent {
+		m.input = input
+	}
+	m.mu.Unlock()
+	if name := aws.ToString(input.FunctionName); name != "test-func" && !strings.HasSuffix(name, ":function:test-func") {
 		return nil, &types.ResourceNotFoundException{
 			Message: aws.String("Resource not found"),
 		}
 	}
-	if aws.ToString(input.Qualifier) != "test" {
+	if q := aws.ToString(input.Qualifier); q != "test" && !slices.Contains(m.qualifiers, q) {
 		return nil, &types.ResourceNotFoundException{
 			Message: aws.String("Resource not found"),
 		}
 	}
+	if m.err != nil {
+		return nil, m.err
+	}
+	if input.InvocationType == types.InvocationTypeEvent {
+		return &lambda.InvokeOutput{StatusCode: 202}, nil
+	}
 	timer := time.NewTimer(m.latency)
 	defer timer.Stop()
 	select {
@@ -46,12 +142,16 @@ func (m *mockClient) Invoke(ctx context.Context, input *lambda.InvokeInput, optF
 			Message: "Internal server error",
 		}
 	}
+	payload := m.payload
+	if payload == nil {
+		payload = []byte(fmt.Sprintf(`{"statusCode":%d}`, m.code))
+	}
 	return &lambda.InvokeOutput{
 		StatusCode:      m.code,
 		FunctionError:   m.functionError,
 		ExecutedVersion: aws.String("1"),
 		LogResult:       aws.String("dummy"),
-		Payload:         []byte(fmt.Sprintf(`{"statusCode":%d}`, m.code)),
+		Payload:         payload,
 	}, nil
 }
 
@@ -171,3 +271,605 @@ func TestProxy(t *testing.T) {
 		t.Errorf("expect %d, got %d", e, a)
 	}
 }
+
+func TestProxyTooManyResponseHeaders(t *testing.T) {
+	headers := map[string]string{}
+	for i := 0; i < 20; i++ {
+		headers[fmt.Sprintf("X-Header-%d", i)] = "v"
+	}
+	payload, _ := json.Marshal(map[string]any{
+		"statusCode": 200,
+		"headers":    headers,
+	})
+	for _, limit := range []int{0, 20, 19} {
+		t.Run(fmt.Sprintf("limit=%d", limit), func(t *testing.T) {
+			r, _ := http.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Forwarded-Host", "test.example.net")
+			app, _ := lamux.NewLamux(&lamux.Config{
+				FunctionName:           "test-func",
+				DomainSuffix:           "example.net",
+				UpstreamTimeout:        time.Second,
+				MaxResponseHeaderCount: limit,
+			})
+			app.SetTestClient(&mockClient{
+				code:    200,
+				payload: payload,
+			})
+			w := httptest.NewRecorder()
+			err := app.HandleProxy(context.Background(), w, r)
+			if limit == 0 || limit >= len(headers) {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var herr *lamux.HandlerError
+			if !errors.As(err, &herr) {
+				t.Fatalf("expected HandlerError, got %v", err)
+			}
+			if e, a := http.StatusBadGateway, herr.Code(); e != a {
+				t.Errorf("expect %d, got %d", e, a)
+			}
+		})
+	}
+}
+
+// pngBytes is the header of a PNG image including NUL and non-UTF-8 bytes.
+var pngBytes = []byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0x00, 0x00, 0x0d, 'I', 'H', 'D', 'R', 0xff, 0xfe, 0x80}
+
+func TestProxyBinaryResponse(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(pngBytes)
+	cases := []struct {
+		name              string
+		headers           map[string]string
+		body              string
+		expectCode        int
+		expectContentType string
+	}{
+		{
+			name:              "png",
+			headers:           map[string]string{"Content-Type": "image/png"},
+			body:              encoded,
+			expectCode:        http.StatusOK,
+			expectContentType: "image/png",
+		},
+		{
+			name:              "wrong content-length",
+			headers:           map[string]string{"Content-Type": "image/png", "Content-Length": strconv.Itoa(len(encoded))},
+			body:              encoded,
+			expectCode:        http.StatusOK,
+			expectContentType: "image/png",
+		},
+		{
+			name:              "sniffed content type",
+			body:              encoded,
+			expectCode:        http.StatusOK,
+			expectContentType: "image/png",
+		},
+		{
+			name:       "invalid base64",
+			headers:    map[string]string{"Content-Type": "image/png"},
+			body:       "not base64!",
+			expectCode: http.StatusBadGateway,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			payload, _ := json.Marshal(map[string]any{
+				"statusCode":      200,
+				"headers":         tc.headers,
+				"body":            tc.body,
+				"isBase64Encoded": true,
+			})
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:    "test-func",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.SetTestClient(&mockClient{code: 200, payload: payload})
+			ts := httptest.NewServer(app.Handler())
+			defer ts.Close()
+
+			req, _ := http.NewRequest("GET", ts.URL, nil)
+			req.Host = "test.example.net"
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			b, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatalf("failed to read body: %v", err)
+			}
+			if resp.StatusCode != tc.expectCode {
+				t.Fatalf("expect %d, got %d", tc.expectCode, resp.StatusCode)
+			}
+			if tc.expectCode != http.StatusOK {
+				return
+			}
+			if !bytes.Equal(b, pngBytes) {
+				t.Errorf("body is corrupted: %x", b)
+			}
+			if e, a := tc.expectContentType, resp.Header.Get("Content-Type"); e != a {
+				t.Errorf("expect content-type %s, got %s", e, a)
+			}
+			if resp.ContentLength != -1 && resp.ContentLength != int64(len(pngBytes)) {
+				t.Errorf("expect content-length %d, got %d", len(pngBytes), resp.ContentLength)
+			}
+		})
+	}
+}
+
+func TestProxyQualifier(t *testing.T) {
+	cases := []struct {
+		name         string
+		cfg          *lamux.Config
+		header       string
+		expectQ      string
+		expectStatus int
+	}{
+		{
+			name:         "alias from host",
+			cfg:          &lamux.Config{},
+			expectQ:      "test",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "header is ignored by default",
+			cfg:          &lamux.Config{},
+			header:       "3",
+			expectQ:      "test",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "header overrides alias",
+			cfg:          &lamux.Config{AllowQualifierHeader: true},
+			header:       "3",
+			expectQ:      "3",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "header overrides config",
+			cfg:          &lamux.Config{AllowQualifierHeader: true, Qualifier: "2"},
+			header:       "3",
+			expectQ:      "3",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "config overrides alias",
+			cfg:          &lamux.Config{Qualifier: "2"},
+			expectQ:      "2",
+			expectStatus: http.StatusOK,
+		},
+		{
+			name:         "invalid header",
+			cfg:          &lamux.Config{AllowQualifierHeader: true},
+			header:       "$LATEST",
+			expectStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := tc.cfg
+			cfg.FunctionName = "test-func"
+			cfg.DomainSuffix = "example.net"
+			cfg.UpstreamTimeout = time.Second
+			app, err := lamux.NewLamux(cfg)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			client := &mockClient{code: 200, qualifiers: []string{"2", "3"}}
+			app.SetTestClient(client)
+			r, _ := http.NewRequest("GET", "/", nil)
+			r.Header.Set("X-Forwarded-Host", "test.example.net")
+			if tc.header != "" {
+				r.Header.Set("X-Lamux-Qualifier", tc.header)
+			}
+			w := httptest.NewRecorder()
+			err = app.HandleProxy(context.Background(), w, r)
+			if tc.expectStatus != http.StatusOK {
+				var herr *lamux.HandlerError
+				if !errors.As(err, &herr) || herr.Code() != tc.expectStatus {
+					t.Fatalf("expect status %d, got %v", tc.expectStatus, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if e, a := tc.expectQ, aws.ToString(client.input.Qualifier); e != a {
+				t.Errorf("expect qualifier %q, got %q", e, a)
+			}
+		})
+	}
+}
+
+func TestRateLimitSource(t *testing.T) {
+	cases := []struct {
+		name         string
+		client       *mockClient
+		expectCode   int
+		expectSource string
+	}{
+		{
+			name: "function returns 429",
+			client: &mockClient{
+				code: 429,
+			},
+			expectCode:   429,
+			expectSource: "function",
+		},
+		{
+			name: "lambda throttling",
+			client: &mockClient{
+				err: &types.TooManyRequestsException{
+					Message:           aws.String("Rate exceeded"),
+					RetryAfterSeconds: aws.String("1"),
+				},
+			},
+			expectCode:   429,
+			expectSource: "proxy",
+		},
+		{
+			name: "function returns 200",
+			client: &mockClient{
+				code: 200,
+			},
+			expectCode:   200,
+			expectSource: "",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, _ := lamux.NewLamux(&lamux.Config{
+				FunctionName:          "test-func",
+				DomainSuffix:          "example.net",
+				UpstreamTimeout:       time.Second,
+				RateLimitSourceHeader: true,
+			})
+			app.SetTestClient(tc.client)
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+			if e, a := tc.expectCode, w.Code; e != a {
+				t.Errorf("expect %d, got %d", e, a)
+			}
+			if e, a := tc.expectSource, w.Header().Get("X-Lamux-RateLimit-Source"); e != a {
+				t.Errorf("expect source %q, got %q", e, a)
+			}
+		})
+	}
+}
+
+func TestAliasMap(t *testing.T) {
+	cases := []struct {
+		name            string
+		host            string
+		strict          bool
+		expectCode      int
+		expectQualifier string
+	}{
+		{name: "mapped", host: "blue.example.net", expectCode: 200, expectQualifier: "test"},
+		{name: "mapped to another alias", host: "green.example.net", expectCode: 200, expectQualifier: "next"},
+		{name: "pass through", host: "test.example.net", expectCode: 200, expectQualifier: "test"},
+		{name: "strict mapped", host: "blue.example.net", strict: true, expectCode: 200, expectQualifier: "test"},
+		{name: "strict unmapped", host: "test.example.net", strict: true, expectCode: 404},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:    "test-func",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+				AliasMap:        map[string]string{"blue": "test", "green": "next"},
+				StrictAlias:     tc.strict,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200, qualifiers: []string{"next"}}
+			app.SetTestClient(client)
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://"+tc.host+"/", nil))
+			if e, a := tc.expectCode, w.Code; e != a {
+				t.Errorf("expect %d, got %d", e, a)
+			}
+			if tc.expectQualifier == "" {
+				if client.input != nil {
+					t.Error("lambda must not be invoked")
+				}
+				return
+			}
+			if e, a := tc.expectQualifier, aws.ToString(client.input.Qualifier); e != a {
+				t.Errorf("expect qualifier %q, got %q", e, a)
+			}
+		})
+	}
+}
+
+func TestAliasMapValidation(t *testing.T) {
+	for _, m := range []map[string]string{
+		{"blue": "invalid-alias"},
+		{"invalid-key": "test"},
+		{"Blue": "test"},
+	} {
+		cfg := &lamux.Config{
+			FunctionName:    "test-func",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: time.Second,
+			AliasMap:        m,
+		}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected validation error for %v", m)
+		}
+	}
+}
+
+func TestFunctionTimeouts(t *testing.T) {
+	sr := recordSpans()
+	cases := []struct {
+		name          string
+		timeouts      map[string]time.Duration
+		expectCode    int
+		expectTimeout float64
+	}{
+		{
+			name:          "per-function timeout",
+			timeouts:      map[string]time.Duration{"test-func": 100 * time.Millisecond},
+			expectCode:    http.StatusGatewayTimeout,
+			expectTimeout: 0.1,
+		},
+		{
+			name:          "fallback to upstream timeout",
+			timeouts:      map[string]time.Duration{"other-func": 100 * time.Millisecond},
+			expectCode:    http.StatusOK,
+			expectTimeout: 1,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:     "test-func",
+				DomainSuffix:     "example.net",
+				UpstreamTimeout:  time.Second,
+				FunctionTimeouts: tc.timeouts,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.SetTestClient(&mockClient{code: 200, latency: 300 * time.Millisecond})
+			n := len(sr.Ended())
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+			if e, a := tc.expectCode, w.Code; e != a {
+				t.Errorf("expect %d, got %d", e, a)
+			}
+			spans := endedSpansSince(sr, n, "Invoke")
+			if len(spans) != 1 {
+				t.Fatalf("expect 1 Invoke span, got %d", len(spans))
+			}
+			var found bool
+			for _, attr := range spans[0].Attributes() {
+				if attr.Key == "lamux.upstream_timeout" {
+					found = true
+					if e, a := tc.expectTimeout, attr.Value.AsFloat64(); e != a {
+						t.Errorf("expect timeout attribute %v, got %v", e, a)
+					}
+				}
+			}
+			if !found {
+				t.Error("lamux.upstream_timeout attribute not found")
+			}
+		})
+	}
+}
+
+func TestFunctionTimeoutsValidation(t *testing.T) {
+	for _, m := range []map[string]time.Duration{
+		{"test-func": 0},
+		{"test-func": -time.Second},
+		{"invalid func": time.Second},
+	} {
+		cfg := &lamux.Config{
+			FunctionName:     "*",
+			DomainSuffix:     "example.net",
+			UpstreamTimeout:  time.Second,
+			FunctionTimeouts: m,
+		}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected validation error for %v", m)
+		}
+	}
+}
+
+func TestLambdaClientTimeout(t *testing.T) {
+	done := make(chan struct{})
+	defer close(done)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a hung connection
+		select {
+		case <-r.Context().Done():
+		case <-done:
+		}
+	}))
+	defer ts.Close()
+
+	cfg := &lamux.Config{
+		FunctionName:        "test-func",
+		DomainSuffix:        "example.net",
+		UpstreamTimeout:     time.Minute,
+		LambdaClientTimeout: 100 * time.Millisecond,
+	}
+	var o lambda.Options
+	cfg.LambdaOptions(&o)
+	if o.HTTPClient == nil {
+		t.Fatal("expect HTTP client with timeout")
+	}
+
+	// the context deadline is longer than the client timeout
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.UpstreamTimeout)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "POST", ts.URL, nil)
+	start := time.Now()
+	_, err := o.HTTPClient.Do(req)
+	if err == nil {
+		t.Fatal("expect timeout error")
+	}
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Errorf("expect timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("client timeout did not trigger: %s", elapsed)
+	}
+	if ctx.Err() != nil {
+		t.Errorf("context must not be expired: %v", ctx.Err())
+	}
+
+	// without the client timeout, the HTTP client of the AWS config is used
+	cfg.LambdaClientTimeout = 0
+	o = lambda.Options{}
+	cfg.LambdaOptions(&o)
+	if o.HTTPClient != nil {
+		t.Error("expect no HTTP client")
+	}
+}
+
+func TestLambdaClientTimeoutResponse(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:        "test-func",
+		DomainSuffix:        "example.net",
+		UpstreamTimeout:     time.Minute,
+		LambdaClientTimeout: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{
+		code: 200,
+		err:  &url.Error{Op: "Post", URL: "https://lambda.ap-northeast-1.amazonaws.com/", Err: os.ErrDeadlineExceeded},
+	})
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+	if e, a := http.StatusGatewayTimeout, w.Code; e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+}
+
+func TestLambdaClientTimeoutNoRetry(t *testing.T) {
+	for _, timeout := range []time.Duration{0, 100 * time.Millisecond} {
+		app, err := lamux.NewLamux(&lamux.Config{
+			FunctionName:        "test-func",
+			DomainSuffix:        "example.net",
+			UpstreamTimeout:     time.Minute,
+			LambdaClientTimeout: timeout,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &mockClient{code: 200}
+		app.SetTestClient(client)
+		app.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://test.example.net/", nil))
+		expect := 0 // the default of the AWS config
+		if timeout > 0 {
+			expect = 1 // invocations severed by the client timeout must not be retried
+		}
+		if e, a := expect, client.options.RetryMaxAttempts; e != a {
+			t.Errorf("timeout %s: expect retry max attempts %d, got %d", timeout, e, a)
+		}
+	}
+}
+
+func TestLambdaClientTimeoutValidation(t *testing.T) {
+	cfg := &lamux.Config{
+		FunctionName:        "test-func",
+		DomainSuffix:        "example.net",
+		UpstreamTimeout:     time.Second,
+		LambdaClientTimeout: -time.Second,
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected validation error")
+	}
+}
+
+func TestMaxPayloadSize(t *testing.T) {
+	for _, size := range []int{100, 2048} {
+		t.Run(fmt.Sprintf("body=%d", size), func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:    "test-func",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+				MaxPayloadSize:  1024,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200}
+			app.SetTestClient(client)
+			r, _ := http.NewRequest("POST", "/", strings.NewReader(strings.Repeat("a", size)))
+			r.Header.Set("X-Forwarded-Host", "test.example.net")
+			err = app.HandleProxy(context.Background(), httptest.NewRecorder(), r)
+			if size < 1024 {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var herr *lamux.HandlerError
+			if !errors.As(err, &herr) {
+				t.Fatalf("expected HandlerError, got %v", err)
+			}
+			if e, a := http.StatusRequestEntityTooLarge, herr.Code(); e != a {
+				t.Errorf("expect %d, got %d", e, a)
+			}
+			if !strings.Contains(herr.Error(), "exceeds the limit 1024 bytes") {
+				t.Errorf("error must describe the size, got %q", herr.Error())
+			}
+			if client.input != nil {
+				t.Error("lambda must not be invoked")
+			}
+		})
+	}
+}
+
+func TestStatusCodeOverrides(t *testing.T) {
+	cfg, err := lamux.ParseConfig([]string{"--function-name", "test-func", "--domain-suffix", "example.net", "--status-code-overrides", "502=503;404=200"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		code   int32
+		expect int
+	}{
+		{code: 200, expect: http.StatusOK},
+		{code: 404, expect: http.StatusOK},
+		{code: 502, expect: http.StatusServiceUnavailable},
+		{code: 500, expect: http.StatusInternalServerError},
+	} {
+		app, err := lamux.NewLamux(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the function returns the status code in the payload
+		app.SetTestClient(&mockClient{code: 200, payload: []byte(fmt.Sprintf(`{"statusCode":%d}`, tc.code))})
+		w := httptest.NewRecorder()
+		app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+		if e, a := tc.expect, w.Code; e != a {
+			t.Errorf("upstream %d: expect %d, got %d", tc.code, e, a)
+		}
+	}
+
+	for _, overrides := range []map[int]int{{502: 600}, {99: 200}} {
+		cfg := &lamux.Config{
+			FunctionName:        "test-func",
+			DomainSuffix:        "example.net",
+			UpstreamTimeout:     time.Second,
+			StatusCodeOverrides: overrides,
+		}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected error for %v", overrides)
+		}
+	}
+}
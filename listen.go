@@ -0,0 +1,147 @@
+package lamux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	unixSocketPrefix = "unix:"
+
+	// shutdownTimeout is the time to wait for in-flight requests on shutdown
+	shutdownTimeout = 30 * time.Second
+)
+
+// listenAddress is an address to listen on, a TCP address or a Unix domain socket.
+type listenAddress struct {
+	network string
+	address string
+}
+
+func (a listenAddress) String() string {
+	if a.network == "unix" {
+		return unixSocketPrefix + a.address
+	}
+	return a.address
+}
+
+// parseListenAddress parses host:port, :port or unix:/path/to.sock.
+func parseListenAddress(s string) (listenAddress, error) {
+	if path, ok := strings.CutPrefix(s, unixSocketPrefix); ok {
+		if path == "" {
+			return listenAddress{}, fmt.Errorf("invalid listen address %s: empty socket path", s)
+		}
+		return listenAddress{network: "unix", address: path}, nil
+	}
+	_, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return listenAddress{}, fmt.Errorf("invalid listen address %s: %w", s, err)
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		return listenAddress{}, fmt.Errorf("invalid listen address %s: invalid port %s", s, port)
+	}
+	return listenAddress{network: "tcp", address: s}, nil
+}
+
+// listenAddresses returns the addresses in Listen, or :Port by default.
+func (cfg *Config) listenAddresses() ([]listenAddress, error) {
+	if len(cfg.Listen) == 0 {
+		return []listenAddress{{network: "tcp", address: fmt.Sprintf(":%d", cfg.Port)}}, nil
+	}
+	addrs := make([]listenAddress, 0, len(cfg.Listen))
+	seen := make(map[listenAddress]bool, len(cfg.Listen))
+	for _, s := range cfg.Listen {
+		a, err := parseListenAddress(s)
+		if err != nil {
+			return nil, err
+		}
+		if seen[a] {
+			return nil, fmt.Errorf("duplicate listen address %s", s)
+		}
+		seen[a] = true
+		addrs = append(addrs, a)
+	}
+	return addrs, nil
+}
+
+// serve serves the handler on all the listen addresses until ctx is done or any listener fails.
+// Listeners decode PROXY protocol headers when ProxyProtocol is set.
+// Unix domain sockets are removed when their listeners are closed on shutdown.
+func (cfg *Config) serve(ctx context.Context, handler http.Handler) error {
+	addrs, err := cfg.listenAddresses()
+	if err != nil {
+		return err
+	}
+	listeners := make([]net.Listener, 0, len(addrs))
+	for _, a := range addrs {
+		ln, err := net.Listen(a.network, a.address)
+		if err != nil {
+			for _, ln := range listeners {
+				ln.Close()
+			}
+			return fmt.Errorf("failed to listen on %s: %w", a, err)
+		}
+		if cfg.ProxyProtocol {
+			ln = &proxyProtocolListener{Listener: ln, strict: cfg.ProxyProtocolStrict}
+		}
+		listeners = append(listeners, ln)
+	}
+
+	srv := cfg.newServer(handler)
+	errCh := make(chan error, len(listeners))
+	for i, ln := range listeners {
+		slog.InfoContext(ctx, "listening", "addr", addrs[i].String())
+		go func() {
+			errCh <- srv.Serve(ln)
+		}()
+	}
+	var serveErr error
+	select {
+	case <-ctx.Done():
+	case serveErr = <-errCh:
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("failed to shutdown the server gracefully", "error", err)
+	}
+	if serveErr != nil && !errors.Is(serveErr, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve: %w", serveErr)
+	}
+	return nil
+}
+
+// newServer returns the HTTP server with the server timeouts and MaxHeaderBytes.
+// MaxRequestDuration and RequestReadTimeout only shorten ServerWriteTimeout and ServerReadTimeout for each request.
+func (cfg *Config) newServer(handler http.Handler) *http.Server {
+	return &http.Server{
+		Handler:           handler,
+		ReadTimeout:       cfg.ServerReadTimeout,
+		ReadHeaderTimeout: cfg.ServerReadHeaderTimeout,
+		WriteTimeout:      cfg.ServerWriteTimeout,
+		IdleTimeout:       cfg.ServerIdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+}
+
+// validateServerTimeouts validates the server timeouts.
+func (cfg *Config) validateServerTimeouts() error {
+	for name, d := range map[string]time.Duration{
+		"server read timeout":        cfg.ServerReadTimeout,
+		"server read header timeout": cfg.ServerReadHeaderTimeout,
+		"server write timeout":       cfg.ServerWriteTimeout,
+		"server idle timeout":        cfg.ServerIdleTimeout,
+	} {
+		if d < 0 {
+			return fmt.Errorf("%s must not be negative", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package lamux_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func TestListenValidation(t *testing.T) {
+	for name, listen := range map[string][]string{
+		"no port":           {"127.0.0.1"},
+		"invalid port":      {":http-alt"},
+		"port out of range": {":65536"},
+		"empty socket":      {"unix:"},
+		"duplicate":         {":8080", "unix:/tmp/lamux.sock", ":8080"},
+	} {
+		cfg := &lamux.Config{
+			FunctionName:    "test-func",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: time.Second,
+			Listen:          listen,
+		}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	cfg := &lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		Listen:          []string{":8080", "127.0.0.1:8081", "[::1]:8082", "unix:/tmp/lamux.sock"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestListenUnixSockets(t *testing.T) {
+	dir := t.TempDir()
+	sockets := []string{filepath.Join(dir, "a.sock"), filepath.Join(dir, "b.sock")}
+	cfg := &lamux.Config{Listen: []string{"unix:" + sockets[0], "unix:" + sockets[1]}}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- cfg.Serve(ctx, handler)
+	}()
+
+	for _, sock := range sockets {
+		client := &http.Client{Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", sock)
+			},
+		}}
+		var resp *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			if resp, err = client.Get("http://test.example.net/"); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatalf("%s: %s", sock, err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if e, a := "ok", string(b); e != a {
+			t.Errorf("%s: expect body %s, got %s", sock, e, a)
+		}
+		client.CloseIdleConnections()
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not shutdown")
+	}
+	for _, sock := range sockets {
+		if _, err := os.Stat(sock); !os.IsNotExist(err) {
+			t.Errorf("%s must be removed on shutdown: %v", sock, err)
+		}
+	}
+}
+
+func TestListenFailure(t *testing.T) {
+	cfg := &lamux.Config{Listen: []string{"unix:" + filepath.Join(t.TempDir(), "no-such-dir", "lamux.sock")}}
+	if err := cfg.Serve(context.Background(), http.NotFoundHandler()); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestServerTimeouts(t *testing.T) {
+	cfg, err := lamux.ParseConfig([]string{"--function-name", "test-func", "--domain-suffix", "example.net"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := cfg.NewServer(http.NotFoundHandler())
+	if srv.ReadTimeout != 0 || srv.ReadHeaderTimeout != 10*time.Second || srv.WriteTimeout != 0 || srv.IdleTimeout != 120*time.Second {
+		t.Errorf("unexpected default timeouts: read %s, read header %s, write %s, idle %s",
+			srv.ReadTimeout, srv.ReadHeaderTimeout, srv.WriteTimeout, srv.IdleTimeout)
+	}
+
+	cfg, err = lamux.ParseConfig([]string{
+		"--function-name", "test-func", "--domain-suffix", "example.net",
+		"--server-read-timeout", "1m", "--server-read-header-timeout", "5s",
+		"--server-write-timeout", "2m", "--server-idle-timeout", "30s",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv = cfg.NewServer(http.NotFoundHandler())
+	if srv.ReadTimeout != time.Minute || srv.ReadHeaderTimeout != 5*time.Second || srv.WriteTimeout != 2*time.Minute || srv.IdleTimeout != 30*time.Second {
+		t.Errorf("unexpected timeouts: read %s, read header %s, write %s, idle %s",
+			srv.ReadTimeout, srv.ReadHeaderTimeout, srv.WriteTimeout, srv.IdleTimeout)
+	}
+	if e, a := 1048576, srv.MaxHeaderBytes; e != a {
+		t.Errorf("expect max header bytes %d, got %d", e, a)
+	}
+}
+
+func TestServerReadHeaderTimeout(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "lamux.sock")
+	cfg := &lamux.Config{
+		Listen:                  []string{"unix:" + sock},
+		ServerReadHeaderTimeout: 100 * time.Millisecond,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cfg.Serve(ctx, http.NotFoundHandler())
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("unix", sock); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// headers are never completed
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test.example.net\r\n")
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	io.ReadAll(conn)
+	if elapsed := time.Since(start); elapsed >= 5*time.Second {
+		t.Errorf("the connection must be closed by the read header timeout, elapsed %s", elapsed)
+	}
+}
+
+func TestServerTimeoutsValidation(t *testing.T) {
+	for name, modify := range map[string]func(*lamux.Config){
+		"negative read":        func(cfg *lamux.Config) { cfg.ServerReadTimeout = -1 },
+		"negative read header": func(cfg *lamux.Config) { cfg.ServerReadHeaderTimeout = -1 },
+		"negative write":       func(cfg *lamux.Config) { cfg.ServerWriteTimeout = -1 },
+		"negative idle":        func(cfg *lamux.Config) { cfg.ServerIdleTimeout = -1 },
+	} {
+		cfg := &lamux.Config{
+			FunctionName:    "test-func",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: time.Second,
+		}
+		modify(cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	// shorter write timeouts are warned, not rejected
+	cfg := &lamux.Config{
+		FunctionName:       "test-func",
+		DomainSuffix:       "example.net",
+		UpstreamTimeout:    time.Second,
+		FunctionTimeouts:   map[string]time.Duration{"test-func": time.Minute},
+		ServerWriteTimeout: 30 * time.Second,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestMaxHeaderBytes(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "lamux.sock")
+	cfg := &lamux.Config{
+		Listen:         []string{"unix:" + sock},
+		MaxHeaderBytes: 1024,
+	}
+	var called atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called.Add(1)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go cfg.Serve(ctx, handler)
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		},
+	}}
+	get := func(size int) int {
+		req, _ := http.NewRequest("GET", "http://test.example.net/", nil)
+		req.Header.Set("X-Large", strings.Repeat("x", size))
+		var resp *http.Response
+		var err error
+		for i := 0; i < 50; i++ {
+			if resp, err = client.Do(req); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if e, a := http.StatusOK, get(100); e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+	// the server allows 4096 bytes more than MaxHeaderBytes
+	if e, a := http.StatusRequestHeaderFieldsTooLarge, get(16*1024); e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+	if n := called.Load(); n != 1 {
+		t.Errorf("the handler must not be called for too large headers, called %d times", n)
+	}
+
+	cfg = &lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		MaxHeaderBytes:  -1,
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for negative max header bytes")
+	}
+}
@@ -0,0 +1,255 @@
+package lamux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	slogcontext "github.com/PumpkinSeed/slog-context"
+)
+
+var (
+	stdout io.Writer = os.Stdout
+	stderr io.Writer = os.Stderr
+)
+
+// setupLogger sets the default logger writing to the destinations, and returns the function to close them.
+func setupLogger(cfg *Config) (func() error, error) {
+	h, closer, err := newLogHandler(cfg)
+	if err != nil {
+		return nil, err
+	}
+	slog.SetDefault(slog.New(slogcontext.NewHandler(h)))
+	return closer, nil
+}
+
+// newLogHandler returns a handler writing to all of the destinations in the format at the level.
+// Only the fields are written when fields are specified.
+func newLogHandler(cfg *Config) (slog.Handler, func() error, error) {
+	level, err := parseLogLevel(cfg.LogLevel)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	h, closer, err := newDestinationsHandler(cfg.LogDestinations, cfg.LogFormat, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(cfg.LogFields) > 0 {
+		h = &fieldFilterHandler{handler: h, fields: cfg.LogFields}
+	}
+	return h, closer, nil
+}
+
+// parseLogLevel parses the log level (debug, info, warn or error). Empty means info.
+func parseLogLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	default:
+		return 0, fmt.Errorf("invalid log level %s (debug, info, warn or error allowed)", s)
+	}
+}
+
+func newFormatHandler(w io.Writer, format string, opts *slog.HandlerOptions) slog.Handler {
+	if format == "combined" {
+		return &combinedHandler{w: w, text: slog.NewTextHandler(w, opts)}
+	}
+	return slog.NewJSONHandler(w, opts)
+}
+
+func newDestinationsHandler(destinations []string, format string, opts *slog.HandlerOptions) (slog.Handler, func() error, error) {
+	var handlers []slog.Handler
+	var closers []io.Closer
+	closer := func() error {
+		var err error
+		for _, c := range closers {
+			err = errors.Join(err, c.Close())
+		}
+		return err
+	}
+	for _, dest := range destinations {
+		w, err := openLogDestination(dest)
+		if err != nil {
+			closer()
+			return nil, nil, fmt.Errorf("failed to open log destination %s: %w", dest, err)
+		}
+		if c, ok := w.(io.Closer); ok && w != stdout && w != stderr {
+			closers = append(closers, c)
+		}
+		handlers = append(handlers, newFormatHandler(w, format, opts))
+	}
+	switch len(handlers) {
+	case 0:
+		return newFormatHandler(stdout, format, opts), closer, nil
+	case 1:
+		return handlers[0], closer, nil
+	default:
+		return &multiHandler{handlers: handlers}, closer, nil
+	}
+}
+
+// openLogDestination opens the destination.
+// stdout, stderr, syslog (local), syslog://host:port (UDP), syslog+tcp://host:port or a file path are supported.
+func openLogDestination(dest string) (io.Writer, error) {
+	switch {
+	case dest == "stdout":
+		return stdout, nil
+	case dest == "stderr":
+		return stderr, nil
+	case dest == "syslog":
+		return openSyslog("", "")
+	case strings.HasPrefix(dest, "syslog://"):
+		return openSyslog("udp", strings.TrimPrefix(dest, "syslog://"))
+	case strings.HasPrefix(dest, "syslog+tcp://"):
+		return openSyslog("tcp", strings.TrimPrefix(dest, "syslog+tcp://"))
+	default:
+		return os.OpenFile(dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	}
+}
+
+// multiHandler is a slog.Handler that fans out records to multiple handlers.
+type multiHandler struct {
+	handlers []slog.Handler
+}
+
+func (m *multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m.handlers {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	for _, h := range m.handlers {
+		if h.Enabled(ctx, r.Level) {
+			err = errors.Join(err, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return err
+}
+
+func (m *multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make([]slog.Handler, 0, len(m.handlers))
+	for _, h := range m.handlers {
+		handlers = append(handlers, h.WithAttrs(attrs))
+	}
+	return &multiHandler{handlers: handlers}
+}
+
+func (m *multiHandler) WithGroup(name string) slog.Handler {
+	handlers := make([]slog.Handler, 0, len(m.handlers))
+	for _, h := range m.handlers {
+		handlers = append(handlers, h.WithGroup(name))
+	}
+	return &multiHandler{handlers: handlers}
+}
+
+// fieldFilterHandler is a slog.Handler that drops the attributes not in fields.
+type fieldFilterHandler struct {
+	handler slog.Handler
+	fields  []string
+}
+
+func (f *fieldFilterHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return f.handler.Enabled(ctx, level)
+}
+
+func (f *fieldFilterHandler) Handle(ctx context.Context, r slog.Record) error {
+	nr := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		if slices.Contains(f.fields, a.Key) {
+			nr.AddAttrs(a)
+		}
+		return true
+	})
+	return f.handler.Handle(ctx, nr)
+}
+
+func (f *fieldFilterHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	filtered := make([]slog.Attr, 0, len(attrs))
+	for _, a := range attrs {
+		if slices.Contains(f.fields, a.Key) {
+			filtered = append(filtered, a)
+		}
+	}
+	return &fieldFilterHandler{handler: f.handler.WithAttrs(filtered), fields: f.fields}
+}
+
+func (f *fieldFilterHandler) WithGroup(name string) slog.Handler {
+	return &fieldFilterHandler{handler: f.handler.WithGroup(name), fields: f.fields}
+}
+
+// combinedHandler is a slog.Handler that writes the access logs of wrapHandler ("request" and "response")
+// in the Apache combined log format, and other records in the slog text format.
+type combinedHandler struct {
+	w    io.Writer
+	text slog.Handler
+}
+
+func (c *combinedHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return c.text.Enabled(ctx, level)
+}
+
+func (c *combinedHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Message != "request" && r.Message != "response" {
+		return c.text.Handle(ctx, r)
+	}
+	v := make(map[string]string, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		v[a.Key] = a.Value.String()
+		return true
+	})
+	host := v["client_ip"]
+	if host == "" {
+		host = v["remote"]
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	size := v["response_size"]
+	if size == "0" {
+		size = ""
+	}
+	_, err := fmt.Fprintf(c.w, "%s - - [%s] %s %s %s %s %s\n",
+		orDash(host),
+		r.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(strings.Join([]string{v["method"], v["url"], v["proto"]}, " ")),
+		orDash(v["status"]),
+		orDash(size),
+		strconv.Quote(orDash(v["referer"])),
+		strconv.Quote(orDash(v["ua"])),
+	)
+	return err
+}
+
+func (c *combinedHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &combinedHandler{w: c.w, text: c.text.WithAttrs(attrs)}
+}
+
+func (c *combinedHandler) WithGroup(name string) slog.Handler {
+	return &combinedHandler{w: c.w, text: c.text.WithGroup(name)}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
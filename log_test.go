@@ -0,0 +1,192 @@
+package lamux_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	slogcontext "github.com/PumpkinSeed/slog-context"
+	"github.com/fujiwara/lamux"
+)
+
+func TestLogDestinations(t *testing.T) {
+	var buf bytes.Buffer
+	defer lamux.SetStdout(&buf)()
+	path := filepath.Join(t.TempDir(), "lamux.log")
+
+	h, closer, err := lamux.NewLogHandler(&lamux.Config{LogDestinations: []string{"stdout", path}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := slog.New(h).With("function_name", "test-func")
+	logger.Info("hello", "status", 200)
+	if err := closer(); err != nil {
+		t.Fatal(err)
+	}
+
+	fileContent, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, b := range map[string][]byte{"stdout": buf.Bytes(), "file": fileContent} {
+		var line map[string]any
+		if err := json.Unmarshal(b, &line); err != nil {
+			t.Fatalf("%s: invalid log line %q: %v", name, b, err)
+		}
+		if e, a := "hello", line["msg"]; e != a {
+			t.Errorf("%s: expect msg %q, got %q", name, e, a)
+		}
+		if e, a := "test-func", line["function_name"]; e != a {
+			t.Errorf("%s: expect function_name %q, got %q", name, e, a)
+		}
+		if e, a := float64(200), line["status"]; e != a {
+			t.Errorf("%s: expect status %v, got %v", name, e, a)
+		}
+	}
+}
+
+func TestLogDestinationsInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notfound", "lamux.log")
+	if _, _, err := lamux.NewLogHandler(&lamux.Config{LogDestinations: []string{"stdout", path}}); err == nil {
+		t.Error("expected error for unwritable destination")
+	}
+}
+
+func serveWithLogHandler(t *testing.T, h slog.Handler, r *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200, payload: []byte(`{"statusCode":200,"body":"hello"}`)})
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slogcontext.NewHandler(h)))
+	defer slog.SetDefault(orig)
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, r)
+	return w
+}
+
+func TestLogFormatCombined(t *testing.T) {
+	var buf bytes.Buffer
+	defer lamux.SetStdout(&buf)()
+	h, _, err := lamux.NewLogHandler(&lamux.Config{LogDestinations: []string{"stdout"}, LogFormat: "combined"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "http://test.example.net/foo?bar=baz", nil)
+	r.RemoteAddr = "192.0.2.1:12345"
+	r.Header.Set("User-Agent", "test-agent")
+	w := serveWithLogHandler(t, h, r)
+
+	var access []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if !strings.Contains(line, "msg=") {
+			access = append(access, line)
+		}
+	}
+	if len(access) != 1 {
+		t.Fatalf("expect 1 access log line, got %q", buf.String())
+	}
+	re := regexp.MustCompile(`^192\.0\.2\.1 - - \[\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4}\] "GET (?:http://test\.example\.net)?/foo\?bar=baz HTTP/1\.1" 200 (\d+) "-" "test-agent"$`)
+	m := re.FindStringSubmatch(access[0])
+	if m == nil {
+		t.Fatalf("unexpected access log line %q", access[0])
+	}
+	if e, a := strconv.Itoa(w.Body.Len()), m[1]; e != a {
+		t.Errorf("expect response size %s, got %s", e, a)
+	}
+}
+
+func TestLogFields(t *testing.T) {
+	var buf bytes.Buffer
+	defer lamux.SetStdout(&buf)()
+	h, _, err := lamux.NewLogHandler(&lamux.Config{LogDestinations: []string{"stdout"}, LogFields: []string{"method", "status", "response_size"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest("GET", "http://test.example.net/", nil)
+	r.Header.Set("Referer", "http://example.com/")
+	w := serveWithLogHandler(t, h, r)
+
+	var found bool
+	for _, b := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var line map[string]any
+		if err := json.Unmarshal(b, &line); err != nil {
+			t.Fatalf("invalid log line %q: %v", b, err)
+		}
+		for k := range line {
+			switch k {
+			case "time", "level", "msg", "method", "status", "response_size":
+			default:
+				t.Errorf("unexpected field %s in %s", k, b)
+			}
+		}
+		if line["msg"] == "response" {
+			found = true
+			if e, a := float64(w.Body.Len()), line["response_size"]; e != a {
+				t.Errorf("expect response_size %v, got %v", e, a)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("access log not found in %s", buf.String())
+	}
+}
+
+func TestLogLevel(t *testing.T) {
+	cases := []struct {
+		level        string
+		expectDebug  bool
+		expectAccess bool
+	}{
+		{level: "debug", expectDebug: true, expectAccess: true},
+		{level: "info", expectAccess: true},
+		{level: "", expectAccess: true},
+		{level: "warn"},
+		{level: "error"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.level, func(t *testing.T) {
+			var buf bytes.Buffer
+			defer lamux.SetStdout(&buf)()
+			h, _, err := lamux.NewLogHandler(&lamux.Config{LogDestinations: []string{"stdout"}, LogLevel: tc.level})
+			if err != nil {
+				t.Fatal(err)
+			}
+			serveWithLogHandler(t, h, httptest.NewRequest("GET", "http://test.example.net/", nil))
+			out := buf.String()
+			if e, a := tc.expectDebug, strings.Contains(out, `"msg":"Invoke"`) && strings.Contains(out, `"payload_size"`); e != a {
+				t.Errorf("expect debug logs %v, got %s", e, out)
+			}
+			if e, a := tc.expectAccess, strings.Contains(out, `"msg":"response"`); e != a {
+				t.Errorf("expect access logs %v, got %s", e, out)
+			}
+		})
+	}
+}
+
+func TestLogLevelInvalid(t *testing.T) {
+	cfg := &lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		LogLevel:        "verbose",
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for invalid log level")
+	}
+}
@@ -0,0 +1,56 @@
+package lamux
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// viaHeader lists the IDs of the Lamux instances which the request has passed through.
+const viaHeader = "X-Lamux-Via"
+
+// selfFunctionName returns the name of the function running Lamux.
+func (cfg *Config) selfFunctionName() string {
+	return cmp.Or(cfg.SelfFunctionName, os.Getenv("AWS_LAMBDA_FUNCTION_NAME"))
+}
+
+// checkLoop returns a HandlerError with 508 when the request invokes the function running Lamux,
+// or has already passed through this Lamux instance.
+func (l *Lamux) checkLoop(r *http.Request, functionName string) error {
+	if l.Config.DisableLoopDetection {
+		return nil
+	}
+	if self := l.Config.selfFunctionName(); self != "" && baseFunctionName(self) == baseFunctionName(functionName) {
+		return newHandlerError(fmt.Errorf("recursive call detected: %s", functionName), http.StatusLoopDetected)
+	}
+	for _, v := range r.Header.Values(viaHeader) {
+		for _, id := range strings.Split(v, ",") {
+			if strings.TrimSpace(id) == l.instanceID {
+				return newHandlerError(fmt.Errorf("loop detected: the request has already passed through this lamux %s", l.instanceID), http.StatusLoopDetected)
+			}
+		}
+	}
+	return nil
+}
+
+// addVia appends the ID of this Lamux instance to the via header of the request.
+func (l *Lamux) addVia(h http.Header) {
+	if l.Config.DisableLoopDetection {
+		return
+	}
+	h.Set(viaHeader, strings.Join(append(h.Values(viaHeader), l.instanceID), ", "))
+}
+
+// baseFunctionName returns the function name of the name, the ARN or the partial ARN without the qualifier.
+func baseFunctionName(name string) string {
+	parts := strings.Split(name, ":")
+	switch {
+	case strings.HasPrefix(name, "arn:") && len(parts) >= 7:
+		return parts[6]
+	case len(parts) >= 3 && parts[1] == "function":
+		return parts[2]
+	}
+	return parts[0]
+}
@@ -0,0 +1,112 @@
+package lamux_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func TestRecursiveCall(t *testing.T) {
+	cases := []struct {
+		name    string
+		self    string
+		env     string
+		disable bool
+		code    int
+	}{
+		{name: "not self", self: "other-func", code: http.StatusOK},
+		{name: "self", self: "test-func", code: http.StatusLoopDetected},
+		{name: "self ARN", self: "arn:aws:lambda:ap-northeast-1:123456789012:function:test-func", code: http.StatusLoopDetected},
+		{name: "self with qualifier", self: "test-func:prod", code: http.StatusLoopDetected},
+		{name: "env", env: "test-func", code: http.StatusLoopDetected},
+		{name: "self overrides env", self: "other-func", env: "test-func", code: http.StatusOK},
+		{name: "disabled", self: "test-func", disable: true, code: http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("AWS_LAMBDA_FUNCTION_NAME", tc.env)
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:         "test-func",
+				DomainSuffix:         "example.net",
+				UpstreamTimeout:      time.Second,
+				SelfFunctionName:     tc.self,
+				DisableLoopDetection: tc.disable,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200}
+			app.SetTestClient(client)
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+			if e, a := tc.code, w.Code; e != a {
+				t.Errorf("expect %d, got %d", e, a)
+			}
+			if tc.code == http.StatusLoopDetected && len(client.invoked()) != 0 {
+				t.Error("the function must not be invoked")
+			}
+		})
+	}
+}
+
+func TestLoopDetection(t *testing.T) {
+	t.Setenv("AWS_LAMBDA_FUNCTION_NAME", "")
+	for _, disable := range []bool{false, true} {
+		app, err := lamux.NewLamux(&lamux.Config{
+			FunctionName:         "test-func",
+			DomainSuffix:         "example.net",
+			UpstreamTimeout:      time.Second,
+			ForwardHeaders:       []string{"Accept"},
+			DisableLoopDetection: disable,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &mockClient{code: 200}
+		app.SetTestClient(client)
+		handler := app.Handler()
+
+		// the request from another lamux
+		r := httptest.NewRequest("GET", "http://test.example.net/", nil)
+		r.Header.Set("X-Lamux-Via", "upstream-lamux")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expect 200, got %d", w.Code)
+		}
+		var fr forwardedRequest
+		if err := json.Unmarshal(client.input.Payload, &fr); err != nil {
+			t.Fatal(err)
+		}
+		via := fr.Headers["x-lamux-via"]
+		if disable {
+			if e, a := "upstream-lamux", via; e != a {
+				t.Errorf("expect via %q, got %q", e, a)
+			}
+			continue
+		}
+		if e, a := "upstream-lamux, "+app.InstanceID(), via; e != a {
+			t.Errorf("expect via %q, got %q", e, a)
+		}
+
+		// the function calls lamux again with the via header
+		r = httptest.NewRequest("GET", "http://test.example.net/", nil)
+		r.Header.Set("X-Lamux-Via", via)
+		w = httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if e, a := http.StatusLoopDetected, w.Code; e != a {
+			t.Errorf("expect %d, got %d", e, a)
+		}
+		if !strings.Contains(w.Body.String(), "loop detected") {
+			t.Errorf("unexpected body: %s", w.Body.String())
+		}
+		if e, a := 1, len(client.invoked()); e != a {
+			t.Errorf("expect %d invocations, got %d", e, a)
+		}
+	}
+}
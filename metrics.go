@@ -0,0 +1,204 @@
+package lamux
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const metricsNamespace = "lamux"
+
+// unknownLabel is the metric label of functions and aliases not validated by invocations.
+const unknownLabel = "unknown"
+
+// maxValidatedLabels is the maximum number of pairs of function names and aliases labeled in metrics.
+const maxValidatedLabels = 1000
+
+// validatedLabels is the set of pairs of function names and aliases validated by invocations.
+// Metrics are labeled by them only, to keep the cardinality bounded against arbitrary host names.
+type validatedLabels struct {
+	mu    sync.RWMutex
+	pairs map[[2]string]struct{}
+}
+
+func newValidatedLabels() *validatedLabels {
+	return &validatedLabels{pairs: make(map[[2]string]struct{})}
+}
+
+func (v *validatedLabels) add(functionName, alias string) {
+	key := [2]string{functionName, alias}
+	v.mu.RLock()
+	_, ok := v.pairs[key]
+	v.mu.RUnlock()
+	if ok {
+		return
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.pairs) < maxValidatedLabels {
+		v.pairs[key] = struct{}{}
+	}
+}
+
+// labels returns the function name and the alias if validated, otherwise unknownLabel.
+func (v *validatedLabels) labels(functionName, alias string) (string, string) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if _, ok := v.pairs[[2]string{functionName, alias}]; ok {
+		return functionName, alias
+	}
+	return unknownLabel, unknownLabel
+}
+
+type metrics struct {
+	registry *prometheus.Registry
+
+	requestsTotal   *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	invokeDuration  *prometheus.HistogramVec
+	invokeErrors    *prometheus.CounterVec
+	warmupInvokes   *prometheus.CounterVec
+	throttled       *prometheus.CounterVec
+	concurrency     *prometheus.GaugeVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "requests_total",
+			Help:      "Total number of HTTP requests.",
+		}, []string{"function_name", "alias", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "request_duration_seconds",
+			Help:      "Duration of HTTP requests.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"function_name", "alias", "code"}),
+		invokeDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "invoke_duration_seconds",
+			Help:      "Duration of Lambda function invocations.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"function_name", "alias"}),
+		invokeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "invoke_errors_total",
+			Help:      "Total number of failed Lambda function invocations.",
+		}, []string{"function_name", "alias", "type"}),
+		warmupInvokes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "warmup_invokes_total",
+			Help:      "Total number of warmup invocations.",
+		}, []string{"function_name", "alias", "result"}),
+		throttled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "throttled_requests_total",
+			Help:      "Total number of requests throttled by rate limits.",
+		}, []string{"function_name", "alias"}),
+		concurrency: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "concurrent_invocations",
+			Help:      "Number of Lambda function invocations in flight.",
+		}, []string{"function_name", "alias"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requestsTotal,
+		m.requestDuration,
+		m.invokeDuration,
+		m.invokeErrors,
+		m.warmupInvokes,
+		m.throttled,
+		m.concurrency,
+	)
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *metrics) observeRequest(functionName, alias string, code int, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	c := strconv.Itoa(code)
+	m.requestsTotal.WithLabelValues(functionName, alias, c).Inc()
+	m.requestDuration.WithLabelValues(functionName, alias, c).Observe(elapsed.Seconds())
+}
+
+func (m *metrics) observeInvoke(functionName, alias string, elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	if err == nil {
+		m.invokeDuration.WithLabelValues(functionName, alias).Observe(elapsed.Seconds())
+		return
+	}
+	errType := invokeErrorType(err)
+	m.invokeErrors.WithLabelValues(functionName, alias, errType).Inc()
+	if errType == "not_found" {
+		return
+	}
+	m.invokeDuration.WithLabelValues(functionName, alias).Observe(elapsed.Seconds())
+}
+
+func (m *metrics) observeWarmup(functionName, alias string, err error) {
+	if m == nil {
+		return
+	}
+	m.warmupInvokes.WithLabelValues(functionName, alias, warmupResult(err)).Inc()
+}
+
+func (m *metrics) observeThrottle(functionName, alias string) {
+	if m == nil {
+		return
+	}
+	m.throttled.WithLabelValues(functionName, alias).Inc()
+}
+
+func (m *metrics) observeConcurrency(functionName, alias string, delta int) {
+	if m == nil {
+		return
+	}
+	m.concurrency.WithLabelValues(functionName, alias).Add(float64(delta))
+}
+
+// warmupResult returns "success" or the error type of the warmup invocation.
+func warmupResult(err error) string {
+	if err == nil {
+		return "success"
+	}
+	return invokeErrorType(err)
+}
+
+// invokeErrorType classifies the error of Lambda function invocations.
+func invokeErrorType(err error) string {
+	var enf *types.ResourceNotFoundException
+	var tmr *types.TooManyRequestsException
+	switch {
+	case errors.As(err, &enf):
+		return "not_found"
+	case errors.As(err, &tmr):
+		return "throttled"
+	case errors.Is(err, errColdStartTimeout):
+		return "cold_start_timeout"
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, context.Canceled), isTimeoutError(err):
+		return "timeout"
+	case errors.Is(err, errFunctionError):
+		return "function_error"
+	default:
+		return "error"
+	}
+}
@@ -0,0 +1,102 @@
+package lamux_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func TestMetrics(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		MetricsEnabled:  true,
+		MetricsPath:     "/metrics",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	app.SetTestClient(&mockClient{code: 200})
+	handler := app.Handler()
+
+	for _, u := range []string{
+		"http://test.example.net/",
+		"http://test.example.net/foo",
+		"http://notfound.example.net/",
+	} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", u, nil))
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/metrics", nil))
+	if e, a := http.StatusOK, w.Code; e != a {
+		t.Fatalf("expect %d, got %d", e, a)
+	}
+	b, _ := io.ReadAll(w.Body)
+	body := string(b)
+	for _, expect := range []string{
+		`lamux_requests_total{alias="test",code="200",function_name="test-func"} 2`,
+		`lamux_requests_total{alias="unknown",code="404",function_name="unknown"} 1`,
+		`lamux_invoke_duration_seconds_count{alias="test",function_name="test-func"} 2`,
+		`lamux_invoke_errors_total{alias="unknown",function_name="unknown",type="not_found"} 1`,
+	} {
+		if !strings.Contains(body, expect) {
+			t.Errorf("metrics must contain %q", expect)
+		}
+	}
+}
+
+func TestMetricsUnknownLabels(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "*",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		MetricsEnabled:  true,
+		MetricsPath:     "/metrics",
+		BasicAuthConfig: lamux.BasicAuthConfig{BasicAuthUser: "admin", BasicAuthPassword: "secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200})
+	handler := app.Handler()
+	get := func(host string, auth bool) {
+		r := httptest.NewRequest("GET", "http://"+host+"/", nil)
+		if auth {
+			r.SetBasicAuth("admin", "secret")
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	// arbitrary host names are not labeled until validated by invocations
+	for _, host := range []string{"a-foo.example.net", "b-bar.example.net", "test-test-func.example.net"} {
+		get(host, false)
+	}
+	get("test-test-func.example.net", true)
+	get("test-test-func.example.net", false)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/metrics", nil))
+	b, _ := io.ReadAll(w.Body)
+	body := string(b)
+	for _, expect := range []string{
+		`lamux_requests_total{alias="unknown",code="401",function_name="unknown"} 3`,
+		`lamux_requests_total{alias="test",code="200",function_name="test-func"} 1`,
+		`lamux_requests_total{alias="test",code="401",function_name="test-func"} 1`,
+	} {
+		if !strings.Contains(body, expect) {
+			t.Errorf("metrics must contain %q", expect)
+		}
+	}
+	for _, unexpected := range []string{`function_name="foo"`, `function_name="bar"`} {
+		if strings.Contains(body, unexpected) {
+			t.Errorf("metrics must not contain %q", unexpected)
+		}
+	}
+}
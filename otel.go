@@ -2,9 +2,11 @@ package lamux
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
 
 	"github.com/mashiike/go-otel-json-exporters/otlptracejson"
@@ -12,10 +14,12 @@ import (
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/log/global"
 	"go.opentelemetry.io/otel/propagation"
 	"go.opentelemetry.io/otel/sdk/resource"
 	"go.opentelemetry.io/otel/sdk/trace"
 	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -23,24 +27,60 @@ var (
 )
 
 type TraceConfig struct {
-	TraceStdout   bool              `help:"Enable stdout exporter for Otel trace" env:"OTEL_EXPORTER_STDOUT" name:"trace-stdout" group:"traceOutput" xor:"traceOutput"`
+	TraceStdout   bool              `help:"Enable stdout exporter for Otel trace" env:"OTEL_EXPORTER_STDOUT,LAMUX_TRACE_STDOUT" name:"trace-stdout" group:"traceOutput" xor:"traceOutput"`
 	TraceEndpoint string            `help:"Otel trace endpoint (e.g. localhost:4318)" env:"OTEL_EXPORTER_OTLP_ENDPOINT" name:"trace-endpoint" group:"traceOutput" xor:"traceOutput"`
 	TraceInsecure bool              `help:"Disable TLS for Otel trace endpoint" env:"OTEL_EXPORTER_OTLP_INSECURE" name:"trace-insecure"`
 	TraceProtocol string            `help:"Otel trace protocol" env:"OTEL_EXPORTER_OTLP_PROTOCOL" name:"trace-protocol" default:"http/protobuf" enum:"http/protobuf,grpc"`
 	TraceHeaders  map[string]string `help:"Additional headers for Otel trace endpoint (key1=value1;key2=value2)" env:"OTEL_EXPORTER_OTLP_HEADERS" name:"trace-headers"`
 	TraceService  string            `help:"Service name for Otel trace" env:"OTEL_SERVICE_NAME" name:"trace-service" default:"lamux"`
-	TraceBatch    bool              `help:"Enable batcher for Otel trace" env:"OTEL_EXPORTER_OTLP_BATCH" name:"trace-batch"`
+	TraceBatch    bool              `help:"Enable batcher for Otel trace" env:"OTEL_EXPORTER_OTLP_BATCH,LAMUX_TRACE_BATCH" name:"trace-batch"`
+
+	TraceAuthToken     string `help:"Bearer token for Otel trace endpoint" env:"LAMUX_TRACE_AUTH_TOKEN" name:"trace-auth-token" xor:"traceAuthToken"`
+	TraceAuthTokenFile string `help:"Path to the file of the bearer token for Otel endpoints, read again when changed" env:"LAMUX_TRACE_AUTH_TOKEN_FILE" name:"trace-auth-token-file" xor:"traceAuthToken"`
+
+	TracePropagators []string `help:"Propagators of Otel trace context (tracecontext, baggage, xray or none)" default:"tracecontext,baggage" env:"OTEL_PROPAGATORS" name:"trace-propagators"`
+
+	TraceLinkResponse bool `help:"Link the trace context returned by the function to the Invoke span" env:"LAMUX_TRACE_LINK_RESPONSE" name:"trace-link-response"`
+
+	TraceSampleRatio        float64 `help:"Ratio of traces to sample (0.0-1.0)" default:"1.0" env:"LAMUX_TRACE_SAMPLE_RATIO" name:"trace-sample-ratio"`
+	TraceSampleIgnoreParent bool    `help:"Sample traces by the ratio regardless of the sampling decision of the parent" env:"LAMUX_TRACE_SAMPLE_IGNORE_PARENT" name:"trace-sample-ignore-parent"`
+
+	OTelLogsEnabled bool `help:"Export access logs as Otel log records to the Otel trace endpoint" env:"LAMUX_OTEL_LOGS_ENABLED" name:"otel-logs-enabled"`
 }
 
 func (tc *TraceConfig) Enabled() bool {
 	return tc.TraceStdout || tc.TraceEndpoint != ""
 }
 
-func setupOtelSDK(ctx context.Context, tc *TraceConfig) (shutdown func(context.Context) error, err error) {
-	if !tc.Enabled() {
+// LogValue returns the config with the auth token redacted.
+func (tc TraceConfig) LogValue() slog.Value {
+	if tc.TraceAuthToken != "" {
+		tc.TraceAuthToken = redactedValue
+	}
+	type traceConfig TraceConfig // without LogValue
+	return slog.AnyValue(traceConfig(tc))
+}
+
+func (tc *TraceConfig) Validate() error {
+	if tc.OTelLogsEnabled && tc.TraceEndpoint == "" {
+		return fmt.Errorf("otel logs require the trace endpoint")
+	}
+	if tc.TraceSampleRatio < 0 || tc.TraceSampleRatio > 1 {
+		return fmt.Errorf("trace sample ratio must be between 0.0 and 1.0: %g", tc.TraceSampleRatio)
+	}
+	for _, name := range tc.TracePropagators {
+		if _, ok := propagators[name]; !ok {
+			return fmt.Errorf("unsupported trace propagator: %s", name)
+		}
+	}
+	return nil
+}
+
+func setupOtelSDK(ctx context.Context, tc *TraceConfig, mc *MetricConfig) (shutdown func(context.Context) error, err error) {
+	if !tc.Enabled() && !mc.Enabled() {
 		return func(context.Context) error { return nil }, nil
 	}
-	slog.InfoContext(ctx, "setting up Otel SDK", "config", tc)
+	slog.InfoContext(ctx, "setting up Otel SDK", "config", tc, "metric_config", mc)
 
 	var shutdownFuncs []func(context.Context) error
 
@@ -62,26 +102,64 @@ func setupOtelSDK(ctx context.Context, tc *TraceConfig) (shutdown func(context.C
 	}
 
 	// Set up propagator.
-	prop := newPropagator()
+	prop := newPropagator(tc)
 	otel.SetTextMapPropagator(prop)
 
 	// Set up trace provider.
-	tracerProvider, err := newTraceProvider(ctx, tc)
-	if err != nil {
-		handleErr(err)
-		return
+	if tc.Enabled() {
+		tracerProvider, err := newTraceProvider(ctx, tc)
+		if err != nil {
+			handleErr(err)
+			return shutdown, err
+		}
+		shutdownFuncs = append(shutdownFuncs, tracerProvider.Shutdown)
+		otel.SetTracerProvider(tracerProvider)
+	}
+
+	// Set up meter provider. Shutdown flushes the metrics not exported yet.
+	if mc.Enabled() {
+		meterProvider, err := newMeterProvider(ctx, tc, mc)
+		if err != nil {
+			handleErr(err)
+			return shutdown, err
+		}
+		shutdownFuncs = append(shutdownFuncs, meterProvider.Shutdown)
+		otel.SetMeterProvider(meterProvider)
+	}
+
+	// Set up logger provider. Shutdown flushes the log records not exported yet.
+	if tc.OTelLogsEnabled {
+		loggerProvider, err := newLoggerProvider(ctx, tc)
+		if err != nil {
+			handleErr(err)
+			return shutdown, err
+		}
+		shutdownFuncs = append(shutdownFuncs, loggerProvider.Shutdown)
+		global.SetLoggerProvider(loggerProvider)
 	}
-	shutdownFuncs = append(shutdownFuncs, tracerProvider.Shutdown)
-	otel.SetTracerProvider(tracerProvider)
 
 	return
 }
 
-func newPropagator() propagation.TextMapPropagator {
-	return propagation.NewCompositeTextMapPropagator(
-		propagation.TraceContext{},
-		propagation.Baggage{},
-	)
+var propagators = map[string]propagation.TextMapPropagator{
+	"tracecontext": propagation.TraceContext{},
+	"baggage":      propagation.Baggage{},
+	"xray":         xrayPropagator{},
+	"none":         propagation.NewCompositeTextMapPropagator(),
+}
+
+func newPropagator(tc *TraceConfig) propagation.TextMapPropagator {
+	names := tc.TracePropagators
+	if len(names) == 0 {
+		names = []string{"tracecontext", "baggage"}
+	}
+	var props []propagation.TextMapPropagator
+	for _, name := range names {
+		if p, ok := propagators[name]; ok {
+			props = append(props, p)
+		}
+	}
+	return propagation.NewCompositeTextMapPropagator(props...)
 }
 
 func newTraceProvider(ctx context.Context, tc *TraceConfig) (*trace.TracerProvider, error) {
@@ -90,21 +168,16 @@ func newTraceProvider(ctx context.Context, tc *TraceConfig) (*trace.TracerProvid
 		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
 	}
 
-	resources, err := resource.New(
-		ctx,
-		resource.WithProcess(),
-		resource.WithTelemetrySDK(),
-		resource.WithAttributes(
-			semconv.ServiceName(tc.TraceService),
-			semconv.ServiceVersion(Version),
-		),
-	)
+	resources, err := newResource(ctx, tc)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create resource: %w", err)
+		return nil, err
 	}
 
+	sampler := newSampler(tc)
+	slog.InfoContext(ctx, "trace sampler", "sampler", sampler.Description())
 	opts := []trace.TracerProviderOption{
 		trace.WithResource(resources),
+		trace.WithSampler(sampler),
 	}
 	if tc.TraceBatch {
 		opts = append(opts, trace.WithBatcher(traceExporter))
@@ -114,11 +187,49 @@ func newTraceProvider(ctx context.Context, tc *TraceConfig) (*trace.TracerProvid
 	return trace.NewTracerProvider(opts...), nil
 }
 
+// newSampler returns the sampler by the ratio. Traces sampled by the parent are always sampled,
+// and not sampled by the parent are never sampled, unless TraceSampleIgnoreParent is set.
+func newSampler(tc *TraceConfig) trace.Sampler {
+	var sampler trace.Sampler
+	if tc.TraceSampleRatio >= 1 {
+		sampler = trace.AlwaysSample()
+	} else {
+		sampler = trace.TraceIDRatioBased(tc.TraceSampleRatio)
+	}
+	if tc.TraceSampleIgnoreParent {
+		return sampler
+	}
+	return trace.ParentBased(sampler)
+}
+
+func newResource(ctx context.Context, tc *TraceConfig) (*resource.Resource, error) {
+	resources, err := resource.New(
+		ctx,
+		resource.WithProcess(),
+		resource.WithTelemetrySDK(),
+		resource.WithAttributes(
+			semconv.ServiceName(tc.TraceService),
+			semconv.ServiceVersion(Version),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create resource: %w", err)
+	}
+	return resources, nil
+}
+
 func newTraceExporter(ctx context.Context, tc *TraceConfig) (trace.SpanExporter, error) {
 	if tc.TraceStdout {
 		return otlptracejson.New(ctx, otlptracejson.WithWriter(os.Stdout))
 	}
+	if tc.TraceAuthTokenFile != "" {
+		return newAuthTokenSpanExporter(ctx, tc)
+	}
+	return newOTLPTraceExporter(ctx, tc, tc.otlpHeaders(tc.TraceAuthToken))
+}
 
+// newOTLPTraceExporter returns the OTLP span exporter with the headers.
+func newOTLPTraceExporter(ctx context.Context, tc *TraceConfig, headers map[string]string) (trace.SpanExporter, error) {
 	var client otlptrace.Client
 	switch tc.TraceProtocol {
 	case "http/protobuf":
@@ -129,8 +240,8 @@ func newTraceExporter(ctx context.Context, tc *TraceConfig) (trace.SpanExporter,
 		if tc.TraceInsecure {
 			opts = append(opts, otlptracehttp.WithInsecure())
 		}
-		if len(tc.TraceHeaders) > 0 {
-			opts = append(opts, otlptracehttp.WithHeaders(tc.TraceHeaders))
+		if len(headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(headers))
 		}
 		client = otlptracehttp.NewClient(opts...)
 	case "grpc":
@@ -140,8 +251,8 @@ func newTraceExporter(ctx context.Context, tc *TraceConfig) (trace.SpanExporter,
 		if tc.TraceInsecure {
 			opts = append(opts, otlptracegrpc.WithInsecure())
 		}
-		if len(tc.TraceHeaders) > 0 {
-			opts = append(opts, otlptracegrpc.WithHeaders(tc.TraceHeaders))
+		if len(headers) > 0 {
+			opts = append(opts, otlptracegrpc.WithHeaders(headers))
 		}
 		client = otlptracegrpc.NewClient(opts...)
 	default:
@@ -150,3 +261,25 @@ func newTraceExporter(ctx context.Context, tc *TraceConfig) (trace.SpanExporter,
 
 	return otlptrace.New(ctx, client)
 }
+
+// responseSpanContext returns the span context of the traceparent header in the function response payload.
+func responseSpanContext(payload []byte) oteltrace.SpanContext {
+	var res struct {
+		Headers           map[string]string   `json:"headers"`
+		MultiValueHeaders map[string][]string `json:"multiValueHeaders"`
+	}
+	if err := json.Unmarshal(payload, &res); err != nil {
+		return oteltrace.SpanContext{}
+	}
+	h := make(http.Header, len(res.Headers)+len(res.MultiValueHeaders))
+	for k, v := range res.Headers {
+		h.Add(k, v)
+	}
+	for k, vs := range res.MultiValueHeaders {
+		for _, v := range vs {
+			h.Add(k, v)
+		}
+	}
+	ctx := propagation.TraceContext{}.Extract(context.Background(), propagation.HeaderCarrier(h))
+	return oteltrace.SpanContextFromContext(ctx)
+}
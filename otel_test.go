@@ -0,0 +1,438 @@
+package lamux_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	slogcontext "github.com/PumpkinSeed/slog-context"
+	"github.com/fujiwara/lamux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+var (
+	spanRecorder     *tracetest.SpanRecorder
+	spanRecorderOnce sync.Once
+)
+
+// recordSpans returns the span recorder set to the global tracer provider.
+// The global tracer provider can be set only once, so the recorder is shared by tests.
+func recordSpans() *tracetest.SpanRecorder {
+	spanRecorderOnce.Do(func() {
+		spanRecorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+	})
+	return spanRecorder
+}
+
+func endedSpansSince(sr *tracetest.SpanRecorder, n int, name string) []sdktrace.ReadOnlySpan {
+	var spans []sdktrace.ReadOnlySpan
+	for _, s := range sr.Ended()[n:] {
+		if s.Name() == name {
+			spans = append(spans, s)
+		}
+	}
+	return spans
+}
+
+func TestTraceLinkResponse(t *testing.T) {
+	sr := recordSpans()
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	const spanID = "00f067aa0ba902b7"
+	payload := []byte(`{"statusCode":200,"headers":{"traceparent":"00-` + traceID + `-` + spanID + `-01"}}`)
+
+	for _, enabled := range []bool{true, false} {
+		app, err := lamux.NewLamux(&lamux.Config{
+			FunctionName:    "test-func",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: time.Second,
+			TraceConfig:     lamux.TraceConfig{TraceLinkResponse: enabled},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.SetTestClient(&mockClient{code: 200, payload: payload})
+		n := len(sr.Ended())
+		w := httptest.NewRecorder()
+		app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+		if w.Code != 200 {
+			t.Fatalf("expect 200, got %d", w.Code)
+		}
+
+		spans := endedSpansSince(sr, n, "Invoke")
+		if len(spans) != 1 {
+			t.Fatalf("expect 1 Invoke span, got %d", len(spans))
+		}
+		links := spans[0].Links()
+		if !enabled {
+			if len(links) != 0 {
+				t.Errorf("expect no links when disabled, got %d", len(links))
+			}
+			continue
+		}
+		if len(links) != 1 {
+			t.Fatalf("expect 1 link, got %d", len(links))
+		}
+		if e, a := traceID, links[0].SpanContext.TraceID().String(); e != a {
+			t.Errorf("expect trace id %s, got %s", e, a)
+		}
+		if e, a := spanID, links[0].SpanContext.SpanID().String(); e != a {
+			t.Errorf("expect span id %s, got %s", e, a)
+		}
+	}
+}
+
+func TestUpstreamDurationAndResponseSize(t *testing.T) {
+	sr := recordSpans()
+	var buf bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slogcontext.NewHandler(slog.NewJSONHandler(&buf, nil))))
+	defer slog.SetDefault(orig)
+
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200, latency: 50 * time.Millisecond, payload: []byte(`{"statusCode":200,"body":"hello world"}`)})
+	n := len(sr.Ended())
+	ctx, span := otel.Tracer("test").Start(context.Background(), "server")
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil).WithContext(ctx))
+	span.End()
+	if w.Code != 200 {
+		t.Fatalf("expect 200, got %d", w.Code)
+	}
+
+	spans := endedSpansSince(sr, n, "server")
+	if len(spans) != 1 {
+		t.Fatalf("expect 1 server span, got %d", len(spans))
+	}
+	attrs := make(map[attribute.Key]attribute.Value)
+	for _, kv := range spans[0].Attributes() {
+		attrs[kv.Key] = kv.Value
+	}
+	if d := attrs["lamux.upstream_duration"].AsFloat64(); d < 0.05 {
+		t.Errorf("expect lamux.upstream_duration >= 0.05, got %v", d)
+	}
+	if e, a := int64(len("hello world")), attrs["http.response.body.size"].AsInt64(); e != a {
+		t.Errorf("expect http.response.body.size %d, got %d", e, a)
+	}
+
+	var found bool
+	for _, b := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var line map[string]any
+		if err := json.Unmarshal(b, &line); err != nil {
+			t.Fatal(err)
+		}
+		if line["msg"] != "handleProxy" {
+			continue
+		}
+		found = true
+		if d, _ := line["upstream_duration"].(float64); d < 0.05 {
+			t.Errorf("expect upstream_duration >= 0.05, got %v", line["upstream_duration"])
+		}
+		if e, a := float64(len("hello world")), line["response_size"]; e != a {
+			t.Errorf("expect response_size %v, got %v", e, a)
+		}
+	}
+	if !found {
+		t.Errorf("handleProxy log not found: %s", buf.String())
+	}
+}
+
+func TestConvertRequestAndWriteResponseSpans(t *testing.T) {
+	sr := recordSpans()
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200, payload: []byte(`{"statusCode":200,"body":"hello world"}`)})
+
+	n := len(sr.Ended())
+	ctx, span := otel.Tracer("test").Start(context.Background(), "server")
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, httptest.NewRequest("POST", "http://test.example.net/", strings.NewReader(`{"foo":"bar"}`)).WithContext(ctx))
+	span.End()
+	if w.Code != 200 {
+		t.Fatalf("expect 200, got %d", w.Code)
+	}
+	for name, check := range map[string]func(attrs map[attribute.Key]attribute.Value){
+		"ConvertRequest": func(attrs map[attribute.Key]attribute.Value) {
+			if a := attrs["lamux.payload_size"].AsInt64(); a <= int64(len(`{"foo":"bar"}`)) {
+				t.Errorf("expect lamux.payload_size larger than the body, got %d", a)
+			}
+		},
+		"WriteResponse": func(attrs map[attribute.Key]attribute.Value) {
+			if e, a := int64(len("hello world")), attrs["lamux.bytes_written"].AsInt64(); e != a {
+				t.Errorf("expect lamux.bytes_written %d, got %d", e, a)
+			}
+		},
+	} {
+		spans := endedSpansSince(sr, n, name)
+		if len(spans) != 1 {
+			t.Fatalf("expect 1 %s span, got %d", name, len(spans))
+		}
+		if e, a := span.SpanContext().TraceID(), spans[0].SpanContext().TraceID(); e != a {
+			t.Errorf("%s: expect trace id %s, got %s", name, e, a)
+		}
+		attrs := make(map[attribute.Key]attribute.Value)
+		for _, kv := range spans[0].Attributes() {
+			attrs[kv.Key] = kv.Value
+		}
+		check(attrs)
+	}
+
+	// the span is ended with the error status on failures
+	n = len(sr.Ended())
+	w = httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, httptest.NewRequest("POST", "http://test.example.net/", iotest.ErrReader(errors.New("connection reset"))))
+	spans := endedSpansSince(sr, n, "ConvertRequest")
+	if len(spans) != 1 {
+		t.Fatalf("expect 1 ConvertRequest span, got %d", len(spans))
+	}
+	if e, a := codes.Error, spans[0].Status().Code; e != a {
+		t.Errorf("expect status %v, got %v", e, a)
+	}
+}
+
+func TestTraceContextPropagation(t *testing.T) {
+	sr := recordSpans()
+	orig := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(lamux.NewPropagator(&lamux.TraceConfig{TracePropagators: []string{"tracecontext", "baggage"}}))
+	defer otel.SetTextMapPropagator(orig)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	for _, tc := range []struct {
+		name    string
+		forward []string
+		parent  bool
+	}{
+		{name: "new trace"},
+		{name: "continued trace", parent: true},
+		{name: "continued trace with forward headers", forward: []string{"X-Custom"}, parent: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:    "test-func",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+				ForwardHeaders:  tc.forward,
+				TraceConfig:     lamux.TraceConfig{TraceStdout: true},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200}
+			app.SetTestClient(client)
+			n := len(sr.Ended())
+			r := httptest.NewRequest("GET", "http://test.example.net/", nil)
+			if tc.parent {
+				r.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+				r.Header.Set("tracestate", "vendor=value")
+				r.Header.Set("baggage", "user=alice")
+			}
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, r)
+			if w.Code != 200 {
+				t.Fatalf("expect 200, got %d", w.Code)
+			}
+
+			spans := endedSpansSince(sr, n, "/")
+			if len(spans) != 1 {
+				t.Fatalf("expect 1 server span, got %d", len(spans))
+			}
+			server := spans[0].SpanContext()
+			var payload struct {
+				Headers map[string]string `json:"headers"`
+			}
+			if err := json.Unmarshal(client.input.Payload, &payload); err != nil {
+				t.Fatal(err)
+			}
+			// the function continues the trace as a child of the lamux span
+			expect := "00-" + server.TraceID().String() + "-" + server.SpanID().String() + "-01"
+			if a := payload.Headers["traceparent"]; expect != a {
+				t.Errorf("expect traceparent %s in the payload, got %q", expect, a)
+			}
+			if !tc.parent {
+				return
+			}
+			if e, a := traceID, server.TraceID().String(); e != a {
+				t.Errorf("expect trace id %s, got %s", e, a)
+			}
+			if e, a := "vendor=value", payload.Headers["tracestate"]; e != a {
+				t.Errorf("expect tracestate %s in the payload, got %q", e, a)
+			}
+			if e, a := "user=alice", payload.Headers["baggage"]; e != a {
+				t.Errorf("expect baggage %s in the payload, got %q", e, a)
+			}
+		})
+	}
+}
+
+func TestSampler(t *testing.T) {
+	sampledParent := oteltrace.NewSpanContext(oteltrace.SpanContextConfig{
+		TraceID:    oteltrace.TraceID{0xff},
+		SpanID:     oteltrace.SpanID{1},
+		TraceFlags: oteltrace.FlagsSampled,
+		Remote:     true,
+	})
+	notSampledParent := sampledParent.WithTraceFlags(0)
+	cases := []struct {
+		name   string
+		tc     lamux.TraceConfig
+		parent oteltrace.SpanContext
+		expect sdktrace.SamplingDecision
+	}{
+		{"always on", lamux.TraceConfig{TraceSampleRatio: 1}, oteltrace.SpanContext{}, sdktrace.RecordAndSample},
+		{"never", lamux.TraceConfig{TraceSampleRatio: 0}, oteltrace.SpanContext{}, sdktrace.Drop},
+		{"sampled parent", lamux.TraceConfig{TraceSampleRatio: 0}, sampledParent, sdktrace.RecordAndSample},
+		{"not sampled parent", lamux.TraceConfig{TraceSampleRatio: 1}, notSampledParent, sdktrace.Drop},
+		{"ignore parent", lamux.TraceConfig{TraceSampleRatio: 0, TraceSampleIgnoreParent: true}, sampledParent, sdktrace.Drop},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			sampler := lamux.NewSampler(&c.tc)
+			res := sampler.ShouldSample(sdktrace.SamplingParameters{
+				ParentContext: oteltrace.ContextWithSpanContext(context.Background(), c.parent),
+				TraceID:       oteltrace.TraceID{0xff},
+				Name:          "test",
+			})
+			if e, a := c.expect, res.Decision; e != a {
+				t.Errorf("expect decision %v, got %v (%s)", e, a, sampler.Description())
+			}
+		})
+	}
+
+	for _, ratio := range []float64{-0.1, 1.1} {
+		if err := (&lamux.TraceConfig{TraceSampleRatio: ratio}).Validate(); err == nil {
+			t.Errorf("ratio %g: expected error", ratio)
+		}
+	}
+}
+
+func TestTraceConfigFlags(t *testing.T) {
+	var exported atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/traces" {
+			exported.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	cases := []struct {
+		name    string
+		args    []string
+		enabled bool
+		stdout  bool
+		batch   bool
+	}{
+		{name: "disabled"},
+		{name: "stdout", args: []string{"--trace-stdout"}, enabled: true, stdout: true},
+		{name: "stdout batch", args: []string{"--trace-stdout", "--trace-batch"}, enabled: true, stdout: true, batch: true},
+		{name: "endpoint", args: []string{"--trace-endpoint", u.Host, "--trace-insecure"}, enabled: true},
+		{name: "endpoint batch", args: []string{"--trace-endpoint", u.Host, "--trace-insecure", "--trace-batch"}, enabled: true, batch: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			cfg, err := lamux.ParseConfig(append([]string{"--function-name", "test-func", "--domain-suffix", "example.net"}, c.args...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc := &cfg.TraceConfig
+			if e, a := c.enabled, tc.Enabled(); e != a {
+				t.Errorf("expect enabled %v, got %v", e, a)
+			}
+			if e, a := c.stdout, tc.TraceStdout; e != a {
+				t.Errorf("expect stdout %v, got %v", e, a)
+			}
+			if e, a := c.batch, tc.TraceBatch; e != a {
+				t.Errorf("expect batch %v, got %v", e, a)
+			}
+			if !c.enabled {
+				return
+			}
+
+			ctx := context.Background()
+			tp, err := lamux.NewTraceProvider(ctx, tc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			n := exported.Load()
+			_, span := tp.Tracer("test").Start(ctx, "test")
+			span.End()
+			// the OTLP exporter sends spans to the endpoint immediately without the batcher
+			if e, a := !c.stdout && !c.batch, exported.Load() > n; e != a {
+				t.Errorf("expect exported to the endpoint on end %v, got %v", e, a)
+			}
+			if err := tp.Shutdown(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if e, a := !c.stdout, exported.Load() > n; e != a {
+				t.Errorf("expect exported to the endpoint on shutdown %v, got %v", e, a)
+			}
+		})
+	}
+
+	if _, err := lamux.ParseConfig([]string{"--function-name", "test-func", "--domain-suffix", "example.net", "--trace-stdout", "--trace-endpoint", u.Host}); err == nil {
+		t.Error("--trace-stdout and --trace-endpoint must be exclusive")
+	}
+}
+
+func TestTraceBatchEnv(t *testing.T) {
+	for _, env := range []string{"OTEL_EXPORTER_OTLP_BATCH", "LAMUX_TRACE_BATCH"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, "true")
+			cfg, err := lamux.ParseConfig([]string{"--function-name", "test-func", "--domain-suffix", "example.net"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cfg.TraceBatch {
+				t.Errorf("%s must enable the batcher", env)
+			}
+		})
+	}
+}
+
+func TestTraceStdoutEnv(t *testing.T) {
+	for _, env := range []string{"OTEL_EXPORTER_STDOUT", "LAMUX_TRACE_STDOUT"} {
+		t.Run(env, func(t *testing.T) {
+			t.Setenv(env, "true")
+			cfg, err := lamux.ParseConfig([]string{"--function-name", "test-func", "--domain-suffix", "example.net"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cfg.TraceStdout || !cfg.TraceConfig.Enabled() {
+				t.Errorf("%s must enable the stdout exporter", env)
+			}
+			if _, err := lamux.ParseConfig([]string{"--function-name", "test-func", "--domain-suffix", "example.net", "--trace-endpoint", "localhost:4318"}); err == nil {
+				t.Errorf("%s and --trace-endpoint must be exclusive", env)
+			}
+		})
+	}
+}
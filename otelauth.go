@@ -0,0 +1,210 @@
+package lamux
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	"go.opentelemetry.io/otel/sdk/trace"
+)
+
+// otlpHeaders returns TraceHeaders with the Authorization header of the bearer token.
+func (tc *TraceConfig) otlpHeaders(token string) map[string]string {
+	if token == "" {
+		return tc.TraceHeaders
+	}
+	headers := maps.Clone(tc.TraceHeaders)
+	if headers == nil {
+		headers = make(map[string]string, 1)
+	}
+	headers["Authorization"] = "Bearer " + token
+	return headers
+}
+
+// readAuthToken returns TraceAuthToken, or the content of TraceAuthTokenFile.
+func (tc *TraceConfig) readAuthToken() (string, error) {
+	if tc.TraceAuthTokenFile == "" {
+		return tc.TraceAuthToken, nil
+	}
+	b, err := os.ReadFile(tc.TraceAuthTokenFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the auth token file: %w", err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// authTokenExporter holds the OTLP exporter of a signal (traces, logs or metrics),
+// and recreates it with the new bearer token when TraceAuthTokenFile is changed.
+type authTokenExporter[E interface{ Shutdown(context.Context) error }] struct {
+	tc     *TraceConfig
+	signal string
+	create func(ctx context.Context, headers map[string]string) (E, error)
+
+	mu       sync.Mutex
+	exporter E
+	created  bool
+	token    string
+	modTime  time.Time
+	size     int64
+}
+
+func newAuthTokenExporter[E interface{ Shutdown(context.Context) error }](ctx context.Context, tc *TraceConfig, signal string, create func(context.Context, map[string]string) (E, error)) (*authTokenExporter[E], error) {
+	e := &authTokenExporter[E]{tc: tc, signal: signal, create: create}
+	if _, err := e.current(ctx); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// current returns the exporter, recreated when the token file is changed.
+// The exporter with the previous token is used when the file cannot be read.
+func (e *authTokenExporter[E]) current(ctx context.Context) (E, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	st, err := os.Stat(e.tc.TraceAuthTokenFile)
+	if err != nil {
+		return e.fallback(ctx, fmt.Errorf("failed to stat the auth token file: %w", err))
+	}
+	if e.created && st.ModTime().Equal(e.modTime) && st.Size() == e.size {
+		return e.exporter, nil
+	}
+	token, err := e.tc.readAuthToken()
+	if err != nil {
+		return e.fallback(ctx, err)
+	}
+	e.modTime, e.size = st.ModTime(), st.Size()
+	if e.created && token == e.token {
+		return e.exporter, nil
+	}
+	exporter, err := e.create(ctx, e.tc.otlpHeaders(token))
+	if err != nil {
+		return e.fallback(ctx, err)
+	}
+	if e.created {
+		slog.InfoContext(ctx, "auth token for Otel endpoint is updated", "signal", e.signal, "file", e.tc.TraceAuthTokenFile)
+		if err := e.exporter.Shutdown(ctx); err != nil {
+			slog.WarnContext(ctx, "failed to shutdown the previous exporter", "signal", e.signal, "error", err)
+		}
+	}
+	e.exporter, e.created, e.token = exporter, true, token
+	return exporter, nil
+}
+
+func (e *authTokenExporter[E]) fallback(ctx context.Context, err error) (E, error) {
+	if !e.created {
+		var zero E
+		return zero, err
+	}
+	slog.WarnContext(ctx, "failed to update the auth token for Otel endpoint", "signal", e.signal, "error", err)
+	return e.exporter, nil
+}
+
+func (e *authTokenExporter[E]) Shutdown(ctx context.Context) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.exporter.Shutdown(ctx)
+}
+
+// authTokenSpanExporter is a span exporter which follows the changes of TraceAuthTokenFile.
+type authTokenSpanExporter struct {
+	*authTokenExporter[trace.SpanExporter]
+}
+
+func newAuthTokenSpanExporter(ctx context.Context, tc *TraceConfig) (*authTokenSpanExporter, error) {
+	e, err := newAuthTokenExporter(ctx, tc, "traces", func(ctx context.Context, headers map[string]string) (trace.SpanExporter, error) {
+		return newOTLPTraceExporter(ctx, tc, headers)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &authTokenSpanExporter{e}, nil
+}
+
+func (e *authTokenSpanExporter) ExportSpans(ctx context.Context, spans []trace.ReadOnlySpan) error {
+	exporter, err := e.current(ctx)
+	if err != nil {
+		return err
+	}
+	return exporter.ExportSpans(ctx, spans)
+}
+
+// authTokenLogExporter is a log exporter which follows the changes of TraceAuthTokenFile.
+type authTokenLogExporter struct {
+	*authTokenExporter[sdklog.Exporter]
+}
+
+func newAuthTokenLogExporter(ctx context.Context, tc *TraceConfig) (*authTokenLogExporter, error) {
+	e, err := newAuthTokenExporter(ctx, tc, "logs", func(ctx context.Context, headers map[string]string) (sdklog.Exporter, error) {
+		return newOTLPLogExporter(ctx, tc, headers)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &authTokenLogExporter{e}, nil
+}
+
+func (e *authTokenLogExporter) Export(ctx context.Context, records []sdklog.Record) error {
+	exporter, err := e.current(ctx)
+	if err != nil {
+		return err
+	}
+	return exporter.Export(ctx, records)
+}
+
+func (e *authTokenLogExporter) ForceFlush(ctx context.Context) error {
+	exporter, err := e.current(ctx)
+	if err != nil {
+		return err
+	}
+	return exporter.ForceFlush(ctx)
+}
+
+// authTokenMetricExporter is a metric exporter which follows the changes of TraceAuthTokenFile.
+type authTokenMetricExporter struct {
+	*authTokenExporter[sdkmetric.Exporter]
+}
+
+func newAuthTokenMetricExporter(ctx context.Context, tc *TraceConfig, mc *MetricConfig) (*authTokenMetricExporter, error) {
+	e, err := newAuthTokenExporter(ctx, tc, "metrics", func(ctx context.Context, headers map[string]string) (sdkmetric.Exporter, error) {
+		return newOTLPMetricExporter(ctx, tc, mc, headers)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &authTokenMetricExporter{e}, nil
+}
+
+// Temporality and Aggregation do not depend on the token, so they are answered by the current exporter as is.
+func (e *authTokenMetricExporter) Temporality(kind sdkmetric.InstrumentKind) metricdata.Temporality {
+	exporter, _ := e.current(context.Background()) // created at startup
+	return exporter.Temporality(kind)
+}
+
+func (e *authTokenMetricExporter) Aggregation(kind sdkmetric.InstrumentKind) sdkmetric.Aggregation {
+	exporter, _ := e.current(context.Background()) // created at startup
+	return exporter.Aggregation(kind)
+}
+
+func (e *authTokenMetricExporter) Export(ctx context.Context, rm *metricdata.ResourceMetrics) error {
+	exporter, err := e.current(ctx)
+	if err != nil {
+		return err
+	}
+	return exporter.Export(ctx, rm)
+}
+
+func (e *authTokenMetricExporter) ForceFlush(ctx context.Context) error {
+	exporter, err := e.current(ctx)
+	if err != nil {
+		return err
+	}
+	return exporter.ForceFlush(ctx)
+}
@@ -0,0 +1,189 @@
+package lamux_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fujiwara/lamux"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func newAuthTestServer(t *testing.T) (string, func() []string) {
+	t.Helper()
+	var mu sync.Mutex
+	var auths []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auths = append(auths, r.Header.Get("Authorization"))
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(ts.Close)
+	u, _ := url.Parse(ts.URL)
+	return u.Host, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), auths...)
+	}
+}
+
+func exportSpan(t *testing.T, exporter sdktrace.SpanExporter) {
+	t.Helper()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	_, span := tp.Tracer("test").Start(context.Background(), "test")
+	span.End()
+}
+
+func TestTraceAuthToken(t *testing.T) {
+	ctx := context.Background()
+	host, auths := newAuthTestServer(t)
+	exporter, err := lamux.NewTraceExporter(ctx, &lamux.TraceConfig{
+		TraceEndpoint:  host,
+		TraceProtocol:  "http/protobuf",
+		TraceInsecure:  true,
+		TraceHeaders:   map[string]string{"X-Foo": "bar"},
+		TraceAuthToken: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Shutdown(ctx)
+	exportSpan(t, exporter)
+	if a := auths(); len(a) != 1 || a[0] != "Bearer secret" {
+		t.Errorf("unexpected Authorization headers: %v", a)
+	}
+}
+
+func TestTraceAuthTokenFile(t *testing.T) {
+	ctx := context.Background()
+	host, auths := newAuthTestServer(t)
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("token1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tc := &lamux.TraceConfig{
+		TraceEndpoint:      host,
+		TraceProtocol:      "http/protobuf",
+		TraceInsecure:      true,
+		TraceAuthTokenFile: path,
+	}
+	exporter, err := lamux.NewTraceExporter(ctx, tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Shutdown(ctx)
+	exportSpan(t, exporter)
+
+	// rotated token
+	if err := os.WriteFile(path, []byte("token-2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	exportSpan(t, exporter)
+
+	// the previous token is used when the file is missing
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	exportSpan(t, exporter)
+
+	expect := []string{"Bearer token1", "Bearer token-2", "Bearer token-2"}
+	a := auths()
+	if len(a) != len(expect) {
+		t.Fatalf("expect %d exports, got %v", len(expect), a)
+	}
+	for i := range expect {
+		if expect[i] != a[i] {
+			t.Errorf("export %d: expect %s, got %s", i, expect[i], a[i])
+		}
+	}
+
+	// the file must exist at startup
+	if _, err := lamux.NewTraceExporter(ctx, tc); err == nil {
+		t.Error("expected error for the missing token file")
+	}
+}
+
+func TestMetricAuthTokenFile(t *testing.T) {
+	ctx := context.Background()
+	host, auths := newAuthTestServer(t)
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("token1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tc := &lamux.TraceConfig{
+		TraceEndpoint:      host,
+		TraceProtocol:      "http/protobuf",
+		TraceInsecure:      true,
+		TraceAuthTokenFile: path,
+	}
+	mc := &lamux.MetricConfig{MetricEndpoint: host}
+	exporter, err := lamux.NewMetricExporter(ctx, tc, mc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exporter.Shutdown(ctx)
+	rm := &metricdata.ResourceMetrics{ScopeMetrics: []metricdata.ScopeMetrics{{
+		Metrics: []metricdata.Metrics{{Name: "test", Data: metricdata.Gauge[int64]{DataPoints: []metricdata.DataPoint[int64]{{Value: 1}}}}},
+	}}}
+	if err := exporter.Export(ctx, rm); err != nil {
+		t.Fatal(err)
+	}
+
+	// rotated token
+	if err := os.WriteFile(path, []byte("token-2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Export(ctx, rm); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []string{"Bearer token1", "Bearer token-2"}
+	a := auths()
+	if len(a) != len(expect) {
+		t.Fatalf("expect %d exports, got %v", len(expect), a)
+	}
+	for i := range expect {
+		if expect[i] != a[i] {
+			t.Errorf("export %d: expect %s, got %s", i, expect[i], a[i])
+		}
+	}
+
+	// the file must exist at startup for logs and metrics as well as traces
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lamux.NewMetricExporter(ctx, tc, mc); err == nil {
+		t.Error("expected error for the missing token file")
+	}
+	if _, err := lamux.NewLogExporter(ctx, tc); err == nil {
+		t.Error("expected error for the missing token file")
+	}
+}
+
+func TestTraceAuthTokenRedacted(t *testing.T) {
+	tc := lamux.TraceConfig{TraceEndpoint: "localhost:4318", TraceAuthToken: "secret"}
+	for _, newHandler := range []func(io.Writer) slog.Handler{
+		func(w io.Writer) slog.Handler { return slog.NewJSONHandler(w, nil) },
+		func(w io.Writer) slog.Handler { return slog.NewTextHandler(w, nil) },
+	} {
+		var buf bytes.Buffer
+		slog.New(newHandler(&buf)).Info("test", "config", tc, "config_ptr", &tc)
+		if strings.Contains(buf.String(), "secret") {
+			t.Errorf("auth token must be redacted: %s", buf.String())
+		}
+		if !strings.Contains(buf.String(), "localhost:4318") {
+			t.Errorf("config must be logged: %s", buf.String())
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package lamux
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	otellog "go.opentelemetry.io/otel/log"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+)
+
+func newLoggerProvider(ctx context.Context, tc *TraceConfig) (*sdklog.LoggerProvider, error) {
+	exporter, err := newLogExporter(ctx, tc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create log exporter: %w", err)
+	}
+	resources, err := newResource(ctx, tc)
+	if err != nil {
+		return nil, err
+	}
+	var processor sdklog.Processor
+	if tc.TraceBatch {
+		processor = sdklog.NewBatchProcessor(exporter)
+	} else {
+		processor = sdklog.NewSimpleProcessor(exporter)
+	}
+	return sdklog.NewLoggerProvider(
+		sdklog.WithResource(resources),
+		sdklog.WithProcessor(processor),
+	), nil
+}
+
+func newLogExporter(ctx context.Context, tc *TraceConfig) (sdklog.Exporter, error) {
+	if tc.TraceAuthTokenFile != "" {
+		return newAuthTokenLogExporter(ctx, tc)
+	}
+	return newOTLPLogExporter(ctx, tc, tc.otlpHeaders(tc.TraceAuthToken))
+}
+
+// newOTLPLogExporter returns the OTLP log exporter with the headers.
+func newOTLPLogExporter(ctx context.Context, tc *TraceConfig, headers map[string]string) (sdklog.Exporter, error) {
+	switch tc.TraceProtocol {
+	case "http/protobuf":
+		opts := []otlploghttp.Option{
+			otlploghttp.WithEndpoint(tc.TraceEndpoint),
+			otlploghttp.WithCompression(otlploghttp.GzipCompression),
+		}
+		if tc.TraceInsecure {
+			opts = append(opts, otlploghttp.WithInsecure())
+		}
+		if len(headers) > 0 {
+			opts = append(opts, otlploghttp.WithHeaders(headers))
+		}
+		return otlploghttp.New(ctx, opts...)
+	case "grpc":
+		opts := []otlploggrpc.Option{
+			otlploggrpc.WithEndpoint(tc.TraceEndpoint),
+		}
+		if tc.TraceInsecure {
+			opts = append(opts, otlploggrpc.WithInsecure())
+		}
+		if len(headers) > 0 {
+			opts = append(opts, otlploggrpc.WithHeaders(headers))
+		}
+		return otlploggrpc.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unsupported trace protocol: %s", tc.TraceProtocol)
+	}
+}
+
+// emitAccessLog emits the access log of the request as an Otel log record.
+// The record is correlated with the trace by the span in ctx.
+func (l *Lamux) emitAccessLog(ctx context.Context, r *http.Request, info *requestInfo, code int, start time.Time, elapsed time.Duration, err error) {
+	if l.accessLogger == nil {
+		return
+	}
+	var rec otellog.Record
+	rec.SetTimestamp(start)
+	rec.SetObservedTimestamp(time.Now())
+	rec.SetBody(otellog.StringValue("access"))
+	switch {
+	case code >= 500:
+		rec.SetSeverity(otellog.SeverityError)
+		rec.SetSeverityText("ERROR")
+	case code >= 400:
+		rec.SetSeverity(otellog.SeverityWarn)
+		rec.SetSeverityText("WARN")
+	default:
+		rec.SetSeverity(otellog.SeverityInfo)
+		rec.SetSeverityText("INFO")
+	}
+	rec.AddAttributes(
+		otellog.String("http.request.method", r.Method),
+		otellog.String("url.full", r.URL.String()),
+		otellog.String("server.address", r.Host),
+		otellog.String("user_agent.original", r.UserAgent()),
+		otellog.String("network.peer.address", r.RemoteAddr),
+		otellog.Int("http.response.status_code", code),
+		otellog.Float64("lamux.duration", elapsed.Seconds()),
+		otellog.String("lamux.request_id", r.Header.Get(requestIDHeader)),
+	)
+	if info.functionName != "" {
+		rec.AddAttributes(
+			otellog.String("lambda.function_name", info.functionName),
+			otellog.String("lambda.alias", info.alias),
+		)
+	}
+	if err != nil {
+		rec.AddAttributes(otellog.String("error.message", err.Error()))
+	}
+	l.accessLogger.Emit(ctx, rec)
+}
@@ -0,0 +1,96 @@
+package lamux_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+	otellog "go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/log/logtest"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func TestOTelLogs(t *testing.T) {
+	sr := recordSpans()
+	for _, enabled := range []bool{true, false} {
+		app, err := lamux.NewLamux(&lamux.Config{
+			FunctionName:    "test-func",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: time.Second,
+			TraceConfig:     lamux.TraceConfig{TraceEndpoint: "localhost:4318", OTelLogsEnabled: enabled},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.SetTestClient(&mockClient{code: 200})
+		rec := logtest.NewRecorder()
+		if enabled {
+			app.SetTestLoggerProvider(rec)
+		}
+		n := len(sr.Ended())
+		for _, u := range []string{"http://test.example.net/foo", "http://notfound.example.net/"} {
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest("GET", u, nil))
+		}
+
+		var records []logtest.EmittedRecord
+		for _, scope := range rec.Result() {
+			records = append(records, scope.Records...)
+		}
+		if !enabled {
+			if len(records) != 0 {
+				t.Errorf("expect no log records, got %d", len(records))
+			}
+			continue
+		}
+		if len(records) != 2 {
+			t.Fatalf("expect 2 log records, got %d", len(records))
+		}
+		spans := endedSpansSince(sr, n, "/")
+		if len(spans) != 2 {
+			t.Fatalf("expect 2 server spans, got %d", len(spans))
+		}
+		for i, expect := range []struct {
+			code     int
+			severity otellog.Severity
+			function string
+		}{
+			{code: http.StatusOK, severity: otellog.SeverityInfo, function: "test-func"},
+			{code: http.StatusNotFound, severity: otellog.SeverityWarn, function: "test-func"},
+		} {
+			r := records[i]
+			if e, a := expect.severity, r.Severity(); e != a {
+				t.Errorf("expect severity %s, got %s", e, a)
+			}
+			attrs := map[string]otellog.Value{}
+			r.WalkAttributes(func(kv otellog.KeyValue) bool {
+				attrs[kv.Key] = kv.Value
+				return true
+			})
+			if e, a := int64(expect.code), attrs["http.response.status_code"].AsInt64(); e != a {
+				t.Errorf("expect status %d, got %d", e, a)
+			}
+			if e, a := expect.function, attrs["lambda.function_name"].AsString(); e != a {
+				t.Errorf("expect function %s, got %s", e, a)
+			}
+			sc := oteltrace.SpanContextFromContext(r.Context())
+			if e, a := spans[i].SpanContext().TraceID(), sc.TraceID(); e != a {
+				t.Errorf("log record must be correlated with the trace %s, got %s", e, a)
+			}
+		}
+	}
+}
+
+func TestOTelLogsValidation(t *testing.T) {
+	cfg := &lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		TraceConfig:     lamux.TraceConfig{TraceStdout: true, OTelLogsEnabled: true},
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for otel logs without the trace endpoint")
+	}
+}
@@ -0,0 +1,206 @@
+package lamux
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/metric"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+)
+
+// MetricConfig configures exporting Otel metrics.
+// The protocol, TLS and headers are shared with TraceConfig.
+type MetricConfig struct {
+	MetricEndpoint string        `help:"Otel metric endpoint (e.g. localhost:4318)" env:"LAMUX_METRIC_ENDPOINT" name:"metric-endpoint"`
+	MetricInterval time.Duration `help:"Interval of exporting Otel metrics" default:"60s" env:"LAMUX_METRIC_INTERVAL" name:"metric-interval"`
+}
+
+func (mc *MetricConfig) Enabled() bool {
+	return mc.MetricEndpoint != ""
+}
+
+func (mc *MetricConfig) Validate() error {
+	if mc.Enabled() && mc.MetricInterval <= 0 {
+		return fmt.Errorf("metric interval must be positive")
+	}
+	return nil
+}
+
+type otelMetrics struct {
+	requestDuration metric.Float64Histogram
+	invokeDuration  metric.Float64Histogram
+	invokeErrors    metric.Int64Counter
+	warmupInvokes   metric.Int64Counter
+	throttled       metric.Int64Counter
+	concurrency     metric.Int64UpDownCounter
+}
+
+func newOtelMetrics(mp metric.MeterProvider) (*otelMetrics, error) {
+	meter := mp.Meter("github.com/fujiwara/lamux")
+	requestDuration, err := meter.Float64Histogram("lamux.request.duration",
+		metric.WithDescription("Duration of HTTP requests."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request duration histogram: %w", err)
+	}
+	invokeDuration, err := meter.Float64Histogram("lamux.invoke.duration",
+		metric.WithDescription("Duration of Lambda function invocations."),
+		metric.WithUnit("s"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invoke duration histogram: %w", err)
+	}
+	invokeErrors, err := meter.Int64Counter("lamux.invoke.errors",
+		metric.WithDescription("Number of failed Lambda function invocations."),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invoke errors counter: %w", err)
+	}
+	warmupInvokes, err := meter.Int64Counter("lamux.warmup.invokes",
+		metric.WithDescription("Number of warmup invocations."),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create warmup invokes counter: %w", err)
+	}
+	throttled, err := meter.Int64Counter("lamux.throttled.requests",
+		metric.WithDescription("Number of requests throttled by rate limits."),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create throttled requests counter: %w", err)
+	}
+	concurrency, err := meter.Int64UpDownCounter("lamux.invoke.concurrency",
+		metric.WithDescription("Number of Lambda function invocations in flight."),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create invoke concurrency counter: %w", err)
+	}
+	return &otelMetrics{
+		requestDuration: requestDuration,
+		invokeDuration:  invokeDuration,
+		invokeErrors:    invokeErrors,
+		warmupInvokes:   warmupInvokes,
+		throttled:       throttled,
+		concurrency:     concurrency,
+	}, nil
+}
+
+func (m *otelMetrics) observeRequest(ctx context.Context, functionName, alias string, code int, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	m.requestDuration.Record(ctx, elapsed.Seconds(), metric.WithAttributes(
+		attribute.String("lambda.function_name", functionName),
+		attribute.String("lambda.alias", alias),
+		attribute.Int("http.response.status_code", code),
+	))
+}
+
+func (m *otelMetrics) observeInvoke(ctx context.Context, functionName, alias string, elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		errType := invokeErrorType(err)
+		m.invokeErrors.Add(ctx, 1, metric.WithAttributes(
+			attribute.String("lambda.function_name", functionName),
+			attribute.String("lambda.alias", alias),
+			attribute.String("error.type", errType),
+		))
+		if errType == "not_found" {
+			return
+		}
+	}
+	m.invokeDuration.Record(ctx, elapsed.Seconds(), metric.WithAttributes(
+		attribute.String("lambda.function_name", functionName),
+		attribute.String("lambda.alias", alias),
+	))
+}
+
+func (m *otelMetrics) observeWarmup(ctx context.Context, functionName, alias string, err error) {
+	if m == nil {
+		return
+	}
+	m.warmupInvokes.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("lambda.function_name", functionName),
+		attribute.String("lambda.alias", alias),
+		attribute.String("lamux.warmup.result", warmupResult(err)),
+	))
+}
+
+func (m *otelMetrics) observeThrottle(ctx context.Context, functionName, alias string) {
+	if m == nil {
+		return
+	}
+	m.throttled.Add(ctx, 1, metric.WithAttributes(
+		attribute.String("lambda.function_name", functionName),
+		attribute.String("lambda.alias", alias),
+	))
+}
+
+func (m *otelMetrics) observeConcurrency(ctx context.Context, functionName, alias string, delta int) {
+	if m == nil {
+		return
+	}
+	m.concurrency.Add(ctx, int64(delta), metric.WithAttributes(
+		attribute.String("lambda.function_name", functionName),
+		attribute.String("lambda.alias", alias),
+	))
+}
+
+func newMeterProvider(ctx context.Context, tc *TraceConfig, mc *MetricConfig) (*sdkmetric.MeterProvider, error) {
+	exporter, err := newMetricExporter(ctx, tc, mc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create metric exporter: %w", err)
+	}
+	resources, err := newResource(ctx, tc)
+	if err != nil {
+		return nil, err
+	}
+	return sdkmetric.NewMeterProvider(
+		sdkmetric.WithResource(resources),
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(exporter, sdkmetric.WithInterval(mc.MetricInterval))),
+	), nil
+}
+
+func newMetricExporter(ctx context.Context, tc *TraceConfig, mc *MetricConfig) (sdkmetric.Exporter, error) {
+	if tc.TraceAuthTokenFile != "" {
+		return newAuthTokenMetricExporter(ctx, tc, mc)
+	}
+	return newOTLPMetricExporter(ctx, tc, mc, tc.otlpHeaders(tc.TraceAuthToken))
+}
+
+// newOTLPMetricExporter returns the OTLP metric exporter with the headers.
+func newOTLPMetricExporter(ctx context.Context, tc *TraceConfig, mc *MetricConfig, headers map[string]string) (sdkmetric.Exporter, error) {
+	switch tc.TraceProtocol {
+	case "http/protobuf":
+		opts := []otlpmetrichttp.Option{
+			otlpmetrichttp.WithEndpoint(mc.MetricEndpoint),
+			otlpmetrichttp.WithCompression(otlpmetrichttp.GzipCompression),
+		}
+		if tc.TraceInsecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		}
+		if len(headers) > 0 {
+			opts = append(opts, otlpmetrichttp.WithHeaders(headers))
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	case "grpc":
+		opts := []otlpmetricgrpc.Option{
+			otlpmetricgrpc.WithEndpoint(mc.MetricEndpoint),
+		}
+		if tc.TraceInsecure {
+			opts = append(opts, otlpmetricgrpc.WithInsecure())
+		}
+		if len(headers) > 0 {
+			opts = append(opts, otlpmetricgrpc.WithHeaders(headers))
+		}
+		return otlpmetricgrpc.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unsupported metric protocol: %s", tc.TraceProtocol)
+	}
+}
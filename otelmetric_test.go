@@ -0,0 +1,139 @@
+package lamux_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+func collectMetrics(t *testing.T, reader sdkmetric.Reader) map[string]metricdata.Aggregation {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	m := make(map[string]metricdata.Aggregation)
+	for _, sm := range rm.ScopeMetrics {
+		for _, metric := range sm.Metrics {
+			m[metric.Name] = metric.Data
+		}
+	}
+	return m
+}
+
+func histogramCount(data metricdata.Aggregation, attrs ...attribute.KeyValue) uint64 {
+	h, ok := data.(metricdata.Histogram[float64])
+	if !ok {
+		return 0
+	}
+	set := attribute.NewSet(attrs...)
+	for _, dp := range h.DataPoints {
+		if dp.Attributes.Equals(&set) {
+			return dp.Count
+		}
+	}
+	return 0
+}
+
+func sumValue(data metricdata.Aggregation, attrs ...attribute.KeyValue) int64 {
+	s, ok := data.(metricdata.Sum[int64])
+	if !ok {
+		return 0
+	}
+	set := attribute.NewSet(attrs...)
+	for _, dp := range s.DataPoints {
+		if dp.Attributes.Equals(&set) {
+			return dp.Value
+		}
+	}
+	return 0
+}
+
+func TestOtelMetrics(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		MetricConfig: lamux.MetricConfig{
+			MetricEndpoint: "localhost:4318",
+			MetricInterval: time.Minute,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := sdkmetric.NewManualReader()
+	if err := app.SetTestMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))); err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{code: 200}
+	app.SetTestClient(client)
+	handler := app.Handler()
+
+	for _, host := range []string{"test.example.net", "test.example.net", "notfound.example.net"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://"+host+"/", nil))
+	}
+	client.code = 500
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+
+	metrics := collectMetrics(t, reader)
+	fn := attribute.String("lambda.function_name", "test-func")
+	alias := attribute.String("lambda.alias", "test")
+	if n := histogramCount(metrics["lamux.request.duration"], fn, alias, attribute.Int("http.response.status_code", 200)); n != 2 {
+		t.Errorf("expect 2 requests with 200, got %d", n)
+	}
+	unknown := []attribute.KeyValue{attribute.String("lambda.function_name", "unknown"), attribute.String("lambda.alias", "unknown")}
+	if n := histogramCount(metrics["lamux.request.duration"], append(unknown, attribute.Int("http.response.status_code", 404))...); n != 1 {
+		t.Errorf("expect 1 unlabeled request with 404, got %d", n)
+	}
+	if n := histogramCount(metrics["lamux.invoke.duration"], fn, alias); n != 3 {
+		t.Errorf("expect 3 invocations, got %d", n)
+	}
+	if v := sumValue(metrics["lamux.invoke.errors"], append(unknown, attribute.String("error.type", "not_found"))...); v != 1 {
+		t.Errorf("expect 1 not_found error, got %d", v)
+	}
+	if v := sumValue(metrics["lamux.invoke.errors"], fn, alias, attribute.String("error.type", "error")); v != 1 {
+		t.Errorf("expect 1 error, got %d", v)
+	}
+}
+
+func TestOtelMetricsShutdownFlush(t *testing.T) {
+	var exported atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/metrics" {
+			exported.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	ctx := context.Background()
+	shutdown, err := lamux.SetupOtelSDK(ctx,
+		&lamux.TraceConfig{TraceProtocol: "http/protobuf", TraceInsecure: true, TraceService: "lamux"},
+		&lamux.MetricConfig{MetricEndpoint: u.Host, MetricInterval: time.Hour},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exported.Load() != 0 {
+		t.Fatal("metrics must not be exported before the interval")
+	}
+	if err := shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if exported.Load() == 0 {
+		t.Error("metrics must be flushed on shutdown")
+	}
+}
@@ -0,0 +1,86 @@
+package lamux
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const (
+	redactedValue         = "[REDACTED]"
+	payloadLogMaxBodySize = 4096
+)
+
+// payloadLogger logs the payloads of a sampled fraction of requests, with sensitive headers redacted.
+type payloadLogger struct {
+	rate   float64
+	redact []string
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newPayloadLogger(cfg *Config) *payloadLogger {
+	redact := make([]string, 0, len(cfg.PayloadLogRedactHeaders))
+	for _, h := range cfg.PayloadLogRedactHeaders {
+		redact = append(redact, strings.ToLower(h))
+	}
+	return &payloadLogger{
+		rate:   cfg.PayloadLogSampleRate,
+		redact: redact,
+		rand:   rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+	}
+}
+
+// sample reports whether the payloads of the request should be logged.
+func (p *payloadLogger) sample() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.rand.Float64() < p.rate
+}
+
+// log logs the payload at info level.
+func (p *payloadLogger) log(ctx context.Context, key string, payload []byte) {
+	slog.InfoContext(ctx, "payload", key, p.redactPayload(payload))
+}
+
+// redactPayload returns the payload with the values of sensitive headers and cookies redacted,
+// and the body truncated. Payloads which are not JSON objects are returned as a truncated string.
+func (p *payloadLogger) redactPayload(payload []byte) any {
+	var v map[string]any
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return truncate(string(payload), payloadLogMaxBodySize)
+	}
+	for _, key := range []string{"headers", "multiValueHeaders"} {
+		headers, ok := v[key].(map[string]any)
+		if !ok {
+			continue
+		}
+		for name := range headers {
+			if slices.Contains(p.redact, strings.ToLower(name)) {
+				headers[name] = redactedValue
+			}
+		}
+	}
+	if _, ok := v["cookies"]; ok {
+		v["cookies"] = redactedValue
+	}
+	if body, ok := v["body"].(string); ok {
+		v["body"] = truncate(body, payloadLogMaxBodySize)
+	}
+	return v
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "...(truncated)"
+}
@@ -0,0 +1,138 @@
+package lamux_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	slogcontext "github.com/PumpkinSeed/slog-context"
+	"github.com/fujiwara/lamux"
+)
+
+type payloadLogEntry struct {
+	Msg             string         `json:"msg"`
+	RequestID       string         `json:"request_id"`
+	RequestPayload  map[string]any `json:"request_payload"`
+	ResponsePayload map[string]any `json:"response_payload"`
+}
+
+func capturePayloadLogs(t *testing.T, fn func()) []payloadLogEntry {
+	t.Helper()
+	var buf bytes.Buffer
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slogcontext.NewHandler(slog.NewJSONHandler(&buf, nil))))
+	fn()
+	slog.SetDefault(orig)
+
+	var entries []payloadLogEntry
+	s := bufio.NewScanner(&buf)
+	s.Buffer(nil, 1024*1024)
+	for s.Scan() {
+		var e payloadLogEntry
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Msg == "payload" {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+func TestPayloadLogSampleRate(t *testing.T) {
+	const n, rate = 1000, 0.1
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:         "test-func",
+		DomainSuffix:         "example.net",
+		UpstreamTimeout:      time.Second,
+		PayloadLogSampleRate: rate,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200})
+	app.SetTestPayloadLogSeed(1)
+	entries := capturePayloadLogs(t, func() {
+		for range n {
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+		}
+	})
+	var requests, responses int
+	for _, e := range entries {
+		if e.RequestPayload != nil {
+			requests++
+		}
+		if e.ResponsePayload != nil {
+			responses++
+		}
+	}
+	if requests != responses {
+		t.Errorf("expect the same number of request and response payloads, got %d and %d", requests, responses)
+	}
+	if lo, hi := int(n*rate*0.7), int(n*rate*1.3); requests < lo || requests > hi {
+		t.Errorf("expect %d-%d payloads logged, got %d", lo, hi, requests)
+	}
+}
+
+func TestPayloadLogRedaction(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:            "test-func",
+		DomainSuffix:            "example.net",
+		UpstreamTimeout:         time.Second,
+		PayloadLogSampleRate:    1,
+		PayloadLogRedactHeaders: []string{"Authorization", "Set-Cookie"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(map[string]any{
+		"statusCode": 200,
+		"headers":    map[string]string{"set-cookie": "session=secret-session", "content-type": "text/plain"},
+		"body":       strings.Repeat("a", 10000),
+	})
+	app.SetTestClient(&mockClient{code: 200, payload: payload})
+	entries := capturePayloadLogs(t, func() {
+		r := httptest.NewRequest("POST", "http://test.example.net/", strings.NewReader("hello"))
+		r.Header.Set("Authorization", "Bearer secret-token")
+		r.Header.Set("Cookie", "session=secret-cookie")
+		r.Header.Set("X-Lamux-Request-Id", "req-123")
+		w := httptest.NewRecorder()
+		app.Handler().ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("expect 200, got %d", w.Code)
+		}
+	})
+	if len(entries) != 2 {
+		t.Fatalf("expect 2 payload logs, got %d", len(entries))
+	}
+	for _, e := range entries {
+		if e.RequestID != "req-123" {
+			t.Errorf("expect request_id req-123, got %q", e.RequestID)
+		}
+		b, _ := json.Marshal(e)
+		if bytes.Contains(b, []byte("secret")) {
+			t.Errorf("secrets must be redacted: %s", b)
+		}
+	}
+	req := entries[0].RequestPayload
+	if e, a := "[REDACTED]", req["headers"].(map[string]any)["authorization"]; e != a {
+		t.Errorf("expect authorization %q, got %v", e, a)
+	}
+	if e, a := "[REDACTED]", req["cookies"]; e != a {
+		t.Errorf("expect cookies %q, got %v", e, a)
+	}
+	res := entries[1].ResponsePayload
+	if e, a := "text/plain", res["headers"].(map[string]any)["content-type"]; e != a {
+		t.Errorf("expect content-type %q, got %v", e, a)
+	}
+	if body := res["body"].(string); !strings.HasSuffix(body, "...(truncated)") || len(body) >= 10000 {
+		t.Errorf("expect truncated body, got %d bytes", len(body))
+	}
+}
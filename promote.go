@@ -0,0 +1,45 @@
+package lamux
+
+import (
+	"fmt"
+
+	"github.com/fujiwara/ridge"
+)
+
+// promotedPayload is the APIGatewayV2 event with the lamux object, which augments the standard fields.
+type promotedPayload struct {
+	ridge.RequestV2
+	Lamux lamuxObject `json:"lamux"`
+}
+
+// lamuxObject is the custom object added to the invoke payload.
+type lamuxObject struct {
+	QueryParams map[string]string `json:"query_params"`
+}
+
+// promoteQueryParams copies the query parameters in PromoteQueryParams into the lamux object.
+// Missing parameters are omitted, and multiple values are joined by commas as queryStringParameters.
+func (cfg *Config) promoteQueryParams(payload ridge.RequestV2) *promotedPayload {
+	params := make(map[string]string, len(cfg.PromoteQueryParams))
+	for _, name := range cfg.PromoteQueryParams {
+		if v, ok := payload.QueryStringParameters[name]; ok {
+			params[name] = v
+		}
+	}
+	return &promotedPayload{RequestV2: payload, Lamux: lamuxObject{QueryParams: params}}
+}
+
+func (cfg *Config) validatePromoteQueryParams() error {
+	for _, name := range cfg.PromoteQueryParams {
+		if name == "" {
+			return fmt.Errorf("promote query params must not contain empty names")
+		}
+	}
+	if cfg.RawPayloadPassthrough {
+		return fmt.Errorf("promote query params cannot be used with raw payload passthrough")
+	}
+	if cfg.Backend == backendFunctionURL {
+		return fmt.Errorf("promote query params cannot be used with %s backend", backendFunctionURL)
+	}
+	return nil
+}
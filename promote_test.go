@@ -0,0 +1,105 @@
+package lamux_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func TestPromoteQueryParams(t *testing.T) {
+	cases := []struct {
+		name    string
+		promote []string
+		query   string
+		expect  map[string]string
+	}{
+		{
+			name:  "not configured",
+			query: "?page=2",
+		},
+		{
+			name:    "selected params",
+			promote: []string{"page", "lang", "missing"},
+			query:   "?page=2&lang=en&lang=ja&sort=asc",
+			expect:  map[string]string{"page": "2", "lang": "en,ja"},
+		},
+		{
+			name:    "no query",
+			promote: []string{"page"},
+			expect:  map[string]string{},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:       "test-func",
+				DomainSuffix:       "example.net",
+				UpstreamTimeout:    time.Second,
+				PromoteQueryParams: tc.promote,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200}
+			app.SetTestClient(client)
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/"+tc.query, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("expect 200, got %d", w.Code)
+			}
+			var payload struct {
+				RawQueryString        string            `json:"rawQueryString"`
+				QueryStringParameters map[string]string `json:"queryStringParameters"`
+				Lamux                 *struct {
+					QueryParams map[string]string `json:"query_params"`
+				} `json:"lamux"`
+			}
+			if err := json.Unmarshal(client.input.Payload, &payload); err != nil {
+				t.Fatal(err)
+			}
+			// the standard fields are kept
+			if e, a := len(tc.query) > 0, payload.RawQueryString != "" && len(payload.QueryStringParameters) > 0; e != a {
+				t.Errorf("expect the standard query fields, got %q %v", payload.RawQueryString, payload.QueryStringParameters)
+			}
+			if tc.expect == nil {
+				if payload.Lamux != nil {
+					t.Errorf("expect no lamux object, got %v", payload.Lamux)
+				}
+				return
+			}
+			if payload.Lamux == nil {
+				t.Fatal("expect lamux object")
+			}
+			if !reflect.DeepEqual(tc.expect, payload.Lamux.QueryParams) {
+				t.Errorf("expect %v, got %v", tc.expect, payload.Lamux.QueryParams)
+			}
+		})
+	}
+}
+
+func TestPromoteQueryParamsValidation(t *testing.T) {
+	for name, modify := range map[string]func(*lamux.Config){
+		"empty name":              func(cfg *lamux.Config) { cfg.PromoteQueryParams = []string{""} },
+		"raw payload passthrough": func(cfg *lamux.Config) { cfg.RawPayloadPassthrough = true },
+		"function URL backend": func(cfg *lamux.Config) {
+			cfg.Backend = "function-url"
+			cfg.FunctionURLTemplate = "https://{function}.lambda-url.{region}.on.aws"
+		},
+	} {
+		cfg := &lamux.Config{
+			FunctionName:       "test-func",
+			DomainSuffix:       "example.net",
+			UpstreamTimeout:    time.Second,
+			PromoteQueryParams: []string{"page"},
+		}
+		modify(cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
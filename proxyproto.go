@@ -0,0 +1,179 @@
+package lamux
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// proxyProtocolHeaderTimeout is the time to wait for the PROXY protocol header after accepting a connection
+	proxyProtocolHeaderTimeout = 10 * time.Second
+
+	// the maximum length of the v1 header including CRLF
+	proxyProtocolV1MaxLength = 107
+)
+
+var (
+	proxyProtocolV1Prefix    = []byte("PROXY ")
+	proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errNoProxyProtocolHeader = errors.New("no PROXY protocol header")
+)
+
+// proxyProtocolListener wraps the listener to decode the PROXY protocol (v1 and v2) headers.
+// Connections without the header are accepted with their remote addresses unless strict.
+type proxyProtocolListener struct {
+	net.Listener
+	strict bool
+}
+
+func (l *proxyProtocolListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtocolConn{Conn: c, r: bufio.NewReader(c), strict: l.strict}, nil
+}
+
+// proxyProtocolConn decodes the header on the first Read or RemoteAddr call,
+// which are called in the goroutine serving the connection, not to block Accept.
+type proxyProtocolConn struct {
+	net.Conn
+	r      *bufio.Reader
+	strict bool
+
+	once   sync.Once
+	remote net.Addr
+	err    error
+}
+
+func (c *proxyProtocolConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyProtocolHeaderTimeout))
+		addr, err := readProxyProtocolHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+		if errors.Is(err, errNoProxyProtocolHeader) && !c.strict {
+			return
+		}
+		if err != nil {
+			slog.Warn("failed to read PROXY protocol header", "remote", c.Conn.RemoteAddr().String(), "error", err)
+			// close the connection not to respond to untrusted clients
+			c.Conn.Close()
+			c.err = err
+			return
+		}
+		c.remote = addr
+	})
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the source address in the header, or the remote address of the connection
+// when the header is absent or does not contain addresses (UNKNOWN in v1, LOCAL or non-IP families in v2).
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyProtocolHeader reads the PROXY protocol header and returns the source address.
+// The address is nil when the header does not contain addresses.
+func readProxyProtocolHeader(r *bufio.Reader) (net.Addr, error) {
+	if b, err := r.Peek(len(proxyProtocolV1Prefix)); err == nil && bytes.Equal(b, proxyProtocolV1Prefix) {
+		return readProxyProtocolV1(r)
+	}
+	if b, err := r.Peek(len(proxyProtocolV2Signature)); err == nil && bytes.Equal(b, proxyProtocolV2Signature) {
+		return readProxyProtocolV2(r)
+	} else if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return nil, errNoProxyProtocolHeader
+}
+
+// readProxyProtocolV1 reads the v1 header: "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n".
+func readProxyProtocolV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyProtocolV1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("invalid PROXY protocol v1 header: %w", err)
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	s, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header: not terminated by CRLF within %d bytes", proxyProtocolV1MaxLength)
+	}
+	fields := strings.Split(s, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header: %q", s)
+	}
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, fmt.Errorf("invalid PROXY protocol v1 header: %q", s)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyProtocolV2 reads the binary v2 header.
+func readProxyProtocolV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyProtocolV2Signature)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("invalid PROXY protocol v2 header: %w", err)
+	}
+	verCmd, family := header[12], header[13]
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("invalid PROXY protocol v2 header: %w", err)
+	}
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("invalid PROXY protocol v2 header: unsupported version %d", verCmd>>4)
+	}
+	switch verCmd & 0x0f {
+	case 0x0: // LOCAL, e.g. health checks by the proxy itself
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, fmt.Errorf("invalid PROXY protocol v2 header: unsupported command %d", verCmd&0x0f)
+	}
+	var ipLen int
+	switch family >> 4 {
+	case 0x1: // AF_INET
+		ipLen = net.IPv4len
+	case 0x2: // AF_INET6
+		ipLen = net.IPv6len
+	default: // AF_UNSPEC or AF_UNIX
+		return nil, nil
+	}
+	if len(payload) < ipLen*2+4 {
+		return nil, fmt.Errorf("invalid PROXY protocol v2 header: too short addresses (%d bytes)", len(payload))
+	}
+	ip := net.IP(payload[:ipLen])
+	port := binary.BigEndian.Uint16(payload[ipLen*2:])
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
@@ -0,0 +1,168 @@
+package lamux_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	slogcontext "github.com/PumpkinSeed/slog-context"
+	"github.com/fujiwara/lamux"
+)
+
+func proxyProtocolV2Header(cmd, family byte, addrs []byte) []byte {
+	b := []byte("\r\n\r\n\x00\r\nQUIT\n")
+	b = append(b, 0x20|cmd, family)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(addrs)))
+	return append(b, addrs...)
+}
+
+// serveProxyProtocol serves the handler on a Unix domain socket with PROXY protocol, and returns the dial function.
+func serveProxyProtocol(t *testing.T, cfg *lamux.Config, handler http.Handler) func() net.Conn {
+	t.Helper()
+	sock := filepath.Join(t.TempDir(), "lamux.sock")
+	cfg.Listen = []string{"unix:" + sock}
+	cfg.ProxyProtocol = true
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cfg.Serve(ctx, handler)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return func() net.Conn {
+		for i := 0; i < 50; i++ {
+			if c, err := net.Dial("unix", sock); err == nil {
+				return c
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("failed to connect")
+		return nil
+	}
+}
+
+func TestProxyProtocol(t *testing.T) {
+	v4 := append(append(net.ParseIP("192.0.2.1").To4(), net.ParseIP("192.0.2.2").To4()...), 0xdc, 0x04, 0x01, 0xbb)
+	v6 := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0xdc, 0x04, 0x01, 0xbb)
+	cases := []struct {
+		name   string
+		strict bool
+		header []byte
+		remote string // empty means the connection is rejected
+	}{
+		{"v1 TCP4", true, []byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"), "192.0.2.1:56324"},
+		{"v1 TCP6", true, []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"), "[2001:db8::1]:56324"},
+		{"v1 UNKNOWN", true, []byte("PROXY UNKNOWN\r\n"), "@"},
+		{"v1 invalid", false, []byte("PROXY TCP4 2001:db8::1 192.0.2.2 56324 443\r\n"), ""},
+		{"v1 too long", false, []byte("PROXY TCP4 " + strings.Repeat("1", 100) + "\r\n"), ""},
+		{"v2 TCP4", true, proxyProtocolV2Header(0x1, 0x11, v4), "192.0.2.1:56324"},
+		{"v2 TCP6", true, proxyProtocolV2Header(0x1, 0x21, v6), "[2001:db8::1]:56324"},
+		{"v2 LOCAL", true, proxyProtocolV2Header(0x0, 0x00, nil), "@"},
+		{"v2 too short", false, proxyProtocolV2Header(0x1, 0x11, v4[:8]), ""},
+		{"no header", false, nil, "@"},
+		{"no header strict", true, nil, ""},
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.RemoteAddr)
+	})
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dial := serveProxyProtocol(t, &lamux.Config{ProxyProtocolStrict: tc.strict}, handler)
+			conn := dial()
+			defer conn.Close()
+			conn.Write(append(tc.header, "GET / HTTP/1.1\r\nHost: test.example.net\r\n\r\n"...))
+			resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if tc.remote == "" {
+				if err == nil {
+					t.Errorf("expect the connection is rejected, got %s", resp.Status)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			b, _ := io.ReadAll(resp.Body)
+			if tc.remote == "@" {
+				// the remote address of the Unix domain socket
+				if bytes.Contains(b, []byte("192.0.2.1")) || bytes.Contains(b, []byte("2001:db8::1")) {
+					t.Errorf("expect the remote address of the connection, got %s", b)
+				}
+				return
+			}
+			if e, a := tc.remote, string(b); e != a {
+				t.Errorf("expect remote %s, got %s", e, a)
+			}
+		})
+	}
+}
+
+func TestProxyProtocolClientIPLog(t *testing.T) {
+	var buf syncBuffer
+	orig := slog.Default()
+	slog.SetDefault(slog.New(slogcontext.NewHandler(slog.NewJSONHandler(&buf, nil))))
+	defer slog.SetDefault(orig)
+
+	cfg := &lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+	}
+	app, err := lamux.NewLamux(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200})
+	dial := serveProxyProtocol(t, cfg, app.Handler())
+	conn := dial()
+	defer conn.Close()
+	conn.Write([]byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nGET / HTTP/1.1\r\nHost: test.example.net\r\n\r\n"))
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if e, a := http.StatusOK, resp.StatusCode; e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+	// the access log is written after the response
+	expect := `"client_ip":"192.0.2.1"`
+	for i := 0; i < 50 && !strings.Contains(buf.String(), expect); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !strings.Contains(buf.String(), expect) {
+		t.Errorf("logs must contain %s: %s", expect, buf.String())
+	}
+}
+
+// syncBuffer is a buffer written by the server goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
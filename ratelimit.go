@@ -0,0 +1,126 @@
+package lamux
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	rateLimitKeyAlias    = "alias"
+	rateLimitKeyClientIP = "client-ip"
+)
+
+// maxRateLimitBuckets is the hard limit of the number of buckets.
+// The least recently used buckets are evicted beyond this.
+var maxRateLimitBuckets = 10000
+
+// rateLimiter throttles requests by token buckets per function and alias,
+// or per function, alias and client IP.
+type rateLimiter struct {
+	rate     float64            // requests per second for aliases not in rates
+	rates    map[string]float64 // requests per second per alias
+	burst    int
+	byClient bool
+	resolver *clientIPResolver
+
+	mu      sync.Mutex
+	ll      *list.List // buckets ordered by the last request, the most recent first
+	buckets map[string]*list.Element
+}
+
+type tokenBucket struct {
+	key    string
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(cfg *Config, resolver *clientIPResolver) *rateLimiter {
+	return &rateLimiter{
+		rate:     cfg.RateLimit,
+		rates:    cfg.RateLimitByAlias,
+		burst:    cfg.RateLimitBurst,
+		byClient: cfg.RateLimitKey == rateLimitKeyClientIP,
+		resolver: resolver,
+		ll:       list.New(),
+		buckets:  make(map[string]*list.Element),
+	}
+}
+
+// refill adds the tokens accumulated since the last request.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// take takes a token, or returns the duration until a token is available.
+func (b *tokenBucket) take(now time.Time) (time.Duration, bool) {
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0, true
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second)), false
+}
+
+// allow returns a HandlerError with 429 and Retry-After header if the request exceeds the rate limit.
+func (rl *rateLimiter) allow(functionName, alias string, r *http.Request) error {
+	rate := rl.rate
+	if v, ok := rl.rates[alias]; ok {
+		rate = v
+	}
+	if rate <= 0 {
+		return nil
+	}
+	key := functionName + ":" + alias
+	if rl.byClient {
+		addr, err := rl.resolver.resolve(r)
+		if err != nil {
+			return newHandlerError(err, http.StatusBadRequest)
+		}
+		key += ":" + addr.String()
+	}
+
+	now := time.Now()
+	rl.mu.Lock()
+	var b *tokenBucket
+	if e, ok := rl.buckets[key]; ok {
+		b = e.Value.(*tokenBucket)
+		rl.ll.MoveToFront(e)
+	} else {
+		rl.evict(now)
+		burst := float64(rl.burst)
+		if burst <= 0 {
+			burst = math.Ceil(rate)
+		}
+		b = &tokenBucket{key: key, rate: rate, burst: burst, tokens: burst, last: now}
+		rl.buckets[key] = rl.ll.PushFront(b)
+	}
+	wait, ok := b.take(now)
+	rl.mu.Unlock()
+	if ok {
+		return nil
+	}
+	err := newHandlerError(fmt.Errorf("rate limit exceeded for %s (%g requests per second)", key, rate), http.StatusTooManyRequests)
+	err.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return err
+}
+
+// evict removes the least recently used buckets refilled to the burst, which are equivalent to new buckets,
+// and the least recently used bucket to keep the number of buckets under maxRateLimitBuckets.
+func (rl *rateLimiter) evict(now time.Time) {
+	for e := rl.ll.Back(); e != nil; e = rl.ll.Back() {
+		b := e.Value.(*tokenBucket)
+		if b.refill(now); b.tokens < b.burst && len(rl.buckets) < maxRateLimitBuckets {
+			return
+		}
+		rl.ll.Remove(e)
+		delete(rl.buckets, b.key)
+	}
+}
@@ -0,0 +1,189 @@
+package lamux_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func TestRateLimit(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:     "test-func",
+		DomainSuffix:     "example.net",
+		UpstreamTimeout:  time.Second,
+		RateLimit:        2,
+		RateLimitByAlias: map[string]float64{"fast": 1000, "free": 0},
+		MetricsEnabled:   true,
+		MetricsPath:      "/metrics",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{code: 200, qualifiers: []string{"fast", "free"}}
+	app.SetTestClient(client)
+	handler := app.Handler()
+
+	get := func(host string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://"+host+"/", nil))
+		return w
+	}
+	for i := 0; i < 2; i++ {
+		if w := get("test.example.net"); w.Code != http.StatusOK {
+			t.Fatalf("expect 200 within the burst, got %d", w.Code)
+		}
+	}
+	w := get("test.example.net")
+	if e, a := http.StatusTooManyRequests, w.Code; e != a {
+		t.Fatalf("expect %d, got %d", e, a)
+	}
+	if e, a := "1", w.Header().Get("Retry-After"); e != a {
+		t.Errorf("expect Retry-After %s, got %s", e, a)
+	}
+	if e, a := 2, len(client.invoked()); e != a {
+		t.Errorf("expect %d invocations, got %d", e, a)
+	}
+
+	// the other aliases have their own rates
+	for i := 0; i < 5; i++ {
+		for _, host := range []string{"fast.example.net", "free.example.net"} {
+			if w := get(host); w.Code != http.StatusOK {
+				t.Errorf("%s: expect 200, got %d", host, w.Code)
+			}
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/metrics", nil))
+	b, _ := io.ReadAll(w.Body)
+	if expect := `lamux_throttled_requests_total{alias="test",function_name="test-func"} 1`; !strings.Contains(string(b), expect) {
+		t.Errorf("metrics must contain %q", expect)
+	}
+}
+
+func TestRateLimitByClientIP(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		RateLimit:       1,
+		RateLimitKey:    "client-ip",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200})
+	handler := app.Handler()
+
+	steps := []struct {
+		remote string
+		code   int
+	}{
+		{"192.0.2.1:1234", http.StatusOK},
+		{"192.0.2.1:5678", http.StatusTooManyRequests},
+		{"192.0.2.2:1234", http.StatusOK},
+	}
+	for _, s := range steps {
+		r := httptest.NewRequest("GET", "http://test.example.net/", nil)
+		r.RemoteAddr = s.remote
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if e, a := s.code, w.Code; e != a {
+			t.Errorf("%s: expect %d, got %d", s.remote, e, a)
+		}
+	}
+}
+
+func TestRateLimitBucketsEviction(t *testing.T) {
+	defer lamux.SetMaxRateLimitBuckets(3)()
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		RateLimit:       0.001,
+		RateLimitKey:    "client-ip",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200})
+	handler := app.Handler()
+
+	request := func(remote string) int {
+		r := httptest.NewRequest("GET", "http://test.example.net/", nil)
+		r.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w.Code
+	}
+	for i := 1; i <= 10; i++ {
+		if code := request(fmt.Sprintf("192.0.2.%d:1234", i)); code != http.StatusOK {
+			t.Fatalf("expect 200, got %d", code)
+		}
+		if n := app.RateLimitBuckets(); n > 3 {
+			t.Fatalf("expect at most 3 buckets, got %d", n)
+		}
+	}
+	// recently used buckets are kept
+	if e, a := http.StatusTooManyRequests, request("192.0.2.10:1234"); e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+}
+
+func TestRateLimitConcurrent(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		RateLimit:       0.1,
+		RateLimitBurst:  10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{code: 200}
+	app.SetTestClient(client)
+	handler := app.Handler()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "http://test.example.net/", nil))
+		}()
+	}
+	wg.Wait()
+	if e, a := 10, len(client.invoked()); e != a {
+		t.Errorf("expect %d invocations, got %d", e, a)
+	}
+}
+
+func TestRateLimitValidation(t *testing.T) {
+	for name, modify := range map[string]func(*lamux.Config){
+		"negative rate":       func(cfg *lamux.Config) { cfg.RateLimit = -1 },
+		"negative alias rate": func(cfg *lamux.Config) { cfg.RateLimitByAlias = map[string]float64{"prod": -1} },
+		"invalid alias":       func(cfg *lamux.Config) { cfg.RateLimitByAlias = map[string]float64{"pr-od": 1} },
+		"uppercase alias":     func(cfg *lamux.Config) { cfg.RateLimitByAlias = map[string]float64{"Prod": 1} },
+		"negative burst":      func(cfg *lamux.Config) { cfg.RateLimitBurst = -1 },
+		"invalid key":         func(cfg *lamux.Config) { cfg.RateLimitKey = "host" },
+	} {
+		cfg := &lamux.Config{
+			FunctionName:    "test-func",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: time.Second,
+			RateLimit:       1,
+		}
+		modify(cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
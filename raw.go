@@ -0,0 +1,49 @@
+package lamux
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// readRawPayload reads the request body to be forwarded verbatim as the invoke payload.
+// An empty body is forwarded as an empty payload.
+func readRawPayload(r *http.Request) ([]byte, error) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read request body: %w", err)
+	}
+	if len(b) == 0 {
+		return nil, nil
+	}
+	if !json.Valid(b) {
+		return nil, newHandlerError(errors.New("request body must be a valid JSON"), http.StatusBadRequest)
+	}
+	return b, nil
+}
+
+// isResponseObject reports whether the JSON payload is a response object of the function,
+// which has the statusCode field.
+func isResponseObject(payload []byte) bool {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &obj); err != nil || obj == nil {
+		return false
+	}
+	_, ok := obj["statusCode"]
+	return ok
+}
+
+// writeRawResponse writes the response payload of the function verbatim.
+func writeRawResponse(w http.ResponseWriter, contentType string, payload []byte) error {
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(payload); err != nil {
+		return fmt.Errorf("failed to write response: %w", err)
+	}
+	return nil
+}
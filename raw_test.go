@@ -0,0 +1,134 @@
+package lamux_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func TestRawPayloadPassthrough(t *testing.T) {
+	const response = `{"jsonrpc":"2.0","result":3,"id":1}`
+	cases := []struct {
+		name              string
+		body              string
+		contentType       string
+		expectCode        int
+		expectPayload     string
+		expectContentType string
+	}{
+		{
+			name:              "json rpc",
+			body:              `{"jsonrpc":"2.0","method":"add","params":[1, 2],"id":1}`,
+			expectCode:        http.StatusOK,
+			expectPayload:     `{"jsonrpc":"2.0","method":"add","params":[1, 2],"id":1}`,
+			expectContentType: "application/json",
+		},
+		{
+			name:              "configured content type",
+			body:              `[1,2,3]`,
+			contentType:       "application/json-rpc",
+			expectCode:        http.StatusOK,
+			expectPayload:     `[1,2,3]`,
+			expectContentType: "application/json-rpc",
+		},
+		{
+			name:              "empty body",
+			expectCode:        http.StatusOK,
+			expectContentType: "application/json",
+		},
+		{
+			name:       "invalid json",
+			body:       `{"jsonrpc":`,
+			expectCode: http.StatusBadRequest,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:          "test-func",
+				DomainSuffix:          "example.net",
+				UpstreamTimeout:       time.Second,
+				RawPayloadPassthrough: true,
+				RawPayloadContentType: tc.contentType,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200, payload: []byte(response)}
+			app.SetTestClient(client)
+
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest("POST", "http://test.example.net/rpc", strings.NewReader(tc.body)))
+			if w.Code != tc.expectCode {
+				t.Fatalf("expect %d, got %d: %s", tc.expectCode, w.Code, w.Body.String())
+			}
+			if tc.expectCode != http.StatusOK {
+				if client.input != nil {
+					t.Error("lambda must not be invoked")
+				}
+				return
+			}
+			if e, a := tc.expectPayload, string(client.input.Payload); e != a {
+				t.Errorf("expect payload %s, got %s", e, a)
+			}
+			if e, a := response, w.Body.String(); e != a {
+				t.Errorf("expect response %s, got %s", e, a)
+			}
+			if e, a := tc.expectContentType, w.Header().Get("Content-Type"); e != a {
+				t.Errorf("expect content-type %s, got %s", e, a)
+			}
+		})
+	}
+}
+
+func TestRawResponseFallback(t *testing.T) {
+	cases := []struct {
+		name       string
+		payload    string
+		fallback   bool
+		expectCode int
+		expectBody string
+	}{
+		{name: "string", payload: `"hello"`, fallback: true, expectCode: http.StatusOK, expectBody: `"hello"`},
+		{name: "array", payload: `[1,2,3]`, fallback: true, expectCode: http.StatusOK, expectBody: `[1,2,3]`},
+		{name: "object", payload: `{"message":"hello"}`, fallback: true, expectCode: http.StatusOK, expectBody: `{"message":"hello"}`},
+		{name: "response object", payload: `{"statusCode":201,"body":"created"}`, fallback: true, expectCode: http.StatusCreated, expectBody: "created"},
+		{name: "string without fallback", payload: `"hello"`, expectCode: http.StatusBadGateway},
+		{name: "array without fallback", payload: `[1,2,3]`, expectCode: http.StatusBadGateway},
+		{name: "object without fallback", payload: `{"message":"hello"}`, expectCode: http.StatusBadGateway},
+		{name: "response object without fallback", payload: `{"statusCode":201,"body":"created"}`, expectCode: http.StatusCreated, expectBody: "created"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:        "test-func",
+				DomainSuffix:        "example.net",
+				UpstreamTimeout:     time.Second,
+				RawResponseFallback: tc.fallback,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.SetTestClient(&mockClient{code: 200, payload: []byte(tc.payload)})
+			r := httptest.NewRequest("GET", "http://test.example.net/", nil)
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, r)
+			if e, a := tc.expectCode, w.Code; e != a {
+				t.Fatalf("expect %d, got %d", e, a)
+			}
+			if tc.expectBody == "" {
+				return
+			}
+			if e, a := tc.expectBody, w.Body.String(); e != a {
+				t.Errorf("expect body %s, got %s", e, a)
+			}
+			if ct := w.Header().Get("Content-Type"); tc.expectCode == http.StatusOK && ct != "application/json" {
+				t.Errorf("expect application/json, got %s", ct)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package lamux
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+const (
+	readinessProbeNone   = "none"
+	readinessProbeSTS    = "sts"
+	readinessProbeInvoke = "invoke"
+
+	// readinessProbeTimeout is the timeout of each readiness probe.
+	readinessProbeTimeout = 5 * time.Second
+)
+
+// readinessProbePayload is the payload of readiness probe invocations.
+// Functions can recognize it by the source field and return immediately.
+var readinessProbePayload = []byte(`{"source":"lamux.readiness"}`)
+
+type readinessCheck struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type readinessResponse struct {
+	Status string           `json:"status"`
+	Checks []readinessCheck `json:"checks,omitempty"`
+}
+
+// readinessProber runs the upstream probe and caches the result for the TTL.
+// Concurrent readiness checks wait for a running probe and share the result.
+type readinessProber struct {
+	probe func(context.Context) error
+	name  string
+	ttl   time.Duration
+
+	mu   sync.Mutex
+	last *readinessCheck
+}
+
+func (l *Lamux) newReadinessProber() *readinessProber {
+	p := &readinessProber{name: l.Config.ReadinessProbe, ttl: l.Config.ReadinessProbeTTL}
+	switch l.Config.ReadinessProbe {
+	case readinessProbeSTS:
+		p.probe = l.probeSTS
+	case readinessProbeInvoke:
+		t, _ := parseWarmupTarget(l.Config.ReadinessProbeTarget) // validated already
+		p.probe = func(ctx context.Context) error {
+			return l.probeInvoke(ctx, t)
+		}
+	default:
+		return nil
+	}
+	return p
+}
+
+// check returns the cached result of the probe, or runs the probe if the result is expired.
+func (p *readinessProber) check(ctx context.Context) readinessCheck {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.last != nil && time.Since(p.last.CheckedAt) < p.ttl {
+		return *p.last
+	}
+	// the probe is not canceled by the client of the readiness check, as the result is shared
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), readinessProbeTimeout)
+	defer cancel()
+	c := readinessCheck{Name: p.name, Status: "ok", CheckedAt: time.Now()}
+	if err := p.probe(ctx); err != nil {
+		c.Status, c.Error = "error", err.Error()
+	}
+	p.last = &c
+	return c
+}
+
+// probeSTS verifies that the AWS credentials are valid by sts:GetCallerIdentity.
+func (l *Lamux) probeSTS(ctx context.Context) error {
+	if _, err := l.stsClient.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{}); err != nil {
+		return fmt.Errorf("failed to get caller identity: %w", err)
+	}
+	return nil
+}
+
+// probeInvoke invokes the target function as a canary.
+// Probe invocations are not recorded as requests nor invocations in metrics.
+func (l *Lamux) probeInvoke(ctx context.Context, t warmupTarget) error {
+	arn, err := l.functionARN(ctx, t.functionName)
+	if err != nil {
+		return err
+	}
+	resp, err := l.lambdaClient.Invoke(ctx, &lambda.InvokeInput{
+		FunctionName: aws.String(arn),
+		Qualifier:    aws.String(t.alias),
+		Payload:      readinessProbePayload,
+	}, l.Config.invokeOptions)
+	if err != nil {
+		return fmt.Errorf("failed to invoke %s:%s: %w", t.functionName, t.alias, err)
+	}
+	if resp.FunctionError != nil {
+		return fmt.Errorf("failed to invoke %s:%s: %w: %s", t.functionName, t.alias, errFunctionError, aws.ToString(resp.FunctionError))
+	}
+	return nil
+}
+
+// handleReadinessCheck responds 200 if ready to route traffic, or 503 with the failing check.
+func (l *Lamux) handleReadinessCheck(w http.ResponseWriter, r *http.Request) {
+	res := readinessResponse{Status: "ok"}
+	code := http.StatusOK
+	if l.readiness != nil {
+		c := l.readiness.check(r.Context())
+		if c.Status != "ok" {
+			res.Status, code = "not ready", http.StatusServiceUnavailable
+		}
+		res.Checks = append(res.Checks, c)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(res)
+}
+
+func (cfg *Config) validateReadiness() error {
+	if cfg.ReadinessCheckPath == "" {
+		if cfg.ReadinessProbe != "" && cfg.ReadinessProbe != readinessProbeNone {
+			return errors.New("readiness probe requires the readiness check path")
+		}
+		return nil
+	}
+	if !isValidLocalPath(cfg.ReadinessCheckPath) || slices.Contains(cfg.localPaths(), cfg.ReadinessCheckPath) {
+		return fmt.Errorf("invalid readiness check path: %s", cfg.ReadinessCheckPath)
+	}
+	if cfg.ReadinessCheckPath == cfg.HealthCheckPath || (cfg.MetricsEnabled && cfg.ReadinessCheckPath == cfg.MetricsPath) {
+		return errors.New("readiness check path must be different from health check and metrics path")
+	}
+	switch cfg.ReadinessProbe {
+	case "", readinessProbeNone, readinessProbeSTS:
+	case readinessProbeInvoke:
+		if cfg.ReadinessProbeTarget == "" {
+			return errors.New("readiness probe target must be set for the invoke probe")
+		}
+		if _, err := parseWarmupTarget(cfg.ReadinessProbeTarget); err != nil {
+			return fmt.Errorf("invalid readiness probe target: %w", err)
+		}
+	default:
+		return fmt.Errorf("invalid readiness probe %s (none, sts or invoke allowed)", cfg.ReadinessProbe)
+	}
+	if cfg.ReadinessProbeTTL < 0 {
+		return errors.New("readiness probe ttl must not be negative")
+	}
+	return nil
+}
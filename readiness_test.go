@@ -0,0 +1,171 @@
+package lamux_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+type readinessTestResponse struct {
+	Status string `json:"status"`
+	Checks []struct {
+		Name   string `json:"name"`
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	} `json:"checks"`
+}
+
+func getReadiness(t *testing.T, h http.Handler) (int, readinessTestResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "http://localhost:8080/readyz", nil))
+	var res readinessTestResponse
+	if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	return w.Code, res
+}
+
+func TestReadinessCheck(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:       "test-func",
+		DomainSuffix:       "example.net",
+		UpstreamTimeout:    time.Second,
+		ReadinessCheckPath: "/readyz",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{code: 200}
+	app.SetTestClient(client)
+	code, res := getReadiness(t, app.Handler())
+	if e, a := http.StatusOK, code; e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+	if res.Status != "ok" || len(res.Checks) != 0 {
+		t.Errorf("unexpected response: %#v", res)
+	}
+	if client.input != nil {
+		t.Error("lambda must not be invoked without the readiness probe")
+	}
+}
+
+func TestReadinessProbeSTS(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:       "test-func",
+		DomainSuffix:       "example.net",
+		UpstreamTimeout:    time.Second,
+		ReadinessCheckPath: "/readyz",
+		ReadinessProbe:     "sts",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestSTSClient(&mockSTSClient{err: errors.New("expired token")}, "ap-northeast-1")
+	code, res := getReadiness(t, app.Handler())
+	if e, a := http.StatusServiceUnavailable, code; e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+	if res.Status != "not ready" || len(res.Checks) != 1 || res.Checks[0].Name != "sts" || res.Checks[0].Status != "error" || res.Checks[0].Error == "" {
+		t.Errorf("unexpected response: %#v", res)
+	}
+}
+
+func TestReadinessProbeInvoke(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		target      string
+		ttl         time.Duration
+		expectCode  int
+		expectCalls int
+	}{
+		{name: "cached", target: "test-func:test", ttl: time.Minute, expectCode: http.StatusOK, expectCalls: 1},
+		{name: "not cached", target: "test-func:test", expectCode: http.StatusOK, expectCalls: 3},
+		{name: "not found", target: "test-func:missing", ttl: time.Minute, expectCode: http.StatusServiceUnavailable, expectCalls: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:         "test-func",
+				DomainSuffix:         "example.net",
+				UpstreamTimeout:      time.Second,
+				ReadinessCheckPath:   "/readyz",
+				ReadinessProbe:       "invoke",
+				ReadinessProbeTarget: tc.target,
+				ReadinessProbeTTL:    tc.ttl,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200}
+			app.SetTestClient(client)
+			handler := app.Handler()
+			for i := 0; i < 3; i++ {
+				code, res := getReadiness(t, handler)
+				if e, a := tc.expectCode, code; e != a {
+					t.Errorf("expect %d, got %d", e, a)
+				}
+				if len(res.Checks) != 1 || res.Checks[0].Name != "invoke" {
+					t.Errorf("unexpected response: %#v", res)
+				}
+			}
+			inputs := client.invoked()
+			if e, a := tc.expectCalls, len(inputs); e != a {
+				t.Fatalf("expect %d invocations, got %d", e, a)
+			}
+			if e, a := `{"source":"lamux.readiness"}`, string(inputs[0].Payload); e != a {
+				t.Errorf("expect payload %s, got %s", e, a)
+			}
+		})
+	}
+}
+
+func TestReadinessValidation(t *testing.T) {
+	for name, modify := range map[string]func(*lamux.Config){
+		"invalid path":       func(cfg *lamux.Config) { cfg.ReadinessCheckPath = "readyz" },
+		"same as health":     func(cfg *lamux.Config) { cfg.ReadinessCheckPath = "/healthz" },
+		"wildcard path":      func(cfg *lamux.Config) { cfg.ReadinessCheckPath = "/{ready}" },
+		"invalid probe":      func(cfg *lamux.Config) { cfg.ReadinessProbe = "ping" },
+		"no target":          func(cfg *lamux.Config) { cfg.ReadinessProbe = "invoke" },
+		"invalid target":     func(cfg *lamux.Config) { cfg.ReadinessProbe, cfg.ReadinessProbeTarget = "invoke", "test-func" },
+		"negative probe ttl": func(cfg *lamux.Config) { cfg.ReadinessProbeTTL = -1 },
+		"probe without path": func(cfg *lamux.Config) { cfg.ReadinessCheckPath, cfg.ReadinessProbe = "", "sts" },
+	} {
+		cfg := &lamux.Config{
+			FunctionName:       "test-func",
+			DomainSuffix:       "example.net",
+			UpstreamTimeout:    time.Second,
+			HealthCheckPath:    "/healthz",
+			ReadinessCheckPath: "/readyz",
+		}
+		modify(cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestReadinessCheckDisabledByDefault(t *testing.T) {
+	cfg, err := lamux.ParseConfig([]string{"--function-name", "test-func", "--domain-suffix", "example.net"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := lamux.NewLamux(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{code: 200}
+	app.SetTestClient(client)
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/readyz", nil))
+	if e, a := http.StatusOK, w.Code; e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+	if client.input == nil {
+		t.Error("the path must be routed to the function by default")
+	}
+}
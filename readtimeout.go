@@ -0,0 +1,116 @@
+package lamux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+)
+
+var (
+	errRequestReadTimeout = errors.New("timeout reading the request body")
+	errMaxRequestDuration = errors.New("request exceeded the maximum duration")
+)
+
+// errorWriteTimeout is the time to write the error response after MaxRequestDuration is exceeded.
+const errorWriteTimeout = time.Second
+
+// readTimeoutReader bounds the time to read the request body.
+// Blocking reads are interrupted by the read deadline of the connection if supported,
+// otherwise the timeout is detected on the next read.
+type readTimeoutReader struct {
+	io.ReadCloser
+	deadline time.Time
+	timedOut bool
+}
+
+// wrapReadTimeout wraps the body of r to be read by the deadline, and returns the function to restore
+// the read deadline of the connection for the request (by ServerReadTimeout and MaxRequestDuration).
+// The deadline never extends the restored one.
+func wrapReadTimeout(w http.ResponseWriter, r *http.Request, timeout time.Duration, restore time.Time) (*readTimeoutReader, func()) {
+	tr := &readTimeoutReader{ReadCloser: r.Body, deadline: time.Now().Add(timeout)}
+	if r.Body == nil || r.Body == http.NoBody {
+		return tr, func() {}
+	}
+	r.Body = tr
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(earlier(tr.deadline, restore)); err != nil {
+		// not supported by the writer (e.g. httptest.ResponseRecorder)
+		return tr, func() {}
+	}
+	return tr, func() {
+		// keep the deadline on timeout not to wait for the rest of the body before responding
+		if !tr.timedOut {
+			rc.SetReadDeadline(restore)
+		}
+	}
+}
+
+func (tr *readTimeoutReader) Read(p []byte) (int, error) {
+	if time.Now().After(tr.deadline) {
+		tr.timedOut = true
+		return 0, errRequestReadTimeout
+	}
+	n, err := tr.ReadCloser.Read(p)
+	if err != nil && err != io.EOF && time.Now().After(tr.deadline) {
+		tr.timedOut = true
+	}
+	return n, err
+}
+
+// connDeadlines are the read and write deadlines of the connection. Zero time means no deadline.
+type connDeadlines struct {
+	read  time.Time
+	write time.Time
+}
+
+// serverDeadlines returns the deadlines by ServerReadTimeout and ServerWriteTimeout of the request
+// started at start. The server starts them slightly earlier, on reading the request.
+func (cfg *Config) serverDeadlines(start time.Time) connDeadlines {
+	var d connDeadlines
+	if cfg.ServerReadTimeout > 0 {
+		d.read = start.Add(cfg.ServerReadTimeout)
+	}
+	if cfg.ServerWriteTimeout > 0 {
+		d.write = start.Add(cfg.ServerWriteTimeout)
+	}
+	return d
+}
+
+// earlier returns the earlier deadline of a and b, where zero time means no deadline.
+func earlier(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// setRequestDeadline sets the read and write deadlines of the connection to bound slow clients,
+// and returns the function to restore the deadlines by the server timeouts for the rest of the connection.
+// The server deadlines win when they are earlier, so the server timeouts are never extended.
+// The deadlines are kept after exceeded, as the connection is closed.
+func setRequestDeadline(w http.ResponseWriter, deadline time.Time, server connDeadlines) func() {
+	rc := http.NewResponseController(w)
+	read, write := earlier(deadline, server.read), earlier(deadline, server.write)
+	readErr := rc.SetReadDeadline(read)
+	writeErr := rc.SetWriteDeadline(write)
+	return func() {
+		if now := time.Now(); now.After(read) || now.After(write) {
+			return
+		}
+		if readErr == nil {
+			rc.SetReadDeadline(server.read)
+		}
+		if writeErr == nil {
+			rc.SetWriteDeadline(server.write)
+		}
+	}
+}
+
+// exceeded reports whether the deadline of ctx is exceeded.
+// The deadline is checked directly, as read deadlines of the connection may expire before ctx is done.
+func exceeded(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && !time.Now().Before(deadline)
+}
@@ -0,0 +1,241 @@
+package lamux_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+// slowReader returns a byte per read after the delay.
+type slowReader struct {
+	body  string
+	delay time.Duration
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	if len(r.body) == 0 {
+		return 0, io.EOF
+	}
+	time.Sleep(r.delay)
+	p[0], r.body = r.body[0], r.body[1:]
+	return 1, nil
+}
+
+func newReadTimeoutApp(t *testing.T, raw bool) *lamux.Lamux {
+	t.Helper()
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:          "test-func",
+		DomainSuffix:          "example.net",
+		UpstreamTimeout:       time.Second,
+		RequestReadTimeout:    100 * time.Millisecond,
+		RawPayloadPassthrough: raw,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200})
+	return app
+}
+
+func TestRequestReadTimeout(t *testing.T) {
+	cases := []struct {
+		name       string
+		delay      time.Duration
+		raw        bool
+		expectCode int
+	}{
+		{name: "fast", delay: 0, expectCode: http.StatusOK},
+		{name: "slow", delay: 30 * time.Millisecond, expectCode: http.StatusRequestTimeout},
+		{name: "slow raw payload", delay: 30 * time.Millisecond, raw: true, expectCode: http.StatusRequestTimeout},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app := newReadTimeoutApp(t, tc.raw)
+			r, _ := http.NewRequest("POST", "/", &slowReader{body: `{"message":"hello"}`, delay: tc.delay})
+			r.Header.Set("X-Forwarded-Host", "test.example.net")
+			err := app.HandleProxy(context.Background(), httptest.NewRecorder(), r)
+			if tc.expectCode == http.StatusOK {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			var herr *lamux.HandlerError
+			if !errors.As(err, &herr) {
+				t.Fatalf("expected HandlerError, got %v", err)
+			}
+			if e, a := tc.expectCode, herr.Code(); e != a {
+				t.Errorf("expect %d, got %d", e, a)
+			}
+		})
+	}
+}
+
+func TestRequestReadTimeoutStalledClient(t *testing.T) {
+	app := newReadTimeoutApp(t, false)
+	ts := httptest.NewServer(app.Handler())
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// send only a part of the body and stall
+	fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: test.example.net\r\nContent-Length: 100\r\n\r\n"+strings.Repeat("a", 10))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if e, a := http.StatusRequestTimeout, res.StatusCode; e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+}
+
+func newMaxRequestDurationApp(t *testing.T, client *mockClient) *lamux.Lamux {
+	t.Helper()
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:       "test-func",
+		DomainSuffix:       "example.net",
+		UpstreamTimeout:    5 * time.Second,
+		MaxRequestDuration: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(client)
+	return app
+}
+
+func TestMaxRequestDuration(t *testing.T) {
+	app := newMaxRequestDurationApp(t, &mockClient{code: 200, latency: 2 * time.Second})
+	start := time.Now()
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+	if e, a := http.StatusGatewayTimeout, w.Code; e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the request must be bounded by the max request duration, took %s", elapsed)
+	}
+}
+
+func TestMaxRequestDurationStalledClient(t *testing.T) {
+	app := newMaxRequestDurationApp(t, &mockClient{code: 200})
+	ts := httptest.NewServer(app.Handler())
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+
+	// the deadline is cleared for the next request on the connection
+	fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: test.example.net\r\n\r\n")
+	res, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, res.Body)
+	res.Body.Close()
+	if e, a := http.StatusOK, res.StatusCode; e != a {
+		t.Fatalf("expect %d, got %d", e, a)
+	}
+	time.Sleep(300 * time.Millisecond)
+
+	// send only a part of the body and stall
+	fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: test.example.net\r\nContent-Length: 100\r\n\r\n"+strings.Repeat("a", 10))
+	res, err = http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if e, a := http.StatusGatewayTimeout, res.StatusCode; e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+}
+
+func TestMaxRequestDurationServerReadTimeout(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:       "test-func",
+		DomainSuffix:       "example.net",
+		UpstreamTimeout:    5 * time.Second,
+		MaxRequestDuration: 5 * time.Second,
+		ServerReadTimeout:  200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200})
+	ts := httptest.NewUnstartedServer(app.Handler())
+	ts.Config.ReadTimeout = 200 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// the longer max request duration must not extend the server read timeout
+	start := time.Now()
+	fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: test.example.net\r\nContent-Length: 100\r\n\r\n"+strings.Repeat("a", 10))
+	if res, err := http.ReadResponse(bufio.NewReader(conn), nil); err == nil {
+		res.Body.Close()
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the stalled request must be bounded by the server read timeout, took %s", elapsed)
+	}
+}
+
+func TestRequestReadTimeoutServerReadTimeout(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:       "test-func",
+		DomainSuffix:       "example.net",
+		UpstreamTimeout:    5 * time.Second,
+		RequestReadTimeout: 5 * time.Second,
+		ServerReadTimeout:  200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200})
+	ts := httptest.NewUnstartedServer(app.Handler())
+	ts.Config.ReadTimeout = 200 * time.Millisecond
+	ts.Start()
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	// the longer request read timeout must not extend the server read timeout
+	start := time.Now()
+	fmt.Fprint(conn, "POST / HTTP/1.1\r\nHost: test.example.net\r\nContent-Length: 100\r\n\r\n"+strings.Repeat("a", 10))
+	if res, err := http.ReadResponse(bufio.NewReader(conn), nil); err == nil {
+		res.Body.Close()
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the stalled request must be bounded by the server read timeout, took %s", elapsed)
+	}
+}
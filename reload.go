@@ -0,0 +1,87 @@
+package lamux
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+)
+
+// routingTable is the routing settings swapped atomically by reloading the config.
+type routingTable struct {
+	aliasMap    map[string]string
+	strictAlias bool
+	routeFilter *routeFilter
+	weighted    map[string]*weightedAliases
+}
+
+func newRoutingTable(cfg *Config) (*routingTable, error) {
+	t := &routingTable{
+		aliasMap:    cfg.AliasMap,
+		strictAlias: cfg.StrictAlias,
+		weighted:    newWeightedRoutes(cfg),
+	}
+	if len(cfg.AllowedMethods) > 0 || len(cfg.AllowedPaths) > 0 {
+		var err error
+		if t.routeFilter, err = newRouteFilter(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// mapAlias translates the alias extracted from the host to the real Lambda alias by the alias map.
+// Unmapped aliases pass through, or are rejected when strictAlias is set.
+func (t *routingTable) mapAlias(alias string) (string, error) {
+	if real, ok := t.aliasMap[alias]; ok {
+		return real, nil
+	}
+	if t.strictAlias {
+		return "", fmt.Errorf("unknown alias: %s", alias)
+	}
+	return alias, nil
+}
+
+// routing returns the current routing table.
+func (l *Lamux) routing() *routingTable {
+	return l.routes.Load()
+}
+
+// reload parses the config from args, environment variables and the config file again,
+// and swaps the routing table. Other settings are not reloaded.
+func (l *Lamux) reload(args []string) error {
+	cfg, err := parseConfig(args)
+	if err != nil {
+		return fmt.Errorf("failed to parse config: %w", err)
+	}
+	t, err := newRoutingTable(cfg)
+	if err != nil {
+		return fmt.Errorf("invalid routing config: %w", err)
+	}
+	l.routes.Store(t)
+	return nil
+}
+
+// watchReload reloads the routing table on SIGHUP until ctx is canceled.
+func (l *Lamux) watchReload(ctx context.Context, args []string) {
+	sigs := reloadSignals()
+	if len(sigs) == 0 {
+		return
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	defer signal.Stop(ch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-ch:
+			if err := l.reload(args); err != nil {
+				slog.Error("failed to reload routing config", "signal", sig.String(), "error", err)
+				continue
+			}
+			slog.Info("reloaded routing config", "signal", sig.String())
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package lamux_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/fujiwara/lamux"
+)
+
+func TestReload(t *testing.T) {
+	t.Setenv("LAMUX_CONFIG", "")
+	path := writeConfigFile(t, "lamux.yaml", `
+function-name: test-func
+domain-suffix: example.net
+strict-alias: true
+alias-map:
+  blue: v1
+`)
+	args := []string{"--config", path}
+	cfg, err := lamux.ParseConfig(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, err := lamux.NewLamux(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{code: 200, qualifiers: []string{"v1", "v2"}}
+	app.SetTestClient(client)
+	handler := app.Handler()
+
+	request := func(method, host string) int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(method, "http://"+host+"/", nil))
+		return w.Code
+	}
+	if e, a := http.StatusOK, request("GET", "blue.example.net"); e != a {
+		t.Fatalf("expect %d, got %d", e, a)
+	}
+	if e, a := http.StatusNotFound, request("GET", "green.example.net"); e != a {
+		t.Fatalf("expect %d, got %d", e, a)
+	}
+
+	if err := os.WriteFile(path, []byte(`
+function-name: test-func
+domain-suffix: example.net
+strict-alias: true
+alias-map:
+  blue: v2
+  green: v1
+allowed-methods:
+  test-func: GET
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Reload(args); err != nil {
+		t.Fatal(err)
+	}
+	if e, a := http.StatusOK, request("GET", "blue.example.net"); e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+	if e, a := "v2", aws.ToString(client.input.Qualifier); e != a {
+		t.Errorf("expect qualifier %s, got %s", e, a)
+	}
+	if e, a := http.StatusOK, request("GET", "green.example.net"); e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+	if e, a := http.StatusMethodNotAllowed, request("POST", "green.example.net"); e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+
+	// invalid config keeps the current routes
+	if err := os.WriteFile(path, []byte("allowed-paths:\n  test-func: \"[\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Reload(args); err == nil {
+		t.Error("expect error for invalid config")
+	}
+	if e, a := http.StatusOK, request("GET", "green.example.net"); e != a {
+		t.Errorf("expect %d, got %d", e, a)
+	}
+}
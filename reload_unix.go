@@ -0,0 +1,13 @@
+//go:build !windows
+
+package lamux
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func reloadSignals() []os.Signal {
+	return []os.Signal{unix.SIGHUP}
+}
@@ -0,0 +1,12 @@
+//go:build windows
+
+package lamux
+
+import (
+	"os"
+)
+
+// SIGHUP is not available on Windows.
+func reloadSignals() []os.Signal {
+	return nil
+}
@@ -0,0 +1,35 @@
+package lamux
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+const requestIDHeader = "X-Lamux-Request-Id"
+
+const maxRequestIDLength = 128
+
+// requestID returns the request ID of r.
+// The ID supplied by the client (X-Lamux-Request-Id or X-Amzn-RequestId) is reused if valid, otherwise a new UUID is generated.
+func requestID(r *http.Request) string {
+	for _, name := range []string{requestIDHeader, "X-Amzn-RequestId"} {
+		if id := r.Header.Get(name); isValidRequestID(id) {
+			return id
+		}
+	}
+	return uuid.NewString()
+}
+
+// isValidRequestID reports whether id is safe to be logged and forwarded.
+func isValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if c := id[i]; c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package lamux_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+var uuidRegexp = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+func TestRequestID(t *testing.T) {
+	cases := []struct {
+		name     string
+		headers  map[string]string
+		expectID string // empty means a generated UUID
+	}{
+		{
+			name: "generated",
+		},
+		{
+			name:     "supplied by client",
+			headers:  map[string]string{"X-Lamux-Request-Id": "client-request-1"},
+			expectID: "client-request-1",
+		},
+		{
+			name:     "X-Amzn-RequestId",
+			headers:  map[string]string{"X-Amzn-RequestId": "amzn-request-1"},
+			expectID: "amzn-request-1",
+		},
+		{
+			name:    "invalid",
+			headers: map[string]string{"X-Lamux-Request-Id": "invalid id\n"},
+		},
+		{
+			name:    "too long",
+			headers: map[string]string{"X-Lamux-Request-Id": strings.Repeat("a", 129)},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:    "test-func",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200}
+			app.SetTestClient(client)
+
+			r := httptest.NewRequest("GET", "http://test.example.net/", nil)
+			for k, v := range tc.headers {
+				r.Header.Set(k, v)
+			}
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, r)
+
+			id := w.Header().Get("X-Lamux-Request-Id")
+			if tc.expectID != "" {
+				if id != tc.expectID {
+					t.Errorf("expect request id %q, got %q", tc.expectID, id)
+				}
+			} else if !uuidRegexp.MatchString(id) {
+				t.Errorf("expect generated UUID, got %q", id)
+			}
+
+			var payload forwardedRequest
+			if err := json.Unmarshal(client.input.Payload, &payload); err != nil {
+				t.Fatal(err)
+			}
+			if e, a := id, payload.Headers["x-lamux-request-id"]; e != a {
+				t.Errorf("expect forwarded request id %q, got %q", e, a)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package lamux
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/fujiwara/ridge"
+)
+
+// ResponseRewriteRule replaces From with To in the bodies of responses of ContentType.
+// ContentType is a media type without parameters (e.g. text/html) or a wildcard of subtypes (e.g. text/*).
+type ResponseRewriteRule struct {
+	ContentType string `yaml:"content-type"`
+	From        string `yaml:"from"`
+	To          string `yaml:"to"`
+}
+
+func (rule ResponseRewriteRule) matches(mediaType string) bool {
+	if t, ok := strings.CutSuffix(rule.ContentType, "/*"); ok {
+		main, _, _ := strings.Cut(mediaType, "/")
+		return strings.EqualFold(t, main)
+	}
+	return strings.EqualFold(rule.ContentType, mediaType)
+}
+
+func (cfg *Config) validateResponseRewrites() error {
+	for i, rule := range cfg.ResponseRewrites {
+		if rule.From == "" {
+			return fmt.Errorf("response rewrite #%d: from must be set", i)
+		}
+		_, params, err := mime.ParseMediaType(rule.ContentType)
+		if err != nil {
+			return fmt.Errorf("response rewrite #%d: invalid content type %q: %w", i, rule.ContentType, err)
+		}
+		if len(params) > 0 {
+			// matched with the media type of responses without parameters
+			return fmt.Errorf("response rewrite #%d: content type %q must not have parameters", i, rule.ContentType)
+		}
+	}
+	return nil
+}
+
+// rewriteResponseBody applies the rules matching the content type to the body of res in order,
+// and corrects Content-Length. Compressed bodies are not rewritten.
+func rewriteResponseBody(res *ridge.Response, rules []ResponseRewriteRule) error {
+	rules = matchingRewrites(responseHeader(res, "Content-Type"), responseHeader(res, "Content-Encoding"), rules)
+	if len(rules) == 0 {
+		return nil
+	}
+	body := res.Body
+	if res.IsBase64Encoded {
+		b, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return fmt.Errorf("failed to decode response body: %w", err)
+		}
+		body = string(b)
+	}
+	body = applyRewrites(body, rules)
+	if res.IsBase64Encoded {
+		res.Body = base64.StdEncoding.EncodeToString([]byte(body))
+	} else {
+		res.Body = body
+	}
+	if responseHeader(res, "Content-Length") != "" {
+		setResponseHeader(res, "Content-Length", strconv.Itoa(len(body)))
+	}
+	return nil
+}
+
+// matchingRewrites returns the rules matching the content type of the response.
+// No rules match compressed responses.
+func matchingRewrites(contentType, contentEncoding string, rules []ResponseRewriteRule) []ResponseRewriteRule {
+	if contentEncoding != "" && !strings.EqualFold(contentEncoding, "identity") {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	var matched []ResponseRewriteRule
+	for _, rule := range rules {
+		if rule.matches(mediaType) {
+			matched = append(matched, rule)
+		}
+	}
+	return matched
+}
+
+func applyRewrites(body string, rules []ResponseRewriteRule) string {
+	for _, rule := range rules {
+		body = strings.ReplaceAll(body, rule.From, rule.To)
+	}
+	return body
+}
@@ -0,0 +1,146 @@
+package lamux_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func TestResponseRewrites(t *testing.T) {
+	rules := []lamux.ResponseRewriteRule{
+		{ContentType: "text/html", From: "http://internal.example.com", To: "https://www.example.net"},
+		{ContentType: "application/*", From: "internal.example.com", To: "api.example.net"},
+		{ContentType: "text/html", From: "https://www.example.net/old", To: "https://www.example.net/new"},
+	}
+	cases := []struct {
+		name            string
+		contentType     string
+		contentEncoding string
+		base64Encoded   bool
+		body            string
+		expectBody      string
+	}{
+		{
+			name:        "html",
+			contentType: "text/html; charset=utf-8",
+			body:        `<a href="http://internal.example.com/old">home</a><a href="http://internal.example.com/">top</a>`,
+			expectBody:  `<a href="https://www.example.net/new">home</a><a href="https://www.example.net/">top</a>`,
+		},
+		{
+			name:          "base64 encoded",
+			contentType:   "Application/JSON",
+			base64Encoded: true,
+			body:          `{"url":"https://internal.example.com/v1"}`,
+			expectBody:    `{"url":"https://api.example.net/v1"}`,
+		},
+		{
+			name:        "not matched",
+			contentType: "text/plain",
+			body:        "http://internal.example.com",
+			expectBody:  "http://internal.example.com",
+		},
+		{
+			name:            "compressed",
+			contentType:     "text/html",
+			contentEncoding: "br",
+			body:            "http://internal.example.com",
+			expectBody:      "http://internal.example.com",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := tc.body
+			if tc.base64Encoded {
+				body = base64.StdEncoding.EncodeToString([]byte(body))
+			}
+			headers := map[string]string{
+				"content-type":   tc.contentType,
+				"content-length": strconv.Itoa(len(tc.body)),
+			}
+			if tc.contentEncoding != "" {
+				headers["content-encoding"] = tc.contentEncoding
+			}
+			payload, err := json.Marshal(map[string]any{
+				"statusCode":      200,
+				"headers":         headers,
+				"body":            body,
+				"isBase64Encoded": tc.base64Encoded,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:     "test-func",
+				DomainSuffix:     "example.net",
+				UpstreamTimeout:  time.Second,
+				ResponseRewrites: rules,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.SetTestClient(&mockClient{code: 200, payload: payload})
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+			if e, a := http.StatusOK, w.Code; e != a {
+				t.Fatalf("expect %d, got %d", e, a)
+			}
+			b, _ := io.ReadAll(w.Body)
+			if e, a := tc.expectBody, string(b); e != a {
+				t.Errorf("expect body %q, got %q", e, a)
+			}
+			if e, a := strconv.Itoa(len(tc.expectBody)), w.Header().Get("Content-Length"); e != a {
+				t.Errorf("expect Content-Length %s, got %s", e, a)
+			}
+		})
+	}
+}
+
+func TestResponseRewritesConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lamux.yaml")
+	yaml := `function-name: test-func
+domain-suffix: example.net
+response-rewrites:
+  - content-type: text/html
+    from: http://internal.example.com
+    to: https://www.example.net
+`
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := lamux.ParseConfig([]string{"--config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []lamux.ResponseRewriteRule{{ContentType: "text/html", From: "http://internal.example.com", To: "https://www.example.net"}}
+	if len(cfg.ResponseRewrites) != 1 || cfg.ResponseRewrites[0] != expect[0] {
+		t.Errorf("unexpected response rewrites: %v", cfg.ResponseRewrites)
+	}
+}
+
+func TestResponseRewritesValidation(t *testing.T) {
+	for name, rule := range map[string]lamux.ResponseRewriteRule{
+		"empty from":           {ContentType: "text/html", To: "x"},
+		"empty content type":   {From: "x", To: "y"},
+		"invalid content type": {ContentType: "text/html;;", From: "x"},
+		"with parameters":      {ContentType: "text/html; charset=utf-8", From: "x"},
+	} {
+		cfg := &lamux.Config{
+			FunctionName:     "test-func",
+			DomainSuffix:     "example.net",
+			UpstreamTimeout:  time.Second,
+			ResponseRewrites: []lamux.ResponseRewriteRule{rule},
+		}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package lamux
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var methodRegexp = regexp.MustCompile(`^[A-Z]+$`)
+
+// route is a valid route listed in 404 responses when Config.Verbose404 is set.
+type route struct {
+	Host    string   `json:"host,omitempty"`
+	Path    string   `json:"path,omitempty"`
+	Methods []string `json:"methods,omitempty"`
+}
+
+// aliasRoutes returns the hosts of the aliases in the alias map for the function.
+func (cfg *Config) aliasRoutes(aliasMap map[string]string, functionName string) []route {
+	aliases := make([]string, 0, len(aliasMap))
+	for alias := range aliasMap {
+		aliases = append(aliases, alias)
+	}
+	slices.Sort(aliases)
+	routes := make([]route, 0, len(aliases))
+	for _, alias := range aliases {
+		host := alias + "." + cfg.DomainSuffix
+		if cfg.FunctionName == "*" {
+			host = alias + "-" + functionName + "." + cfg.DomainSuffix
+		}
+		routes = append(routes, route{Host: host})
+	}
+	return routes
+}
+
+// routeFilter restricts the methods and paths of requests per function.
+type routeFilter struct {
+	methods map[string][]string
+	paths   map[string]*regexp.Regexp
+}
+
+func newRouteFilter(cfg *Config) (*routeFilter, error) {
+	f := &routeFilter{
+		methods: make(map[string][]string, len(cfg.AllowedMethods)),
+		paths:   make(map[string]*regexp.Regexp, len(cfg.AllowedPaths)),
+	}
+	for name, v := range cfg.AllowedMethods {
+		if !cfg.hostFunctionNameRegexp().MatchString(name) {
+			return nil, fmt.Errorf("invalid function name in allowed methods: %s", name)
+		}
+		var methods []string
+		for _, m := range strings.Split(v, ",") {
+			m = strings.ToUpper(strings.TrimSpace(m))
+			if !methodRegexp.MatchString(m) {
+				return nil, fmt.Errorf("invalid method in allowed methods for %s: %q", name, m)
+			}
+			methods = append(methods, m)
+		}
+		f.methods[name] = methods
+	}
+	for name, v := range cfg.AllowedPaths {
+		if !cfg.hostFunctionNameRegexp().MatchString(name) {
+			return nil, fmt.Errorf("invalid function name in allowed paths: %s", name)
+		}
+		re, err := regexp.Compile(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path pattern in allowed paths for %s: %w", name, err)
+		}
+		f.paths[name] = re
+	}
+	return f, nil
+}
+
+// check returns an error with 404 if the path is not allowed for the function,
+// or 405 with Allow header if the method is not allowed.
+// Functions not configured accept any methods and paths.
+func (f *routeFilter) check(functionName string, r *http.Request) error {
+	if re, ok := f.paths[functionName]; ok && !re.MatchString(r.URL.Path) {
+		err := newHandlerError(fmt.Errorf("path %s is not allowed for %s", r.URL.Path, functionName), http.StatusNotFound)
+		err.routes = []route{{Path: re.String(), Methods: f.methods[functionName]}}
+		return err
+	}
+	if methods, ok := f.methods[functionName]; ok && !slices.Contains(methods, r.Method) {
+		err := newHandlerError(fmt.Errorf("method %s is not allowed for %s", r.Method, functionName), http.StatusMethodNotAllowed)
+		err.Header().Set("Allow", strings.Join(methods, ", "))
+		return err
+	}
+	return nil
+}
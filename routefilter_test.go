@@ -0,0 +1,152 @@
+package lamux_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func TestAllowedMethodsAndPaths(t *testing.T) {
+	cases := []struct {
+		method       string
+		path         string
+		expectStatus int
+		expectAllow  string
+	}{
+		{method: "GET", path: "/api/users", expectStatus: http.StatusOK},
+		{method: "HEAD", path: "/api/users", expectStatus: http.StatusOK},
+		{method: "POST", path: "/api/users", expectStatus: http.StatusMethodNotAllowed, expectAllow: "GET, HEAD"},
+		{method: "GET", path: "/admin", expectStatus: http.StatusNotFound},
+		{method: "POST", path: "/admin", expectStatus: http.StatusNotFound},
+	}
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		AllowedMethods:  map[string]string{"test-func": "get, HEAD"},
+		AllowedPaths:    map[string]string{"test-func": "^/api/"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range cases {
+		t.Run(tc.method+" "+tc.path, func(t *testing.T) {
+			client := &mockClient{code: 200}
+			app.SetTestClient(client)
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest(tc.method, "http://test.example.net"+tc.path, nil))
+			if e, a := tc.expectStatus, w.Code; e != a {
+				t.Errorf("expect %d, got %d", e, a)
+			}
+			if e, a := tc.expectAllow, w.Header().Get("Allow"); e != a {
+				t.Errorf("expect Allow %q, got %q", e, a)
+			}
+			invoked := len(client.invoked())
+			if tc.expectStatus == http.StatusOK && invoked != 1 {
+				t.Errorf("expect the function to be invoked, got %d invocations", invoked)
+			}
+			if tc.expectStatus != http.StatusOK && invoked != 0 {
+				t.Errorf("expect the function not to be invoked, got %d invocations", invoked)
+			}
+		})
+	}
+}
+
+func TestAllowedMethodsAndPathsValidation(t *testing.T) {
+	cases := []struct {
+		name    string
+		methods map[string]string
+		paths   map[string]string
+	}{
+		{name: "invalid regexp", paths: map[string]string{"test-func": "^/api/("}},
+		{name: "invalid method", methods: map[string]string{"test-func": "GET,"}},
+		{name: "invalid function name", methods: map[string]string{"test_func": "GET"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &lamux.Config{
+				FunctionName:    "test-func",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+				AllowedMethods:  tc.methods,
+				AllowedPaths:    tc.paths,
+			}
+			if err := cfg.Validate(); err == nil {
+				t.Error("expected validation error")
+			}
+		})
+	}
+}
+
+func TestVerbose404(t *testing.T) {
+	cases := []struct {
+		name         string
+		host         string
+		path         string
+		verbose      bool
+		expectRoutes string
+	}{
+		{
+			name:         "path not allowed",
+			host:         "current.example.net",
+			path:         "/admin",
+			verbose:      true,
+			expectRoutes: `[{"path":"^/api/","methods":["GET","HEAD"]}]`,
+		},
+		{
+			name:         "unknown alias",
+			host:         "unknown.example.net",
+			path:         "/api/",
+			verbose:      true,
+			expectRoutes: `[{"host":"current.example.net"},{"host":"previous.example.net"}]`,
+		},
+		{name: "path not allowed without verbose", host: "current.example.net", path: "/admin"},
+		{name: "unknown alias without verbose", host: "unknown.example.net", path: "/api/"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:    "test-func",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+				AliasMap:        map[string]string{"current": "v2", "previous": "v1"},
+				StrictAlias:     true,
+				AllowedMethods:  map[string]string{"test-func": "GET,HEAD"},
+				AllowedPaths:    map[string]string{"test-func": "^/api/"},
+				Verbose404:      tc.verbose,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.SetTestClient(&mockClient{code: 200})
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://"+tc.host+tc.path, nil))
+			if e, a := http.StatusNotFound, w.Code; e != a {
+				t.Errorf("expect %d, got %d", e, a)
+			}
+			if tc.expectRoutes == "" {
+				if strings.Contains(w.Body.String(), "routes") || strings.Contains(w.Body.String(), "previous") {
+					t.Errorf("routes must not be exposed: %s", w.Body.String())
+				}
+				return
+			}
+			if e, a := "application/json", w.Header().Get("Content-Type"); e != a {
+				t.Errorf("expect content type %q, got %q", e, a)
+			}
+			var body struct {
+				Routes json.RawMessage `json:"routes"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if e, a := tc.expectRoutes, string(body.Routes); e != a {
+				t.Errorf("expect routes %s, got %s", e, a)
+			}
+		})
+	}
+}
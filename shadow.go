@@ -0,0 +1,41 @@
+package lamux
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+)
+
+// invokeShadow invokes the shadow function asynchronously with a copy of the request payload.
+// Failures are only logged and never affect the primary response.
+func (l *Lamux) invokeShadow(ctx context.Context, alias string, b []byte) {
+	if l.Config.ShadowAlias != "" {
+		alias = l.Config.ShadowAlias
+	}
+	// the shadow invocation must not be canceled when the primary request finishes
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), l.Config.FunctionTimeout(l.Config.ShadowFunction))
+	go func() {
+		defer cancel()
+		ctx, span := tracer.Start(ctx, "InvokeShadow")
+		defer span.End()
+		arn, err := l.functionARN(ctx, l.Config.ShadowFunction)
+		if err == nil {
+			_, err = l.lambdaClient.Invoke(ctx, &lambda.InvokeInput{
+				FunctionName:   aws.String(arn),
+				Qualifier:      aws.String(alias),
+				InvocationType: types.InvocationTypeEvent,
+				Payload:        b,
+			}, l.Config.invokeOptions)
+		}
+		if err != nil {
+			slog.WarnContext(ctx, "failed to invoke shadow function",
+				"shadow_function_name", l.Config.ShadowFunction,
+				"shadow_alias", alias,
+				"error", err,
+			)
+		}
+	}()
+}
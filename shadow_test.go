@@ -0,0 +1,105 @@
+package lamux_test
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+	"github.com/fujiwara/lamux"
+)
+
+func waitShadowInvocation(t *testing.T, client *mockClient) *lambda.InvokeInput {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		for _, input := range client.invoked() {
+			if input.InvocationType == types.InvocationTypeEvent {
+				return input
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("shadow function was not invoked")
+	return nil
+}
+
+func TestShadow(t *testing.T) {
+	cases := []struct {
+		name        string
+		shadowAlias string
+		expectAlias string
+		primaryCode int32
+		expectCode  int
+	}{
+		{
+			name:        "same alias",
+			expectAlias: "test",
+			primaryCode: 200,
+			expectCode:  200,
+		},
+		{
+			name:        "shadow alias",
+			shadowAlias: "shadow",
+			expectAlias: "shadow",
+			primaryCode: 404,
+			expectCode:  404,
+		},
+		{
+			name:        "shadow function not found",
+			shadowAlias: "notfound",
+			expectAlias: "notfound",
+			primaryCode: 200,
+			expectCode:  200,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:    "test-func",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+				ShadowFunction:  "test-func",
+				ShadowAlias:     tc.shadowAlias,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: tc.primaryCode, qualifiers: []string{"shadow"}}
+			app.SetTestClient(client)
+
+			r, _ := http.NewRequest("POST", "/foo?bar=baz", strings.NewReader("hello"))
+			r.Header.Set("X-Forwarded-Host", "test.example.net")
+			w := httptest.NewRecorder()
+			if err := app.HandleProxy(context.Background(), w, r); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if e, a := tc.expectCode, w.Code; e != a {
+				t.Errorf("expect %d, got %d", e, a)
+			}
+
+			shadow := waitShadowInvocation(t, client)
+			if e, a := "test-func", aws.ToString(shadow.FunctionName); e != a {
+				t.Errorf("expect shadow function %q, got %q", e, a)
+			}
+			if e, a := tc.expectAlias, aws.ToString(shadow.Qualifier); e != a {
+				t.Errorf("expect shadow alias %q, got %q", e, a)
+			}
+			if client.input == nil {
+				t.Fatal("primary function was not invoked")
+			}
+			if client.input.InvocationType == types.InvocationTypeEvent {
+				t.Error("primary function must be invoked synchronously")
+			}
+			if !bytes.Equal(client.input.Payload, shadow.Payload) {
+				t.Errorf("shadow payload must be a copy of the request\nprimary: %s\nshadow: %s", client.input.Payload, shadow.Payload)
+			}
+		})
+	}
+}
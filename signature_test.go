@@ -0,0 +1,205 @@
+package lamux_test
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+// sign returns the signature of the request to http://test.example.net/.
+func sign(secret, ts, method, body string) string {
+	return signRequest(secret, ts, method, "test.example.net", "/", body)
+}
+
+func signRequest(secret, ts, method, host, uri, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{ts, method, host, uri, body}, ".")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestSignature(t *testing.T) {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	stale := strconv.FormatInt(time.Now().Add(-10*time.Minute).Unix(), 10)
+	cases := []struct {
+		name      string
+		method    string
+		body      string
+		timestamp string
+		signature string
+		code      int
+	}{
+		{"valid", "POST", `{"foo":"bar"}`, now, sign("secret", now, "POST", `{"foo":"bar"}`), http.StatusOK},
+		{"valid without body", "GET", "", now, sign("secret", now, "GET", ""), http.StatusOK},
+		{"missing signature", "POST", `{"foo":"bar"}`, now, "", http.StatusUnauthorized},
+		{"missing timestamp", "POST", `{"foo":"bar"}`, "", sign("secret", now, "POST", `{"foo":"bar"}`), http.StatusUnauthorized},
+		{"invalid timestamp", "POST", `{"foo":"bar"}`, "yesterday", sign("secret", "yesterday", "POST", `{"foo":"bar"}`), http.StatusUnauthorized},
+		{"stale timestamp", "POST", `{"foo":"bar"}`, stale, sign("secret", stale, "POST", `{"foo":"bar"}`), http.StatusUnauthorized},
+		{"tampered body", "POST", `{"foo":"baz"}`, now, sign("secret", now, "POST", `{"foo":"bar"}`), http.StatusUnauthorized},
+		{"wrong secret", "POST", `{"foo":"bar"}`, now, sign("other", now, "POST", `{"foo":"bar"}`), http.StatusUnauthorized},
+		{"not hex", "POST", `{"foo":"bar"}`, now, "not-hex", http.StatusUnauthorized},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:    "test-func",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+				HealthCheckPath: "/healthz",
+				SignatureConfig: lamux.SignatureConfig{
+					SignatureSecret:          "secret",
+					SignatureHeader:          "X-Lamux-Signature",
+					SignatureTimestampHeader: "X-Lamux-Timestamp",
+					SignatureMaxClockSkew:    5 * time.Minute,
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client := &mockClient{code: 200}
+			app.SetTestClient(client)
+			r := httptest.NewRequest(tc.method, "http://test.example.net/", strings.NewReader(tc.body))
+			if tc.timestamp != "" {
+				r.Header.Set("X-Lamux-Timestamp", tc.timestamp)
+			}
+			if tc.signature != "" {
+				r.Header.Set("X-Lamux-Signature", tc.signature)
+			}
+			w := httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, r)
+			if e, a := tc.code, w.Code; e != a {
+				t.Errorf("expect %d, got %d", e, a)
+			}
+			if tc.code != http.StatusOK && len(client.invoked()) > 0 {
+				t.Error("function must not be invoked")
+			}
+
+			// the health check endpoint is exempt
+			w = httptest.NewRecorder()
+			app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/healthz", nil))
+			if e, a := http.StatusOK, w.Code; e != a {
+				t.Errorf("health check: expect %d, got %d", e, a)
+			}
+		})
+	}
+}
+
+func TestSignatureCacheBypass(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		CacheEnabled:    true,
+		CacheDefaultTTL: time.Minute,
+		CacheMaxBytes:   1024 * 1024,
+		SignatureConfig: lamux.SignatureConfig{
+			SignatureSecret:          "secret",
+			SignatureHeader:          "X-Lamux-Signature",
+			SignatureTimestampHeader: "X-Lamux-Timestamp",
+			SignatureMaxClockSkew:    5 * time.Minute,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{code: 200}
+	app.SetTestClient(client)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	// responses to authenticated requests are never cached
+	for _, tc := range []struct {
+		signature string
+		code      int
+		cache     string
+	}{
+		{sign("secret", now, "GET", ""), http.StatusOK, ""},
+		{sign("other", now, "GET", ""), http.StatusUnauthorized, ""},
+		{sign("secret", now, "GET", ""), http.StatusOK, ""},
+	} {
+		r := httptest.NewRequest("GET", "http://test.example.net/", nil)
+		r.Header.Set("X-Lamux-Timestamp", now)
+		r.Header.Set("X-Lamux-Signature", tc.signature)
+		w := httptest.NewRecorder()
+		app.Handler().ServeHTTP(w, r)
+		if e, a := tc.code, w.Code; e != a {
+			t.Errorf("expect %d, got %d", e, a)
+		}
+		if e, a := tc.cache, w.Header().Get("X-Lamux-Cache"); e != a {
+			t.Errorf("expect X-Lamux-Cache %q, got %q", e, a)
+		}
+	}
+	if e, a := 2, len(client.invoked()); e != a {
+		t.Errorf("expect %d invocations, got %d", e, a)
+	}
+}
+
+func TestSignatureReplay(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "*",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		SignatureConfig: lamux.SignatureConfig{
+			SignatureSecret:          "secret",
+			SignatureHeader:          "X-Lamux-Signature",
+			SignatureTimestampHeader: "X-Lamux-Timestamp",
+			SignatureMaxClockSkew:    5 * time.Minute,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200})
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := signRequest("secret", now, "GET", "test-test-func.example.net", "/public?x=1", "")
+	for _, tc := range []struct {
+		method string
+		url    string
+		code   int
+	}{
+		{"GET", "http://test-test-func.example.net/public?x=1", http.StatusOK},
+		{"GET", "http://TEST-test-func.example.net/public?x=1", http.StatusOK},
+		{"GET", "http://test-test-func.example.net/admin", http.StatusUnauthorized},
+		{"GET", "http://test-test-func.example.net/public?x=2", http.StatusUnauthorized},
+		{"DELETE", "http://test-test-func.example.net/public?x=1", http.StatusUnauthorized},
+		{"GET", "http://test-other-func.example.net/public?x=1", http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(tc.method, tc.url, nil)
+		r.Header.Set("X-Lamux-Timestamp", now)
+		r.Header.Set("X-Lamux-Signature", signature)
+		w := httptest.NewRecorder()
+		app.Handler().ServeHTTP(w, r)
+		if e, a := tc.code, w.Code; e != a {
+			t.Errorf("%s %s: expect %d, got %d", tc.method, tc.url, e, a)
+		}
+	}
+}
+
+func TestSignatureValidation(t *testing.T) {
+	for name, modify := range map[string]func(*lamux.SignatureConfig){
+		"empty header":           func(sc *lamux.SignatureConfig) { sc.SignatureHeader = "" },
+		"empty timestamp header": func(sc *lamux.SignatureConfig) { sc.SignatureTimestampHeader = "" },
+		"zero clock skew":        func(sc *lamux.SignatureConfig) { sc.SignatureMaxClockSkew = 0 },
+	} {
+		cfg := &lamux.Config{
+			FunctionName:    "test-func",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: time.Second,
+			SignatureConfig: lamux.SignatureConfig{
+				SignatureSecret:          "secret",
+				SignatureHeader:          "X-Lamux-Signature",
+				SignatureTimestampHeader: "X-Lamux-Timestamp",
+				SignatureMaxClockSkew:    5 * time.Minute,
+			},
+		}
+		modify(&cfg.SignatureConfig)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package lamux
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	faviconPath   = "/favicon.ico"
+	robotsTxtPath = "/robots.txt"
+)
+
+// localPaths returns the paths answered locally without invoking functions.
+func (cfg *Config) localPaths() []string {
+	var paths []string
+	if cfg.ServeFaviconEmpty {
+		paths = append(paths, faviconPath)
+	}
+	if cfg.RobotsTxt != "" {
+		paths = append(paths, robotsTxtPath)
+	}
+	return paths
+}
+
+// isValidLocalPath reports whether p can be the path of a local endpoint other than the root.
+// Braces and spaces are rejected, as http.ServeMux parses them as wildcards and methods in patterns.
+func isValidLocalPath(p string) bool {
+	if !strings.HasPrefix(p, "/") || p == "/" || strings.ContainsAny(p, "{}") {
+		return false
+	}
+	return !strings.ContainsFunc(p, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) })
+}
+
+// withIPFilter applies the IP filter to h if configured.
+func (l *Lamux) withIPFilter(h http.Handler) http.Handler {
+	if l.ipFilter == nil {
+		return h
+	}
+	return l.ipFilterMiddleware(h)
+}
+
+// handleFavicon responds 204 No Content to /favicon.ico without invoking functions.
+func (l *Lamux) handleFavicon(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleRobotsTxt responds Config.RobotsTxt to /robots.txt without invoking functions.
+func (l *Lamux) handleRobotsTxt(w http.ResponseWriter, r *http.Request) {
+	body := l.Config.RobotsTxt
+	if !strings.HasSuffix(body, "\n") {
+		body += "\n"
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write([]byte(body))
+	}
+}
@@ -0,0 +1,122 @@
+package lamux_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func TestServeFaviconAndRobotsTxt(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:      "test-func",
+		DomainSuffix:      "example.net",
+		UpstreamTimeout:   time.Second,
+		ServeFaviconEmpty: true,
+		RobotsTxt:         "User-agent: *\nDisallow: /",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client := &mockClient{code: 200}
+	app.SetTestClient(client)
+	handler := app.Handler()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/favicon.ico", nil))
+	if w.Code != http.StatusNoContent {
+		t.Errorf("favicon: expect %d, got %d", http.StatusNoContent, w.Code)
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("favicon: unexpected body %q", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/robots.txt", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("robots.txt: expect %d, got %d", http.StatusOK, w.Code)
+	}
+	if e, a := "User-agent: *\nDisallow: /\n", w.Body.String(); e != a {
+		t.Errorf("robots.txt: expect body %q, got %q", e, a)
+	}
+	if e, a := "text/plain; charset=utf-8", w.Header().Get("Content-Type"); e != a {
+		t.Errorf("robots.txt: expect content type %q, got %q", e, a)
+	}
+	if n := len(client.invoked()); n != 0 {
+		t.Fatalf("expect no invocations, got %d", n)
+	}
+
+	// other paths still route to functions
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/favicon.png", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expect %d, got %d", http.StatusOK, w.Code)
+	}
+	if n := len(client.invoked()); n != 1 {
+		t.Errorf("expect 1 invocation, got %d", n)
+	}
+}
+
+func TestServeFaviconAndRobotsTxtDisabled(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	client := &mockClient{code: 200}
+	app.SetTestClient(client)
+	handler := app.Handler()
+	for _, path := range []string{"/favicon.ico", "/robots.txt"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net"+path, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expect %d, got %d", path, http.StatusOK, w.Code)
+		}
+	}
+	if n := len(client.invoked()); n != 2 {
+		t.Errorf("expect 2 invocations, got %d", n)
+	}
+}
+
+func TestLocalPathsValidation(t *testing.T) {
+	cfg := &lamux.Config{
+		FunctionName:      "test-func",
+		DomainSuffix:      "example.net",
+		UpstreamTimeout:   time.Second,
+		HealthCheckPath:   "/favicon.ico",
+		ServeFaviconEmpty: true,
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expect error for health check path conflicting with favicon")
+	}
+	cfg.ServeFaviconEmpty = false
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLocalPathsPatternValidation(t *testing.T) {
+	for _, path := range []string{"/health check", "/healthz\t", "/{path}", "/healthz{", "/healthz/}"} {
+		for name, modify := range map[string]func(*lamux.Config){
+			"health check": func(cfg *lamux.Config) { cfg.HealthCheckPath = path },
+			"readiness":    func(cfg *lamux.Config) { cfg.ReadinessCheckPath = path },
+			"metrics":      func(cfg *lamux.Config) { cfg.MetricsEnabled, cfg.MetricsPath = true, path },
+		} {
+			cfg := &lamux.Config{
+				FunctionName:    "test-func",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+				HealthCheckPath: "/healthz",
+			}
+			modify(cfg)
+			if err := cfg.Validate(); err == nil {
+				t.Errorf("%s path %q: expected error", name, path)
+			}
+		}
+	}
+}
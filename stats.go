@@ -0,0 +1,73 @@
+package lamux
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// invokeStatsSize is the number of recent invocations kept for latency stats.
+const invokeStatsSize = 1024
+
+type invokeStats struct {
+	mu          sync.Mutex
+	durations   []time.Duration
+	next        int
+	lastSuccess time.Time
+}
+
+func newInvokeStats() *invokeStats {
+	return &invokeStats{
+		durations: make([]time.Duration, 0, invokeStatsSize),
+	}
+}
+
+func (s *invokeStats) record(d time.Duration, success bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.durations) < invokeStatsSize {
+		s.durations = append(s.durations, d)
+	} else {
+		s.durations[s.next] = d
+	}
+	s.next = (s.next + 1) % invokeStatsSize
+	if success {
+		s.lastSuccess = time.Now()
+	}
+}
+
+type invokeStatsSnapshot struct {
+	Count       int        `json:"count"`
+	P50         float64    `json:"p50"`
+	P95         float64    `json:"p95"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+}
+
+func (s *invokeStats) snapshot() *invokeStatsSnapshot {
+	s.mu.Lock()
+	sorted := slices.Clone(s.durations)
+	lastSuccess := s.lastSuccess
+	s.mu.Unlock()
+
+	snap := &invokeStatsSnapshot{Count: len(sorted)}
+	if !lastSuccess.IsZero() {
+		snap.LastSuccess = &lastSuccess
+	}
+	if len(sorted) == 0 {
+		return snap
+	}
+	slices.Sort(sorted)
+	snap.P50 = percentile(sorted, 0.50).Seconds()
+	snap.P95 = percentile(sorted, 0.95).Seconds()
+	return snap
+}
+
+// percentile returns the nearest-rank percentile of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(float64(len(sorted))*p+0.5) - 1
+	i = max(0, min(i, len(sorted)-1))
+	return sorted[i]
+}
@@ -0,0 +1,113 @@
+package lamux
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/fujiwara/ridge"
+)
+
+// streamChunkSize is the size of chunks to write and flush streaming responses.
+const streamChunkSize = 32 * 1024
+
+// writeResponse writes the response decoded by decodeResponseBody, and returns the bytes of the body written.
+// When threshold is positive, bodies up to threshold bytes are written with Content-Length,
+// and larger bodies (or any bodies when chunked is true) are streamed in chunks flushed to the client one by one.
+func writeResponse(w http.ResponseWriter, res *ridge.Response, threshold int64, chunked bool) (int64, error) {
+	if threshold <= 0 {
+		n, err := res.WriteTo(w)
+		if err != nil {
+			return n, fmt.Errorf("failed to write response: %w", err)
+		}
+		return n, nil
+	}
+	if !chunked && int64(len(res.Body)) <= threshold {
+		setResponseHeader(res, "Content-Length", strconv.Itoa(len(res.Body)))
+		n, err := res.WriteTo(w)
+		if err != nil {
+			return n, fmt.Errorf("failed to write response: %w", err)
+		}
+		return n, nil
+	}
+
+	deleteResponseHeader(res, "Content-Length")
+	head := *res
+	head.Body = ""
+	if _, err := head.WriteTo(w); err != nil {
+		return 0, fmt.Errorf("failed to write response: %w", err)
+	}
+	rc := http.NewResponseController(w)
+	var written int64
+	for body := res.Body; body != ""; {
+		n, err := w.Write([]byte(body[:min(len(body), streamChunkSize)]))
+		written += int64(n)
+		if err != nil {
+			return written, fmt.Errorf("failed to write response: %w", err)
+		}
+		if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return written, fmt.Errorf("failed to flush response: %w", err)
+		}
+		body = body[n:]
+	}
+	return written, nil
+}
+
+const eventStreamContentType = "text/event-stream"
+
+// isEventStream reports whether the content type is of Server-Sent Events.
+func isEventStream(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mt == eventStreamContentType
+}
+
+// setEventStreamHeaders sets the headers to prevent proxies and clients from buffering
+// or caching Server-Sent Events.
+func setEventStreamHeaders(h http.Header) {
+	if h.Get("Cache-Control") == "" {
+		h.Set("Cache-Control", "no-cache")
+	}
+	h.Set("X-Accel-Buffering", "no")
+	h.Del("Content-Length")
+}
+
+// setEventStreamResponseHeaders is setEventStreamHeaders for the response of functions.
+func setEventStreamResponseHeaders(res *ridge.Response) {
+	if responseHeader(res, "Cache-Control") == "" {
+		setResponseHeader(res, "Cache-Control", "no-cache")
+	}
+	setResponseHeader(res, "X-Accel-Buffering", "no")
+	deleteResponseHeader(res, "Content-Length")
+}
+
+// copyEventStream copies the body of Server-Sent Events to the client, flushing each read as soon as possible.
+func copyEventStream(w http.ResponseWriter, body io.Reader) (int64, error) {
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return 0, fmt.Errorf("failed to flush response: %w", err)
+	}
+	buf := make([]byte, streamChunkSize)
+	var written int64
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			m, werr := w.Write(buf[:n])
+			written += int64(m)
+			if werr != nil {
+				return written, fmt.Errorf("failed to write response: %w", werr)
+			}
+			if ferr := rc.Flush(); ferr != nil && !errors.Is(ferr, http.ErrNotSupported) {
+				return written, fmt.Errorf("failed to flush response: %w", ferr)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return written, nil
+		}
+		if err != nil {
+			return written, fmt.Errorf("failed to read response: %w", err)
+		}
+	}
+}
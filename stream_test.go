@@ -0,0 +1,219 @@
+package lamux_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func TestStreamThresholdBytes(t *testing.T) {
+	cases := []struct {
+		name          string
+		threshold     int64
+		bodySize      int
+		expectChunked bool
+	}{
+		{name: "small", threshold: 64 * 1024, bodySize: 10 * 1024},
+		{name: "large", threshold: 64 * 1024, bodySize: 100 * 1024, expectChunked: true},
+		{name: "threshold", threshold: 64 * 1024, bodySize: 64 * 1024},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:         "test-func",
+				DomainSuffix:         "example.net",
+				UpstreamTimeout:      time.Second,
+				StreamThresholdBytes: tc.threshold,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			body := strings.Repeat("a", tc.bodySize)
+			payload, _ := json.Marshal(map[string]any{
+				"statusCode": 200,
+				"headers":    map[string]string{"content-type": "text/plain", "content-length": "1"},
+				"body":       body,
+			})
+			app.SetTestClient(&mockClient{code: 200, payload: payload})
+			ts := httptest.NewServer(app.Handler())
+			defer ts.Close()
+
+			req, _ := http.NewRequest("GET", ts.URL+"/", nil)
+			req.Host = "test.example.net"
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			b, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != body {
+				t.Errorf("unexpected body length %d", len(b))
+			}
+			if tc.expectChunked {
+				if len(res.TransferEncoding) != 1 || res.TransferEncoding[0] != "chunked" {
+					t.Errorf("expect chunked, got %v", res.TransferEncoding)
+				}
+				if res.ContentLength != -1 {
+					t.Errorf("expect no content-length, got %d", res.ContentLength)
+				}
+			} else {
+				if e, a := int64(tc.bodySize), res.ContentLength; e != a {
+					t.Errorf("expect content-length %d, got %d", e, a)
+				}
+			}
+		})
+	}
+}
+
+func TestUpstreamTransferEncoding(t *testing.T) {
+	cases := []struct {
+		name          string
+		threshold     int64
+		expectChunked bool
+	}{
+		{name: "buffered"},
+		{name: "streaming", threshold: 64 * 1024, expectChunked: true},
+	}
+	body := "hello world"
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			app, err := lamux.NewLamux(&lamux.Config{
+				FunctionName:         "test-func",
+				DomainSuffix:         "example.net",
+				UpstreamTimeout:      time.Second,
+				StreamThresholdBytes: tc.threshold,
+				// Transfer-Encoding is removed even if it is not listed as a hop-by-hop header
+				HopByHopHeaders: []string{"Connection"},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			payload, _ := json.Marshal(map[string]any{
+				"statusCode": 200,
+				"headers":    map[string]string{"content-type": "text/plain", "transfer-encoding": "Chunked", "content-length": "3"},
+				"body":       body,
+			})
+			app.SetTestClient(&mockClient{code: 200, payload: payload})
+			ts := httptest.NewServer(app.Handler())
+			defer ts.Close()
+
+			req, _ := http.NewRequest("GET", ts.URL+"/", nil)
+			req.Host = "test.example.net"
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			b, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("expect 200, got %d", res.StatusCode)
+			}
+			if string(b) != body {
+				t.Errorf("expect body %q, got %q", body, b)
+			}
+			if tc.expectChunked {
+				if len(res.TransferEncoding) != 1 || res.TransferEncoding[0] != "chunked" {
+					t.Errorf("expect chunked, got %v", res.TransferEncoding)
+				}
+			} else if res.ContentLength != int64(len(body)) {
+				t.Errorf("expect content-length %d, got %d", len(body), res.ContentLength)
+			}
+		})
+	}
+}
+
+func TestSSEPassthroughFunctionURL(t *testing.T) {
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, "data: first\n\n")
+		w.(http.Flusher).Flush()
+		<-release
+		io.WriteString(w, "data: second\n\n")
+	}))
+	defer upstream.Close()
+	defer close(release)
+
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:        "test-func",
+		DomainSuffix:        "example.net",
+		UpstreamTimeout:     5 * time.Second,
+		Backend:             "function-url",
+		FunctionURLTemplate: upstream.URL,
+		SSEPassthrough:      true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(app.Handler())
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL+"/events", nil)
+	req.Host = "test.example.net"
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	for k, v := range map[string]string{"Cache-Control": "no-cache", "X-Accel-Buffering": "no"} {
+		if e, a := v, res.Header.Get(k); e != a {
+			t.Errorf("expect %s: %q, got %q", k, e, a)
+		}
+	}
+
+	// the first event arrives before the upstream finishes the response
+	done := make(chan string)
+	go func() {
+		buf := make([]byte, len("data: first\n\n"))
+		io.ReadFull(res.Body, buf)
+		done <- string(buf)
+	}()
+	select {
+	case got := <-done:
+		if e, a := "data: first\n\n", got; e != a {
+			t.Errorf("expect %q, got %q", e, a)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the first event is not flushed")
+	}
+}
+
+func TestSSEPassthroughInvoke(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		SSEPassthrough:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetTestClient(&mockClient{code: 200, payload: []byte(`{"statusCode":200,"headers":{"content-type":"text/event-stream","content-length":"26"},"body":"data: first\n\ndata: second\n\n"}`)})
+
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/events", nil))
+	if e, a := http.StatusOK, w.Code; e != a {
+		t.Fatalf("expect %d, got %d", e, a)
+	}
+	for k, v := range map[string]string{"Cache-Control": "no-cache", "X-Accel-Buffering": "no", "Content-Length": ""} {
+		if e, a := v, w.Header().Get(k); e != a {
+			t.Errorf("expect %s: %q, got %q", k, e, a)
+		}
+	}
+	if e, a := "data: first\n\ndata: second\n\n", w.Body.String(); e != a {
+		t.Errorf("expect body %q, got %q", e, a)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package lamux
+
+import (
+	"errors"
+	"io"
+)
+
+func openSyslog(network, raddr string) (io.Writer, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
@@ -0,0 +1,12 @@
+//go:build !windows && !plan9
+
+package lamux
+
+import (
+	"io"
+	"log/syslog"
+)
+
+func openSyslog(network, raddr string) (io.Writer, error) {
+	return syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, "lamux")
+}
@@ -0,0 +1,88 @@
+package lamux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
+)
+
+// validateFunctions checks that the function and the qualifiers in the configuration exist.
+// It is skipped in the wildcard function mode, where function names come from hosts.
+func (l *Lamux) validateFunctions(ctx context.Context) error {
+	cfg := l.Config
+	if cfg.FunctionName == "*" {
+		slog.WarnContext(ctx, "skip validating functions in the wildcard function mode")
+		return nil
+	}
+	arn, err := l.functionARN(ctx, cfg.FunctionName)
+	if err != nil {
+		return err
+	}
+	if _, err := l.lambdaClient.GetFunction(ctx, &lambda.GetFunctionInput{FunctionName: aws.String(arn)}); err != nil {
+		var enf *types.ResourceNotFoundException
+		if errors.As(err, &enf) {
+			return fmt.Errorf("function %s is not found: %w", cfg.FunctionName, err)
+		}
+		return fmt.Errorf("failed to get function %s: %w", cfg.FunctionName, err)
+	}
+
+	aliases := make(map[string]bool)
+	p := lambda.NewListAliasesPaginator(l.lambdaClient, &lambda.ListAliasesInput{FunctionName: aws.String(arn)})
+	for p.HasMorePages() {
+		out, err := p.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list aliases of %s: %w", cfg.FunctionName, err)
+		}
+		for _, a := range out.Aliases {
+			aliases[aws.ToString(a.Name)] = true
+		}
+	}
+	var missing []string
+	for _, q := range cfg.configuredQualifiers() {
+		if aliases[q] {
+			continue
+		}
+		if versionRegexp.MatchString(q) {
+			_, err := l.lambdaClient.GetFunction(ctx, &lambda.GetFunctionInput{FunctionName: aws.String(arn), Qualifier: aws.String(q)})
+			if err == nil {
+				continue
+			}
+			var enf *types.ResourceNotFoundException
+			if !errors.As(err, &enf) {
+				return fmt.Errorf("failed to get function %s:%s: %w", cfg.FunctionName, q, err)
+			}
+		}
+		missing = append(missing, q)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("qualifiers of function %s are not found: %s", cfg.FunctionName, strings.Join(missing, ", "))
+	}
+	slog.InfoContext(ctx, "validated functions", "function_name", cfg.FunctionName, "aliases", len(aliases))
+	return nil
+}
+
+// configuredQualifiers returns the sorted qualifiers which the configuration routes to:
+// the values of AliasMap, the mapped DefaultAlias and Qualifier.
+func (cfg *Config) configuredQualifiers() []string {
+	var qs []string
+	for _, v := range cfg.AliasMap {
+		qs = append(qs, v)
+	}
+	if cfg.DefaultAlias != "" {
+		if q, err := cfg.MapAlias(cfg.DefaultAlias); err == nil {
+			qs = append(qs, q)
+		}
+	}
+	if cfg.Qualifier != "" {
+		qs = append(qs, cfg.Qualifier)
+	}
+	slices.Sort(qs)
+	return slices.Compact(qs)
+}
@@ -0,0 +1,77 @@
+package lamux_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+)
+
+func TestValidateFunctions(t *testing.T) {
+	cases := []struct {
+		name   string
+		modify func(*lamux.Config)
+		err    string
+	}{
+		{
+			name: "wildcard",
+			modify: func(cfg *lamux.Config) {
+				cfg.FunctionName = "*"
+			},
+		},
+		{
+			name:   "no aliases",
+			modify: func(cfg *lamux.Config) {},
+		},
+		{
+			name: "existing aliases and versions",
+			modify: func(cfg *lamux.Config) {
+				cfg.AliasMap = map[string]string{"current": "test", "next": "prod", "old": "1"}
+				cfg.DefaultAlias = "current"
+				cfg.Qualifier = "staging"
+			},
+		},
+		{
+			name: "function not found",
+			modify: func(cfg *lamux.Config) {
+				cfg.FunctionName = "unknown-func"
+			},
+			err: "function unknown-func is not found",
+		},
+		{
+			name: "qualifiers not found",
+			modify: func(cfg *lamux.Config) {
+				cfg.AliasMap = map[string]string{"current": "test", "next": "canary", "old": "2"}
+				cfg.DefaultAlias = "beta"
+			},
+			err: "qualifiers of function test-func are not found: 2, beta, canary",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := &lamux.Config{
+				FunctionName:    "test-func",
+				DomainSuffix:    "example.net",
+				UpstreamTimeout: time.Second,
+			}
+			tc.modify(cfg)
+			app, err := lamux.NewLamux(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			app.SetTestClient(&mockClient{qualifiers: []string{"prod", "staging"}})
+			err = app.ValidateFunctions(context.Background())
+			if tc.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("expect error %q, got %v", tc.err, err)
+			}
+		})
+	}
+}
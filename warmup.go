@@ -0,0 +1,107 @@
+package lamux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/lambda"
+)
+
+// warmupPayload is the payload of warmup invocations.
+// Functions can recognize it by the source field and return immediately.
+var warmupPayload = []byte(`{"source":"lamux.warmup"}`)
+
+type warmupTarget struct {
+	functionName string
+	alias        string
+}
+
+// parseWarmupTarget parses a warmup target in the form of {function}:{alias}.
+func parseWarmupTarget(s string) (warmupTarget, error) {
+	functionName, alias, ok := strings.Cut(s, ":")
+	if !ok {
+		return warmupTarget{}, fmt.Errorf("invalid warmup target %s (must be {function}:{alias})", s)
+	}
+	if !functionNameRegexp.MatchString(functionName) {
+		return warmupTarget{}, fmt.Errorf("invalid function name in warmup target %s (%s allowed)", s, functionNameRegexp.String())
+	}
+	if !isValidQualifier(alias) {
+		return warmupTarget{}, fmt.Errorf("invalid alias in warmup target %s (%s or %s allowed)", s, versionRegexp.String(), aliasRegexp.String())
+	}
+	return warmupTarget{functionName: functionName, alias: alias}, nil
+}
+
+// runWarmup invokes the warmup targets periodically until ctx is canceled.
+// Warmup invocations are not recorded as requests nor invocations in metrics.
+func (l *Lamux) runWarmup(ctx context.Context) {
+	targets := make([]warmupTarget, 0, len(l.Config.WarmupTargets))
+	for _, s := range l.Config.WarmupTargets {
+		t, err := parseWarmupTarget(s)
+		if err != nil {
+			// validated already
+			slog.WarnContext(ctx, "skip warmup target", "error", err)
+			continue
+		}
+		targets = append(targets, t)
+	}
+	if len(targets) == 0 {
+		return
+	}
+	ticker := time.NewTicker(l.Config.WarmupInterval)
+	defer ticker.Stop()
+	for {
+		for _, t := range targets {
+			l.warmup(ctx, t)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (l *Lamux) warmup(ctx context.Context, t warmupTarget) {
+	ctx, cancel := context.WithTimeout(ctx, l.Config.FunctionTimeout(t.functionName))
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "Warmup")
+	defer span.End()
+
+	start := time.Now()
+	var resp *lambda.InvokeOutput
+	arn, err := l.functionARN(ctx, t.functionName)
+	if err == nil {
+		resp, err = l.lambdaClient.Invoke(ctx, &lambda.InvokeInput{
+			FunctionName: aws.String(arn),
+			Qualifier:    aws.String(t.alias),
+			Payload:      warmupPayload,
+		}, l.Config.invokeOptions)
+	}
+	elapsed := time.Since(start)
+	if err == nil && resp.FunctionError != nil {
+		err = fmt.Errorf("%w: %s", errFunctionError, aws.ToString(resp.FunctionError))
+	}
+	l.metrics.observeWarmup(t.functionName, t.alias, err)
+	l.otelMetrics.observeWarmup(ctx, t.functionName, t.alias, err)
+	if err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return // shutting down
+		}
+		slog.WarnContext(ctx, "failed to warmup",
+			"warmup_function_name", t.functionName,
+			"warmup_alias", t.alias,
+			"error", err,
+		)
+		return
+	}
+	slog.InfoContext(ctx, "warmup",
+		"warmup_function_name", t.functionName,
+		"warmup_alias", t.alias,
+		"elapsed", elapsed.Seconds(),
+	)
+}
@@ -0,0 +1,109 @@
+package lamux_test
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/fujiwara/lamux"
+)
+
+func TestWarmup(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		MetricsEnabled:  true,
+		MetricsPath:     "/metrics",
+		WarmupTargets:   []string{"test-func:test", "other-func:live"},
+		WarmupInterval:  20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{code: 200}
+	app.SetTestClient(client)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		app.RunWarmup(ctx)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for len(client.invoked()) < 4 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("warmup must stop when the context is canceled")
+	}
+
+	inputs := client.invoked()
+	if len(inputs) < 4 {
+		t.Fatalf("expect at least 4 warmup invocations, got %d", len(inputs))
+	}
+	for i, input := range inputs[:4] {
+		expectFunction, expectAlias := "test-func", "test"
+		if i%2 == 1 {
+			expectFunction, expectAlias = "other-func", "live"
+		}
+		if e, a := expectFunction, aws.ToString(input.FunctionName); e != a {
+			t.Errorf("expect function %s, got %s", e, a)
+		}
+		if e, a := expectAlias, aws.ToString(input.Qualifier); e != a {
+			t.Errorf("expect alias %s, got %s", e, a)
+		}
+		if e, a := `{"source":"lamux.warmup"}`, string(input.Payload); e != a {
+			t.Errorf("expect payload %s, got %s", e, a)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, httptest.NewRequest("GET", "http://localhost/metrics", nil))
+	b, _ := io.ReadAll(w.Body)
+	body := string(b)
+	for _, expect := range []string{
+		`lamux_warmup_invokes_total{alias="test",function_name="test-func",result="success"}`,
+		`lamux_warmup_invokes_total{alias="live",function_name="other-func",result="not_found"}`,
+	} {
+		if !strings.Contains(body, expect) {
+			t.Errorf("metrics must contain %q", expect)
+		}
+	}
+	for _, unexpected := range []string{"lamux_requests_total{", "lamux_invoke_duration_seconds_count{", "lamux_invoke_errors_total{"} {
+		if strings.Contains(body, unexpected) {
+			t.Errorf("warmup must not be recorded as %s", unexpected)
+		}
+	}
+}
+
+func TestWarmupTargetsValidation(t *testing.T) {
+	for _, target := range []string{"test-func", "test_func:test", "test-func:", ":test", "test-func:te-st"} {
+		cfg := &lamux.Config{
+			FunctionName:    "test-func",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: time.Second,
+			WarmupTargets:   []string{target},
+			WarmupInterval:  time.Minute,
+		}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("expected error for warmup target %q", target)
+		}
+	}
+	cfg := &lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		WarmupTargets:   []string{"test-func:1"},
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for zero warmup interval")
+	}
+}
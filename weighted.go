@@ -0,0 +1,153 @@
+package lamux
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// weightedAliasHeader is the response header of the alias chosen by the weighted routing.
+const weightedAliasHeader = "X-Lamux-Alias"
+
+// weightedAliases chooses an alias of the function by weighted random.
+type weightedAliases struct {
+	aliases []string
+	bounds  []int // cumulative weights
+}
+
+func newWeightedAliases(weights map[string]int) *weightedAliases {
+	w := &weightedAliases{}
+	for alias := range weights {
+		w.aliases = append(w.aliases, alias)
+	}
+	slices.Sort(w.aliases)
+	total := 0
+	for _, alias := range w.aliases {
+		total += weights[alias]
+		w.bounds = append(w.bounds, total)
+	}
+	return w
+}
+
+// pick returns the alias for n in [0, total weight).
+func (w *weightedAliases) pick(n int) string {
+	i, _ := slices.BinarySearch(w.bounds, n+1)
+	return w.aliases[i]
+}
+
+// has reports whether the alias has a positive weight.
+func (w *weightedAliases) has(alias string) bool {
+	i := slices.Index(w.aliases, alias)
+	if i < 0 {
+		return false
+	}
+	if i == 0 {
+		return w.bounds[0] > 0
+	}
+	return w.bounds[i] > w.bounds[i-1]
+}
+
+func (w *weightedAliases) choose() string {
+	return w.pick(rand.IntN(w.bounds[len(w.bounds)-1]))
+}
+
+// newWeightedRoutes returns the weighted aliases by "function" or "function:alias" keys.
+func newWeightedRoutes(cfg *Config) map[string]*weightedAliases {
+	if len(cfg.WeightedAliases) == 0 {
+		return nil
+	}
+	routes := make(map[string]*weightedAliases, len(cfg.WeightedAliases))
+	for key, weights := range cfg.WeightedAliases {
+		routes[key] = newWeightedAliases(weights)
+	}
+	return routes
+}
+
+// weightedAliases returns the rule for "function:alias" by the alias in the host name.
+func (t *routingTable) weightedAliases(functionName, alias string) (*weightedAliases, bool) {
+	w, ok := t.weighted[functionName+":"+alias]
+	return w, ok
+}
+
+// functionWeightedAliases returns the rule for "function" for the alias mapped by the alias map.
+// The rule is not applied if the alias is a target of the rule, so that the host name can pin the alias.
+func (t *routingTable) functionWeightedAliases(functionName, alias string) (*weightedAliases, bool) {
+	w, ok := t.weighted[functionName]
+	if !ok || slices.Contains(w.aliases, alias) {
+		return nil, false
+	}
+	return w, true
+}
+
+// chooseWeightedAlias chooses the alias by weighted random, or by the sticky cookie if WeightedAliasCookie is set.
+// The alias in the cookie is honored only if it has a positive weight, otherwise the weights are rolled again.
+// The newly chosen alias is set to the cookie, and the chosen alias is set to the response header.
+func (cfg *Config) chooseWeightedAlias(w http.ResponseWriter, r *http.Request, weighted *weightedAliases) string {
+	var alias string
+	if cfg.WeightedAliasCookie != "" {
+		if c, err := r.Cookie(cfg.WeightedAliasCookie); err == nil && weighted.has(c.Value) {
+			alias = c.Value
+		}
+	}
+	if alias == "" {
+		alias = weighted.choose()
+		if cfg.WeightedAliasCookie != "" {
+			cookie := &http.Cookie{
+				Name:     cfg.WeightedAliasCookie,
+				Value:    alias,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			}
+			if ttl := cfg.WeightedAliasCookieTTL; ttl > 0 {
+				cookie.MaxAge = int(ttl.Seconds())
+				cookie.Expires = time.Now().Add(ttl)
+			}
+			http.SetCookie(w, cookie)
+		}
+	}
+	w.Header().Set(weightedAliasHeader, alias)
+	return alias
+}
+
+func (cfg *Config) validateWeightedAliases() error {
+	if name := cfg.WeightedAliasCookie; name != "" {
+		if err := (&http.Cookie{Name: name, Value: "x"}).Valid(); err != nil {
+			return fmt.Errorf("invalid weighted alias cookie name %s: %w", name, err)
+		}
+	}
+	if cfg.WeightedAliasCookieTTL < 0 {
+		return fmt.Errorf("weighted alias cookie TTL must not be negative")
+	}
+	for key, weights := range cfg.WeightedAliases {
+		functionName, alias, hasAlias := strings.Cut(key, ":")
+		if functionName == "" {
+			return fmt.Errorf("invalid weighted aliases key %s (function or function:alias)", key)
+		}
+		if hasAlias && !cfg.hostAliasRegexp().MatchString(alias) {
+			return fmt.Errorf("invalid alias in weighted aliases key %s (%s allowed)", key, cfg.hostAliasRegexp().String())
+		}
+		if hasAlias {
+			if err := checkLowerAlias("alias in weighted aliases key", alias); err != nil {
+				return err
+			}
+		}
+		total := 0
+		for target, weight := range weights {
+			if !isValidQualifier(target) {
+				return fmt.Errorf("invalid alias %s in weighted aliases of %s (%s or %s allowed)", target, key, versionRegexp.String(), aliasRegexp.String())
+			}
+			if weight < 0 {
+				return fmt.Errorf("weight of %s in weighted aliases of %s must not be negative", target, key)
+			}
+			total += weight
+		}
+		if total <= 0 {
+			return fmt.Errorf("total weight of weighted aliases of %s must be positive", key)
+		}
+	}
+	return nil
+}
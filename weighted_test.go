@@ -0,0 +1,233 @@
+package lamux_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/fujiwara/lamux"
+)
+
+func newWeightedTestApp(t *testing.T, weighted map[string]map[string]int, cookie string) (http.Handler, *mockClient) {
+	t.Helper()
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:           "test-func",
+		DomainSuffix:           "example.net",
+		UpstreamTimeout:        time.Second,
+		WeightedAliases:        weighted,
+		WeightedAliasCookie:    cookie,
+		WeightedAliasCookieTTL: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{code: 200, qualifiers: []string{"stable", "canary", "prod", "1"}}
+	app.SetTestClient(client)
+	return app.Handler(), client
+}
+
+func TestWeightedAliases(t *testing.T) {
+	handler, client := newWeightedTestApp(t, map[string]map[string]int{
+		"test-func":      {"stable": 90, "canary": 10},
+		"test-func:prod": {"prod": 1, "canary": 0},
+	}, "")
+
+	counts := map[string]int{}
+	const n = 2000
+	for i := 0; i < n; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expect 200, got %d", w.Code)
+		}
+		invoked := aws.ToString(client.input.Qualifier)
+		if e, a := invoked, w.Header().Get("X-Lamux-Alias"); e != a {
+			t.Fatalf("expect X-Lamux-Alias %s, got %s", e, a)
+		}
+		counts[invoked]++
+	}
+	if c := counts["canary"]; c < n*5/100 || c > n*15/100 {
+		t.Errorf("expect about 10%% to canary, got %v", counts)
+	}
+	if e, a := n, counts["stable"]+counts["canary"]; e != a {
+		t.Errorf("expect only stable and canary, got %v", counts)
+	}
+
+	// the rule for function:alias takes precedence, and zero weights are never chosen
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://prod.example.net/", nil))
+		if e, a := "prod", aws.ToString(client.input.Qualifier); e != a {
+			t.Fatalf("expect %s, got %s", e, a)
+		}
+	}
+
+	// the host name pins a target of the rule for the function
+	for i := 0; i < 10; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://canary.example.net/", nil))
+		if e, a := "canary", aws.ToString(client.input.Qualifier); e != a {
+			t.Fatalf("expect %s, got %s", e, a)
+		}
+		if h := w.Header().Get("X-Lamux-Alias"); h != "" {
+			t.Fatalf("expect no X-Lamux-Alias for the pinned alias, got %s", h)
+		}
+	}
+}
+
+func TestWeightedAliasesStrictAlias(t *testing.T) {
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		AliasMap:        map[string]string{"www": "live", "beta": "canary"},
+		StrictAlias:     true,
+		WeightedAliases: map[string]map[string]int{"test-func": {"stable": 1, "canary": 0}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{code: 200, qualifiers: []string{"stable", "canary"}}
+	app.SetTestClient(client)
+	handler := app.Handler()
+
+	for host, expect := range map[string]string{"www": "stable", "beta": "canary"} {
+		client.input = nil
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "http://"+host+".example.net/", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: expect 200, got %d", host, w.Code)
+		}
+		if e, a := expect, aws.ToString(client.input.Qualifier); e != a {
+			t.Errorf("%s: expect %s, got %s", host, e, a)
+		}
+	}
+
+	// unknown aliases are rejected before the rule for the function
+	client.input = nil
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://unknown.example.net/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expect 404, got %d", w.Code)
+	}
+	if client.input != nil {
+		t.Error("unknown alias must not be invoked")
+	}
+}
+
+func TestWeightedAliasCookie(t *testing.T) {
+	handler, client := newWeightedTestApp(t, map[string]map[string]int{
+		"test-func": {"stable": 50, "canary": 50, "1": 0},
+	}, "lamux_alias")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "lamux_alias" {
+		t.Fatalf("expect the sticky cookie, got %v", cookies)
+	}
+	if e, a := 3600, cookies[0].MaxAge; e != a {
+		t.Errorf("expect Max-Age %d, got %d", e, a)
+	}
+	chosen := cookies[0].Value
+	if e, a := chosen, aws.ToString(client.input.Qualifier); e != a {
+		t.Fatalf("expect %s invoked, got %s", e, a)
+	}
+	for i := 0; i < 20; i++ {
+		r := httptest.NewRequest("GET", "http://test.example.net/", nil)
+		r.AddCookie(cookies[0])
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if e, a := chosen, aws.ToString(client.input.Qualifier); e != a {
+			t.Fatalf("expect sticky alias %s, got %s", e, a)
+		}
+		if len(w.Result().Cookies()) != 0 {
+			t.Error("the cookie must not be set again")
+		}
+	}
+
+	// unknown aliases and zero weights in cookies are not honored
+	for _, value := range []string{"unknown", "1"} {
+		r := httptest.NewRequest("GET", "http://test.example.net/", nil)
+		r.AddCookie(&http.Cookie{Name: "lamux_alias", Value: value})
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if a := aws.ToString(client.input.Qualifier); a == value {
+			t.Errorf("cookie %s must not be honored", value)
+		}
+		if len(w.Result().Cookies()) != 1 {
+			t.Errorf("cookie %s must be replaced", value)
+		}
+	}
+}
+
+func TestWeightedAliasWithoutCookie(t *testing.T) {
+	handler, _ := newWeightedTestApp(t, map[string]map[string]int{"test-func": {"stable": 1}}, "")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "http://test.example.net/", nil))
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("cookies must not be set without sticky mode, got %v", cookies)
+	}
+}
+
+func TestWeightedAliasesConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lamux.yaml")
+	if err := os.WriteFile(path, []byte(`
+function-name: test-func
+domain-suffix: example.net
+weighted-aliases:
+  test-func:
+    stable: 90
+    canary: 10
+`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := lamux.ParseConfig([]string{"--config", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, a := 10, cfg.WeightedAliases["test-func"]["canary"]; e != a {
+		t.Errorf("expect weight %d, got %d", e, a)
+	}
+}
+
+func TestWeightedAliasesValidation(t *testing.T) {
+	for name, weighted := range map[string]map[string]map[string]int{
+		"empty function":  {":prod": {"stable": 1}},
+		"invalid alias":   {"test-func:pr-od": {"stable": 1}},
+		"uppercase alias": {"test-func:Prod": {"stable": 1}},
+		"invalid target":  {"test-func": {"sta ble": 1}},
+		"negative weight": {"test-func": {"stable": 1, "canary": -1}},
+		"zero total":      {"test-func": {"stable": 0}},
+		"no targets":      {"test-func": {}},
+	} {
+		cfg := &lamux.Config{
+			FunctionName:    "test-func",
+			DomainSuffix:    "example.net",
+			UpstreamTimeout: time.Second,
+			WeightedAliases: weighted,
+		}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+	for name, modify := range map[string]func(*lamux.Config){
+		"invalid cookie name": func(cfg *lamux.Config) { cfg.WeightedAliasCookie = "lamux alias" },
+		"negative cookie TTL": func(cfg *lamux.Config) { cfg.WeightedAliasCookieTTL = -time.Second },
+	} {
+		cfg := &lamux.Config{
+			FunctionName:        "test-func",
+			DomainSuffix:        "example.net",
+			UpstreamTimeout:     time.Second,
+			WeightedAliasCookie: "lamux_alias",
+		}
+		modify(cfg)
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
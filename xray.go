@@ -0,0 +1,77 @@
+package lamux
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+
+	"go.opentelemetry.io/otel/propagation"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+const xrayTraceIDHeader = "X-Amzn-Trace-Id"
+
+// xrayPropagator propagates the trace context in the AWS X-Ray format.
+// e.g. X-Amzn-Trace-Id: Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1
+type xrayPropagator struct{}
+
+var _ propagation.TextMapPropagator = xrayPropagator{}
+
+func (xrayPropagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	sc := oteltrace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	traceID := sc.TraceID().String()
+	sampled := "0"
+	if sc.IsSampled() {
+		sampled = "1"
+	}
+	carrier.Set(xrayTraceIDHeader, "Root=1-"+traceID[:8]+"-"+traceID[8:]+";Parent="+sc.SpanID().String()+";Sampled="+sampled)
+}
+
+func (xrayPropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	sc, ok := parseXrayTraceID(carrier.Get(xrayTraceIDHeader))
+	if !ok {
+		return ctx
+	}
+	return oteltrace.ContextWithRemoteSpanContext(ctx, sc)
+}
+
+func (xrayPropagator) Fields() []string {
+	return []string{xrayTraceIDHeader}
+}
+
+func parseXrayTraceID(header string) (oteltrace.SpanContext, bool) {
+	var cfg oteltrace.SpanContextConfig
+	for _, part := range strings.Split(header, ";") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "Root":
+			// 1-{8 hex digits of epoch}-{24 hex digits}
+			version, rest, _ := strings.Cut(v, "-")
+			epoch, id, _ := strings.Cut(rest, "-")
+			if version != "1" || len(epoch) != 8 || len(id) != 24 {
+				return oteltrace.SpanContext{}, false
+			}
+			b, err := hex.DecodeString(epoch + id)
+			if err != nil {
+				return oteltrace.SpanContext{}, false
+			}
+			copy(cfg.TraceID[:], b)
+		case "Parent":
+			b, err := hex.DecodeString(v)
+			if err != nil || len(b) != len(cfg.SpanID) {
+				return oteltrace.SpanContext{}, false
+			}
+			copy(cfg.SpanID[:], b)
+		case "Sampled":
+			if v == "1" {
+				cfg.TraceFlags = oteltrace.FlagsSampled
+			}
+		}
+	}
+	cfg.Remote = true
+	sc := oteltrace.NewSpanContext(cfg)
+	return sc, sc.IsValid()
+}
@@ -0,0 +1,139 @@
+package lamux_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fujiwara/lamux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+const xrayHeader = "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1"
+
+func TestXrayPropagatorExtract(t *testing.T) {
+	prop := lamux.NewPropagator(&lamux.TraceConfig{TracePropagators: []string{"xray"}})
+	cases := []struct {
+		header  string
+		valid   bool
+		sampled bool
+	}{
+		{header: xrayHeader, valid: true, sampled: true},
+		{header: "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=0", valid: true},
+		{header: "Sampled=1;Self=1-abc;Parent=53995c3f42cd8ad8;Root=1-5759e988-bd862e3fe1be46a994272793", valid: true, sampled: true},
+		{header: "Root=1-5759e988-bd862e3fe1be46a994272793"},
+		{header: "Root=2-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8"},
+		{header: "Root=1-5759e988-bd862e3fe1be46a99427279z;Parent=53995c3f42cd8ad8"},
+		{header: "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f"},
+		{header: ""},
+	}
+	for _, tc := range cases {
+		h := http.Header{}
+		h.Set("X-Amzn-Trace-Id", tc.header)
+		sc := oteltrace.SpanContextFromContext(prop.Extract(context.Background(), propagation.HeaderCarrier(h)))
+		if sc.IsValid() != tc.valid {
+			t.Errorf("%q: expect valid %v", tc.header, tc.valid)
+			continue
+		}
+		if !tc.valid {
+			continue
+		}
+		if e, a := "5759e988bd862e3fe1be46a994272793", sc.TraceID().String(); e != a {
+			t.Errorf("%q: expect trace id %s, got %s", tc.header, e, a)
+		}
+		if e, a := "53995c3f42cd8ad8", sc.SpanID().String(); e != a {
+			t.Errorf("%q: expect span id %s, got %s", tc.header, e, a)
+		}
+		if sc.IsSampled() != tc.sampled {
+			t.Errorf("%q: expect sampled %v", tc.header, tc.sampled)
+		}
+		if !sc.IsRemote() {
+			t.Errorf("%q: expect remote span context", tc.header)
+		}
+	}
+}
+
+func TestXrayPropagatorInject(t *testing.T) {
+	prop := lamux.NewPropagator(&lamux.TraceConfig{TracePropagators: []string{"xray"}})
+	h := http.Header{}
+	h.Set("X-Amzn-Trace-Id", xrayHeader)
+	ctx := prop.Extract(context.Background(), propagation.HeaderCarrier(h))
+
+	out := http.Header{}
+	prop.Inject(ctx, propagation.HeaderCarrier(out))
+	if e, a := xrayHeader, out.Get("X-Amzn-Trace-Id"); e != a {
+		t.Errorf("expect %s, got %s", e, a)
+	}
+}
+
+func TestTracePropagatorsValidation(t *testing.T) {
+	cfg := &lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		TraceConfig:     lamux.TraceConfig{TracePropagators: []string{"tracecontext", "b3"}},
+	}
+	if err := cfg.Validate(); err == nil {
+		t.Error("expected error for unsupported propagator")
+	}
+}
+
+func TestXrayPropagation(t *testing.T) {
+	sr := recordSpans()
+	orig := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(lamux.NewPropagator(&lamux.TraceConfig{TracePropagators: []string{"tracecontext", "xray"}}))
+	defer otel.SetTextMapPropagator(orig)
+
+	app, err := lamux.NewLamux(&lamux.Config{
+		FunctionName:    "test-func",
+		DomainSuffix:    "example.net",
+		UpstreamTimeout: time.Second,
+		TraceConfig:     lamux.TraceConfig{TraceStdout: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &mockClient{code: 200}
+	app.SetTestClient(client)
+	n := len(sr.Ended())
+	r := httptest.NewRequest("GET", "http://test.example.net/", nil)
+	r.Header.Set("X-Amzn-Trace-Id", xrayHeader)
+	w := httptest.NewRecorder()
+	app.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("expect 200, got %d", w.Code)
+	}
+
+	spans := endedSpansSince(sr, n, "/")
+	if len(spans) != 1 {
+		t.Fatalf("expect 1 server span, got %d", len(spans))
+	}
+	server := spans[0]
+	if e, a := "5759e988bd862e3fe1be46a994272793", server.SpanContext().TraceID().String(); e != a {
+		t.Errorf("server span must continue the X-Ray trace %s, got %s", e, a)
+	}
+	if e, a := "53995c3f42cd8ad8", server.Parent().SpanID().String(); e != a {
+		t.Errorf("expect parent span id %s, got %s", e, a)
+	}
+
+	var payload struct {
+		Headers map[string]string `json:"headers"`
+	}
+	if err := json.Unmarshal(client.input.Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	forwarded := payload.Headers["x-amzn-trace-id"]
+	expect := "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=" + server.SpanContext().SpanID().String() + ";Sampled=1"
+	if forwarded != expect {
+		t.Errorf("expect forwarded header %s, got %s", expect, forwarded)
+	}
+	if tp := payload.Headers["traceparent"]; !strings.Contains(tp, "5759e988bd862e3fe1be46a994272793") {
+		t.Errorf("expect traceparent in the same trace, got %q", tp)
+	}
+}